@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	_ "embed"
 	"encoding/base64"
@@ -20,7 +19,6 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 	"sydneyqt/sydney"
 	"sydneyqt/util"
@@ -33,15 +31,16 @@ var version string
 
 // App struct
 type App struct {
-	settings *Settings
-	ctx      context.Context
-	logFile  *os.File
-	logToStd bool
+	settings   *Settings
+	workspaces *WorkspaceStore
+	ctx        context.Context
+	logFile    *os.File
+	logToStd   bool
 }
 
 // NewApp creates a new App application struct
-func NewApp(settings *Settings) *App {
-	return &App{settings: settings}
+func NewApp(settings *Settings, workspaces *WorkspaceStore) *App {
+	return &App{settings: settings, workspaces: workspaces}
 }
 
 // startup is called when the app starts. The context is saved
@@ -72,6 +71,7 @@ func (a *App) shutdown(ctx context.Context) {
 		a.logFile.Close()
 	}
 	a.settings.Exit <- struct{}{}
+	a.workspaces.close()
 	os.Exit(0)
 }
 func (a *App) updateLogger(debug bool) {
@@ -161,6 +161,20 @@ type UploadSydneyDocumentResult struct {
 	Ext      string `json:"ext,omitempty"`
 }
 
+func documentReaderFor(ext string) (util.DocumentReader, error) {
+	switch strings.ToLower(ext) {
+	case ".pdf":
+		return util.PDFDocumentReader{}, nil
+	case ".docx":
+		return util.DocxDocumentReader{}, nil
+	case ".pptx":
+		return util.PptxDocumentReader{}, nil
+	case ".txt", ".md":
+		return util.PlainDocumentReader{}, nil
+	default:
+		return nil, errors.New("file type " + ext + " not implemented")
+	}
+}
 func (a *App) UploadDocument() (UploadSydneyDocumentResult, error) {
 	file, err := runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
 		Title: "Open a document to upload",
@@ -176,18 +190,9 @@ func (a *App) UploadDocument() (UploadSydneyDocumentResult, error) {
 		return UploadSydneyDocumentResult{Canceled: true}, nil
 	}
 	ext := filepath.Ext(file)
-	var docReader util.DocumentReader
-	switch ext {
-	case ".pdf":
-		docReader = util.PDFDocumentReader{}
-	case ".docx":
-		docReader = util.DocxDocumentReader{}
-	case ".pptx":
-		docReader = util.PptxDocumentReader{}
-	case ".txt", ".md":
-		docReader = util.PlainDocumentReader{}
-	default:
-		return UploadSydneyDocumentResult{}, errors.New("file type " + ext + " not implemented")
+	docReader, err := documentReaderFor(ext)
+	if err != nil {
+		return UploadSydneyDocumentResult{}, err
 	}
 	s, err := docReader.Read(file)
 	if err != nil {
@@ -244,12 +249,13 @@ func (a *App) FetchWebpage(url string) (FetchWebpageResult, error) {
 	}, nil
 }
 
+// GetUser returns the name of the account of the cookie profile of the current workspace
 func (a *App) GetUser() (string, error) {
-	sydneyIns, err := a.createSydney()
+	currentWorkspace, err := a.workspaces.GetWorkspace(a.settings.config.CurrentWorkspaceID)
 	if err != nil {
 		return "", err
 	}
-	return sydneyIns.GetUser()
+	return a.GetProfileUser(currentWorkspace.CookieProfile)
 }
 
 type CheckUpdateResult struct {
@@ -384,46 +390,6 @@ func (a *App) SaveRemoteFile(extWithoutDot, defaultFilenameWithoutExt, url strin
 	}
 	return os.WriteFile(filePath, resp.Bytes(), 0644)
 }
-func (a *App) ExportWorkspace(id int) error {
-	workspace, ok := lo.Find(a.settings.config.Workspaces, func(item Workspace) bool {
-		return item.ID == id
-	})
-	if !ok {
-		return errors.New("workspace not exist by id: " + strconv.Itoa(id))
-	}
-	fn, err := filenamify.FilenamifyV2(workspace.Title + ".md")
-	if err != nil {
-		return err
-	}
-	filePath, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
-		Title: "Choose a destination to save the chat",
-		Filters: []runtime.FileFilter{{
-			DisplayName: "MarkDown Files (*.md)",
-			Pattern:     "*.md",
-		}},
-		CanCreateDirectories: true,
-		DefaultFilename:      fn,
-	})
-	if err != nil {
-		return err
-	}
-	if filePath == "" {
-		return nil
-	}
-	if !strings.HasSuffix(filePath, ".md") {
-		filePath += ".md"
-	}
-	messages := util.GetChatMessage(workspace.Context)
-	var out bytes.Buffer
-	for _, msg := range messages {
-		out.WriteString(fmt.Sprintf("# \\[%s\\](#%s)\n%s\n\n", msg.Role, msg.Type, msg.Content))
-	}
-	input := strings.TrimSpace(workspace.Input)
-	if input != "" {
-		out.WriteString("# \\[user\\](#message)\n" + workspace.Input + "\n\n")
-	}
-	return os.WriteFile(filePath, out.Bytes(), 0644)
-}
 
 type ShareGPTRequest struct {
 	Title string         `json:"title"`
@@ -439,11 +405,9 @@ type ShareGPTResponse struct {
 }
 
 func (a *App) ShareWorkspace(id int) error {
-	workspace, ok := lo.Find(a.settings.config.Workspaces, func(item Workspace) bool {
-		return item.ID == id
-	})
-	if !ok {
-		return errors.New("workspace not exist by id: " + strconv.Itoa(id))
+	workspace, err := a.workspaces.GetWorkspace(id)
+	if err != nil {
+		return err
 	}
 	_, client, err := util.MakeHTTPClient(a.settings.config.Proxy, 5*time.Second)
 	if err != nil {
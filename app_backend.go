@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sydneyqt/backend"
+	"sydneyqt/util"
+)
+
+func (a *App) findBackend(name string) (backend.Config, bool) {
+	a.settings.mu.RLock()
+	defer a.settings.mu.RUnlock()
+	return lo.Find(a.settings.config.Backends, func(item backend.Config) bool {
+		return item.Name == name
+	})
+}
+
+// newBackend creates the backend with the proxy in settings, except Ollama which usually runs on the machine
+func (a *App) newBackend(config backend.Config) (backend.Backend, error) {
+	client := &http.Client{}
+	if config.Type != backend.TypeOllama {
+		hClient, _, err := util.MakeHTTPClient(a.settings.config.Proxy, 0)
+		if err != nil {
+			return nil, err
+		}
+		client = hClient
+	}
+	return backend.New(config, client)
+}
+
+// backendMessages converts the chat context in the same way as GetOpenAIChatMessages, and appends the prompt
+func backendMessages(chatContext string, prompt string, imageURL string) []backend.Message {
+	messages := lo.Map(util.GetChatMessage(chatContext), func(item util.ChatMessage, index int) backend.Message {
+		content := item.Content
+		if item.Type != "message" && !strings.Contains(item.Type, "instructions") {
+			content = "# " + item.Type + "\n" + content
+		}
+		return backend.Message{Role: item.Role, Content: content}
+	})
+	message := backend.Message{Role: backend.RoleUser, Content: prompt}
+	if imageURL != "" {
+		message.ImageURLs = []string{imageURL}
+	}
+	return append(messages, message)
+}
+
+func (a *App) askBackend(options AskOptions) {
+	chatFinishResult := ChatFinishResult{
+		Success: true,
+		ErrType: "",
+		ErrMsg:  "",
+	}
+	handleErr := func(err error) {
+		chatFinishResult = ChatFinishResult{
+			Success: false,
+			ErrType: ChatFinishResultErrTypeOthers,
+			ErrMsg:  err.Error(),
+		}
+	}
+	defer func() {
+		slog.Info("invoke EventChatFinish", "result", chatFinishResult)
+		runtime.EventsEmit(a.ctx, EventChatFinish, chatFinishResult)
+	}()
+	stopCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runtime.EventsOn(a.ctx, EventChatStop, func(optionalData ...interface{}) {
+		slog.Info("Received EventChatStop")
+		cancel()
+		runtime.EventsOff(a.ctx, EventChatStop)
+	})
+	config, ok := a.findBackend(options.Backend)
+	if !ok {
+		handleErr(errors.New("backend not exist: " + options.Backend))
+		return
+	}
+	slog.Info("Ask backend", "name", config.Name, "type", config.Type, "model", config.Model())
+	b, err := a.newBackend(config)
+	if err != nil {
+		handleErr(err)
+		return
+	}
+	ch, err := b.Stream(stopCtx, backendMessages(options.ChatContext, options.Prompt, options.ImageURL))
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err != nil {
+		handleErr(err)
+		return
+	}
+	runtime.EventsEmit(a.ctx, EventConversationCreated)
+	runtime.EventsEmit(a.ctx, EventChatModel, ChatModelInfo{Model: config.Model()})
+	fullMessage := ""
+	for delta := range ch {
+		if delta.Error != nil {
+			handleErr(delta.Error)
+			return
+		}
+		textToAppend := delta.Text
+		if fullMessage == "" {
+			textToAppend = "[assistant](#message)\n" + textToAppend
+		}
+		fullMessage += delta.Text
+		runtime.EventsEmit(a.ctx, EventChatToken, a.CountToken(fullMessage))
+		runtime.EventsEmit(a.ctx, EventChatAppend, textToAppend)
+	}
+	slog.Info("backend chat completed")
+}
+
+// getBackendConciseAnswer waits for the whole reply of the backend
+func (a *App) getBackendConciseAnswer(config backend.Config, req ConciseAnswerReq) (string, error) {
+	b, err := a.newBackend(config)
+	if err != nil {
+		return "", err
+	}
+	messages := []backend.Message{{Role: backend.RoleUser, Content: req.Prompt}}
+	if req.Context != "" {
+		messages = append([]backend.Message{{Role: backend.RoleSystem, Content: req.Context}}, messages...)
+	}
+	ch, err := b.Stream(context.Background(), messages)
+	if err != nil {
+		return "", err
+	}
+	var result strings.Builder
+	for delta := range ch {
+		if delta.Error != nil {
+			return "", delta.Error
+		}
+		result.WriteString(delta.Text)
+	}
+	return strings.TrimSpace(result.String()), nil
+}
@@ -20,6 +20,7 @@ const (
 	_ = iota
 	AskTypeSydney
 	AskTypeOpenAI
+	AskTypeBackend
 )
 
 type AskType int
@@ -27,6 +28,7 @@ type AskType int
 type AskOptions struct {
 	Type           AskType `json:"type"`
 	OpenAIBackend  string  `json:"openai_backend"`
+	Backend        string  `json:"backend"` // for AskTypeBackend
 	ChatContext    string  `json:"chat_context"`
 	Prompt         string  `json:"prompt"`
 	ImageURL       string  `json:"image_url"`
@@ -54,38 +56,40 @@ const (
 	EventChatGenerateImage      = "chat_generate_image"
 	EventChatGenerateMusic      = "chat_generate_music"
 	EventChatResolvingCaptcha   = "chat_resolving_captcha"
+	EventChatModel              = "chat_model"
+	EventChatToolApproval       = "chat_tool_approval"
 )
 
 const (
-	EventChatStop = "chat_stop"
+	EventChatStop               = "chat_stop"
+	EventChatToolApprovalResult = "chat_tool_approval_result"
 )
 
 func (a *App) Dummy1() ChatFinishResult {
 	return ChatFinishResult{}
 }
+func (a *App) Dummy2() ChatModelInfo {
+	return ChatModelInfo{}
+}
+func (a *App) Dummy3() util.ToolCallBlock {
+	return util.ToolCallBlock{}
+}
 func (a *App) createSydney() (*sydney.Sydney, error) {
-	currentWorkspace, err := a.settings.config.GetCurrentWorkspace()
+	currentWorkspace, err := a.workspaces.GetWorkspace(a.settings.config.CurrentWorkspaceID)
 	if err != nil {
 		return nil, err
 	}
-	cookies, err := util.ReadCookiesFile()
+	options, err := a.cookieProfileOptions(currentWorkspace.CookieProfile)
 	if err != nil {
 		return nil, err
 	}
-	return sydney.NewSydney(sydney.Options{
-		Debug:                 a.settings.config.Debug,
-		Cookies:               cookies,
-		Proxy:                 a.settings.config.Proxy,
-		ConversationStyle:     currentWorkspace.ConversationStyle,
-		Locale:                currentWorkspace.Locale,
-		WssDomain:             a.settings.config.WssDomain,
-		CreateConversationURL: a.settings.config.CreateConversationURL,
-		NoSearch:              currentWorkspace.NoSearch,
-		UseClassic:            currentWorkspace.UseClassic,
-		GPT4Turbo:             currentWorkspace.GPT4Turbo,
-		BypassServer:          a.settings.config.BypassServer,
-		Plugins:               currentWorkspace.Plugins,
-	}), nil
+	options.ConversationStyle = currentWorkspace.ConversationStyle
+	options.Locale = currentWorkspace.Locale
+	options.NoSearch = currentWorkspace.NoSearch
+	options.UseClassic = currentWorkspace.UseClassic
+	options.GPT4Turbo = currentWorkspace.GPT4Turbo
+	options.Plugins = currentWorkspace.Plugins
+	return sydney.NewSydney(options), nil
 }
 
 func (a *App) askSydney(options AskOptions) {
@@ -265,54 +269,127 @@ func (a *App) askOpenAI(options AskOptions) {
 			}},
 		})
 	}
-	stream, err := client.CreateChatCompletionStream(stopCtx, openai.ChatCompletionRequest{
-		Model:            backend.OpenaiShortModel,
-		Messages:         messages,
-		Temperature:      backend.OpenaiTemperature,
-		FrequencyPenalty: backend.FrequencyPenalty,
-		PresencePenalty:  backend.PresencePenalty,
-		MaxTokens:        backend.MaxTokens,
-	})
-	if err != nil {
-		handleErr(err)
-		return
-	}
-	runtime.EventsEmit(a.ctx, EventConversationCreated)
-	defer stream.Close()
+	tools := a.openAITools()
 	fullMessage := ""
-	replied := false
-	for {
-		response, err := stream.Recv()
-		if errors.Is(err, io.EOF) {
-			slog.Info("openai chat completed")
-			return
+	for round := 0; ; round++ {
+		// tool calls and their results grow the messages every round, which may need the long model or trimming,
+		// while messages keeps all of them so that each round trims from the whole conversation
+		roundMessages, modelInfo := a.chooseOpenAIModel(backend, messages)
+		slog.Info("Choose OpenAI model", "round", round, "info", modelInfo)
+		runtime.EventsEmit(a.ctx, EventChatModel, modelInfo)
+		request := openai.ChatCompletionRequest{
+			Model:            modelInfo.Model,
+			Messages:         roundMessages,
+			Temperature:      backend.OpenaiTemperature,
+			FrequencyPenalty: backend.FrequencyPenalty,
+			PresencePenalty:  backend.PresencePenalty,
+			MaxTokens:        backend.MaxTokens,
 		}
-		if errors.Is(err, context.Canceled) {
-			return
+		// the assistant has to reply without tools in the last round
+		if round < maxToolRounds {
+			request.Tools = tools
 		}
+		stream, err := client.CreateChatCompletionStream(stopCtx, request)
 		if err != nil {
 			handleErr(err)
 			return
 		}
-		slog.Debug("Received OpenAI delta", "v", response)
-		if len(response.Choices) == 0 {
-			continue
+		if round == 0 {
+			runtime.EventsEmit(a.ctx, EventConversationCreated)
+		}
+		roundMessage := ""
+		var toolCalls []openai.ToolCall
+		for {
+			response, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if errors.Is(err, context.Canceled) {
+				stream.Close()
+				return
+			}
+			if err != nil {
+				stream.Close()
+				handleErr(err)
+				return
+			}
+			slog.Debug("Received OpenAI delta", "v", response)
+			if len(response.Choices) == 0 {
+				continue
+			}
+			toolCalls = appendToolCallDeltas(toolCalls, response.Choices[0].Delta.ToolCalls)
+			textToAppend := response.Choices[0].Delta.Content
+			if textToAppend == "" {
+				continue
+			}
+			fullMessage += textToAppend
+			runtime.EventsEmit(a.ctx, EventChatToken, a.CountToken(fullMessage))
+			if roundMessage == "" {
+				textToAppend = "[assistant](#message)\n" + textToAppend
+			}
+			roundMessage += textToAppend
+			runtime.EventsEmit(a.ctx, EventChatAppend, textToAppend)
+		}
+		stream.Close()
+		if len(toolCalls) == 0 {
+			slog.Info("openai chat completed")
+			return
+		}
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:      openai.ChatMessageRoleAssistant,
+			Content:   strings.TrimPrefix(roundMessage, "[assistant](#message)\n"),
+			ToolCalls: toolCalls,
+		})
+		for _, call := range toolCalls {
+			block, err := a.runToolCall(stopCtx, call)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			if err != nil {
+				handleErr(err)
+				return
+			}
+			slog.Info("Ran local tool", "name", block.Name, "arguments", block.Arguments)
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				Content:    block.Result,
+				ToolCallID: block.ID,
+			})
+			runtime.EventsEmit(a.ctx, EventChatAppend,
+				lo.Ternary(roundMessage == "", "", "\n\n")+util.FormatToolCallBlock(block))
+			roundMessage = ""
 		}
-		textToAppend := response.Choices[0].Delta.Content
-		fullMessage += textToAppend
-		runtime.EventsEmit(a.ctx, EventChatToken, a.CountToken(fullMessage))
-		if !replied {
-			textToAppend = "[assistant](#message)\n" + textToAppend
-			replied = true
+	}
+}
+
+// appendToolCallDeltas merges the streamed pieces of tool calls by their index
+func appendToolCallDeltas(toolCalls []openai.ToolCall, deltas []openai.ToolCall) []openai.ToolCall {
+	for _, delta := range deltas {
+		index := len(toolCalls) - 1
+		if delta.Index != nil {
+			index = *delta.Index
+		} else if delta.ID != "" || index < 0 {
+			// some compatible endpoints leave out the index, where a new id starts a new call
+			index = len(toolCalls)
+		}
+		for len(toolCalls) <= index {
+			toolCalls = append(toolCalls, openai.ToolCall{Type: openai.ToolTypeFunction})
 		}
-		runtime.EventsEmit(a.ctx, EventChatAppend, textToAppend)
+		if delta.ID != "" {
+			toolCalls[index].ID = delta.ID
+		}
+		toolCalls[index].Function.Name += delta.Function.Name
+		toolCalls[index].Function.Arguments += delta.Function.Arguments
 	}
+	return toolCalls
 }
 func (a *App) AskAI(options AskOptions) {
 	if options.Type == AskTypeSydney {
 		a.askSydney(options)
 	} else if options.Type == AskTypeOpenAI {
 		a.askOpenAI(options)
+	} else if options.Type == AskTypeBackend {
+		a.askBackend(options)
 	}
 }
 
@@ -324,19 +401,18 @@ type ConciseAnswerReq struct {
 
 func (a *App) GetConciseAnswer(req ConciseAnswerReq) (string, error) {
 	if req.Backend == "Sydney" {
-		cookies, err := util.ReadCookiesFile()
+		currentWorkspace, err := a.workspaces.GetWorkspace(a.settings.config.CurrentWorkspaceID)
 		if err != nil {
 			return "", err
 		}
-		syd := sydney.NewSydney(sydney.Options{
-			Debug:                 false,
-			Cookies:               cookies,
-			Proxy:                 a.settings.config.Proxy,
-			WssDomain:             a.settings.config.WssDomain,
-			CreateConversationURL: a.settings.config.CreateConversationURL,
-			NoSearch:              true,
-			UseClassic:            false,
-		})
+		options, err := a.cookieProfileOptions(currentWorkspace.CookieProfile)
+		if err != nil {
+			return "", err
+		}
+		options.Debug = false
+		options.BypassServer = ""
+		options.NoSearch = true
+		syd := sydney.NewSydney(options)
 		ch, err := syd.AskStream(sydney.AskStreamOptions{
 			StopCtx:        context.Background(),
 			Prompt:         req.Prompt,
@@ -357,6 +433,9 @@ func (a *App) GetConciseAnswer(req ConciseAnswerReq) (string, error) {
 		}
 		return strings.TrimSpace(result.String()), nil
 	}
+	if config, ok := a.findBackend(req.Backend); ok {
+		return a.getBackendConciseAnswer(config, req)
+	}
 	// openai backends
 	backend, ok := lo.Find(a.settings.config.OpenAIBackends, func(item OpenAIBackend) bool {
 		return item.Name == req.Backend
@@ -374,8 +453,9 @@ func (a *App) GetConciseAnswer(req ConciseAnswerReq) (string, error) {
 		{Role: "system", Content: req.Context},
 		{Role: "user", Content: req.Prompt},
 	})
+	messages, modelInfo := a.chooseOpenAIModel(backend, messages)
 	resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
-		Model:       backend.OpenaiShortModel,
+		Model:       modelInfo.Model,
 		Messages:    messages,
 		Temperature: 1,
 	})
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendToolCallDeltas(t *testing.T) {
+	delta := func(index *int, id, name, arguments string) openai.ToolCall {
+		return openai.ToolCall{Index: index, ID: id, Function: openai.FunctionCall{Name: name, Arguments: arguments}}
+	}
+	call := func(id, name, arguments string) openai.ToolCall {
+		return openai.ToolCall{ID: id, Type: openai.ToolTypeFunction,
+			Function: openai.FunctionCall{Name: name, Arguments: arguments}}
+	}
+	tests := []struct {
+		name   string
+		deltas []openai.ToolCall
+		want   []openai.ToolCall
+	}{
+		{"one call across chunks", []openai.ToolCall{
+			delta(lo.ToPtr(0), "call_1", "evaluate_", ""),
+			delta(lo.ToPtr(0), "", "arithmetic", `{"expression"`),
+			delta(lo.ToPtr(0), "", "", `:"1+1"}`),
+		}, []openai.ToolCall{call("call_1", "evaluate_arithmetic", `{"expression":"1+1"}`)}},
+		{"interleaved calls", []openai.ToolCall{
+			delta(lo.ToPtr(0), "call_1", "fetch_webpage", `{"url":`),
+			delta(lo.ToPtr(1), "call_2", "read_document", `{"path":`),
+			delta(lo.ToPtr(0), "", "", `"https://example.com"}`),
+			delta(lo.ToPtr(1), "", "", `"/a.pdf"}`),
+		}, []openai.ToolCall{
+			call("call_1", "fetch_webpage", `{"url":"https://example.com"}`),
+			call("call_2", "read_document", `{"path":"/a.pdf"}`),
+		}},
+		{"without index", []openai.ToolCall{
+			delta(nil, "call_1", "fetch_webpage", `{"url":`),
+			delta(nil, "", "", `"https://example.com"}`),
+			delta(nil, "call_2", "evaluate_arithmetic", `{}`),
+		}, []openai.ToolCall{
+			call("call_1", "fetch_webpage", `{"url":"https://example.com"}`),
+			call("call_2", "evaluate_arithmetic", `{}`),
+		}},
+		{"without index or id", []openai.ToolCall{
+			delta(nil, "", "evaluate_arithmetic", `{}`),
+		}, []openai.ToolCall{call("", "evaluate_arithmetic", `{}`)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var toolCalls []openai.ToolCall
+			for _, delta := range tt.deltas {
+				toolCalls = appendToolCallDeltas(toolCalls, []openai.ToolCall{delta})
+			}
+			assert.Equal(t, tt.want, toolCalls)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+type AnthropicOptions struct {
+	Key         string  `json:"key"`
+	Endpoint    string  `json:"endpoint"` // without /v1
+	Model       string  `json:"model"`
+	MaxTokens   int     `json:"max_tokens"` // required by the API
+	Temperature float32 `json:"temperature"`
+	TopP        float32 `json:"top_p"` // not sent if 0
+}
+
+// Anthropic streams replies from the Messages API
+type Anthropic struct {
+	options AnthropicOptions
+	client  *http.Client
+}
+
+type anthropicContent struct {
+	Type   string                `json:"type"`
+	Text   string                `json:"text,omitempty"`
+	Source *anthropicImageSource `json:"source,omitempty"`
+}
+type anthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+type anthropicMessage struct {
+	Role    string             `json:"role"`
+	Content []anthropicContent `json:"content"`
+}
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	MaxTokens   int                `json:"max_tokens"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	Temperature float32            `json:"temperature"`
+	TopP        float32            `json:"top_p,omitempty"`
+	Stream      bool               `json:"stream"`
+}
+type anthropicEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (o *Anthropic) Stream(ctx context.Context, messages []Message) (<-chan Delta, error) {
+	system, messages := splitSystem(messages)
+	if len(messages) > 0 && messages[0].Role != RoleUser {
+		// the first message must be from the user
+		messages = append([]Message{{Role: RoleUser, Content: "(The conversation continues.)"}}, messages...)
+	}
+	request := anthropicRequest{
+		Model:       o.options.Model,
+		MaxTokens:   o.options.MaxTokens,
+		System:      system,
+		Temperature: o.options.Temperature,
+		TopP:        o.options.TopP,
+		Stream:      true,
+	}
+	for _, message := range messages {
+		var content []anthropicContent
+		for _, imageURL := range message.ImageURLs {
+			mediaType, data, err := loadImage(ctx, o.client, imageURL)
+			if err != nil {
+				return nil, err
+			}
+			content = append(content, anthropicContent{Type: "image", Source: &anthropicImageSource{
+				Type:      "base64",
+				MediaType: mediaType,
+				Data:      data,
+			}})
+		}
+		content = append(content, anthropicContent{Type: "text", Text: message.Content})
+		request.Messages = append(request.Messages, anthropicMessage{Role: message.Role, Content: content})
+	}
+	resp, err := postJSON(ctx, o.client, strings.TrimSuffix(o.options.Endpoint, "/")+"/v1/messages", map[string]string{
+		"x-api-key":         o.options.Key,
+		"anthropic-version": "2023-06-01",
+	}, request)
+	if err != nil {
+		return nil, err
+	}
+	return stream(ctx, resp, func(send func(text string)) error {
+		var streamErr error
+		err := readSSE(ctx, resp.Body, func(data []byte) bool {
+			var event anthropicEvent
+			if json.Unmarshal(data, &event) != nil {
+				return true
+			}
+			switch event.Type {
+			case "content_block_delta":
+				send(event.Delta.Text)
+			case "error":
+				streamErr = errors.New(event.Error.Type + ": " + event.Error.Message)
+				return false
+			case "message_stop":
+				return false
+			}
+			return true
+		})
+		if streamErr != nil {
+			return streamErr
+		}
+		return err
+	}), nil
+}
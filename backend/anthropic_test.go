@@ -0,0 +1,89 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnthropicStream(t *testing.T) {
+	var request anthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/messages", r.URL.Path)
+		assert.Equal(t, "sk-ant", r.Header.Get("x-api-key"))
+		assert.Equal(t, "2023-06-01", r.Header.Get("anthropic-version"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("event: message_start\ndata: {\"type\":\"message_start\"}\n\n" +
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hello\"}}\n\n" +
+			"event: ping\ndata: {\"type\": \"ping\"}\n\n" +
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\", world!\"}}\n\n" +
+			"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"))
+	}))
+	defer server.Close()
+
+	backend, err := New(Config{Type: TypeAnthropic, Anthropic: AnthropicOptions{
+		Key:         "sk-ant",
+		Endpoint:    server.URL + "/",
+		Model:       "claude-3-haiku-20240307",
+		MaxTokens:   1024,
+		Temperature: 0.5,
+	}}, server.Client())
+	assert.NoError(t, err)
+	ch, err := backend.Stream(context.Background(), []Message{
+		{Role: RoleSystem, Content: "You are Sydney."},
+		{Role: RoleAssistant, Content: "Hi!"},
+		{Role: RoleUser, Content: "What's in the image?", ImageURLs: []string{"data:image/png;base64,AAAA"}},
+	})
+	assert.NoError(t, err)
+	text, err := collect(ch)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello, world!", text)
+
+	assert.Equal(t, anthropicRequest{
+		Model:     "claude-3-haiku-20240307",
+		MaxTokens: 1024,
+		System:    "You are Sydney.",
+		Messages: []anthropicMessage{
+			{Role: RoleUser, Content: []anthropicContent{{Type: "text", Text: "(The conversation continues.)"}}},
+			{Role: RoleAssistant, Content: []anthropicContent{{Type: "text", Text: "Hi!"}}},
+			{Role: RoleUser, Content: []anthropicContent{
+				{Type: "image", Source: &anthropicImageSource{Type: "base64", MediaType: "image/png", Data: "AAAA"}},
+				{Type: "text", Text: "What's in the image?"},
+			}},
+		},
+		Temperature: 0.5,
+		Stream:      true,
+	}, request)
+}
+
+func TestAnthropicErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "sk-ant" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`))
+			return
+		}
+		_, _ = w.Write([]byte("event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"Hel\"}}\n\n" +
+			"event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n"))
+	}))
+	defer server.Close()
+
+	backend, err := New(Config{Type: TypeAnthropic, Anthropic: AnthropicOptions{Endpoint: server.URL}}, server.Client())
+	assert.NoError(t, err)
+	_, err = backend.Stream(context.Background(), []Message{{Role: RoleUser, Content: "Hello!"}})
+	assert.ErrorContains(t, err, "401")
+	assert.ErrorContains(t, err, "invalid x-api-key")
+
+	backend, err = New(Config{Type: TypeAnthropic, Anthropic: AnthropicOptions{Key: "sk-ant", Endpoint: server.URL}}, server.Client())
+	assert.NoError(t, err)
+	ch, err := backend.Stream(context.Background(), []Message{{Role: RoleUser, Content: "Hello!"}})
+	assert.NoError(t, err)
+	text, err := collect(ch)
+	assert.Equal(t, "Hel", text)
+	assert.EqualError(t, err, "overloaded_error: Overloaded")
+}
@@ -0,0 +1,216 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	TypeAnthropic = "anthropic"
+	TypeGemini    = "gemini"
+	TypeOllama    = "ollama"
+)
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+type Message struct {
+	Role      string
+	Content   string
+	ImageURLs []string // http or data URLs, only for user messages
+}
+
+// Delta is a piece of the reply, or the error which ends it
+type Delta struct {
+	Text  string
+	Error error
+}
+
+// Backend is a chat model other than Sydney and OpenAI-compatible ones
+type Backend interface {
+	// Stream sends the reply to the messages in pieces, and closes the channel when the reply ends or ctx is done
+	Stream(ctx context.Context, messages []Message) (<-chan Delta, error)
+}
+
+// Config is a backend in settings, where only the options of its type are used
+type Config struct {
+	Name      string           `json:"name"`
+	Type      string           `json:"type"`
+	Anthropic AnthropicOptions `json:"anthropic"`
+	Gemini    GeminiOptions    `json:"gemini"`
+	Ollama    OllamaOptions    `json:"ollama"`
+}
+
+// Model returns the model in the options of the type
+func (o Config) Model() string {
+	switch o.Type {
+	case TypeAnthropic:
+		return o.Anthropic.Model
+	case TypeGemini:
+		return o.Gemini.Model
+	case TypeOllama:
+		return o.Ollama.Model
+	default:
+		return ""
+	}
+}
+
+func New(config Config, client *http.Client) (Backend, error) {
+	switch config.Type {
+	case TypeAnthropic:
+		return &Anthropic{options: config.Anthropic, client: client}, nil
+	case TypeGemini:
+		return &Gemini{options: config.Gemini, client: client}, nil
+	case TypeOllama:
+		return &Ollama{options: config.Ollama, client: client}, nil
+	default:
+		return nil, errors.New("unknown backend type: " + config.Type)
+	}
+}
+
+// splitSystem returns the system messages joined, and the rest with consecutive messages of the same role merged
+// along with their images, since the APIs take the system prompt apart and expect user and assistant messages
+// to alternate
+func splitSystem(messages []Message) (string, []Message) {
+	var system []string
+	var result []Message
+	for _, message := range messages {
+		if message.Role == RoleSystem {
+			system = append(system, message.Content)
+			continue
+		}
+		if len(result) > 0 && result[len(result)-1].Role == message.Role {
+			last := &result[len(result)-1]
+			last.Content += "\n\n" + message.Content
+			last.ImageURLs = append(slices.Clip(last.ImageURLs), message.ImageURLs...)
+			continue
+		}
+		result = append(result, message)
+	}
+	return strings.Join(system, "\n\n"), result
+}
+
+// loadImage returns the media type and the base64 data of the image at an http or data URL
+func loadImage(ctx context.Context, client *http.Client, url string) (string, string, error) {
+	if strings.HasPrefix(url, "data:") {
+		mediaType, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ";base64,")
+		if !ok {
+			return "", "", errors.New("image data URL is not in base64")
+		}
+		return mediaType, data, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", errors.New("cannot fetch image: http status code " + strconv.Itoa(resp.StatusCode))
+	}
+	v, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+	mediaType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(mediaType, "image/") {
+		mediaType = http.DetectContentType(v)
+	}
+	return mediaType, base64.StdEncoding.EncodeToString(v), nil
+}
+
+// postJSON sends the body, and returns the response if the status is 200, or an error with the message in the response
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body any) (*http.Response, error) {
+	v, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(v))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	v, _ = io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return nil, errors.New("http status code " + strconv.Itoa(resp.StatusCode) + ": " + strings.TrimSpace(string(v)))
+}
+
+// readLines calls onLine with each line of the streaming body until it ends, onLine returns false or ctx is done
+func readLines(ctx context.Context, body io.Reader, onLine func(line string) bool) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !onLine(scanner.Text()) {
+			return nil
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+// readSSE calls onData with the data of each server-sent event
+func readSSE(ctx context.Context, body io.Reader, onData func(data []byte) bool) error {
+	return readLines(ctx, body, func(line string) bool {
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			return true
+		}
+		return onData([]byte(strings.TrimSpace(data)))
+	})
+}
+
+// stream runs read in a goroutine with a channel to send the reply, which is closed when read returns.
+// An error other than the cancellation of ctx is sent as the last delta.
+func stream(ctx context.Context, resp *http.Response, read func(send func(text string)) error) <-chan Delta {
+	ch := make(chan Delta)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+		err := read(func(text string) {
+			if text == "" {
+				return
+			}
+			select {
+			case ch <- Delta{Text: text}:
+			case <-ctx.Done():
+			}
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			select {
+			case ch <- Delta{Error: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return ch
+}
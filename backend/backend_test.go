@@ -0,0 +1,74 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// collect reads the whole reply, returning the error which ends it
+func collect(ch <-chan Delta) (string, error) {
+	text := ""
+	for delta := range ch {
+		if delta.Error != nil {
+			return text, delta.Error
+		}
+		text += delta.Text
+	}
+	return text, nil
+}
+
+func TestSplitSystem(t *testing.T) {
+	system, messages := splitSystem([]Message{
+		{Role: RoleSystem, Content: "You are Sydney."},
+		{Role: RoleUser, Content: "Hello!"},
+		{Role: RoleSystem, Content: "Be brief."},
+		{Role: RoleUser, Content: "What's in the image?", ImageURLs: []string{"data:image/png;base64,AAAA"}},
+		{Role: RoleUser, Content: "And this one?", ImageURLs: []string{"data:image/png;base64,BBBB"}},
+		{Role: RoleAssistant, Content: "A cat."},
+		{Role: RoleUser, Content: "Which one?"},
+	})
+	assert.Equal(t, "You are Sydney.\n\nBe brief.", system)
+	assert.Equal(t, []Message{
+		{Role: RoleUser, Content: "Hello!\n\nWhat's in the image?\n\nAnd this one?",
+			ImageURLs: []string{"data:image/png;base64,AAAA", "data:image/png;base64,BBBB"}},
+		{Role: RoleAssistant, Content: "A cat."},
+		{Role: RoleUser, Content: "Which one?"},
+	}, messages)
+}
+
+func TestLoadImage(t *testing.T) {
+	mediaType, data, err := loadImage(context.Background(), http.DefaultClient, "data:image/jpeg;base64,/9j/")
+	assert.NoError(t, err)
+	assert.Equal(t, "image/jpeg", mediaType)
+	assert.Equal(t, "/9j/", data)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cat.png" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	}))
+	defer server.Close()
+	mediaType, data, err = loadImage(context.Background(), server.Client(), server.URL+"/cat.png")
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", mediaType)
+	assert.Equal(t, "iVBORw0KGgo=", data)
+	_, _, err = loadImage(context.Background(), server.Client(), server.URL+"/dog.png")
+	assert.Error(t, err)
+}
+
+func TestNewUnknownType(t *testing.T) {
+	_, err := New(Config{Name: "Foo", Type: "foo"}, http.DefaultClient)
+	assert.Error(t, err)
+}
+
+func TestConfigModel(t *testing.T) {
+	assert.Equal(t, "llama3", Config{Type: TypeOllama, Ollama: OllamaOptions{Model: "llama3"},
+		Gemini: GeminiOptions{Model: "gemini-pro"}}.Model())
+	assert.Equal(t, "", Config{Type: "foo"}.Model())
+}
@@ -0,0 +1,129 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type GeminiOptions struct {
+	Key             string  `json:"key"`
+	Endpoint        string  `json:"endpoint"` // without /v1beta
+	Model           string  `json:"model"`
+	MaxOutputTokens int     `json:"max_output_tokens"` // not sent if 0
+	Temperature     float32 `json:"temperature"`
+	TopP            float32 `json:"top_p"` // not sent if 0
+}
+
+// Gemini streams replies from the generateContent API of Google AI
+type Gemini struct {
+	options GeminiOptions
+	client  *http.Client
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mime_type"`
+	Data     string `json:"data"`
+}
+type geminiPart struct {
+	Text       string            `json:"text,omitempty"`
+	InlineData *geminiInlineData `json:"inline_data,omitempty"`
+}
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+type geminiGenerationConfig struct {
+	MaxOutputTokens int     `json:"maxOutputTokens,omitempty"`
+	Temperature     float32 `json:"temperature"`
+	TopP            float32 `json:"topP,omitempty"`
+}
+type geminiRequest struct {
+	Contents          []geminiContent        `json:"contents"`
+	SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+	GenerationConfig  geminiGenerationConfig `json:"generationConfig"`
+}
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (o *Gemini) Stream(ctx context.Context, messages []Message) (<-chan Delta, error) {
+	system, messages := splitSystem(messages)
+	request := geminiRequest{
+		GenerationConfig: geminiGenerationConfig{
+			MaxOutputTokens: o.options.MaxOutputTokens,
+			Temperature:     o.options.Temperature,
+			TopP:            o.options.TopP,
+		},
+	}
+	if system != "" {
+		request.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: system}}}
+	}
+	for _, message := range messages {
+		var parts []geminiPart
+		for _, imageURL := range message.ImageURLs {
+			mediaType, data, err := loadImage(ctx, o.client, imageURL)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, geminiPart{InlineData: &geminiInlineData{MimeType: mediaType, Data: data}})
+		}
+		parts = append(parts, geminiPart{Text: message.Content})
+		role := message.Role
+		if role == RoleAssistant {
+			role = "model"
+		}
+		request.Contents = append(request.Contents, geminiContent{Role: role, Parts: parts})
+	}
+	endpoint := strings.TrimSuffix(o.options.Endpoint, "/") + "/v1beta/models/" +
+		url.PathEscape(o.options.Model) + ":streamGenerateContent?alt=sse"
+	resp, err := postJSON(ctx, o.client, endpoint, map[string]string{
+		"x-goog-api-key": o.options.Key,
+	}, request)
+	if err != nil {
+		return nil, err
+	}
+	return stream(ctx, resp, func(send func(text string)) error {
+		var streamErr error
+		err := readSSE(ctx, resp.Body, func(data []byte) bool {
+			var response geminiResponse
+			if json.Unmarshal(data, &response) != nil {
+				return true
+			}
+			if response.Error.Message != "" {
+				streamErr = errors.New(response.Error.Message)
+				return false
+			}
+			if response.PromptFeedback.BlockReason != "" {
+				streamErr = errors.New("prompt blocked: " + response.PromptFeedback.BlockReason)
+				return false
+			}
+			for _, candidate := range response.Candidates {
+				for _, part := range candidate.Content.Parts {
+					send(part.Text)
+				}
+				if candidate.FinishReason == "SAFETY" {
+					streamErr = errors.New("reply blocked for safety")
+					return false
+				}
+			}
+			return true
+		})
+		if streamErr != nil {
+			return streamErr
+		}
+		return err
+	}), nil
+}
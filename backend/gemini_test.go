@@ -0,0 +1,73 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeminiStream(t *testing.T) {
+	var request geminiRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1beta/models/gemini-1.5-flash:streamGenerateContent", r.URL.Path)
+		assert.Equal(t, "sse", r.URL.Query().Get("alt"))
+		assert.Equal(t, "AIza", r.Header.Get("x-goog-api-key"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"Hello\"}],\"role\":\"model\"}}]}\r\n\r\n" +
+			"data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\", world!\"}],\"role\":\"model\"},\"finishReason\":\"STOP\"}]}\r\n\r\n"))
+	}))
+	defer server.Close()
+
+	backend, err := New(Config{Type: TypeGemini, Gemini: GeminiOptions{
+		Key:             "AIza",
+		Endpoint:        server.URL,
+		Model:           "gemini-1.5-flash",
+		MaxOutputTokens: 2048,
+		Temperature:     0.9,
+		TopP:            0.95,
+	}}, server.Client())
+	assert.NoError(t, err)
+	ch, err := backend.Stream(context.Background(), []Message{
+		{Role: RoleSystem, Content: "You are Sydney."},
+		{Role: RoleUser, Content: "Hello!"},
+		{Role: RoleAssistant, Content: "Hi!"},
+		{Role: RoleUser, Content: "What's in the image?", ImageURLs: []string{"data:image/jpeg;base64,/9j/"}},
+	})
+	assert.NoError(t, err)
+	text, err := collect(ch)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello, world!", text)
+
+	assert.Equal(t, geminiRequest{
+		Contents: []geminiContent{
+			{Role: "user", Parts: []geminiPart{{Text: "Hello!"}}},
+			{Role: "model", Parts: []geminiPart{{Text: "Hi!"}}},
+			{Role: "user", Parts: []geminiPart{
+				{InlineData: &geminiInlineData{MimeType: "image/jpeg", Data: "/9j/"}},
+				{Text: "What's in the image?"},
+			}},
+		},
+		SystemInstruction: &geminiContent{Parts: []geminiPart{{Text: "You are Sydney."}}},
+		GenerationConfig:  geminiGenerationConfig{MaxOutputTokens: 2048, Temperature: 0.9, TopP: 0.95},
+	}, request)
+}
+
+func TestGeminiBlocked(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("data: {\"promptFeedback\":{\"blockReason\":\"SAFETY\"}}\n\n"))
+	}))
+	defer server.Close()
+
+	backend, err := New(Config{Type: TypeGemini, Gemini: GeminiOptions{Endpoint: server.URL, Model: "gemini-pro"}},
+		server.Client())
+	assert.NoError(t, err)
+	ch, err := backend.Stream(context.Background(), []Message{{Role: RoleUser, Content: "Hello!"}})
+	assert.NoError(t, err)
+	_, err = collect(ch)
+	assert.EqualError(t, err, "prompt blocked: SAFETY")
+}
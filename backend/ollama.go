@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+type OllamaOptions struct {
+	Endpoint    string  `json:"endpoint"`
+	Model       string  `json:"model"`
+	Temperature float32 `json:"temperature"`
+	NumCtx      int     `json:"num_ctx"`    // context length, the default of the model if 0
+	KeepAlive   string  `json:"keep_alive"` // how long the model stays loaded, such as 5m
+}
+
+// Ollama streams replies from the chat API of a local Ollama server
+type Ollama struct {
+	options OllamaOptions
+	client  *http.Client
+}
+
+type ollamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"`
+}
+type ollamaRequest struct {
+	Model     string          `json:"model"`
+	Messages  []ollamaMessage `json:"messages"`
+	Stream    bool            `json:"stream"`
+	KeepAlive string          `json:"keep_alive,omitempty"`
+	Options   map[string]any  `json:"options"`
+}
+type ollamaResponse struct {
+	Message ollamaMessage `json:"message"`
+	Done    bool          `json:"done"`
+	Error   string        `json:"error"`
+}
+
+func (o *Ollama) Stream(ctx context.Context, messages []Message) (<-chan Delta, error) {
+	request := ollamaRequest{
+		Model:     o.options.Model,
+		Stream:    true,
+		KeepAlive: o.options.KeepAlive,
+		Options:   map[string]any{"temperature": o.options.Temperature},
+	}
+	if o.options.NumCtx > 0 {
+		request.Options["num_ctx"] = o.options.NumCtx
+	}
+	for _, message := range messages {
+		var images []string
+		for _, imageURL := range message.ImageURLs {
+			_, data, err := loadImage(ctx, o.client, imageURL)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, data)
+		}
+		request.Messages = append(request.Messages, ollamaMessage{
+			Role:    message.Role,
+			Content: message.Content,
+			Images:  images,
+		})
+	}
+	resp, err := postJSON(ctx, o.client, strings.TrimSuffix(o.options.Endpoint, "/")+"/api/chat", nil, request)
+	if err != nil {
+		return nil, err
+	}
+	return stream(ctx, resp, func(send func(text string)) error {
+		var streamErr error
+		err := readLines(ctx, resp.Body, func(line string) bool {
+			var response ollamaResponse
+			if json.Unmarshal([]byte(line), &response) != nil {
+				return true
+			}
+			if response.Error != "" {
+				streamErr = errors.New(response.Error)
+				return false
+			}
+			send(response.Message.Content)
+			return !response.Done
+		})
+		if streamErr != nil {
+			return streamErr
+		}
+		return err
+	}), nil
+}
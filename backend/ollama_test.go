@@ -0,0 +1,106 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOllamaStream(t *testing.T) {
+	var request ollamaRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/chat", r.URL.Path)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"Hello"},"done":false}` + "\n" +
+			`{"message":{"role":"assistant","content":", world!"},"done":false}` + "\n" +
+			`{"message":{"role":"assistant","content":""},"done":true,"eval_count":4}` + "\n"))
+	}))
+	defer server.Close()
+
+	backend, err := New(Config{Type: TypeOllama, Ollama: OllamaOptions{
+		Endpoint:    server.URL,
+		Model:       "llava",
+		Temperature: 0.8,
+		NumCtx:      8192,
+		KeepAlive:   "10m",
+	}}, server.Client())
+	assert.NoError(t, err)
+	ch, err := backend.Stream(context.Background(), []Message{
+		{Role: RoleSystem, Content: "You are Sydney."},
+		{Role: RoleUser, Content: "What's in the image?", ImageURLs: []string{"data:image/png;base64,AAAA"}},
+	})
+	assert.NoError(t, err)
+	text, err := collect(ch)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello, world!", text)
+
+	assert.Equal(t, ollamaRequest{
+		Model: "llava",
+		Messages: []ollamaMessage{
+			{Role: RoleSystem, Content: "You are Sydney."},
+			{Role: RoleUser, Content: "What's in the image?", Images: []string{"AAAA"}},
+		},
+		Stream:    true,
+		KeepAlive: "10m",
+		Options:   map[string]any{"temperature": 0.8, "num_ctx": float64(8192)},
+	}, request)
+}
+
+func TestOllamaError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"model \"llava\" not found, try pulling it first"}`))
+	}))
+	defer server.Close()
+
+	backend, err := New(Config{Type: TypeOllama, Ollama: OllamaOptions{Endpoint: server.URL, Model: "llava"}},
+		server.Client())
+	assert.NoError(t, err)
+	_, err = backend.Stream(context.Background(), []Message{{Role: RoleUser, Content: "Hello!"}})
+	assert.ErrorContains(t, err, "not found, try pulling it first")
+}
+
+func TestOllamaStop(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for {
+			_, err := w.Write([]byte(`{"message":{"role":"assistant","content":"word "},"done":false}` + "\n"))
+			if err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	defer server.Close()
+
+	backend, err := New(Config{Type: TypeOllama, Ollama: OllamaOptions{Endpoint: server.URL, Model: "llama3"}},
+		server.Client())
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := backend.Stream(ctx, []Message{{Role: RoleUser, Content: "Count forever."}})
+	assert.NoError(t, err)
+	delta := <-ch
+	assert.Equal(t, "word ", delta.Text)
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		// the channel is closed without an error after stop
+		_, err := collect(ch)
+		assert.NoError(t, err)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not stopped")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
 	"os"
+	"sydneyqt/backend"
 	"sydneyqt/util"
 	"sync"
 	"time"
@@ -31,6 +32,7 @@ type Workspace struct {
 	PersistentInput   bool            `json:"persistent_input"`
 	Plugins           []string        `json:"plugins"`
 	DataReferences    []DataReference `json:"data_references"`
+	CookieProfile     string          `json:"cookie_profile"` // the first profile if empty
 }
 type DataReference struct {
 	UUID string `json:"uuid"`
@@ -43,45 +45,58 @@ type OpenAIBackend struct {
 	OpenaiEndpoint    string  `json:"openai_endpoint"`
 	OpenaiShortModel  string  `json:"openai_short_model"`
 	OpenaiLongModel   string  `json:"openai_long_model"`
-	OpenaiThreshold   int     `json:"openai_threshold"`
+	OpenaiThreshold   int     `json:"openai_threshold"`  // prompt tokens above which the long model is used
+	OpenaiLongLimit   int     `json:"openai_long_limit"` // context length of the long model, above which the oldest messages are trimmed
 	OpenaiTemperature float32 `json:"openai_temperature"`
 	FrequencyPenalty  float32 `json:"frequency_penalty"`
 	PresencePenalty   float32 `json:"presence_penalty"`
 	MaxTokens         int     `json:"max_tokens"`
 }
+
+// CookieProfile is a Bing account, whose cookies are kept in a file of its own
+type CookieProfile struct {
+	Name     string `json:"name"`
+	Proxy    string `json:"proxy"`     // overrides the proxy of settings if not empty
+	UserName string `json:"user_name"` // cached result of GetUser
+}
 type Config struct {
-	Debug                         bool            `json:"debug"`
-	Presets                       []Preset        `json:"presets"`
-	EnterMode                     string          `json:"enter_mode"`
-	Proxy                         string          `json:"proxy"`
-	NoSuggestion                  bool            `json:"no_suggestion"`
-	FontFamily                    string          `json:"font_family"`
-	FontSize                      int             `json:"font_size"`
-	StretchFactor                 int             `json:"stretch_factor"`
-	RevokeReplyText               string          `json:"revoke_reply_text"`
-	RevokeReplyCount              int             `json:"revoke_reply_count"`
-	Workspaces                    []Workspace     `json:"workspaces"`
-	CurrentWorkspaceID            int             `json:"current_workspace_id"`
-	Quick                         []string        `json:"quick"`
-	DisableDirectQuick            bool            `json:"disable_direct_quick"`
-	OpenAIBackends                []OpenAIBackend `json:"open_ai_backends"`
-	WssDomain                     string          `json:"wss_domain"`
-	DarkMode                      bool            `json:"dark_mode"`
-	NoImageRemovalAfterChat       bool            `json:"no_image_removal_after_chat"`
-	NoFileRemovalAfterChat        bool            `json:"no_file_removal_after_chat"`
-	CreateConversationURL         string          `json:"create_conversation_url"`
-	ThemeColor                    string          `json:"theme_color"`
-	DisableNoSearchLoader         bool            `json:"disable_no_search_loader"`
-	BypassServer                  string          `json:"bypass_server"`
-	DisableSummaryTitleGeneration bool            `json:"disable_summary_title_generation"`
+	Debug                         bool             `json:"debug"`
+	Presets                       []Preset         `json:"presets"`
+	EnterMode                     string           `json:"enter_mode"`
+	Proxy                         string           `json:"proxy"`
+	NoSuggestion                  bool             `json:"no_suggestion"`
+	FontFamily                    string           `json:"font_family"`
+	FontSize                      int              `json:"font_size"`
+	StretchFactor                 int              `json:"stretch_factor"`
+	RevokeReplyText               string           `json:"revoke_reply_text"`
+	RevokeReplyCount              int              `json:"revoke_reply_count"`
+	Workspaces                    []Workspace      `json:"workspaces,omitempty"` // only read by the migration to WorkspaceStore
+	CurrentWorkspaceID            int              `json:"current_workspace_id"`
+	Quick                         []string         `json:"quick"`
+	DisableDirectQuick            bool             `json:"disable_direct_quick"`
+	OpenAIBackends                []OpenAIBackend  `json:"open_ai_backends"`
+	Backends                      []backend.Config `json:"backends"` // Anthropic, Gemini and Ollama
+	CookieProfiles                []CookieProfile  `json:"cookie_profiles"`
+	ToolApprovals                 ToolApprovals    `json:"tool_approvals"`
+	WssDomain                     string           `json:"wss_domain"`
+	DarkMode                      bool             `json:"dark_mode"`
+	NoImageRemovalAfterChat       bool             `json:"no_image_removal_after_chat"`
+	NoFileRemovalAfterChat        bool             `json:"no_file_removal_after_chat"`
+	CreateConversationURL         string           `json:"create_conversation_url"`
+	ThemeColor                    string           `json:"theme_color"`
+	DisableNoSearchLoader         bool             `json:"disable_no_search_loader"`
+	BypassServer                  string           `json:"bypass_server"`
+	DisableSummaryTitleGeneration bool             `json:"disable_summary_title_generation"`
 
 	Migration Migration `json:"migration"`
 }
 type Migration struct {
-	SydneyPreset20240304 bool `json:"sydney_preset_20240304"`
-	ThemeColor20240304   bool `json:"theme_color_20240304"`
-	Quick20240326        bool `json:"quick_20240326"`
-	Quick20240405        bool `json:"quick_20240405"`
+	SydneyPreset20240304    bool `json:"sydney_preset_20240304"`
+	ThemeColor20240304      bool `json:"theme_color_20240304"`
+	Quick20240326           bool `json:"quick_20240326"`
+	Quick20240405           bool `json:"quick_20240405"`
+	Workspaces20261018      bool `json:"workspaces_20261018"`
+	OpenAILongLimit20261018 bool `json:"openai_long_limit_20261018"`
 }
 
 func fillDefault[T comparable](pointer *T, defaultValue T) {
@@ -90,16 +105,7 @@ func fillDefault[T comparable](pointer *T, defaultValue T) {
 		*pointer = defaultValue
 	}
 }
-func (o *Config) GetCurrentWorkspace() (Workspace, error) {
-	workspace, exist := util.FindFirst(o.Workspaces, func(value Workspace) bool {
-		return value.ID == o.CurrentWorkspaceID
-	})
-	if !exist {
-		return Workspace{}, errors.New("error finding current workspace")
-	}
-	return workspace, nil
-}
-func (o *Config) DoMigration() {
+func (o *Config) DoMigration(workspaceStore *WorkspaceStore) error {
 	if !o.Migration.SydneyPreset20240304 {
 		_, index, ok := lo.FindIndexOf(o.Presets, func(item Preset) bool {
 			return item.Name == "Sydney"
@@ -136,6 +142,22 @@ func (o *Config) DoMigration() {
 		}
 		o.Migration.Quick20240405 = true
 	}
+	if !o.Migration.Workspaces20261018 {
+		err := workspaceStore.importWorkspaces(o.Workspaces)
+		if err != nil {
+			return err
+		}
+		o.Workspaces = nil
+		o.Migration.Workspaces20261018 = true
+	}
+	if !o.Migration.OpenAILongLimit20261018 {
+		// existing backends may use any model, so the limit is only set for models whose context length is known
+		for i := range o.OpenAIBackends {
+			fillDefault(&o.OpenAIBackends[i].OpenaiLongLimit, inferContextLength(o.OpenAIBackends[i].OpenaiLongModel))
+		}
+		o.Migration.OpenAILongLimit20261018 = true
+	}
+	return nil
 }
 func (o *Config) FillDefault() {
 	if len(o.Presets) == 0 {
@@ -173,10 +195,17 @@ func (o *Config) FillDefault() {
 				OpenaiShortModel:  "gpt-3.5-turbo",
 				OpenaiLongModel:   "gpt-3.5-turbo-16k",
 				OpenaiThreshold:   3500,
+				OpenaiLongLimit:   16385,
 				OpenaiTemperature: 0.4,
 			},
 		}
 	}
+	if o.Backends == nil {
+		o.Backends = []backend.Config{}
+	}
+	if len(o.CookieProfiles) == 0 {
+		o.CookieProfiles = []CookieProfile{{Name: defaultCookieProfileName}}
+	}
 	fillDefault(&o.WssDomain, "sydney.bing.com")
 	fillDefault(&o.CreateConversationURL, "https://edgeservices.bing.com/edgesvc/turing/conversation/create")
 	fillDefault(&o.ThemeColor, "#00B8FF")
@@ -186,12 +215,15 @@ type Settings struct {
 	version           int
 	mu                sync.RWMutex
 	config            Config
+	secrets           *util.SecretStore
 	Exit              chan struct{}
 	DebugChangeSignal chan bool
 }
 
-func NewSettings() *Settings {
+func NewSettings(workspaceStore *WorkspaceStore, secrets *util.SecretStore) *Settings {
 	var config Config
+	version := 0
+	migrated := true
 	fileExist := true
 	if _, err := os.Stat(util.WithPath("config.json")); err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -209,45 +241,90 @@ func NewSettings() *Settings {
 		if err != nil {
 			util.GracefulPanic(err)
 		}
-		config.DoMigration()
+		migrated = config.Migration.Workspaces20261018
+		err = config.DoMigration(workspaceStore)
+		if err != nil {
+			util.GracefulPanic(err)
+		}
+		rewrite, cleared := decryptConfig(&config, secrets)
+		if rewrite {
+			// rewrite config.json with secrets encrypted, and without those which cannot be decrypted
+			version = 1
+		}
+		warnClearedSecrets(cleared)
 	}
 	config.FillDefault()
-	settings := &Settings{config: config, Exit: make(chan struct{}), DebugChangeSignal: make(chan bool)}
+	settings := &Settings{version: version, config: config, secrets: secrets,
+		Exit: make(chan struct{}), DebugChangeSignal: make(chan bool)}
 	settings.checkMutex()
+	if !migrated {
+		// rewrite config.json without workspaces at once, otherwise they would be migrated again over newer changes
+		// if the app exits before the writer runs
+		settings.mu.RLock()
+		err := settings.writeConfig()
+		settings.mu.RUnlock()
+		if err != nil {
+			util.GracefulPanic(err)
+		}
+	}
 	go settings.writer()
 	go settings.mutexWriter()
 	return settings
 }
+
+// GetConfig returns the config with secrets masked, which are revealed by RevealOpenAIKey
 func (o *Settings) GetConfig() Config {
 	o.mu.RLock()
 	defer o.mu.RUnlock()
-	return o.config
+	return maskConfig(o.config)
 }
+
+// SetConfig saves the config, where secrets left masked and user names cached for cookie profiles keep their values
 func (o *Settings) SetConfig(config Config) {
 	o.mu.Lock()
 	defer o.mu.Unlock()
 	if o.config.Debug != config.Debug {
 		o.DebugChangeSignal <- config.Debug
 	}
+	config = unmaskConfig(config, o.config)
+	config.CookieProfiles = keepCookieProfileUsers(config.CookieProfiles, o.config.CookieProfiles)
 	o.config = config
 	o.version++
 }
+
+// marshalConfig returns the config to be written to disk, with the secrets encrypted by the encrypter
+func (o *Settings) marshalConfig(secrets util.SecretEncrypter) ([]byte, error) {
+	config, err := encryptConfig(o.config, secrets)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(&config, "", "  ")
+}
+
+// writeConfig writes config.json readable only by the user, and must be called with the lock held
+func (o *Settings) writeConfig() error {
+	v, err := o.marshalConfig(o.secrets)
+	if err != nil {
+		return err
+	}
+	_ = os.Rename(util.WithPath("config.json"), util.WithPath("config.json.old"))
+	err = os.WriteFile(util.WithPath("config.json"), v, 0600)
+	if err != nil {
+		return err
+	}
+	_ = os.Remove(util.WithPath("config.json.old"))
+	return nil
+}
 func (o *Settings) writer() {
 	localVersion := 0
 WriterFor:
 	for {
 		o.mu.RLock()
 		if o.version > localVersion {
-			v, err := json.MarshalIndent(&o.config, "", "  ")
-			if err != nil {
-				util.GracefulPanic(err)
-			}
-			_ = os.Rename(util.WithPath("config.json"), util.WithPath("config.json.old"))
-			err = os.WriteFile(util.WithPath("config.json"), v, 0644)
+			err := o.writeConfig()
 			if err != nil {
 				util.GracefulPanic(err)
 			}
-			_ = os.Remove(util.WithPath("config.json.old"))
 			localVersion = o.version
 		}
 		o.mu.RUnlock()
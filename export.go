@@ -0,0 +1,484 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/flytam/filenamify"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sydneyqt/sydney"
+	"sydneyqt/util"
+	"time"
+)
+
+// workspaceArchiveFormat marks the lossless JSON export, which is recognized by ImportWorkspaces
+const workspaceArchiveFormat = "sydneyqt-workspace"
+
+type ExportFormat struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Extension string `json:"extension"` // without the dot
+}
+
+// imageEmbedder returns the image as a data URL, or the URL itself if the image cannot be fetched
+type imageEmbedder func(url string) string
+
+// WorkspaceExporter writes a workspace in one format. Exporters are registered in workspaceExporters.
+type WorkspaceExporter interface {
+	Format() ExportFormat
+	Export(workspace Workspace, embedImage imageEmbedder) ([]byte, error)
+}
+
+var workspaceExporters = []WorkspaceExporter{
+	MarkdownExporter{},
+	JSONExporter{},
+	HTMLExporter{},
+	HTMLExporter{Printable: true},
+	TextExporter{},
+}
+
+func findExporter(id string) (WorkspaceExporter, error) {
+	if id == "" {
+		id = "markdown"
+	}
+	exporter, ok := lo.Find(workspaceExporters, func(item WorkspaceExporter) bool {
+		return item.Format().ID == id
+	})
+	if !ok {
+		return nil, errors.New("export format not exist: " + id)
+	}
+	return exporter, nil
+}
+
+// exportMessages returns the messages of the context followed by the unsent input
+func exportMessages(workspace Workspace) []util.ChatMessage {
+	messages := util.GetChatMessage(workspace.Context)
+	input := strings.TrimSpace(workspace.Input)
+	if input != "" {
+		messages = append(messages, util.ChatMessage{Role: "user", Type: "message", Content: input})
+	}
+	return messages
+}
+
+func findDataReference(workspace Workspace, uuid string) (DataReference, bool) {
+	return lo.Find(workspace.DataReferences, func(item DataReference) bool {
+		return item.UUID == strings.TrimSpace(uuid)
+	})
+}
+
+// decodeDataReference converts the data of a reference, which is a map after being read from JSON
+func decodeDataReference[T any](dataReference DataReference) (T, error) {
+	var result T
+	v, err := json.Marshal(dataReference.Data)
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(v, &result)
+	return result, err
+}
+
+type MarkdownExporter struct{}
+
+func (MarkdownExporter) Format() ExportFormat {
+	return ExportFormat{ID: "markdown", Name: "Markdown", Extension: "md"}
+}
+
+func (MarkdownExporter) Export(workspace Workspace, embedImage imageEmbedder) ([]byte, error) {
+	var out bytes.Buffer
+	for _, msg := range exportMessages(workspace) {
+		out.WriteString(fmt.Sprintf("# \\[%s\\](#%s)\n%s\n\n", msg.Role, msg.Type, msg.Content))
+	}
+	return out.Bytes(), nil
+}
+
+// workspaceArchive is the lossless JSON export of a workspace
+type workspaceArchive struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	Workspace  Workspace `json:"workspace"`
+}
+
+type JSONExporter struct{}
+
+func (JSONExporter) Format() ExportFormat {
+	return ExportFormat{ID: "json", Name: "JSON (Lossless)", Extension: "json"}
+}
+
+func (JSONExporter) Export(workspace Workspace, embedImage imageEmbedder) ([]byte, error) {
+	return json.MarshalIndent(workspaceArchive{
+		Format:     workspaceArchiveFormat,
+		Version:    1,
+		ExportedAt: time.Now(),
+		Workspace:  workspace,
+	}, "", "  ")
+}
+
+type TextExporter struct{}
+
+func (TextExporter) Format() ExportFormat {
+	return ExportFormat{ID: "text", Name: "Plain Text", Extension: "txt"}
+}
+
+func (TextExporter) Export(workspace Workspace, embedImage imageEmbedder) ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString(workspace.Title + "\n")
+	out.WriteString(workspace.CreatedAt.Format("2006-01-02 15:04") + "\n\n")
+	for _, msg := range exportMessages(workspace) {
+		out.WriteString(strings.ToUpper(msg.Role) + " (" + msg.Type + "):\n")
+		if msg.Type != "rich_data_reference" {
+			out.WriteString(msg.Content + "\n\n")
+			continue
+		}
+		dataReference, ok := findDataReference(workspace, msg.Content)
+		switch {
+		case !ok:
+			out.WriteString("(missing data reference)\n\n")
+		case dataReference.Type == "image":
+			image, err := decodeDataReference[sydney.GenerateImageResult](dataReference)
+			if err != nil {
+				return nil, err
+			}
+			out.WriteString("Generated images of " + image.Text + "\n" + strings.Join(image.ImageURLs, "\n") + "\n\n")
+		case dataReference.Type == "music":
+			music, err := decodeDataReference[sydney.GenerateMusicResult](dataReference)
+			if err != nil {
+				return nil, err
+			}
+			out.WriteString("Generated music " + music.Title + "\n" + music.AudioURL + "\n\n")
+		default:
+			out.WriteString("(" + dataReference.Type + ")\n\n")
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// HTMLExporter writes a self-contained page with rendered Markdown, highlighted code and embedded images.
+// The printable page expands instructions and is laid out for printing to PDF.
+type HTMLExporter struct {
+	Printable bool
+}
+
+func (o HTMLExporter) Format() ExportFormat {
+	if o.Printable {
+		return ExportFormat{ID: "print_html", Name: "HTML (Print to PDF)", Extension: "html"}
+	}
+	return ExportFormat{ID: "html", Name: "HTML", Extension: "html"}
+}
+
+type htmlMessage struct {
+	Role      string
+	Type      string
+	Collapsed bool
+	Body      template.HTML
+}
+type htmlPage struct {
+	Title     string
+	Printable bool
+	Metadata  [][2]string
+	Messages  []htmlMessage
+}
+
+var markdownRenderer = goldmark.New(
+	goldmark.WithExtensions(
+		extension.GFM,
+		highlighting.NewHighlighting(
+			highlighting.WithStyle("github"),
+			highlighting.WithFormatOptions(chromahtml.WithLineNumbers(false)),
+		),
+	),
+)
+
+var htmlImagesTemplate = template.Must(template.New("images").Parse(
+	`<p class="caption">{{.Caption}}</p><div class="images">{{range .Images}}<img src="{{.}}" alt="">{{end}}</div>`))
+var htmlMusicTemplate = template.Must(template.New("music").Parse(
+	`<p class="caption">{{.Title}}</p>{{if .Cover}}<img class="cover" src="{{.Cover}}" alt="">{{end}}` +
+		`<p><a href="{{.Audio}}">{{.Audio}}</a></p><pre class="lyrics">{{.Lyrics}}</pre>`))
+
+var htmlPageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Roboto, "Helvetica Neue", Arial, sans-serif; line-height: 1.6;
+  max-width: 900px; margin: 0 auto; padding: 24px; color: #1f2328; }
+h1.title { margin-bottom: 4px; }
+table.metadata td { padding: 0 16px 0 0; color: #59636e; font-size: 0.9em; }
+.message { border-top: 1px solid #d1d9e0; padding: 12px 0; }
+.role { font-weight: bold; text-transform: uppercase; }
+.type { color: #999; font-size: 0.85em; margin-left: 8px; }
+pre { padding: 12px; border-radius: 6px; overflow-x: auto; background: #f6f8fa; }
+code { font-family: ui-monospace, Consolas, monospace; font-size: 0.9em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #d1d9e0; padding: 4px 8px; }
+img { max-width: 100%; }
+.images img { width: 240px; height: 240px; object-fit: cover; margin: 0 8px 8px 0; border-radius: 6px; }
+.cover { width: 240px; border-radius: 6px; }
+.caption { color: #59636e; font-style: italic; }
+.lyrics { white-space: pre-wrap; background: none; padding: 0; }
+{{if .Printable}}
+body { max-width: none; padding: 0; font-size: 11pt; }
+pre { white-space: pre-wrap; word-break: break-word; }
+.message, pre, img { break-inside: avoid; }
+@page { margin: 2cm; }
+{{end}}
+@media print { a { color: inherit; } }
+</style>
+</head>
+<body>
+<h1 class="title">{{.Title}}</h1>
+<table class="metadata">{{range .Metadata}}<tr><td>{{index . 0}}</td><td>{{index . 1}}</td></tr>{{end}}</table>
+{{range .Messages}}<div class="message">
+<div><span class="role">{{.Role}}</span><span class="type">{{.Type}}</span></div>
+{{if .Collapsed}}<details><summary>Show instructions</summary>{{.Body}}</details>{{else}}{{.Body}}{{end}}
+</div>
+{{end}}</body>
+</html>
+`))
+
+func (o HTMLExporter) renderDataReference(workspace Workspace, uuid string, embedImage imageEmbedder) (template.HTML, error) {
+	dataReference, ok := findDataReference(workspace, uuid)
+	if !ok {
+		return "<p><i>Undefined UUID</i></p>", nil
+	}
+	var out bytes.Buffer
+	switch dataReference.Type {
+	case "image":
+		image, err := decodeDataReference[sydney.GenerateImageResult](dataReference)
+		if err != nil {
+			return "", err
+		}
+		err = htmlImagesTemplate.Execute(&out, map[string]any{
+			"Caption": "Prompt: " + image.Text,
+			"Images": lo.Map(image.ImageURLs, func(item string, index int) template.URL {
+				return template.URL(embedImage(item))
+			}),
+		})
+		if err != nil {
+			return "", err
+		}
+	case "music":
+		music, err := decodeDataReference[sydney.GenerateMusicResult](dataReference)
+		if err != nil {
+			return "", err
+		}
+		err = htmlMusicTemplate.Execute(&out, map[string]any{
+			"Title":  music.Title,
+			"Cover":  lo.Ternary(music.CoverImgURL == "", "", template.URL(embedImage(music.CoverImgURL))),
+			"Audio":  music.AudioURL,
+			"Lyrics": music.Lyrics,
+		})
+		if err != nil {
+			return "", err
+		}
+	default:
+		out.WriteString("<p><i>Undefined data reference type: " + template.HTMLEscapeString(dataReference.Type) + "</i></p>")
+	}
+	return template.HTML(out.String()), nil
+}
+
+func (o HTMLExporter) Export(workspace Workspace, embedImage imageEmbedder) ([]byte, error) {
+	page := htmlPage{
+		Title:     workspace.Title,
+		Printable: o.Printable,
+		Metadata: [][2]string{
+			{"Created", workspace.CreatedAt.Format("2006-01-02 15:04")},
+			{"Backend", workspace.Backend},
+			{"Preset", workspace.Preset},
+		},
+	}
+	if workspace.Backend == "Sydney" {
+		page.Metadata = append(page.Metadata, [2]string{"Conversation Style", workspace.ConversationStyle},
+			[2]string{"Locale", workspace.Locale})
+	}
+	for _, msg := range exportMessages(workspace) {
+		message := htmlMessage{
+			Role:      msg.Role,
+			Type:      msg.Type,
+			Collapsed: !o.Printable && strings.Contains(msg.Type, "instructions"),
+		}
+		if msg.Type == "rich_data_reference" {
+			body, err := o.renderDataReference(workspace, msg.Content, embedImage)
+			if err != nil {
+				return nil, err
+			}
+			message.Body = body
+		} else {
+			var body bytes.Buffer
+			err := markdownRenderer.Convert([]byte(msg.Content), &body)
+			if err != nil {
+				return nil, err
+			}
+			message.Body = template.HTML(body.String())
+		}
+		page.Messages = append(page.Messages, message)
+	}
+	var out bytes.Buffer
+	err := htmlPageTemplate.Execute(&out, page)
+	return out.Bytes(), err
+}
+
+// newImageEmbedder fetches images through the proxy, and links to those which cannot be fetched
+func (a *App) newImageEmbedder() (imageEmbedder, error) {
+	_, client, err := util.MakeHTTPClient(a.settings.config.Proxy, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	cache := map[string]string{}
+	return func(url string) string {
+		if result, ok := cache[url]; ok {
+			return result
+		}
+		result := url
+		resp, err := client.R().Get(url)
+		if err == nil && resp.IsSuccessState() {
+			data := resp.Bytes()
+			result = "data:" + http.DetectContentType(data) + ";base64," + base64.StdEncoding.EncodeToString(data)
+		} else {
+			slog.Warn("Cannot embed image", "url", url, "err", err)
+		}
+		cache[url] = result
+		return result
+	}, nil
+}
+
+func exportFilename(workspace Workspace, format ExportFormat) (string, error) {
+	return filenamify.FilenamifyV2(workspace.Title + "." + format.Extension)
+}
+
+// ListExportFormats returns the formats which ExportWorkspace and ExportWorkspaces accept
+func (a *App) ListExportFormats() []ExportFormat {
+	return lo.Map(workspaceExporters, func(item WorkspaceExporter, index int) ExportFormat {
+		return item.Format()
+	})
+}
+
+// ExportWorkspace asks for a destination and writes the workspace in the format, which is Markdown if empty
+func (a *App) ExportWorkspace(id int, formatID string) error {
+	exporter, err := findExporter(formatID)
+	if err != nil {
+		return err
+	}
+	format := exporter.Format()
+	workspace, err := a.workspaces.GetWorkspace(id)
+	if err != nil {
+		return err
+	}
+	fn, err := exportFilename(workspace, format)
+	if err != nil {
+		return err
+	}
+	filePath, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title: "Choose a destination to save the chat",
+		Filters: []runtime.FileFilter{{
+			DisplayName: format.Name + " Files (*." + format.Extension + ")",
+			Pattern:     "*." + format.Extension,
+		}},
+		CanCreateDirectories: true,
+		DefaultFilename:      fn,
+	})
+	if err != nil {
+		return err
+	}
+	if filePath == "" {
+		return nil
+	}
+	if !strings.HasSuffix(filePath, "."+format.Extension) {
+		filePath += "." + format.Extension
+	}
+	embedImage, err := a.newImageEmbedder()
+	if err != nil {
+		return err
+	}
+	v, err := exporter.Export(workspace, embedImage)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, v, 0644)
+}
+
+// ExportWorkspaces asks for a destination and writes the workspaces in the format into a single zip
+func (a *App) ExportWorkspaces(ids []int, formatID string) error {
+	exporter, err := findExporter(formatID)
+	if err != nil {
+		return err
+	}
+	format := exporter.Format()
+	if len(ids) == 0 {
+		return errors.New("no workspace selected")
+	}
+	filePath, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title: "Choose a destination to save the chats",
+		Filters: []runtime.FileFilter{{
+			DisplayName: "Zip Files (*.zip)",
+			Pattern:     "*.zip",
+		}},
+		CanCreateDirectories: true,
+		DefaultFilename:      "workspaces.zip",
+	})
+	if err != nil {
+		return err
+	}
+	if filePath == "" {
+		return nil
+	}
+	if !strings.HasSuffix(filePath, ".zip") {
+		filePath += ".zip"
+	}
+	embedImage, err := a.newImageEmbedder()
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	zipWriter := zip.NewWriter(&out)
+	usedNames := map[string]bool{}
+	for _, id := range ids {
+		workspace, err := a.workspaces.GetWorkspace(id)
+		if err != nil {
+			return err
+		}
+		fn, err := exportFilename(workspace, format)
+		if err != nil {
+			return err
+		}
+		// workspaces may share a title
+		name := fn
+		for i := 2; usedNames[name]; i++ {
+			name = strings.TrimSuffix(fn, "."+format.Extension) + " (" + strconv.Itoa(i) + ")." + format.Extension
+		}
+		usedNames[name] = true
+		v, err := exporter.Export(workspace, embedImage)
+		if err != nil {
+			return err
+		}
+		w, err := zipWriter.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: workspace.CreatedAt})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(v)
+		if err != nil {
+			return err
+		}
+	}
+	err = zipWriter.Close()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, out.Bytes(), 0644)
+}
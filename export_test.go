@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func exportedWorkspace() Workspace {
+	return Workspace{
+		ID:    7,
+		Title: "Cats",
+		Context: "[system](#additional_instructions)\nBe brief.\n\n[user](#message)\nDraw a cat\n\n" +
+			"[assistant](#rich_data_reference)\nuuid-1\n\n",
+		Input:             "And a dog",
+		Backend:           "Sydney",
+		Locale:            "en-US",
+		Preset:            "Sydney",
+		ConversationStyle: "Creative",
+		CreatedAt:         time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Plugins:           []string{"Suno"},
+		DataReferences: []DataReference{{UUID: "uuid-1", Type: "image", Data: map[string]any{
+			"text":       "a cat",
+			"url":        "https://www.bing.com/images/create/a-cat",
+			"image_urls": []any{"https://th.bing.com/1.jpg", "https://th.bing.com/2.jpg"},
+			"duration":   float64(0),
+		}}},
+	}
+}
+
+// noEmbedding keeps the URLs of images
+func noEmbedding(url string) string {
+	return url
+}
+
+func TestJSONExportRoundTrip(t *testing.T) {
+	workspace := exportedWorkspace()
+	v, err := JSONExporter{}.Export(workspace, noEmbedding)
+	assert.NoError(t, err)
+	format, parsed, err := parseImportData("Cats.json", v, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "json", format)
+	assert.Len(t, parsed, 1)
+	assert.Equal(t, workspace, *parsed[0].workspace)
+	assert.Equal(t, workspace.Title, parsed[0].title)
+	assert.Equal(t, workspace.CreatedAt, parsed[0].createdAt)
+	assert.Len(t, parsed[0].messages, 3)
+
+	store, err := NewWorkspaceStore(t.TempDir())
+	assert.NoError(t, err)
+	defer store.close()
+	imported, err := store.addImportedWorkspaces(parsed, Workspace{}, false)
+	assert.NoError(t, err)
+	saved, err := store.GetWorkspace(imported[0].WorkspaceID)
+	assert.NoError(t, err)
+	workspace.ID = imported[0].WorkspaceID
+	assert.Equal(t, workspace, saved)
+}
+
+func TestMarkdownExportRoundTrip(t *testing.T) {
+	workspace := exportedWorkspace()
+	v, err := MarkdownExporter{}.Export(workspace, noEmbedding)
+	assert.NoError(t, err)
+	format, parsed, err := parseImportData("Cats.md", v, workspace.CreatedAt)
+	assert.NoError(t, err)
+	assert.Equal(t, "markdown", format)
+	assert.Equal(t, []parsedWorkspace{{title: "Cats", createdAt: workspace.CreatedAt,
+		messages: exportMessages(workspace)}}, parsed)
+	assert.Equal(t, "And a dog", parsed[0].messages[3].Content)
+}
+
+func TestTextExport(t *testing.T) {
+	v, err := TextExporter{}.Export(exportedWorkspace(), noEmbedding)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"Cats",
+		"2024-01-02 03:04",
+		"",
+		"SYSTEM (additional_instructions):",
+		"Be brief.",
+		"",
+		"USER (message):",
+		"Draw a cat",
+		"",
+		"ASSISTANT (rich_data_reference):",
+		"Generated images of a cat",
+		"https://th.bing.com/1.jpg",
+		"https://th.bing.com/2.jpg",
+		"",
+		"USER (message):",
+		"And a dog",
+		"",
+		"",
+	}, "\n"), string(v))
+}
+
+func TestFindExporter(t *testing.T) {
+	exporter, err := findExporter("")
+	assert.NoError(t, err)
+	assert.Equal(t, "markdown", exporter.Format().ID)
+	exporter, err = findExporter("print_html")
+	assert.NoError(t, err)
+	assert.Equal(t, HTMLExporter{Printable: true}, exporter)
+	_, err = findExporter("pdf")
+	assert.Error(t, err)
+}
@@ -1,2 +1,3 @@
 export const AskTypeSydney = 1
-export const AskTypeOpenAI = 2
\ No newline at end of file
+export const AskTypeOpenAI = 2
+export const AskTypeBackend = 3
\ No newline at end of file
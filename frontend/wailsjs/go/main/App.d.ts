@@ -10,9 +10,19 @@ export function CheckUpdate():Promise<main.CheckUpdateResult>;
 
 export function CountToken(arg1:string):Promise<number>;
 
+export function DeleteProfileCookies(arg1:string):Promise<void>;
+
+export function DiffVersions(arg1:number,arg2:number,arg3:number):Promise<Array<main.MessageDiff>>;
+
 export function Dummy1():Promise<main.ChatFinishResult>;
 
-export function ExportWorkspace(arg1:number):Promise<void>;
+export function Dummy2():Promise<main.ChatModelInfo>;
+
+export function Dummy3():Promise<util.ToolCallBlock>;
+
+export function ExportWorkspace(arg1:number,arg2:string):Promise<void>;
+
+export function ExportWorkspaces(arg1:Array<number>,arg2:string):Promise<void>;
 
 export function FetchWebpage(arg1:string):Promise<main.FetchWebpageResult>;
 
@@ -20,22 +30,44 @@ export function GenerateImage(arg1:sydney.GenerativeImage):Promise<sydney.Genera
 
 export function GenerateMusic(arg1:sydney.GenerativeMusic):Promise<sydney.GenerateMusicResult>;
 
+export function GetBranchMessages(arg1:number,arg2:number):Promise<Array<main.MessageNode>>;
+
 export function GetConciseAnswer(arg1:main.ConciseAnswerReq):Promise<string>;
 
+export function GetProfileUser(arg1:string):Promise<string>;
+
 export function GetUser():Promise<string>;
 
 export function GetYoutubeTranscript(arg1:util.YtCustomCaption):Promise<Array<util.YtTranscriptText>>;
 
 export function GetYoutubeVideo(arg1:string):Promise<main.YoutubeVideoResult>;
 
+export function ImportWorkspaces(arg1:string,arg2:boolean):Promise<main.WorkspaceImportResult>;
+
+export function ListBranches(arg1:number):Promise<Array<main.WorkspaceBranch>>;
+
+export function ListExportFormats():Promise<Array<main.ExportFormat>>;
+
+export function ListLocalTools():Promise<Array<main.LocalToolInfo>>;
+
+export function RestoreVersion(arg1:number,arg2:number):Promise<main.Workspace>;
+
 export function SaveRemoteFile(arg1:string,arg2:string,arg3:string):Promise<void>;
 
 export function SaveRemoteJPEGImage(arg1:string):Promise<void>;
 
+export function SearchWorkspaces(arg1:main.WorkspaceSearchQuery):Promise<Array<main.WorkspaceSearchResult>>;
+
+export function SelectImportFile():Promise<string>;
+
 export function SelectUploadFile():Promise<string>;
 
+export function SetProfileCookies(arg1:string,arg2:string):Promise<void>;
+
 export function ShareWorkspace(arg1:number):Promise<void>;
 
+export function SwitchBranch(arg1:number,arg2:number):Promise<main.Workspace>;
+
 export function UploadDocument():Promise<main.UploadSydneyDocumentResult>;
 
 export function UploadSydneyImage():Promise<main.UploadSydneyImageResult>;
@@ -14,12 +14,32 @@ export function CountToken(arg1) {
   return window['go']['main']['App']['CountToken'](arg1);
 }
 
+export function DeleteProfileCookies(arg1) {
+  return window['go']['main']['App']['DeleteProfileCookies'](arg1);
+}
+
+export function DiffVersions(arg1, arg2, arg3) {
+  return window['go']['main']['App']['DiffVersions'](arg1, arg2, arg3);
+}
+
 export function Dummy1() {
   return window['go']['main']['App']['Dummy1']();
 }
 
-export function ExportWorkspace(arg1) {
-  return window['go']['main']['App']['ExportWorkspace'](arg1);
+export function Dummy2() {
+  return window['go']['main']['App']['Dummy2']();
+}
+
+export function Dummy3() {
+  return window['go']['main']['App']['Dummy3']();
+}
+
+export function ExportWorkspace(arg1, arg2) {
+  return window['go']['main']['App']['ExportWorkspace'](arg1, arg2);
+}
+
+export function ExportWorkspaces(arg1, arg2) {
+  return window['go']['main']['App']['ExportWorkspaces'](arg1, arg2);
 }
 
 export function FetchWebpage(arg1) {
@@ -34,10 +54,18 @@ export function GenerateMusic(arg1) {
   return window['go']['main']['App']['GenerateMusic'](arg1);
 }
 
+export function GetBranchMessages(arg1, arg2) {
+  return window['go']['main']['App']['GetBranchMessages'](arg1, arg2);
+}
+
 export function GetConciseAnswer(arg1) {
   return window['go']['main']['App']['GetConciseAnswer'](arg1);
 }
 
+export function GetProfileUser(arg1) {
+  return window['go']['main']['App']['GetProfileUser'](arg1);
+}
+
 export function GetUser() {
   return window['go']['main']['App']['GetUser']();
 }
@@ -50,6 +78,26 @@ export function GetYoutubeVideo(arg1) {
   return window['go']['main']['App']['GetYoutubeVideo'](arg1);
 }
 
+export function ImportWorkspaces(arg1, arg2) {
+  return window['go']['main']['App']['ImportWorkspaces'](arg1, arg2);
+}
+
+export function ListBranches(arg1) {
+  return window['go']['main']['App']['ListBranches'](arg1);
+}
+
+export function ListExportFormats() {
+  return window['go']['main']['App']['ListExportFormats']();
+}
+
+export function ListLocalTools() {
+  return window['go']['main']['App']['ListLocalTools']();
+}
+
+export function RestoreVersion(arg1, arg2) {
+  return window['go']['main']['App']['RestoreVersion'](arg1, arg2);
+}
+
 export function SaveRemoteFile(arg1, arg2, arg3) {
   return window['go']['main']['App']['SaveRemoteFile'](arg1, arg2, arg3);
 }
@@ -58,14 +106,30 @@ export function SaveRemoteJPEGImage(arg1) {
   return window['go']['main']['App']['SaveRemoteJPEGImage'](arg1);
 }
 
+export function SearchWorkspaces(arg1) {
+  return window['go']['main']['App']['SearchWorkspaces'](arg1);
+}
+
+export function SelectImportFile() {
+  return window['go']['main']['App']['SelectImportFile']();
+}
+
 export function SelectUploadFile() {
   return window['go']['main']['App']['SelectUploadFile']();
 }
 
+export function SetProfileCookies(arg1, arg2) {
+  return window['go']['main']['App']['SetProfileCookies'](arg1, arg2);
+}
+
 export function ShareWorkspace(arg1) {
   return window['go']['main']['App']['ShareWorkspace'](arg1);
 }
 
+export function SwitchBranch(arg1, arg2) {
+  return window['go']['main']['App']['SwitchBranch'](arg1, arg2);
+}
+
 export function UploadDocument() {
   return window['go']['main']['App']['UploadDocument']();
 }
@@ -4,4 +4,14 @@ import {main} from '../models';
 
 export function GetConfig():Promise<main.Config>;
 
+export function GetSecretMode():Promise<string>;
+
+export function RevealBackendKey(arg1:string):Promise<string>;
+
+export function RevealOpenAIKey(arg1:string):Promise<string>;
+
 export function SetConfig(arg1:main.Config):Promise<void>;
+
+export function SetSecretPassphrase(arg1:string):Promise<void>;
+
+export function UseKeyringForSecrets():Promise<void>;
@@ -6,6 +6,26 @@ export function GetConfig() {
   return window['go']['main']['Settings']['GetConfig']();
 }
 
+export function GetSecretMode() {
+  return window['go']['main']['Settings']['GetSecretMode']();
+}
+
+export function RevealBackendKey(arg1) {
+  return window['go']['main']['Settings']['RevealBackendKey'](arg1);
+}
+
+export function RevealOpenAIKey(arg1) {
+  return window['go']['main']['Settings']['RevealOpenAIKey'](arg1);
+}
+
 export function SetConfig(arg1) {
   return window['go']['main']['Settings']['SetConfig'](arg1);
 }
+
+export function SetSecretPassphrase(arg1) {
+  return window['go']['main']['Settings']['SetSecretPassphrase'](arg1);
+}
+
+export function UseKeyringForSecrets() {
+  return window['go']['main']['Settings']['UseKeyringForSecrets']();
+}
@@ -1,8 +1,116 @@
+export namespace backend {
+	
+	export class AnthropicOptions {
+	    key: string;
+	    endpoint: string;
+	    model: string;
+	    max_tokens: number;
+	    temperature: number;
+	    top_p: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new AnthropicOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.key = source["key"];
+	        this.endpoint = source["endpoint"];
+	        this.model = source["model"];
+	        this.max_tokens = source["max_tokens"];
+	        this.temperature = source["temperature"];
+	        this.top_p = source["top_p"];
+	    }
+	}
+	export class Config {
+	    name: string;
+	    type: string;
+	    anthropic: AnthropicOptions;
+	    gemini: GeminiOptions;
+	    ollama: OllamaOptions;
+	
+	    static createFrom(source: any = {}) {
+	        return new Config(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.type = source["type"];
+	        this.anthropic = this.convertValues(source["anthropic"], AnthropicOptions);
+	        this.gemini = this.convertValues(source["gemini"], GeminiOptions);
+	        this.ollama = this.convertValues(source["ollama"], OllamaOptions);
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class GeminiOptions {
+	    key: string;
+	    endpoint: string;
+	    model: string;
+	    max_output_tokens: number;
+	    temperature: number;
+	    top_p: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new GeminiOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.key = source["key"];
+	        this.endpoint = source["endpoint"];
+	        this.model = source["model"];
+	        this.max_output_tokens = source["max_output_tokens"];
+	        this.temperature = source["temperature"];
+	        this.top_p = source["top_p"];
+	    }
+	}
+	export class OllamaOptions {
+	    endpoint: string;
+	    model: string;
+	    temperature: number;
+	    num_ctx: number;
+	    keep_alive: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new OllamaOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.endpoint = source["endpoint"];
+	        this.model = source["model"];
+	        this.temperature = source["temperature"];
+	        this.num_ctx = source["num_ctx"];
+	        this.keep_alive = source["keep_alive"];
+	    }
+	}
+
+}
+
 export namespace main {
 	
 	export class AskOptions {
 	    type: number;
 	    openai_backend: string;
+	    backend: string;
 	    chat_context: string;
 	    prompt: string;
 	    image_url: string;
@@ -16,12 +124,29 @@ export namespace main {
 	        if ('string' === typeof source) source = JSON.parse(source);
 	        this.type = source["type"];
 	        this.openai_backend = source["openai_backend"];
+	        this.backend = source["backend"];
 	        this.chat_context = source["chat_context"];
 	        this.prompt = source["prompt"];
 	        this.image_url = source["image_url"];
 	        this.upload_file_path = source["upload_file_path"];
 	    }
 	}
+	export class ChatModelInfo {
+	    model: string;
+	    prompt_tokens: number;
+	    trimmed_messages: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new ChatModelInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.model = source["model"];
+	        this.prompt_tokens = source["prompt_tokens"];
+	        this.trimmed_messages = source["trimmed_messages"];
+	    }
+	}
 	export class ChatFinishResult {
 	    success: boolean;
 	    err_type: string;
@@ -79,6 +204,7 @@ export namespace main {
 	    theme_color_20240304: boolean;
 	    quick_20240326: boolean;
 	    quick_20240405: boolean;
+	    workspaces_20261018: boolean;
 	
 	    static createFrom(source: any = {}) {
 	        return new Migration(source);
@@ -90,6 +216,69 @@ export namespace main {
 	        this.theme_color_20240304 = source["theme_color_20240304"];
 	        this.quick_20240326 = source["quick_20240326"];
 	        this.quick_20240405 = source["quick_20240405"];
+	        this.workspaces_20261018 = source["workspaces_20261018"];
+	    }
+	}
+	export class MessageNode {
+	    id: number;
+	    parent_id: number;
+	    role: string;
+	    type: string;
+	    content: string;
+	    raw?: string;
+	    // Go type: time
+	    created_at: any;
+	    // Go type: time
+	    updated_at: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new MessageNode(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.parent_id = source["parent_id"];
+	        this.role = source["role"];
+	        this.type = source["type"];
+	        this.content = source["content"];
+	        this.raw = source["raw"];
+	        this.created_at = this.convertValues(source["created_at"], null);
+	        this.updated_at = this.convertValues(source["updated_at"], null);
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class CookieProfile {
+	    name: string;
+	    proxy: string;
+	    user_name: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new CookieProfile(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.proxy = source["proxy"];
+	        this.user_name = source["user_name"];
 	    }
 	}
 	export class OpenAIBackend {
@@ -99,6 +288,7 @@ export namespace main {
 	    openai_short_model: string;
 	    openai_long_model: string;
 	    openai_threshold: number;
+	    openai_long_limit: number;
 	    openai_temperature: number;
 	    frequency_penalty: number;
 	    presence_penalty: number;
@@ -116,6 +306,7 @@ export namespace main {
 	        this.openai_short_model = source["openai_short_model"];
 	        this.openai_long_model = source["openai_long_model"];
 	        this.openai_threshold = source["openai_threshold"];
+	        this.openai_long_limit = source["openai_long_limit"];
 	        this.openai_temperature = source["openai_temperature"];
 	        this.frequency_penalty = source["frequency_penalty"];
 	        this.presence_penalty = source["presence_penalty"];
@@ -155,6 +346,7 @@ export namespace main {
 	    persistent_input: boolean;
 	    plugins: string[];
 	    data_references: DataReference[];
+	    cookie_profile: string;
 	
 	    static createFrom(source: any = {}) {
 	        return new Workspace(source);
@@ -177,6 +369,7 @@ export namespace main {
 	        this.persistent_input = source["persistent_input"];
 	        this.plugins = source["plugins"];
 	        this.data_references = this.convertValues(source["data_references"], DataReference);
+	        this.cookie_profile = source["cookie_profile"];
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -227,6 +420,9 @@ export namespace main {
 	    quick: string[];
 	    disable_direct_quick: boolean;
 	    open_ai_backends: OpenAIBackend[];
+	    backends: backend.Config[];
+	    cookie_profiles: CookieProfile[];
+	    tool_approvals: {[key: string]: string};
 	    wss_domain: string;
 	    dark_mode: boolean;
 	    no_image_removal_after_chat: boolean;
@@ -259,6 +455,9 @@ export namespace main {
 	        this.quick = source["quick"];
 	        this.disable_direct_quick = source["disable_direct_quick"];
 	        this.open_ai_backends = this.convertValues(source["open_ai_backends"], OpenAIBackend);
+	        this.backends = this.convertValues(source["backends"], backend.Config);
+	        this.cookie_profiles = this.convertValues(source["cookie_profiles"], CookieProfile);
+	        this.tool_approvals = source["tool_approvals"];
 	        this.wss_domain = source["wss_domain"];
 	        this.dark_mode = source["dark_mode"];
 	        this.no_image_removal_after_chat = source["no_image_removal_after_chat"];
@@ -290,6 +489,74 @@ export namespace main {
 		}
 	}
 	
+	export class DiffLine {
+	    op: string;
+	    text: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new DiffLine(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.op = source["op"];
+	        this.text = source["text"];
+	    }
+	}
+	export class MessageDiff {
+	    index: number;
+	    kind: string;
+	    role: string;
+	    type: string;
+	    lines: DiffLine[];
+	
+	    static createFrom(source: any = {}) {
+	        return new MessageDiff(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.index = source["index"];
+	        this.kind = source["kind"];
+	        this.role = source["role"];
+	        this.type = source["type"];
+	        this.lines = this.convertValues(source["lines"], DiffLine);
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class ExportFormat {
+	    id: string;
+	    name: string;
+	    extension: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ExportFormat(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.name = source["name"];
+	        this.extension = source["extension"];
+	    }
+	}
 	export class FetchWebpageResult {
 	    title: string;
 	    content: string;
@@ -306,6 +573,34 @@ export namespace main {
 	}
 	
 	
+	export class LocalToolInfo {
+	    name: string;
+	    description: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new LocalToolInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.description = source["description"];
+	    }
+	}
+	export class SearchSnippetPart {
+	    text: string;
+	    highlight: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new SearchSnippetPart(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.text = source["text"];
+	        this.highlight = source["highlight"];
+	    }
+	}
 	
 	export class UploadSydneyDocumentResult {
 	    canceled?: boolean;
@@ -339,6 +634,221 @@ export namespace main {
 	        this.canceled = source["canceled"];
 	    }
 	}
+	export class WorkspaceBranch {
+	    leaf_id: number;
+	    current: boolean;
+	    message_count: number;
+	    fork_index: number;
+	    // Go type: time
+	    updated_at: any;
+	    preview: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new WorkspaceBranch(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.leaf_id = source["leaf_id"];
+	        this.current = source["current"];
+	        this.message_count = source["message_count"];
+	        this.fork_index = source["fork_index"];
+	        this.updated_at = this.convertValues(source["updated_at"], null);
+	        this.preview = source["preview"];
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class ImportedWorkspace {
+	    workspace_id: number;
+	    title: string;
+	    // Go type: time
+	    created_at: any;
+	    message_count: number;
+	    duplicate_of: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new ImportedWorkspace(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.workspace_id = source["workspace_id"];
+	        this.title = source["title"];
+	        this.created_at = this.convertValues(source["created_at"], null);
+	        this.message_count = source["message_count"];
+	        this.duplicate_of = source["duplicate_of"];
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class WorkspaceImportResult {
+	    format: string;
+	    dry_run: boolean;
+	    workspaces: ImportedWorkspace[];
+	
+	    static createFrom(source: any = {}) {
+	        return new WorkspaceImportResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.format = source["format"];
+	        this.dry_run = source["dry_run"];
+	        this.workspaces = this.convertValues(source["workspaces"], ImportedWorkspace);
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class WorkspaceSearchMatch {
+	    role: string;
+	    type: string;
+	    message_index: number;
+	    snippet: SearchSnippetPart[];
+	
+	    static createFrom(source: any = {}) {
+	        return new WorkspaceSearchMatch(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.role = source["role"];
+	        this.type = source["type"];
+	        this.message_index = source["message_index"];
+	        this.snippet = this.convertValues(source["snippet"], SearchSnippetPart);
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class WorkspaceSearchQuery {
+	    text: string;
+	    roles: string[];
+	    from: string;
+	    to: string;
+	    backend: string;
+	    preset: string;
+	    limit: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new WorkspaceSearchQuery(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.text = source["text"];
+	        this.roles = source["roles"];
+	        this.from = source["from"];
+	        this.to = source["to"];
+	        this.backend = source["backend"];
+	        this.preset = source["preset"];
+	        this.limit = source["limit"];
+	    }
+	}
+	export class WorkspaceSearchResult {
+	    workspace_id: number;
+	    title: string;
+	    // Go type: time
+	    created_at: any;
+	    backend: string;
+	    preset: string;
+	    matches: WorkspaceSearchMatch[];
+	
+	    static createFrom(source: any = {}) {
+	        return new WorkspaceSearchResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.workspace_id = source["workspace_id"];
+	        this.title = source["title"];
+	        this.created_at = this.convertValues(source["created_at"], null);
+	        this.backend = source["backend"];
+	        this.preset = source["preset"];
+	        this.matches = this.convertValues(source["matches"], WorkspaceSearchMatch);
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 	
 	export class YoutubeVideoDetails {
 	    title: string;
@@ -484,6 +994,24 @@ export namespace sydney {
 
 export namespace util {
 	
+	export class ToolCallBlock {
+	    id: string;
+	    name: string;
+	    arguments: string;
+	    result: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ToolCallBlock(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.name = source["name"];
+	        this.arguments = source["arguments"];
+	        this.result = source["result"];
+	    }
+	}
 	export class YtCustomCaption {
 	    name: string;
 	    language_code: string;
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sydneyqt/util"
+	"time"
+	"unicode"
+)
+
+// historyDraftWindow is how long the latest messages may be rewritten in place rather than branched,
+// so that streamed replies and typing do not leave a version for every change
+const historyDraftWindow = time.Minute
+
+// maxLineDiffCells limits the size of the table of a line diff, beyond which changed messages are replaced as a whole
+const maxLineDiffCells = 1 << 20
+
+type MessageNode struct {
+	ID        int       `json:"id"`
+	ParentID  int       `json:"parent_id"` // 0 for the first messages
+	Role      string    `json:"role"`
+	Type      string    `json:"type"`
+	Content   string    `json:"content"`
+	Raw       string    `json:"raw,omitempty"` // the exact text in the context, empty for older versions
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WorkspaceHistory is a tree of the messages of a workspace, where the path from the first message to the head
+// is the current context. Editing or regenerating a message creates a sibling of it, which starts a new branch.
+type WorkspaceHistory struct {
+	Nodes    []*MessageNode `json:"nodes"`
+	HeadID   int            `json:"head_id"`
+	NextID   int            `json:"next_id"`
+	nodes    map[int]*MessageNode
+	children map[int][]int
+}
+
+type WorkspaceBranch struct {
+	LeafID       int       `json:"leaf_id"`
+	Current      bool      `json:"current"`
+	MessageCount int       `json:"message_count"`
+	ForkIndex    int       `json:"fork_index"` // the index of the first message which differs from the current branch
+	UpdatedAt    time.Time `json:"updated_at"`
+	Preview      string    `json:"preview"`
+}
+
+type MessageDiff struct {
+	Index int        `json:"index"`
+	Kind  string     `json:"kind"` // equal, changed, added or removed
+	Role  string     `json:"role"`
+	Type  string     `json:"type"`
+	Lines []DiffLine `json:"lines"` // empty for equal messages
+}
+type DiffLine struct {
+	Op   string `json:"op"` // a space, + or -
+	Text string `json:"text"`
+}
+
+func newWorkspaceHistory() *WorkspaceHistory {
+	return &WorkspaceHistory{NextID: 1, nodes: map[int]*MessageNode{}, children: map[int][]int{}}
+}
+
+func (o *WorkspaceHistory) buildIndex() {
+	o.nodes = map[int]*MessageNode{}
+	o.children = map[int][]int{}
+	for _, node := range o.Nodes {
+		o.nodes[node.ID] = node
+		o.children[node.ParentID] = append(o.children[node.ParentID], node.ID)
+	}
+}
+
+// path returns the nodes from the first message to the node
+func (o *WorkspaceHistory) path(id int) []*MessageNode {
+	var result []*MessageNode
+	for node := o.nodes[id]; node != nil; node = o.nodes[node.ParentID] {
+		result = append(result, node)
+	}
+	slices.Reverse(result)
+	return result
+}
+
+func (o *WorkspaceHistory) add(parentID int, message util.ChatMessage, raw string, now time.Time) int {
+	node := &MessageNode{
+		ID:        o.NextID,
+		ParentID:  parentID,
+		Role:      message.Role,
+		Type:      message.Type,
+		Content:   message.Content,
+		Raw:       raw,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	o.NextID++
+	o.Nodes = append(o.Nodes, node)
+	o.nodes[node.ID] = node
+	o.children[parentID] = append(o.children[parentID], node.ID)
+	return node.ID
+}
+
+func (o *WorkspaceHistory) removeDescendants(id int) {
+	removed := map[int]bool{}
+	queue := slices.Clone(o.children[id])
+	for len(queue) > 0 {
+		child := queue[0]
+		queue = append(queue[1:], o.children[child]...)
+		removed[child] = true
+		delete(o.nodes, child)
+		delete(o.children, child)
+	}
+	delete(o.children, id)
+	o.Nodes = slices.DeleteFunc(o.Nodes, func(node *MessageNode) bool {
+		return removed[node.ID]
+	})
+}
+
+// findChild returns the child which is the same message, whose raw text is updated, since trailing whitespace
+// changes as messages are appended, and nodes of older versions have no raw text
+func (o *WorkspaceHistory) findChild(parentID int, message util.ChatMessage, raw string) int {
+	for _, id := range o.children[parentID] {
+		node := o.nodes[id]
+		if node.Role != message.Role || node.Type != message.Type || node.Content != message.Content {
+			continue
+		}
+		if node.Raw == "" || strings.TrimRightFunc(node.Raw, unicode.IsSpace) == strings.TrimRightFunc(raw, unicode.IsSpace) {
+			node.Raw = raw
+			return id
+		}
+	}
+	return 0
+}
+
+// isDraft reports whether the node and its descendants are the tail of the current branch
+// and were changed recently, which are rewritten in place
+func (o *WorkspaceHistory) isDraft(node *MessageNode, now time.Time) bool {
+	if now.Sub(node.UpdatedAt) >= historyDraftWindow {
+		return false
+	}
+	for id := node.ID; ; {
+		children := o.children[id]
+		if len(children) == 0 {
+			return id == o.HeadID
+		}
+		if len(children) > 1 {
+			return false
+		}
+		id = children[0]
+	}
+}
+
+// merge follows the messages of the context down the tree, moves the head to the last of them,
+// and adds the messages which are not in the tree yet
+func (o *WorkspaceHistory) merge(context string, now time.Time) {
+	messages, raws := util.SplitChatContext(context)
+	oldPath := o.path(o.HeadID)
+	parentID := 0
+	for i, message := range messages {
+		if child := o.findChild(parentID, message, raws[i]); child != 0 {
+			parentID = child
+			continue
+		}
+		if i < len(oldPath) && oldPath[i].ParentID == parentID && o.isDraft(oldPath[i], now) {
+			node := oldPath[i]
+			o.removeDescendants(node.ID)
+			node.Role = message.Role
+			node.Type = message.Type
+			node.Content = message.Content
+			node.Raw = raws[i]
+			node.UpdatedAt = now
+			parentID = node.ID
+		} else {
+			parentID = o.add(parentID, message, raws[i], now)
+		}
+		for j := i + 1; j < len(messages); j++ {
+			parentID = o.add(parentID, messages[j], raws[j], now)
+		}
+		break
+	}
+	o.HeadID = parentID
+}
+
+// leaves returns the last nodes of all branches
+func (o *WorkspaceHistory) leaves() []*MessageNode {
+	var result []*MessageNode
+	for _, node := range o.Nodes {
+		if len(o.children[node.ID]) == 0 {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// renderMessages joins the raw texts of messages into the context they were in, while nodes without the raw text
+// are formatted like fromChatMessages of the frontend
+func renderMessages(nodes []*MessageNode) string {
+	var result strings.Builder
+	for _, node := range nodes {
+		if node.Raw != "" {
+			result.WriteString(node.Raw)
+			continue
+		}
+		result.WriteString("[" + node.Role + "](#" + node.Type + ")\n" + node.Content + "\n\n")
+	}
+	return result.String()
+}
+
+// diffLines is a line diff by the longest common subsequence
+func diffLines(a, b string) []DiffLine {
+	aLines, bLines := strings.Split(a, "\n"), strings.Split(b, "\n")
+	var result []DiffLine
+	if len(aLines)*len(bLines) > maxLineDiffCells {
+		return append(prefixLines("-", a), prefixLines("+", b)...)
+	}
+	// lcs[i][j] is the length of the longest common subsequence of aLines[i:] and bLines[j:]
+	lcs := make([][]int, len(aLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bLines)+1)
+	}
+	for i := len(aLines) - 1; i >= 0; i-- {
+		for j := len(bLines) - 1; j >= 0; j-- {
+			if aLines[i] == bLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(aLines) || j < len(bLines) {
+		switch {
+		case i < len(aLines) && j < len(bLines) && aLines[i] == bLines[j]:
+			result = append(result, DiffLine{Op: " ", Text: aLines[i]})
+			i++
+			j++
+		case i < len(aLines) && (j == len(bLines) || lcs[i+1][j] >= lcs[i][j+1]):
+			result = append(result, DiffLine{Op: "-", Text: aLines[i]})
+			i++
+		default:
+			result = append(result, DiffLine{Op: "+", Text: bLines[j]})
+			j++
+		}
+	}
+	return result
+}
+
+func prefixLines(op, text string) []DiffLine {
+	return lo.Map(strings.Split(text, "\n"), func(line string, index int) DiffLine {
+		return DiffLine{Op: op, Text: line}
+	})
+}
+
+func diffPaths(from, to []*MessageNode) []MessageDiff {
+	var result []MessageDiff
+	for i := 0; i < max(len(from), len(to)); i++ {
+		switch {
+		case i >= len(from):
+			result = append(result, MessageDiff{Index: i, Kind: "added", Role: to[i].Role, Type: to[i].Type,
+				Lines: prefixLines("+", to[i].Content)})
+		case i >= len(to):
+			result = append(result, MessageDiff{Index: i, Kind: "removed", Role: from[i].Role, Type: from[i].Type,
+				Lines: prefixLines("-", from[i].Content)})
+		case from[i].ID == to[i].ID:
+			result = append(result, MessageDiff{Index: i, Kind: "equal", Role: to[i].Role, Type: to[i].Type})
+		default:
+			result = append(result, MessageDiff{Index: i, Kind: "changed", Role: to[i].Role, Type: to[i].Type,
+				Lines: diffLines(from[i].Content, to[i].Content)})
+		}
+	}
+	return result
+}
+
+// pendingContext is a context to be merged into the history
+type pendingContext struct {
+	context   string
+	truncated bool
+}
+
+// addPendingContext queues the changed context, where only the latest of consecutive changes is merged,
+// except that truncated contexts, usually revoked replies, are kept so that a regenerated reply is branched
+func (o *WorkspaceStore) addPendingContext(id int, previous, context string) {
+	pending := o.historyPending[id]
+	truncated := len(context) < len(previous) && strings.HasPrefix(previous, context)
+	if len(pending) != 0 && pending[len(pending)-1].truncated == truncated {
+		pending[len(pending)-1].context = context
+		return
+	}
+	o.historyPending[id] = append(pending, pendingContext{context: context, truncated: truncated})
+}
+
+func (o *WorkspaceStore) historyPath(id int) string {
+	return filepath.Join(o.dir, strconv.Itoa(id)+".history.json")
+}
+
+// history returns the history of the workspace, loading it from its file if needed,
+// and must be called with the lock held
+func (o *WorkspaceStore) history(id int) (*WorkspaceHistory, error) {
+	if history, ok := o.histories[id]; ok {
+		return history, nil
+	}
+	history := newWorkspaceHistory()
+	v, err := os.ReadFile(o.historyPath(id))
+	if err == nil {
+		err = json.Unmarshal(v, history)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot parse history of workspace "+strconv.Itoa(id))
+		}
+		history.buildIndex()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	o.histories[id] = history
+	return history, nil
+}
+
+// mergeHistory adds the changed context of the workspace to its history, and must be called with the lock held
+func (o *WorkspaceStore) mergeHistory(id int, now time.Time) error {
+	if len(o.historyPending[id]) == 0 {
+		return nil
+	}
+	history, err := o.history(id)
+	if err != nil {
+		return err
+	}
+	for _, pending := range o.historyPending[id] {
+		history.merge(pending.context, now)
+	}
+	delete(o.historyPending, id)
+	o.historyDirty[id] = true
+	return nil
+}
+
+// workspaceHistory returns the up-to-date history of the workspace, and must be called with the lock held
+func (o *WorkspaceStore) workspaceHistory(id int) (*WorkspaceHistory, error) {
+	if _, ok := o.index[id]; !ok {
+		return nil, errors.New("workspace not exist by id: " + strconv.Itoa(id))
+	}
+	err := o.mergeHistory(id, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return o.history(id)
+}
+
+func (o *WorkspaceStore) listBranches(workspaceID int) ([]WorkspaceBranch, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	history, err := o.workspaceHistory(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	current := history.path(history.HeadID)
+	result := []WorkspaceBranch{}
+	for _, leaf := range history.leaves() {
+		path := history.path(leaf.ID)
+		forkIndex := 0
+		for forkIndex < min(len(path), len(current)) && path[forkIndex].ID == current[forkIndex].ID {
+			forkIndex++
+		}
+		updatedAt := leaf.UpdatedAt
+		for _, node := range path {
+			updatedAt = lo.Ternary(node.UpdatedAt.After(updatedAt), node.UpdatedAt, updatedAt)
+		}
+		result = append(result, WorkspaceBranch{
+			LeafID:       leaf.ID,
+			Current:      slices.ContainsFunc(path, func(node *MessageNode) bool { return node.ID == history.HeadID }),
+			MessageCount: len(path),
+			ForkIndex:    forkIndex,
+			UpdatedAt:    updatedAt,
+			Preview:      string([]rune(leaf.Content)[:min(100, len([]rune(leaf.Content)))]),
+		})
+	}
+	slices.SortFunc(result, func(a, b WorkspaceBranch) int {
+		return b.UpdatedAt.Compare(a.UpdatedAt)
+	})
+	return result, nil
+}
+
+func (o *WorkspaceStore) branchMessages(workspaceID, nodeID int) ([]MessageNode, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	history, err := o.workspaceHistory(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := history.nodes[nodeID]; !ok {
+		return nil, errors.New("message not exist by id: " + strconv.Itoa(nodeID))
+	}
+	result := []MessageNode{}
+	for _, node := range history.path(nodeID) {
+		result = append(result, *node)
+	}
+	return result, nil
+}
+
+// restoreVersion makes the path to the node the context of the workspace
+func (o *WorkspaceStore) restoreVersion(workspaceID, nodeID int) (Workspace, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	history, err := o.workspaceHistory(workspaceID)
+	if err != nil {
+		return Workspace{}, err
+	}
+	if _, ok := history.nodes[nodeID]; !ok {
+		return Workspace{}, errors.New("message not exist by id: " + strconv.Itoa(nodeID))
+	}
+	workspace, err := o.get(workspaceID)
+	if err != nil {
+		return Workspace{}, err
+	}
+	workspace.Context = renderMessages(history.path(nodeID))
+	history.HeadID = nodeID
+	o.historyDirty[workspaceID] = true
+	o.save(workspace)
+	// the context is already in the history
+	delete(o.historyPending, workspaceID)
+	return workspace, nil
+}
+
+func (o *WorkspaceStore) diffVersions(workspaceID, fromNodeID, toNodeID int) ([]MessageDiff, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	history, err := o.workspaceHistory(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range []int{fromNodeID, toNodeID} {
+		if _, ok := history.nodes[id]; !ok && id != 0 {
+			return nil, errors.New("message not exist by id: " + strconv.Itoa(id))
+		}
+	}
+	return diffPaths(history.path(fromNodeID), history.path(toNodeID)), nil
+}
+
+// ListBranches returns the branches of the workspace, the most recently updated first
+func (a *App) ListBranches(workspaceID int) ([]WorkspaceBranch, error) {
+	return a.workspaces.listBranches(workspaceID)
+}
+
+// GetBranchMessages returns the messages from the first one to the node
+func (a *App) GetBranchMessages(workspaceID int, nodeID int) ([]MessageNode, error) {
+	return a.workspaces.branchMessages(workspaceID, nodeID)
+}
+
+// SwitchBranch makes the branch ending with the leaf the context of the workspace, and returns the workspace
+func (a *App) SwitchBranch(workspaceID int, leafID int) (Workspace, error) {
+	return a.workspaces.restoreVersion(workspaceID, leafID)
+}
+
+// RestoreVersion makes the context of the workspace what it was when the node was the last message,
+// which also works for the middle of a branch, and returns the workspace
+func (a *App) RestoreVersion(workspaceID int, nodeID int) (Workspace, error) {
+	return a.workspaces.restoreVersion(workspaceID, nodeID)
+}
+
+// DiffVersions compares the contexts ending with the two nodes message by message,
+// where the node 0 is the empty context
+func (a *App) DiffVersions(workspaceID int, fromNodeID int, toNodeID int) ([]MessageDiff, error) {
+	return a.workspaces.diffVersions(workspaceID, fromNodeID, toNodeID)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryMerge(t *testing.T) {
+	prompt := "[system](#additional_instructions)\nBe brief.\n\n[user](#message)\nHi\n\n"
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	history := newWorkspaceHistory()
+	tests := []struct {
+		name    string
+		context string
+		after   time.Duration
+		nodes   int
+		leaves  int
+	}{
+		{"first messages", prompt, 0, 2, 1},
+		{"streamed reply", prompt + "[assistant](#message)\nHel", 10 * time.Second, 3, 1},
+		{"draft rewritten in place", prompt + "[assistant](#message)\nHello!\n\n", 20 * time.Second, 3, 1},
+		{"edited message branched", "[system](#additional_instructions)\nBe brief.\n\n[user](#message)\nHey\n\n",
+			3 * time.Minute, 4, 2},
+		{"switched back", prompt + "[assistant](#message)\nHello!\n\n", 4 * time.Minute, 4, 2},
+		{"reply revoked", prompt, 5 * time.Minute, 4, 2},
+		{"reply regenerated", prompt + "[assistant](#message)\nHi there!\n\n", 5*time.Minute + 10*time.Second, 5, 3},
+		{"whitespace kept", prompt + "[assistant](#message)\nHi there!\n\n\n", 6 * time.Minute, 5, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history.merge(tt.context, start.Add(tt.after))
+			assert.Len(t, history.Nodes, tt.nodes)
+			assert.Len(t, history.leaves(), tt.leaves)
+			assert.Equal(t, tt.context, renderMessages(history.path(history.HeadID)))
+		})
+	}
+
+	// versions without raw texts are rendered like the frontend does
+	nodes := lo.Map(history.path(history.HeadID), func(item *MessageNode, index int) *MessageNode {
+		node := *item
+		node.Raw = ""
+		return &node
+	})
+	assert.Equal(t, prompt+"[assistant](#message)\nHi there!\n\n", renderMessages(nodes))
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []DiffLine
+	}{
+		{"equal", "a\nb", "a\nb", []DiffLine{{" ", "a"}, {" ", "b"}}},
+		{"changed", "a\nb\nc", "a\nx\nc", []DiffLine{{" ", "a"}, {"-", "b"}, {"+", "x"}, {" ", "c"}}},
+		{"added", "a", "a\nb", []DiffLine{{" ", "a"}, {"+", "b"}}},
+		{"removed", "a\nb", "b", []DiffLine{{"-", "a"}, {" ", "b"}}},
+		{"moved", "a\nb\nc", "c\na\nb", []DiffLine{{"+", "c"}, {" ", "a"}, {" ", "b"}, {"-", "c"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, diffLines(tt.a, tt.b))
+		})
+	}
+}
+
+func TestWorkspaceStoreHistory(t *testing.T) {
+	store, err := NewWorkspaceStore(t.TempDir())
+	assert.NoError(t, err)
+	defer store.close()
+	prompt := "[user](#message)\nHi\n\n"
+	store.SaveWorkspace(Workspace{ID: 1, Title: "Chat", Context: prompt + "[assistant](#message)\nHello!\n\n"})
+	store.SaveWorkspace(Workspace{ID: 1, Title: "Chat", Context: prompt})
+	store.SaveWorkspace(Workspace{ID: 1, Title: "Chat", Context: prompt + "[assistant](#message)\nHi there!\n\n"})
+
+	branches, err := store.listBranches(1)
+	assert.NoError(t, err)
+	assert.Len(t, branches, 2)
+	current, _ := lo.Find(branches, func(item WorkspaceBranch) bool { return item.Current })
+	other, _ := lo.Find(branches, func(item WorkspaceBranch) bool { return !item.Current })
+	assert.Equal(t, "Hi there!", current.Preview)
+	assert.Equal(t, "Hello!", other.Preview)
+	assert.Equal(t, 1, other.ForkIndex)
+
+	diffs, err := store.diffVersions(1, current.LeafID, other.LeafID)
+	assert.NoError(t, err)
+	assert.Equal(t, []MessageDiff{
+		{Index: 0, Kind: "equal", Role: "user", Type: "message"},
+		{Index: 1, Kind: "changed", Role: "assistant", Type: "message",
+			Lines: []DiffLine{{"-", "Hi there!"}, {"+", "Hello!"}}},
+	}, diffs)
+
+	workspace, err := store.restoreVersion(1, other.LeafID)
+	assert.NoError(t, err)
+	assert.Equal(t, prompt+"[assistant](#message)\nHello!\n\n", workspace.Context)
+	messages, err := store.branchMessages(1, other.LeafID)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2)
+	_, err = store.restoreVersion(1, 100)
+	assert.Error(t, err)
+}
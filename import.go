@@ -0,0 +1,418 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sydneyqt/util"
+	"time"
+)
+
+// markdownHeadingRegexp matches the headings written by ExportWorkspace, like # \[user\](#message)
+var markdownHeadingRegexp = regexp.MustCompile(`(?m)^# \\\[(system|user|assistant)\\]\(#([^)\s]*)\)[ \t]*$`)
+
+type WorkspaceImportResult struct {
+	Format     string              `json:"format"` // json, chatgpt, sharegpt, markdown or zip
+	DryRun     bool                `json:"dry_run"`
+	Workspaces []ImportedWorkspace `json:"workspaces"`
+}
+type ImportedWorkspace struct {
+	WorkspaceID  int       `json:"workspace_id"` // 0 if not imported
+	Title        string    `json:"title"`
+	CreatedAt    time.Time `json:"created_at"`
+	MessageCount int       `json:"message_count"`
+	DuplicateOf  int       `json:"duplicate_of"` // the existing workspace with the same messages, or 0
+}
+
+// parsedWorkspace is a conversation read from a file, which becomes a workspace if it is not a duplicate
+type parsedWorkspace struct {
+	title     string
+	createdAt time.Time
+	messages  []util.ChatMessage
+	workspace *Workspace // the whole workspace if exported losslessly, which is kept as it is
+}
+
+func renderChatMessages(messages []util.ChatMessage) string {
+	if len(messages) == 0 {
+		return ""
+	}
+	return strings.Join(lo.Map(messages, func(item util.ChatMessage, index int) string {
+		return "[" + item.Role + "](#" + item.Type + ")\n" + item.Content
+	}), "\n\n") + "\n\n"
+}
+
+// contextDigest identifies the messages of a context regardless of the surrounding whitespace
+func contextDigest(messages []util.ChatMessage) string {
+	hash := sha256.Sum256([]byte(renderChatMessages(messages)))
+	return hex.EncodeToString(hash[:])
+}
+
+type chatGPTConversation struct {
+	Title       string                 `json:"title"`
+	CreateTime  float64                `json:"create_time"`
+	Mapping     map[string]chatGPTNode `json:"mapping"`
+	CurrentNode string                 `json:"current_node"`
+}
+type chatGPTNode struct {
+	ID       string          `json:"id"`
+	Message  *chatGPTMessage `json:"message"`
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+}
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	Content struct {
+		ContentType string `json:"content_type"`
+		Parts       []any  `json:"parts"`
+		Text        string `json:"text"`
+		Language    string `json:"language"`
+	} `json:"content"`
+	Metadata struct {
+		IsVisuallyHidden bool `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+func unixTime(seconds float64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
+// chatGPTContent returns the visible text of the message, or an empty string for tool calls, images and alike
+func chatGPTContent(message *chatGPTMessage) string {
+	switch message.Content.ContentType {
+	case "text", "multimodal_text":
+		parts := lo.FilterMap(message.Content.Parts, func(item any, index int) (string, bool) {
+			text, ok := item.(string)
+			return text, ok && strings.TrimSpace(text) != ""
+		})
+		return strings.TrimSpace(strings.Join(parts, "\n\n"))
+	case "code":
+		if strings.TrimSpace(message.Content.Text) == "" {
+			return ""
+		}
+		return "```" + lo.Ternary(message.Content.Language == "unknown", "", message.Content.Language) + "\n" +
+			strings.TrimSpace(message.Content.Text) + "\n```"
+	}
+	return ""
+}
+
+// parseChatGPT reads the conversations.json of a ChatGPT data export,
+// where only the branch shown last in ChatGPT is imported for each conversation
+func parseChatGPT(conversations []chatGPTConversation) []parsedWorkspace {
+	var result []parsedWorkspace
+	for _, conversation := range conversations {
+		current := conversation.CurrentNode
+		if _, ok := conversation.Mapping[current]; !ok {
+			// follow the latest children from the root if the current node is unknown
+			for id, node := range conversation.Mapping {
+				if node.Parent == "" {
+					current = id
+					break
+				}
+			}
+			// a malformed mapping may link back to a visited node
+			descended := map[string]bool{current: true}
+			for len(conversation.Mapping[current].Children) != 0 {
+				children := conversation.Mapping[current].Children
+				if descended[children[len(children)-1]] {
+					break
+				}
+				current = children[len(children)-1]
+				descended[current] = true
+			}
+		}
+		var messages []util.ChatMessage
+		visited := map[string]bool{}
+		for id := current; id != "" && !visited[id]; id = conversation.Mapping[id].Parent {
+			visited[id] = true
+			message := conversation.Mapping[id].Message
+			if message == nil || message.Metadata.IsVisuallyHidden {
+				continue
+			}
+			content := chatGPTContent(message)
+			if content == "" {
+				continue
+			}
+			var msg util.ChatMessage
+			switch message.Author.Role {
+			case "user":
+				msg = util.ChatMessage{Role: "user", Type: "message"}
+			case "assistant":
+				msg = util.ChatMessage{Role: "assistant", Type: "message"}
+			case "system":
+				msg = util.ChatMessage{Role: "system", Type: "additional_instructions"}
+			default:
+				continue
+			}
+			msg.Content = content
+			messages = append(messages, msg)
+		}
+		result = append(result, parsedWorkspace{
+			title:     conversation.Title,
+			createdAt: unixTime(conversation.CreateTime),
+			messages:  lo.Reverse(messages),
+		})
+	}
+	return result
+}
+
+// shareGPTConversation is either what ShareWorkspace posts or a conversation of a ShareGPT dataset
+type shareGPTConversation struct {
+	Title         string         `json:"title"`
+	Items         []ShareGPTItem `json:"items"`
+	Conversations []ShareGPTItem `json:"conversations"`
+}
+
+func parseShareGPT(conversations []shareGPTConversation) []parsedWorkspace {
+	var result []parsedWorkspace
+	for _, conversation := range conversations {
+		var messages []util.ChatMessage
+		for _, item := range append(conversation.Items, conversation.Conversations...) {
+			value := strings.TrimSpace(item.Value)
+			// items shared by this app keep their roles and types in headings
+			if strings.HasPrefix(value, "[") {
+				if parsed := util.GetChatMessage(value); len(parsed) != 0 {
+					messages = append(messages, parsed...)
+					continue
+				}
+			}
+			if value == "" {
+				continue
+			}
+			switch strings.ToLower(item.From) {
+			case "human", "user":
+				messages = append(messages, util.ChatMessage{Role: "user", Type: "message", Content: value})
+			case "system":
+				messages = append(messages, util.ChatMessage{Role: "system", Type: "additional_instructions", Content: value})
+			default:
+				messages = append(messages, util.ChatMessage{Role: "assistant", Type: "message", Content: value})
+			}
+		}
+		result = append(result, parsedWorkspace{title: conversation.Title, messages: messages})
+	}
+	return result
+}
+
+// parseMarkdown reads a file written by ExportWorkspace, whose title is the name of the file
+func parseMarkdown(text string, title string, createdAt time.Time) []parsedWorkspace {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	headings := markdownHeadingRegexp.FindAllStringSubmatchIndex(text, -1)
+	var messages []util.ChatMessage
+	for i, heading := range headings {
+		end := len(text)
+		if i+1 < len(headings) {
+			end = headings[i+1][0]
+		}
+		messages = append(messages, util.ChatMessage{
+			Role:    text[heading[2]:heading[3]],
+			Type:    text[heading[4]:heading[5]],
+			Content: strings.TrimSpace(text[heading[1]:end]),
+		})
+	}
+	return []parsedWorkspace{{title: title, createdAt: createdAt, messages: messages}}
+}
+
+// parseImportFile detects the format of the file and reads the conversations in it
+func parseImportFile(path string) (string, []parsedWorkspace, error) {
+	v, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".zip") {
+		return parseImportData(filepath.Base(path), v, info.ModTime())
+	}
+	// a zip written by ExportWorkspaces, whose files are read one by one
+	zipReader, err := zip.NewReader(bytes.NewReader(v), int64(len(v)))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "cannot open the zip file")
+	}
+	var result []parsedWorkspace
+	for _, file := range zipReader.File {
+		ext := strings.ToLower(filepath.Ext(file.Name))
+		if file.FileInfo().IsDir() || (ext != ".json" && ext != ".md") {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return "", nil, err
+		}
+		v, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return "", nil, err
+		}
+		_, parsed, err := parseImportData(filepath.Base(file.Name), v, file.Modified)
+		if err != nil {
+			return "", nil, errors.Wrap(err, file.Name)
+		}
+		result = append(result, parsed...)
+	}
+	if len(result) == 0 {
+		return "", nil, errors.New("no conversation found in the zip file")
+	}
+	return "zip", result, nil
+}
+
+func parseImportData(name string, v []byte, modTime time.Time) (string, []parsedWorkspace, error) {
+	if strings.EqualFold(filepath.Ext(name), ".md") {
+		title := strings.TrimSuffix(name, filepath.Ext(name))
+		result := parseMarkdown(string(v), title, modTime)
+		if len(result[0].messages) == 0 {
+			return "", nil, errors.New("no message found in the markdown file")
+		}
+		return "markdown", result, nil
+	}
+	v = bytes.TrimSpace(v)
+	if len(v) != 0 && v[0] == '{' {
+		v = append(append([]byte{'['}, v...), ']')
+	}
+	var fields []map[string]json.RawMessage
+	err := json.Unmarshal(v, &fields)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "cannot parse the file as JSON")
+	}
+	if len(fields) == 0 {
+		return "", nil, errors.New("no conversation found in the file")
+	}
+	if string(fields[0]["format"]) == `"`+workspaceArchiveFormat+`"` {
+		var archives []workspaceArchive
+		err = json.Unmarshal(v, &archives)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "cannot parse the exported workspace")
+		}
+		return "json", lo.Map(archives, func(item workspaceArchive, index int) parsedWorkspace {
+			return parsedWorkspace{
+				title:     item.Workspace.Title,
+				createdAt: item.Workspace.CreatedAt,
+				messages:  util.GetChatMessage(item.Workspace.Context),
+				workspace: &item.Workspace,
+			}
+		}), nil
+	}
+	if _, ok := fields[0]["mapping"]; ok {
+		var conversations []chatGPTConversation
+		err = json.Unmarshal(v, &conversations)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "cannot parse the ChatGPT export")
+		}
+		return "chatgpt", parseChatGPT(conversations), nil
+	}
+	_, hasItems := fields[0]["items"]
+	_, hasConversations := fields[0]["conversations"]
+	if hasItems || hasConversations {
+		var conversations []shareGPTConversation
+		err = json.Unmarshal(v, &conversations)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "cannot parse the ShareGPT conversations")
+		}
+		return "sharegpt", parseShareGPT(conversations), nil
+	}
+	return "", nil, errors.New("unknown format of the file")
+}
+
+// addImportedWorkspaces creates a workspace for each conversation whose messages are not in any workspace yet,
+// where the settings other than the context are copied from the template unless the whole workspace is exported
+func (o *WorkspaceStore) addImportedWorkspaces(parsed []parsedWorkspace, template Workspace,
+	dryRun bool) ([]ImportedWorkspace, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	existing := map[string]int{}
+	nextID := 1
+	for id := range o.index {
+		workspace, err := o.read(id)
+		if err != nil {
+			return nil, err
+		}
+		existing[contextDigest(util.GetChatMessage(workspace.Context))] = id
+		nextID = max(nextID, id+1)
+	}
+	now := time.Now()
+	result := []ImportedWorkspace{}
+	for _, conversation := range parsed {
+		if len(conversation.messages) == 0 {
+			continue
+		}
+		item := ImportedWorkspace{
+			Title:        lo.Ternary(strings.TrimSpace(conversation.title) == "", "Imported Chat", conversation.title),
+			CreatedAt:    lo.Ternary(conversation.createdAt.IsZero(), now, conversation.createdAt),
+			MessageCount: len(conversation.messages),
+		}
+		digest := contextDigest(conversation.messages)
+		if id, ok := existing[digest]; ok {
+			item.DuplicateOf = id
+			result = append(result, item)
+			continue
+		}
+		if !dryRun {
+			item.WorkspaceID = nextID
+			var workspace Workspace
+			if conversation.workspace != nil {
+				workspace = *conversation.workspace
+			} else {
+				workspace = template
+				workspace.Context = renderChatMessages(conversation.messages)
+				workspace.Input = ""
+				workspace.DataReferences = nil
+			}
+			workspace.ID = nextID
+			workspace.Title = item.Title
+			workspace.CreatedAt = item.CreatedAt
+			o.save(workspace)
+		}
+		// conversations repeated in the file are duplicates of the first one, even in a dry run
+		existing[digest] = nextID
+		nextID++
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+// SelectImportFile asks for a file to import, and returns an empty path if canceled
+func (a *App) SelectImportFile() (string, error) {
+	return runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "Open a file to import",
+		Filters: []runtime.FileFilter{{
+			DisplayName: "Chat Exports (*.json; *.md; *.zip)",
+			Pattern:     "*.json;*.md;*.zip",
+		}},
+	})
+}
+
+// ImportWorkspaces imports the conversations in a ChatGPT conversations.json, ShareGPT JSON,
+// or a workspace exported as JSON or Markdown, including zips of them,
+// skipping those already in a workspace. A dry run only returns what would be imported.
+func (a *App) ImportWorkspaces(path string, dryRun bool) (WorkspaceImportResult, error) {
+	format, parsed, err := parseImportFile(path)
+	if err != nil {
+		return WorkspaceImportResult{}, err
+	}
+	template, err := a.workspaces.GetWorkspace(a.settings.config.CurrentWorkspaceID)
+	if err != nil {
+		template = Workspace{Backend: "Sydney", Locale: "en-US", Preset: "Sydney", ConversationStyle: "Creative"}
+	}
+	workspaces, err := a.workspaces.addImportedWorkspaces(parsed, template, dryRun)
+	if err != nil {
+		return WorkspaceImportResult{}, err
+	}
+	return WorkspaceImportResult{Format: format, DryRun: dryRun, Workspaces: workspaces}, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sydneyqt/util"
+)
+
+// parseImportText writes the text into a file with the name and the modification time, and parses the file
+func parseImportText(t *testing.T, name string, text string, modTime time.Time) (string, []parsedWorkspace, error) {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(text), 0644))
+	assert.NoError(t, os.Chtimes(path, modTime, modTime))
+	return parseImportFile(path)
+}
+
+func TestParseChatGPT(t *testing.T) {
+	data := `[{
+		"title": "Code",
+		"create_time": 1704164645.5,
+		"current_node": "a3",
+		"mapping": {
+			"r": {"id": "r", "message": null, "parent": "", "children": ["s"]},
+			"s": {"id": "s", "parent": "r", "children": ["sys"], "message": {"author": {"role": "system"},
+				"content": {"content_type": "text", "parts": [""]},
+				"metadata": {"is_visually_hidden_from_conversation": true}}},
+			"sys": {"id": "sys", "parent": "s", "children": ["u"], "message": {"author": {"role": "system"},
+				"content": {"content_type": "text", "parts": ["Be brief."]}}},
+			"u": {"id": "u", "parent": "sys", "children": ["a1", "a2"], "message": {"author": {"role": "user"},
+				"content": {"content_type": "text", "parts": ["Print one", ""]}}},
+			"a1": {"id": "a1", "parent": "u", "children": [], "message": {"author": {"role": "assistant"},
+				"content": {"content_type": "text", "parts": ["No."]}}},
+			"a2": {"id": "a2", "parent": "u", "children": ["t"], "message": {"author": {"role": "assistant"},
+				"content": {"content_type": "code", "language": "python", "text": "print(1)\n"}}},
+			"t": {"id": "t", "parent": "a2", "children": ["a3"], "message": {"author": {"role": "tool"},
+				"content": {"content_type": "execution_output", "text": "1"}}},
+			"a3": {"id": "a3", "parent": "t", "children": [], "message": {"author": {"role": "assistant"},
+				"content": {"content_type": "multimodal_text", "parts": [{"asset_pointer": "file-1"}, "Done."]}}}
+		}
+	}, {
+		"title": "Lost current node",
+		"mapping": {
+			"r": {"id": "r", "message": null, "parent": "", "children": ["u"]},
+			"u": {"id": "u", "parent": "r", "children": ["a1", "a2"], "message": {"author": {"role": "user"},
+				"content": {"content_type": "text", "parts": ["Hi"]}}},
+			"a1": {"id": "a1", "parent": "u", "children": [], "message": {"author": {"role": "assistant"},
+				"content": {"content_type": "text", "parts": ["Hello"]}}},
+			"a2": {"id": "a2", "parent": "u", "children": [], "message": {"author": {"role": "assistant"},
+				"content": {"content_type": "text", "parts": ["Hey"]}}}
+		}
+	}, {
+		"title": "Cyclic mapping",
+		"mapping": {
+			"r": {"id": "r", "message": null, "parent": "", "children": ["u"]},
+			"u": {"id": "u", "parent": "r", "children": ["a"], "message": {"author": {"role": "user"},
+				"content": {"content_type": "text", "parts": ["Loop"]}}},
+			"a": {"id": "a", "parent": "u", "children": ["u"], "message": {"author": {"role": "assistant"},
+				"content": {"content_type": "text", "parts": ["Again"]}}}
+		}
+	}]`
+	format, parsed, err := parseImportText(t, "conversations.json", data, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "chatgpt", format)
+	assert.Equal(t, []parsedWorkspace{
+		{title: "Code", createdAt: time.Unix(1704164645, 5e8), messages: []util.ChatMessage{
+			{Role: "system", Type: "additional_instructions", Content: "Be brief."},
+			{Role: "user", Type: "message", Content: "Print one"},
+			{Role: "assistant", Type: "message", Content: "```python\nprint(1)\n```"},
+			{Role: "assistant", Type: "message", Content: "Done."},
+		}},
+		{title: "Lost current node", messages: []util.ChatMessage{
+			{Role: "user", Type: "message", Content: "Hi"},
+			{Role: "assistant", Type: "message", Content: "Hey"},
+		}},
+		{title: "Cyclic mapping", messages: []util.ChatMessage{
+			{Role: "user", Type: "message", Content: "Loop"},
+			{Role: "assistant", Type: "message", Content: "Again"},
+		}},
+	}, parsed)
+}
+
+func TestParseShareGPT(t *testing.T) {
+	data := `[{"title": "Shared", "items": [{"from": "human", "value": "Hi"}, {"from": "gpt", "value": "Hello"}]},
+		{"conversations": [
+			{"from": "system", "value": "Be brief."},
+			{"from": "user", "value": "  "},
+			{"from": "human", "value": "[user](#message)\nHi\n\n[assistant](#suggestion)\nHello"},
+			{"from": "gpt", "value": "[not a header] text"}
+		]}]`
+	format, parsed, err := parseImportText(t, "sharegpt.json", data, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "sharegpt", format)
+	assert.Equal(t, []parsedWorkspace{
+		{title: "Shared", messages: []util.ChatMessage{
+			{Role: "user", Type: "message", Content: "Hi"},
+			{Role: "assistant", Type: "message", Content: "Hello"},
+		}},
+		{messages: []util.ChatMessage{
+			{Role: "system", Type: "additional_instructions", Content: "Be brief."},
+			{Role: "user", Type: "message", Content: "Hi"},
+			{Role: "assistant", Type: "suggestion", Content: "Hello"},
+			{Role: "assistant", Type: "message", Content: "[not a header] text"},
+		}},
+	}, parsed)
+}
+
+func TestParseMarkdown(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	data := "# \\[system\\](#additional_instructions)\nBe brief.\n\n# \\[user\\](#message)\r\nHi\r\n# Not a header\r\n\r\n" +
+		"# \\[assistant\\](#message)  \nHello\n\n"
+	format, parsed, err := parseImportText(t, "Chat.md", data, modTime)
+	assert.NoError(t, err)
+	assert.Equal(t, "markdown", format)
+	assert.Equal(t, []parsedWorkspace{{title: "Chat", createdAt: modTime, messages: []util.ChatMessage{
+		{Role: "system", Type: "additional_instructions", Content: "Be brief."},
+		{Role: "user", Type: "message", Content: "Hi\n# Not a header"},
+		{Role: "assistant", Type: "message", Content: "Hello"},
+	}}}, parsed)
+}
+
+func TestParseImportDataErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+	}{
+		{"empty.md", "# Notes\nNothing here"},
+		{"empty.json", "[]"},
+		{"invalid.json", "{"},
+		{"unknown.json", `{"messages": []}`},
+	} {
+		_, _, err := parseImportText(t, tc.name, tc.data, time.Now())
+		assert.Error(t, err, tc.name)
+	}
+}
+
+func TestAddImportedWorkspaces(t *testing.T) {
+	store, err := NewWorkspaceStore(t.TempDir())
+	assert.NoError(t, err)
+	defer store.close()
+	store.SaveWorkspace(Workspace{ID: 2, Title: "Existing", Context: "[user](#message)\nHi\n\n"})
+	template := Workspace{ID: 2, Backend: "Sydney", Preset: "Sydney", Context: "template", Input: "draft",
+		DataReferences: []DataReference{{UUID: "a"}}}
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	parsed := []parsedWorkspace{
+		{title: "Duplicate", messages: []util.ChatMessage{{Role: "user", Type: "message", Content: "Hi"}}},
+		{title: "New", createdAt: createdAt, messages: []util.ChatMessage{{Role: "user", Type: "message", Content: "Hello"}}},
+		{title: "Empty"},
+		{messages: []util.ChatMessage{{Role: "user", Type: "message", Content: "Hello"}}},
+	}
+
+	imported, err := store.addImportedWorkspaces(parsed, template, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(store.ListWorkspaces()))
+	assert.Equal(t, 0, imported[1].WorkspaceID)
+	assert.Equal(t, 3, imported[2].DuplicateOf)
+
+	imported, err = store.addImportedWorkspaces(parsed, template, false)
+	assert.NoError(t, err)
+	assert.Len(t, imported, 3)
+	assert.Equal(t, ImportedWorkspace{Title: "Duplicate", CreatedAt: imported[0].CreatedAt, MessageCount: 1,
+		DuplicateOf: 2}, imported[0])
+	assert.Equal(t, ImportedWorkspace{WorkspaceID: 3, Title: "New", CreatedAt: createdAt, MessageCount: 1}, imported[1])
+	assert.Equal(t, "Imported Chat", imported[2].Title)
+	assert.Equal(t, 3, imported[2].DuplicateOf)
+	workspace, err := store.GetWorkspace(3)
+	assert.NoError(t, err)
+	assert.Equal(t, Workspace{ID: 3, Title: "New", CreatedAt: createdAt, Backend: "Sydney", Preset: "Sydney",
+		Context: "[user](#message)\nHello\n\n"}, workspace)
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
 	"github.com/wailsapp/wails/v2/pkg/options/assetserver"
+	"sydneyqt/util"
 )
 
 //go:embed all:frontend/dist
@@ -12,16 +13,36 @@ var assets embed.FS
 
 func main() {
 	// Create an instance of the app structure
-	settings := NewSettings()
+	workspaceStore, err := NewWorkspaceStore(util.WithPath("workspaces"))
+	if err != nil {
+		util.GracefulPanic(err)
+	}
+	secrets, err := util.OpenSecretStore(util.WithPath("secret.json"), askSecretPassphrase)
+	if err != nil {
+		util.GracefulPanic(err)
+	}
+	util.SetSecretStore(secrets)
+	settings := NewSettings(workspaceStore, secrets)
+	var clearedCookies []string
+	for _, profile := range settings.config.CookieProfiles {
+		dropped, err := util.EncryptCookiesFile(cookieProfilePath(profile.Name))
+		if err != nil {
+			util.GracefulPanic(err)
+		}
+		if dropped {
+			clearedCookies = append(clearedCookies, "the cookies of profile "+profile.Name)
+		}
+	}
+	warnClearedSecrets(clearedCookies)
 
 	// Mark ipc server deprecated since we are using built-in CAPTCHA resolver now
 	//ipcServer := NewIPCServer(settings)
 	//go ipcServer.Serve()
 
-	app := NewApp(settings)
+	app := NewApp(settings, workspaceStore)
 
 	// Create application with options
-	err := wails.Run(&options.App{
+	err = wails.Run(&options.App{
 		Title:  "SydneyQt",
 		Width:  1200,
 		Height: 800,
@@ -34,6 +55,7 @@ func main() {
 		Bind: []any{
 			app,
 			settings,
+			workspaceStore,
 		},
 		EnableDefaultContextMenu: true,
 	})
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"github.com/sashabaranov/go-openai"
+	"log/slog"
+	"strings"
+)
+
+// knownContextLengths are the context lengths of OpenAI models by the prefix of their names,
+// where longer prefixes come first
+var knownContextLengths = []struct {
+	prefix string
+	length int
+}{
+	{"gpt-4o", 128000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4-1106", 128000},
+	{"gpt-4-0125", 128000},
+	{"gpt-4-vision", 128000},
+	{"gpt-4-32k", 32768},
+	{"gpt-4", 8192},
+	{"gpt-3.5-turbo-16k", 16385},
+	{"gpt-3.5-turbo-instruct", 4096},
+	{"gpt-3.5-turbo-0613", 4096},
+	{"gpt-3.5-turbo-0301", 4096},
+	{"gpt-3.5-turbo", 16385},
+}
+
+// inferContextLength guesses the context length from the name of the model, or returns 0 if it is unknown,
+// which leaves messages untrimmed
+func inferContextLength(model string) int {
+	model = strings.ToLower(model)
+	for _, known := range knownContextLengths {
+		if strings.HasPrefix(model, known.prefix) {
+			return known.length
+		}
+	}
+	return 0
+}
+
+// ChatModelInfo tells the frontend which model answers, and how many of the oldest messages are left out to fit it
+type ChatModelInfo struct {
+	Model           string `json:"model"`
+	PromptTokens    int    `json:"prompt_tokens"`
+	TrimmedMessages int    `json:"trimmed_messages"`
+}
+
+// countMessageTokens estimates the prompt tokens of the messages, with the overhead of each message as OpenAI counts
+func (a *App) countMessageTokens(messages []openai.ChatCompletionMessage) int {
+	count := 3 // every reply is primed with the assistant role
+	for _, message := range messages {
+		count += 4 + a.CountToken(message.Role) + a.CountToken(message.Content)
+		for _, part := range message.MultiContent {
+			count += a.CountToken(part.Text)
+		}
+	}
+	return count
+}
+
+// chooseOpenAIModel picks the short model, or the long model if the messages are above the threshold of the backend.
+// If the messages do not fit the long model either, the oldest messages are trimmed, except system ones
+// and those from the last user message on, which are the prompt and the tool calls made for it.
+func (a *App) chooseOpenAIModel(backend OpenAIBackend,
+	messages []openai.ChatCompletionMessage) ([]openai.ChatCompletionMessage, ChatModelInfo) {
+	tokens := a.countMessageTokens(messages)
+	if backend.OpenaiLongModel == "" || tokens <= backend.OpenaiThreshold {
+		return messages, ChatModelInfo{Model: backend.OpenaiShortModel, PromptTokens: tokens}
+	}
+	info := ChatModelInfo{Model: backend.OpenaiLongModel, PromptTokens: tokens}
+	// leave room for the reply
+	limit := backend.OpenaiLongLimit - backend.MaxTokens
+	if backend.OpenaiLongLimit <= 0 || tokens <= limit {
+		return messages, info
+	}
+	kept := len(messages) - 1
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == openai.ChatMessageRoleUser {
+			kept = i
+			break
+		}
+	}
+	var trimmed []openai.ChatCompletionMessage
+	// results of tool calls are trimmed along with the calls
+	trimmedCalls := map[string]bool{}
+	for i, message := range messages {
+		if (tokens > limit && i < kept && message.Role != openai.ChatMessageRoleSystem) ||
+			(message.ToolCallID != "" && trimmedCalls[message.ToolCallID]) {
+			tokens -= a.countMessageTokens([]openai.ChatCompletionMessage{message}) - 3
+			info.TrimmedMessages++
+			for _, call := range message.ToolCalls {
+				trimmedCalls[call.ID] = true
+			}
+			continue
+		}
+		trimmed = append(trimmed, message)
+	}
+	info.PromptTokens = tokens
+	slog.Info("Trimmed the oldest messages to fit the long model", "info", info, "limit", limit)
+	return trimmed, info
+}
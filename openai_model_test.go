@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// useByteTokenizer makes CountToken count bytes, since the encoding of tiktoken is downloaded at the first use
+func useByteTokenizer(t *testing.T) {
+	ranks := map[string]int{}
+	for i := 0; i < 256; i++ {
+		ranks[string([]byte{byte(i)})] = i
+	}
+	bpe, err := tiktoken.NewCoreBPE(ranks, map[string]int{tiktoken.ENDOFTEXT: 256}, `\S+|\s+`)
+	assert.NoError(t, err)
+	tk = tiktoken.NewTiktoken(bpe, &tiktoken.Encoding{}, map[string]any{})
+	initTkFunc = func() {}
+}
+
+func TestInferContextLength(t *testing.T) {
+	for _, tc := range []struct {
+		model  string
+		length int
+	}{
+		{"gpt-4o-mini", 128000},
+		{"GPT-4-Turbo", 128000},
+		{"gpt-4-32k-0613", 32768},
+		{"gpt-4-0613", 8192},
+		{"gpt-3.5-turbo-16k", 16385},
+		{"gpt-3.5-turbo-0613", 4096},
+		{"gpt-3.5-turbo", 16385},
+		{"llama3", 0},
+		{"", 0},
+	} {
+		assert.Equal(t, tc.length, inferContextLength(tc.model), tc.model)
+	}
+}
+
+func TestChooseOpenAIModel(t *testing.T) {
+	useByteTokenizer(t)
+	app := &App{}
+	system := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: "sys"}
+	user := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: strings.Repeat("a", 10)}
+	assistant := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: strings.Repeat("b", 10)}
+	prompt := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: strings.Repeat("c", 10)}
+	toolCall := func(id string) openai.ChatCompletionMessage {
+		return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant,
+			ToolCalls: []openai.ToolCall{{ID: id, Type: openai.ToolTypeFunction}}}
+	}
+	toolResult := func(id string) openai.ChatCompletionMessage {
+		return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleTool, ToolCallID: id,
+			Content: strings.Repeat("d", 10)}
+	}
+	// 3 for the reply, and 4 plus the role and the content for each message
+	chat := []openai.ChatCompletionMessage{system, user, assistant, prompt}
+	// 3 + 13 + 18 + 13 + 18 + 23 + 18 + 13 + 18
+	toolRound := []openai.ChatCompletionMessage{system, user, toolCall("call_1"), toolResult("call_1"),
+		assistant, prompt, toolCall("call_2"), toolResult("call_2")}
+
+	tests := []struct {
+		name     string
+		backend  OpenAIBackend
+		messages []openai.ChatCompletionMessage
+		want     []openai.ChatCompletionMessage
+		info     ChatModelInfo
+	}{
+		{"short model", OpenAIBackend{OpenaiShortModel: "short", OpenaiLongModel: "long", OpenaiThreshold: 100},
+			chat, chat, ChatModelInfo{Model: "short", PromptTokens: 75}},
+		{"no long model", OpenAIBackend{OpenaiShortModel: "short", OpenaiThreshold: 10},
+			chat, chat, ChatModelInfo{Model: "short", PromptTokens: 75}},
+		{"unknown limit", OpenAIBackend{OpenaiShortModel: "short", OpenaiLongModel: "long", OpenaiThreshold: 50},
+			chat, chat, ChatModelInfo{Model: "long", PromptTokens: 75}},
+		{"fits the long model", OpenAIBackend{OpenaiShortModel: "short", OpenaiLongModel: "long", OpenaiThreshold: 50,
+			OpenaiLongLimit: 100, MaxTokens: 20},
+			chat, chat, ChatModelInfo{Model: "long", PromptTokens: 75}},
+		{"oldest trimmed", OpenAIBackend{OpenaiShortModel: "short", OpenaiLongModel: "long", OpenaiThreshold: 50,
+			OpenaiLongLimit: 80, MaxTokens: 20},
+			chat, []openai.ChatCompletionMessage{system, assistant, prompt},
+			ChatModelInfo{Model: "long", PromptTokens: 57, TrimmedMessages: 1}},
+		{"tool results trimmed with calls", OpenAIBackend{OpenaiShortModel: "short", OpenaiLongModel: "long",
+			OpenaiThreshold: 50, OpenaiLongLimit: 130, MaxTokens: 20},
+			toolRound, []openai.ChatCompletionMessage{system, assistant, prompt, toolCall("call_2"), toolResult("call_2")},
+			ChatModelInfo{Model: "long", PromptTokens: 88, TrimmedMessages: 3}},
+		{"current round kept", OpenAIBackend{OpenaiShortModel: "short", OpenaiLongModel: "long",
+			OpenaiThreshold: 50, OpenaiLongLimit: 50},
+			toolRound, []openai.ChatCompletionMessage{system, prompt, toolCall("call_2"), toolResult("call_2")},
+			ChatModelInfo{Model: "long", PromptTokens: 65, TrimmedMessages: 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, info := app.chooseOpenAIModel(tt.backend, tt.messages)
+			assert.Equal(t, tt.want, messages)
+			assert.Equal(t, tt.info, info)
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sydneyqt/sydney"
+	"sydneyqt/util"
+)
+
+const defaultCookieProfileName = "Default"
+
+// cookieProfilePath returns the cookie file of the profile.
+// The default profile keeps cookies.json, which is also read by the web API.
+func cookieProfilePath(name string) string {
+	if name == defaultCookieProfileName {
+		return util.WithPath("cookies.json")
+	}
+	return util.WithPath(filepath.Join("cookies", url.PathEscape(name)+".json"))
+}
+
+// keepCookieProfileUsers restores the user names cached by GetProfileUser, which the frontend cannot change
+func keepCookieProfileUsers(profiles []CookieProfile, previous []CookieProfile) []CookieProfile {
+	return lo.Map(profiles, func(item CookieProfile, index int) CookieProfile {
+		profile, _ := lo.Find(previous, func(profile CookieProfile) bool {
+			return profile.Name == item.Name
+		})
+		item.UserName = profile.UserName
+		return item
+	})
+}
+
+// findCookieProfile returns the profile by name, or the first profile if the name is empty
+func (o *Settings) findCookieProfile(name string) (CookieProfile, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if name == "" {
+		return o.config.CookieProfiles[0], nil
+	}
+	profile, ok := lo.Find(o.config.CookieProfiles, func(item CookieProfile) bool {
+		return item.Name == name
+	})
+	if !ok {
+		return CookieProfile{}, errors.New("cookie profile not exist: " + name)
+	}
+	return profile, nil
+}
+func (o *Settings) setCookieProfileUser(name string, userName string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, index, ok := lo.FindIndexOf(o.config.CookieProfiles, func(item CookieProfile) bool {
+		return item.Name == name
+	})
+	if !ok || o.config.CookieProfiles[index].UserName == userName {
+		return
+	}
+	o.config.CookieProfiles[index].UserName = userName
+	o.version++
+}
+
+// cookieProfileOptions returns the options of Sydney with the cookies and the proxy of the profile,
+// so that cookies updated by Bing are written back to the profile
+func (a *App) cookieProfileOptions(name string) (sydney.Options, error) {
+	profile, err := a.settings.findCookieProfile(name)
+	if err != nil {
+		return sydney.Options{}, err
+	}
+	path := cookieProfilePath(profile.Name)
+	cookies, err := util.ReadCookiesFileAt(path)
+	if err != nil {
+		return sydney.Options{}, errors.Wrap(err, "cannot read cookies of profile "+profile.Name)
+	}
+	return sydney.Options{
+		Debug:                 a.settings.config.Debug,
+		Cookies:               cookies,
+		Proxy:                 lo.Ternary(profile.Proxy != "", profile.Proxy, a.settings.config.Proxy),
+		WssDomain:             a.settings.config.WssDomain,
+		CreateConversationURL: a.settings.config.CreateConversationURL,
+		BypassServer:          a.settings.config.BypassServer,
+		CookiesFile:           path,
+	}, nil
+}
+
+// GetProfileUser returns the name of the account of the profile, which is cached in the profile
+func (a *App) GetProfileUser(name string) (string, error) {
+	options, err := a.cookieProfileOptions(name)
+	if err != nil {
+		return "", err
+	}
+	userName, err := sydney.NewSydney(options).GetUser()
+	if err != nil {
+		return "", err
+	}
+	profile, err := a.settings.findCookieProfile(name)
+	if err != nil {
+		return "", err
+	}
+	a.settings.setCookieProfileUser(profile.Name, userName)
+	return userName, nil
+}
+
+// SetProfileCookies replaces the cookies of the profile with the text,
+// which is either a JSON array exported by browser extensions or a Cookie header
+func (a *App) SetProfileCookies(name string, text string) error {
+	profile, err := a.settings.findCookieProfile(name)
+	if err != nil {
+		return err
+	}
+	text = strings.TrimSpace(text)
+	var cookies []util.FileCookie
+	if strings.HasPrefix(text, "[") {
+		err = json.Unmarshal([]byte(text), &cookies)
+		if err != nil {
+			return errors.Wrap(err, "cannot parse cookies as JSON")
+		}
+	} else {
+		for k, v := range util.ParseCookiesFromString(text) {
+			cookies = append(cookies, util.FileCookie{Name: k, Value: v})
+		}
+	}
+	if len(cookies) == 0 {
+		return errors.New("no cookie found")
+	}
+	err = util.WriteCookiesFileAt(cookieProfilePath(profile.Name), cookies)
+	if err != nil {
+		return err
+	}
+	a.settings.setCookieProfileUser(profile.Name, "")
+	return nil
+}
+
+// DeleteProfileCookies removes the cookie file of a profile which is removed from settings
+func (a *App) DeleteProfileCookies(name string) error {
+	if name == defaultCookieProfileName {
+		return errors.New("cannot delete the default cookie profile")
+	}
+	err := os.Remove(cookieProfilePath(name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeepCookieProfileUsers(t *testing.T) {
+	previous := []CookieProfile{
+		{Name: defaultCookieProfileName, UserName: "Alice"},
+		{Name: "Work", Proxy: "http://127.0.0.1:7890", UserName: "Bob"},
+	}
+	profiles := keepCookieProfileUsers([]CookieProfile{
+		{Name: "Work", UserName: "Mallory"},
+		{Name: defaultCookieProfileName},
+		{Name: "New", UserName: "Eve"},
+	}, previous)
+	assert.Equal(t, []CookieProfile{
+		{Name: "Work", UserName: "Bob"},
+		{Name: defaultCookieProfileName, UserName: "Alice"},
+		{Name: "New"},
+	}, profiles)
+}
+
+func TestFindCookieProfile(t *testing.T) {
+	settings := &Settings{config: Config{CookieProfiles: []CookieProfile{
+		{Name: defaultCookieProfileName},
+		{Name: "Work", Proxy: "http://127.0.0.1:7890"},
+	}}}
+	for _, tc := range []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"", defaultCookieProfileName, true},
+		{"Work", "Work", true},
+		{"Home", "", false},
+	} {
+		profile, err := settings.findCookieProfile(tc.name)
+		assert.Equal(t, tc.ok, err == nil, tc.name)
+		assert.Equal(t, tc.want, profile.Name, tc.name)
+	}
+
+	settings.setCookieProfileUser("Work", "Bob")
+	assert.Equal(t, "Bob", settings.config.CookieProfiles[1].UserName)
+	assert.Equal(t, 1, settings.version)
+	settings.setCookieProfileUser("Work", "Bob")
+	settings.setCookieProfileUser("Home", "Eve")
+	assert.Equal(t, 1, settings.version)
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sydneyqt/util"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// searchSnippetRadius is the number of characters kept around the first match in a snippet
+const searchSnippetRadius = 60
+
+// defaultSearchLimit is the number of workspaces returned if the query has no limit
+const defaultSearchLimit = 50
+
+var searchTermRegexp = regexp.MustCompile(`"([^"]+)"|(\S+)`)
+
+type WorkspaceSearchQuery struct {
+	Text    string   `json:"text"`
+	Roles   []string `json:"roles"`   // system, user or assistant; titles are only searched if empty
+	From    string   `json:"from"`    // the first date of creation, like 2024-01-02
+	To      string   `json:"to"`      // the last date of creation
+	Backend string   `json:"backend"` // Sydney or the name of an OpenAI backend
+	Preset  string   `json:"preset"`
+	Limit   int      `json:"limit"`
+}
+type WorkspaceSearchResult struct {
+	WorkspaceID int                    `json:"workspace_id"`
+	Title       string                 `json:"title"`
+	CreatedAt   time.Time              `json:"created_at"`
+	Backend     string                 `json:"backend"`
+	Preset      string                 `json:"preset"`
+	Matches     []WorkspaceSearchMatch `json:"matches"`
+}
+type WorkspaceSearchMatch struct {
+	Role         string              `json:"role"` // title for the title of the workspace
+	Type         string              `json:"type"`
+	MessageIndex int                 `json:"message_index"` // -1 for the title
+	Snippet      []SearchSnippetPart `json:"snippet"`
+}
+type SearchSnippetPart struct {
+	Text      string `json:"text"`
+	Highlight bool   `json:"highlight"`
+}
+
+type searchDocument struct {
+	role         string
+	typ          string
+	messageIndex int
+	text         string
+}
+
+// workspaceIndex is a trigram index of the titles and messages of workspaces, which finds the workspaces
+// containing a term, while the documents of these workspaces are then checked for the exact terms.
+// Only the original text is kept, and it is lowercased again for the candidates of a search.
+type workspaceIndex struct {
+	documents map[int][]searchDocument
+	trigrams  map[int][]string
+	postings  map[string]map[int]struct{}
+}
+
+func newWorkspaceIndex() *workspaceIndex {
+	return &workspaceIndex{
+		documents: map[int][]searchDocument{},
+		trigrams:  map[int][]string{},
+		postings:  map[string]map[int]struct{}{},
+	}
+}
+
+func lowerText(text string) string {
+	// unlike strings.ToLower, mapping rune by rune keeps the offsets of runes
+	return strings.Map(unicode.ToLower, text)
+}
+
+func textTrigrams(lower string) []string {
+	runes := []rune(lower)
+	var result []string
+	for i := 0; i+3 <= len(runes); i++ {
+		result = append(result, string(runes[i:i+3]))
+	}
+	return result
+}
+
+func workspaceDocuments(workspace Workspace) []searchDocument {
+	documents := []searchDocument{{role: "title", messageIndex: -1, text: workspace.Title}}
+	messages := util.GetChatMessage(workspace.Context)
+	if len(messages) == 0 && strings.TrimSpace(workspace.Context) != "" {
+		// a context without any message header is still searchable
+		documents = append(documents, searchDocument{messageIndex: 0, text: workspace.Context})
+	}
+	for i, message := range messages {
+		documents = append(documents, searchDocument{
+			role:         message.Role,
+			typ:          message.Type,
+			messageIndex: i,
+			text:         message.Content,
+		})
+	}
+	return documents
+}
+
+func (o *workspaceIndex) remove(id int) {
+	for _, trigram := range o.trigrams[id] {
+		delete(o.postings[trigram], id)
+		if len(o.postings[trigram]) == 0 {
+			delete(o.postings, trigram)
+		}
+	}
+	delete(o.trigrams, id)
+	delete(o.documents, id)
+}
+
+func (o *workspaceIndex) add(workspace Workspace) {
+	o.remove(workspace.ID)
+	documents := workspaceDocuments(workspace)
+	seen := map[string]struct{}{}
+	var trigrams []string
+	for _, document := range documents {
+		for _, trigram := range textTrigrams(lowerText(document.text)) {
+			if _, ok := seen[trigram]; ok {
+				continue
+			}
+			seen[trigram] = struct{}{}
+			trigrams = append(trigrams, trigram)
+			if o.postings[trigram] == nil {
+				o.postings[trigram] = map[int]struct{}{}
+			}
+			o.postings[trigram][workspace.ID] = struct{}{}
+		}
+	}
+	o.documents[workspace.ID] = documents
+	o.trigrams[workspace.ID] = trigrams
+}
+
+// candidates returns the workspaces which may contain all the terms, or nil if any workspace may
+func (o *workspaceIndex) candidates(terms []string) map[int]struct{} {
+	var result map[int]struct{}
+	for _, term := range terms {
+		for _, trigram := range textTrigrams(term) {
+			postings := o.postings[trigram]
+			if result == nil {
+				result = map[int]struct{}{}
+				for id := range postings {
+					result[id] = struct{}{}
+				}
+				continue
+			}
+			for id := range result {
+				if _, ok := postings[id]; !ok {
+					delete(result, id)
+				}
+			}
+		}
+	}
+	return result
+}
+
+func parseSearchTerms(text string) []string {
+	var terms []string
+	for _, match := range searchTermRegexp.FindAllStringSubmatch(text, -1) {
+		term := lowerText(match[1] + match[2])
+		if strings.TrimSpace(term) != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+func parseSearchDate(date string) (time.Time, error) {
+	t, err := time.ParseInLocation(time.DateOnly, date, time.Local)
+	if err != nil {
+		return t, errors.Wrap(err, "invalid date")
+	}
+	return t, nil
+}
+
+// makeSnippet cuts the text around the first match and highlights every term in it,
+// where lower is the text mapped by lowerText
+func makeSnippet(document searchDocument, lower string, terms []string) []SearchSnippetPart {
+	text := []rune(document.text)
+	highlighted := make([]bool, len(text))
+	first := len(text)
+	for _, term := range terms {
+		termLength := utf8.RuneCountInString(term)
+		offset := 0
+		lower := lower
+		for {
+			ix := strings.Index(lower, term)
+			if ix < 0 {
+				break
+			}
+			start := offset + utf8.RuneCountInString(lower[:ix])
+			for i := start; i < start+termLength; i++ {
+				highlighted[i] = true
+			}
+			first = min(first, start)
+			offset = start + termLength
+			lower = lower[ix+len(term):]
+		}
+	}
+	start := max(0, first-searchSnippetRadius)
+	end := min(len(text), first+2*searchSnippetRadius)
+	var parts []SearchSnippetPart
+	if start > 0 {
+		parts = append(parts, SearchSnippetPart{Text: "..."})
+	}
+	for i := start; i < end; {
+		j := i
+		for j < end && highlighted[j] == highlighted[i] {
+			j++
+		}
+		parts = append(parts, SearchSnippetPart{
+			Text:      strings.ReplaceAll(string(text[i:j]), "\n", " "),
+			Highlight: highlighted[i],
+		})
+		i = j
+	}
+	if end < len(text) {
+		parts = append(parts, SearchSnippetPart{Text: "..."})
+	}
+	return parts
+}
+
+// read returns the workspace without keeping it loaded, and must be called with the lock held
+func (o *WorkspaceStore) read(id int) (Workspace, error) {
+	if workspace, ok := o.loaded[id]; ok {
+		return workspace, nil
+	}
+	workspace, err := o.get(id)
+	delete(o.loaded, id)
+	return workspace, err
+}
+
+// invalidateSearch marks the workspace to be indexed again, which is deferred since workspaces change
+// on every keystroke. It must be called with the lock held.
+func (o *WorkspaceStore) invalidateSearch(id int) {
+	if o.searchStale != nil {
+		o.searchStale[id] = true
+	}
+}
+
+// refreshIndex indexes all workspaces at the first search, and then only the changed ones, and returns
+// the summaries the index is consistent with. Only a snapshot is taken with the lock held, and workspaces
+// which are not loaded are read from their files, so that SaveWorkspace is not blocked while indexing.
+// It must be called with searchMu held.
+func (o *WorkspaceStore) refreshIndex() (map[int]Workspace, error) {
+	o.mu.Lock()
+	if o.searchStale == nil {
+		o.searchStale = map[int]bool{}
+		for id := range o.index {
+			o.searchStale[id] = true
+		}
+	}
+	summaries := maps.Clone(o.index)
+	stale := lo.Keys(o.searchStale)
+	loaded := map[int]Workspace{}
+	for _, id := range stale {
+		if workspace, ok := o.loaded[id]; ok {
+			loaded[id] = workspace
+		}
+	}
+	clear(o.searchStale)
+	o.mu.Unlock()
+
+	index := o.searchIndex
+	for i, id := range stale {
+		if _, ok := summaries[id]; !ok {
+			index.remove(id)
+			continue
+		}
+		workspace, ok := loaded[id]
+		if !ok {
+			var err error
+			workspace, err = o.readFile(id)
+			if errors.Is(err, os.ErrNotExist) {
+				// deleted after the snapshot, which has marked it stale again
+				index.remove(id)
+				continue
+			}
+			if err != nil {
+				o.mu.Lock()
+				for _, id := range stale[i:] {
+					o.invalidateSearch(id)
+				}
+				o.mu.Unlock()
+				return nil, err
+			}
+		}
+		index.add(workspace)
+	}
+	return summaries, nil
+}
+
+func (o *WorkspaceStore) search(query WorkspaceSearchQuery) ([]WorkspaceSearchResult, error) {
+	var from, to time.Time
+	var err error
+	if query.From != "" {
+		from, err = parseSearchDate(query.From)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if query.To != "" {
+		to, err = parseSearchDate(query.To)
+		if err != nil {
+			return nil, err
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	terms := parseSearchTerms(query.Text)
+	if len(terms) == 0 {
+		return []WorkspaceSearchResult{}, nil
+	}
+
+	o.searchMu.Lock()
+	defer o.searchMu.Unlock()
+	summaries, err := o.refreshIndex()
+	if err != nil {
+		return nil, err
+	}
+	candidates := o.searchIndex.candidates(terms)
+
+	results := []WorkspaceSearchResult{}
+	for id, summary := range summaries {
+		if _, ok := candidates[id]; candidates != nil && !ok {
+			continue
+		}
+		if (query.Backend != "" && summary.Backend != query.Backend) ||
+			(query.Preset != "" && summary.Preset != query.Preset) ||
+			(!from.IsZero() && summary.CreatedAt.Before(from)) ||
+			(!to.IsZero() && !summary.CreatedAt.Before(to)) {
+			continue
+		}
+		var matches []WorkspaceSearchMatch
+		for _, document := range o.searchIndex.documents[id] {
+			if len(query.Roles) != 0 && !slices.Contains(query.Roles, document.role) {
+				continue
+			}
+			lower := lowerText(document.text)
+			if !lo.EveryBy(terms, func(term string) bool {
+				return strings.Contains(lower, term)
+			}) {
+				continue
+			}
+			matches = append(matches, WorkspaceSearchMatch{
+				Role:         document.role,
+				Type:         document.typ,
+				MessageIndex: document.messageIndex,
+				Snippet:      makeSnippet(document, lower, terms),
+			})
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		results = append(results, WorkspaceSearchResult{
+			WorkspaceID: id,
+			Title:       summary.Title,
+			CreatedAt:   summary.CreatedAt,
+			Backend:     summary.Backend,
+			Preset:      summary.Preset,
+			Matches:     matches,
+		})
+	}
+
+	// matched titles first, then workspaces with more matches, then newer ones
+	slices.SortFunc(results, func(a, b WorkspaceSearchResult) int {
+		aTitle, bTitle := a.Matches[0].MessageIndex == -1, b.Matches[0].MessageIndex == -1
+		if aTitle != bTitle {
+			return lo.Ternary(aTitle, -1, 1)
+		}
+		if len(a.Matches) != len(b.Matches) {
+			return len(b.Matches) - len(a.Matches)
+		}
+		return b.WorkspaceID - a.WorkspaceID
+	})
+	limit := lo.Ternary(query.Limit > 0, query.Limit, defaultSearchLimit)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// SearchWorkspaces finds the messages and titles containing all terms of the query, where quoted terms are phrases
+func (a *App) SearchWorkspaces(query WorkspaceSearchQuery) ([]WorkspaceSearchResult, error) {
+	return a.workspaces.search(query)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkspaceIndexCandidates(t *testing.T) {
+	index := newWorkspaceIndex()
+	index.add(Workspace{ID: 1, Title: "Go generics", Context: "[user](#message)\nHow do generics work?\n\n"})
+	index.add(Workspace{ID: 2, Title: "Cooking",
+		Context: "[user](#message)\nA recipe for pasta\n\n[assistant](#message)\nBoil the water.\n\n"})
+	index.add(Workspace{ID: 3, Title: "Travel", Context: "Notes about Paris"})
+
+	tests := []struct {
+		name  string
+		terms []string
+		want  map[int]struct{}
+	}{
+		{"title and message", []string{"generics"}, map[int]struct{}{1: {}}},
+		{"all terms", []string{"water", "pasta"}, map[int]struct{}{2: {}}},
+		{"context without headers", []string{"paris"}, map[int]struct{}{3: {}}},
+		{"short term matches any", []string{"go"}, nil},
+		{"short term ignored", []string{"go", "pasta"}, map[int]struct{}{2: {}}},
+		{"phrase", []string{"for pasta"}, map[int]struct{}{2: {}}},
+		{"not found", []string{"zebra"}, map[int]struct{}{}},
+		{"not all found", []string{"pasta", "generics"}, map[int]struct{}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, index.candidates(tt.terms))
+		})
+	}
+
+	index.add(Workspace{ID: 2, Title: "Cooking", Context: "[user](#message)\nA recipe for rice\n\n"})
+	assert.Equal(t, map[int]struct{}{}, index.candidates([]string{"pasta"}))
+	assert.Equal(t, map[int]struct{}{2: {}}, index.candidates([]string{"rice"}))
+	for id := 1; id <= 3; id++ {
+		index.remove(id)
+	}
+	assert.Empty(t, index.postings)
+	assert.Empty(t, index.documents)
+}
+
+func TestMakeSnippet(t *testing.T) {
+	part := func(text string) SearchSnippetPart {
+		return SearchSnippetPart{Text: text}
+	}
+	highlight := func(text string) SearchSnippetPart {
+		return SearchSnippetPart{Text: text, Highlight: true}
+	}
+	tests := []struct {
+		name  string
+		text  string
+		terms []string
+		want  []SearchSnippetPart
+	}{
+		{"case insensitive", "Hello World", []string{"world"}, []SearchSnippetPart{part("Hello "), highlight("World")}},
+		{"every match", "a cat and a Cat", []string{"cat"},
+			[]SearchSnippetPart{part("a "), highlight("cat"), part(" and a "), highlight("Cat")}},
+		{"every term", "red and blue", []string{"blue", "red"},
+			[]SearchSnippetPart{highlight("red"), part(" and "), highlight("blue")}},
+		{"newlines", "line one\nline two", []string{"two"}, []SearchSnippetPart{part("line one line "), highlight("two")}},
+		{"unicode", "Ünïcode ÄBC straße", []string{"äbc"},
+			[]SearchSnippetPart{part("Ünïcode "), highlight("ÄBC"), part(" straße")}},
+		{"cut around the first match", strings.Repeat("x", 100) + "needle" + strings.Repeat("y", 200), []string{"needle"},
+			[]SearchSnippetPart{part("..."), part(strings.Repeat("x", searchSnippetRadius)), highlight("needle"),
+				part(strings.Repeat("y", 2*searchSnippetRadius-len("needle"))), part("...")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document := searchDocument{text: tt.text}
+			assert.Equal(t, tt.want, makeSnippet(document, lowerText(tt.text), tt.terms))
+		})
+	}
+}
+
+func TestSearchWorkspaces(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewWorkspaceStore(dir)
+	assert.NoError(t, err)
+	date := func(month time.Month) time.Time {
+		return time.Date(2024, month, 1, 12, 0, 0, 0, time.Local)
+	}
+	store.SaveWorkspace(Workspace{ID: 1, Title: "Pasta night", CreatedAt: date(1), Backend: "Sydney",
+		Context: "[user](#message)\nCook pasta with tomato\n\n"})
+	store.SaveWorkspace(Workspace{ID: 2, Title: "Dinner", CreatedAt: date(2), Backend: "OpenAI", Preset: "ChatGPT",
+		Context: "[system](#additional_instructions)\nYou like pasta.\n\n[user](#message)\nPasta or rice?\n\n" +
+			"[assistant](#message)\nPasta, with tomato sauce.\n\n"})
+	store.SaveWorkspace(Workspace{ID: 3, Title: "Travel", CreatedAt: date(3), Context: "[user](#message)\nTrip to Rome\n\n"})
+
+	search := func(query WorkspaceSearchQuery) []int {
+		results, err := store.search(query)
+		assert.NoError(t, err)
+		return lo.Map(results, func(item WorkspaceSearchResult, index int) int {
+			return item.WorkspaceID
+		})
+	}
+	tests := []struct {
+		name  string
+		query WorkspaceSearchQuery
+		want  []int
+	}{
+		{"titles first", WorkspaceSearchQuery{Text: "pasta"}, []int{1, 2}},
+		{"newer first", WorkspaceSearchQuery{Text: "pasta tomato"}, []int{2, 1}},
+		{"phrase", WorkspaceSearchQuery{Text: `"tomato sauce"`}, []int{2}},
+		{"roles", WorkspaceSearchQuery{Text: "pasta", Roles: []string{"user"}}, []int{2, 1}},
+		{"backend", WorkspaceSearchQuery{Text: "pasta", Backend: "OpenAI"}, []int{2}},
+		{"preset", WorkspaceSearchQuery{Text: "pasta", Preset: "Sydney"}, []int{}},
+		{"from", WorkspaceSearchQuery{Text: "pasta", From: "2024-01-15"}, []int{2}},
+		{"to", WorkspaceSearchQuery{Text: "pasta", To: "2024-01-01"}, []int{1}},
+		{"limit", WorkspaceSearchQuery{Text: "pasta", Limit: 1}, []int{1}},
+		{"empty", WorkspaceSearchQuery{Text: " "}, []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, search(tt.query))
+		})
+	}
+
+	results, err := store.search(WorkspaceSearchQuery{Text: `"Tomato sauce"`})
+	assert.NoError(t, err)
+	assert.Equal(t, []WorkspaceSearchMatch{{Role: "assistant", Type: "message", MessageIndex: 2, Snippet: []SearchSnippetPart{
+		{Text: "Pasta, with "}, {Text: "tomato sauce", Highlight: true}, {Text: "."},
+	}}}, results[0].Matches)
+	_, err = store.search(WorkspaceSearchQuery{Text: "pasta", From: "yesterday"})
+	assert.Error(t, err)
+
+	// changes are indexed at the next search
+	store.SaveWorkspace(Workspace{ID: 3, Title: "Travel", CreatedAt: date(3), Context: "[user](#message)\nTrip to Naples\n\n"})
+	assert.Equal(t, []int{}, search(WorkspaceSearchQuery{Text: "rome"}))
+	assert.Equal(t, []int{3}, search(WorkspaceSearchQuery{Text: "naples"}))
+	assert.NoError(t, store.DeleteWorkspace(2))
+	assert.Equal(t, []int{}, search(WorkspaceSearchQuery{Text: "sauce"}))
+	store.close()
+
+	// workspaces which are not loaded are read from their files
+	store, err = NewWorkspaceStore(dir)
+	assert.NoError(t, err)
+	defer store.close()
+	assert.Equal(t, []int{3}, search(WorkspaceSearchQuery{Text: "naples"}))
+	assert.Equal(t, []int{1}, search(WorkspaceSearchQuery{Text: "tomato"}))
+}
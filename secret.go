@@ -0,0 +1,280 @@
+package main
+
+import (
+	"github.com/ncruces/zenity"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sydneyqt/backend"
+	"sydneyqt/util"
+)
+
+// maskSecret keeps the beginning and the end of a secret to tell keys apart, which is what the frontend sees
+func maskSecret(secret string) string {
+	if len(secret) < 12 {
+		return strings.Repeat("*", len(secret))
+	}
+	return secret[:3] + strings.Repeat("*", 8) + secret[len(secret)-4:]
+}
+
+// askSecretPassphrase prompts for the passphrase before the window shows, and exits if canceled
+func askSecretPassphrase(prompt string) (string, error) {
+	passphrase, err := zenity.Entry(prompt, zenity.Title("SydneyQt"), zenity.HideText())
+	if errors.Is(err, zenity.ErrCanceled) {
+		os.Exit(0)
+	}
+	return passphrase, err
+}
+
+// backendKey returns the key in the options of the type of the backend, or nil if the type needs no key
+func backendKey(config *backend.Config) *string {
+	switch config.Type {
+	case backend.TypeAnthropic:
+		return &config.Anthropic.Key
+	case backend.TypeGemini:
+		return &config.Gemini.Key
+	default:
+		return nil
+	}
+}
+
+// warnClearedSecrets tells the user which secrets have been cleared, since they cannot be decrypted
+func warnClearedSecrets(names []string) {
+	if len(names) == 0 {
+		return
+	}
+	slog.Warn("Secrets cannot be decrypted and are cleared", "secrets", names)
+	_ = zenity.Warning("The following secrets cannot be decrypted, since the secret key is lost or has changed, "+
+		"and have been cleared. Please enter them again.\n\n"+strings.Join(names, "\n"),
+		zenity.Title("SydneyQt"))
+}
+
+// decryptConfig replaces the encrypted secrets of the config read from disk, and returns whether the config
+// should be written again, since a secret was in plaintext or has been cleared, and the names of the cleared ones.
+// Secrets which cannot be decrypted are cleared, otherwise the app would fail at every startup.
+func decryptConfig(config *Config, secrets *util.SecretStore) (bool, []string) {
+	rewrite := false
+	var cleared []string
+	for i, backend := range config.OpenAIBackends {
+		if !util.IsEncryptedSecret(backend.OpenaiKey) {
+			rewrite = rewrite || backend.OpenaiKey != ""
+			continue
+		}
+		key, err := secrets.Decrypt(backend.OpenaiKey)
+		if err != nil {
+			slog.Warn("Cannot decrypt the key of OpenAI backend", "name", backend.Name, "err", err)
+			cleared = append(cleared, "the key of OpenAI backend "+backend.Name)
+			rewrite = true
+		}
+		config.OpenAIBackends[i].OpenaiKey = key
+	}
+	for i := range config.Backends {
+		key := backendKey(&config.Backends[i])
+		if key == nil {
+			continue
+		}
+		if !util.IsEncryptedSecret(*key) {
+			rewrite = rewrite || *key != ""
+			continue
+		}
+		decrypted, err := secrets.Decrypt(*key)
+		if err != nil {
+			slog.Warn("Cannot decrypt the key of backend", "name", config.Backends[i].Name, "err", err)
+			cleared = append(cleared, "the key of backend "+config.Backends[i].Name)
+			rewrite = true
+		}
+		*key = decrypted
+	}
+	return rewrite, cleared
+}
+
+// encryptConfig returns a copy of the config with the secrets encrypted to be written to disk
+func encryptConfig(config Config, secrets util.SecretEncrypter) (Config, error) {
+	config.OpenAIBackends = slices.Clone(config.OpenAIBackends)
+	for i, backend := range config.OpenAIBackends {
+		key, err := secrets.Encrypt(backend.OpenaiKey)
+		if err != nil {
+			return Config{}, err
+		}
+		config.OpenAIBackends[i].OpenaiKey = key
+	}
+	config.Backends = slices.Clone(config.Backends)
+	for i := range config.Backends {
+		key := backendKey(&config.Backends[i])
+		if key == nil {
+			continue
+		}
+		encrypted, err := secrets.Encrypt(*key)
+		if err != nil {
+			return Config{}, err
+		}
+		*key = encrypted
+	}
+	return config, nil
+}
+
+// maskConfig returns a copy of the config with the secrets masked to be sent to the frontend
+func maskConfig(config Config) Config {
+	config.OpenAIBackends = lo.Map(config.OpenAIBackends, func(item OpenAIBackend, index int) OpenAIBackend {
+		item.OpenaiKey = maskSecret(item.OpenaiKey)
+		return item
+	})
+	config.Backends = lo.Map(config.Backends, func(item backend.Config, index int) backend.Config {
+		if key := backendKey(&item); key != nil {
+			*key = maskSecret(*key)
+		}
+		return item
+	})
+	return config
+}
+
+// unmaskConfig restores the secrets which are left masked in the config from the frontend,
+// including those of backends copied or renamed from existing ones
+func unmaskConfig(config Config, previous Config) Config {
+	config.OpenAIBackends = lo.Map(config.OpenAIBackends, func(item OpenAIBackend, index int) OpenAIBackend {
+		candidates := previous.OpenAIBackends
+		if backend, ok := lo.Find(previous.OpenAIBackends, func(backend OpenAIBackend) bool {
+			return backend.Name == item.Name
+		}); ok {
+			candidates = append([]OpenAIBackend{backend}, candidates...)
+		}
+		for _, backend := range candidates {
+			if backend.OpenaiKey != "" && item.OpenaiKey == maskSecret(backend.OpenaiKey) {
+				item.OpenaiKey = backend.OpenaiKey
+				break
+			}
+		}
+		return item
+	})
+	config.Backends = lo.Map(config.Backends, func(item backend.Config, index int) backend.Config {
+		key := backendKey(&item)
+		if key == nil {
+			return item
+		}
+		candidates := previous.Backends
+		if previousItem, ok := lo.Find(previous.Backends, func(previousItem backend.Config) bool {
+			return previousItem.Name == item.Name
+		}); ok {
+			candidates = append([]backend.Config{previousItem}, candidates...)
+		}
+		for _, candidate := range candidates {
+			previousKey := backendKey(&candidate)
+			if previousKey != nil && *previousKey != "" && *key == maskSecret(*previousKey) {
+				*key = *previousKey
+				break
+			}
+		}
+		return item
+	})
+	return config
+}
+
+// RevealOpenAIKey returns the key of the backend, which GetConfig masks
+func (o *Settings) RevealOpenAIKey(backendName string) (string, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	backend, ok := lo.Find(o.config.OpenAIBackends, func(item OpenAIBackend) bool {
+		return item.Name == backendName
+	})
+	if !ok {
+		return "", errors.New("OpenAI backend not exist: " + backendName)
+	}
+	return backend.OpenaiKey, nil
+}
+
+// RevealBackendKey returns the key of the Anthropic or Gemini backend, which GetConfig masks
+func (o *Settings) RevealBackendKey(backendName string) (string, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	item, ok := lo.Find(o.config.Backends, func(item backend.Config) bool {
+		return item.Name == backendName
+	})
+	if !ok {
+		return "", errors.New("backend not exist: " + backendName)
+	}
+	key := backendKey(&item)
+	if key == nil {
+		return "", nil
+	}
+	return *key, nil
+}
+
+// GetSecretMode returns keyring or passphrase, which is where the key encrypting secrets comes from
+func (o *Settings) GetSecretMode() string {
+	return o.secrets.Mode()
+}
+
+// switchSecretKey changes to the key created by the function, and encrypts the cookies of all profiles
+// and the config again with it. Everything is written to temporary files before the key file is switched,
+// so that a failure leaves the current key and secrets working, and the files are renamed right after.
+func (o *Settings) switchSecretKey(newKey func() (*util.SecretKeyChange, error)) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	cookiesOfProfiles := map[string][]util.FileCookie{}
+	for _, profile := range o.config.CookieProfiles {
+		path := cookieProfilePath(profile.Name)
+		cookies, err := util.ReadCookiesFileRawAt(path)
+		if err != nil {
+			return errors.Wrap(err, "cannot read cookies of profile "+profile.Name)
+		}
+		if cookies != nil {
+			cookiesOfProfiles[path] = cookies
+		}
+	}
+	change, err := newKey()
+	if err != nil {
+		return err
+	}
+	var written []string
+	abandon := func(err error) error {
+		change.Discard()
+		for _, path := range written {
+			_ = os.Remove(path + ".tmp")
+		}
+		return err
+	}
+	for path, cookies := range cookiesOfProfiles {
+		err = util.WriteCookiesFileWith(path+".tmp", cookies, change)
+		if err != nil {
+			return abandon(err)
+		}
+		written = append(written, path)
+	}
+	v, err := o.marshalConfig(change)
+	if err != nil {
+		return abandon(err)
+	}
+	configPath := util.WithPath("config.json")
+	err = os.WriteFile(configPath+".tmp", v, 0600)
+	if err != nil {
+		return abandon(err)
+	}
+	written = append(written, configPath)
+	removePreviousKey, err := o.secrets.Commit(change)
+	if err != nil {
+		return abandon(err)
+	}
+	for _, path := range written {
+		err = os.Rename(path+".tmp", path)
+		if err != nil {
+			return err
+		}
+	}
+	removePreviousKey()
+	return nil
+}
+
+// SetSecretPassphrase encrypts secrets with a key derived from the passphrase, which is asked at every startup
+func (o *Settings) SetSecretPassphrase(passphrase string) error {
+	return o.switchSecretKey(func() (*util.SecretKeyChange, error) {
+		return util.NewPassphraseKey(passphrase)
+	})
+}
+
+// UseKeyringForSecrets encrypts secrets with a new key kept in the OS keyring
+func (o *Settings) UseKeyringForSecrets() error {
+	return o.switchSecretKey(util.NewKeyringKey)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sydneyqt/backend"
+)
+
+func TestMaskSecret(t *testing.T) {
+	assert.Equal(t, "sk-********mnop", maskSecret("sk-abcdefghijklmnop"))
+	assert.Equal(t, "*****", maskSecret("short"))
+	assert.Equal(t, "", maskSecret(""))
+}
+
+func TestUnmaskConfig(t *testing.T) {
+	openAIKey, otherKey, anthropicKey := "sk-abcdefghijklmnop", "sk-zyxwvutsrqponmlk", "sk-ant-1234567890"
+	previous := Config{
+		OpenAIBackends: []OpenAIBackend{{Name: "OpenAI", OpenaiKey: openAIKey}, {Name: "Other", OpenaiKey: otherKey}},
+		Backends: []backend.Config{
+			{Name: "Claude", Type: backend.TypeAnthropic, Anthropic: backend.AnthropicOptions{Key: anthropicKey}},
+			{Name: "Ollama", Type: backend.TypeOllama},
+		},
+	}
+	tests := []struct {
+		name    string
+		key     string // of the OpenAI backend named OpenAI
+		renamed string // the name of the OpenAI backend if not empty
+		want    string
+	}{
+		{"masked", maskSecret(openAIKey), "", openAIKey},
+		{"changed", "sk-new-key-0000000", "", "sk-new-key-0000000"},
+		{"cleared", "", "", ""},
+		{"renamed", maskSecret(openAIKey), "OpenAI 2", openAIKey},
+		{"copied from another backend", maskSecret(otherKey), "", otherKey},
+		{"unknown mask", maskSecret("sk-unknown-key-000"), "", maskSecret("sk-unknown-key-000")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := maskConfig(previous)
+			config.OpenAIBackends[0].OpenaiKey = tt.key
+			if tt.renamed != "" {
+				config.OpenAIBackends[0].Name = tt.renamed
+			}
+			config = unmaskConfig(config, previous)
+			assert.Equal(t, tt.want, config.OpenAIBackends[0].OpenaiKey)
+			assert.Equal(t, otherKey, config.OpenAIBackends[1].OpenaiKey)
+			assert.Equal(t, previous.Backends, config.Backends)
+		})
+	}
+
+	t.Run("backends", func(t *testing.T) {
+		config := maskConfig(previous)
+		assert.Equal(t, maskSecret(anthropicKey), config.Backends[0].Anthropic.Key)
+		config.Backends[0].Name = "Claude 2"
+		config.Backends = append(config.Backends, backend.Config{Name: "Gemini", Type: backend.TypeGemini,
+			Gemini: backend.GeminiOptions{Key: maskSecret(anthropicKey)}})
+		config = unmaskConfig(config, previous)
+		assert.Equal(t, anthropicKey, config.Backends[0].Anthropic.Key)
+		assert.Equal(t, anthropicKey, config.Backends[2].Gemini.Key)
+		assert.Equal(t, previous.Backends[1], config.Backends[1])
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/go-rod/rod/lib/proto"
 	"github.com/go-rod/stealth"
 	"github.com/google/uuid"
+	"github.com/samber/lo"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -81,7 +82,7 @@ func (o *Sydney) ResolveCaptcha(stopCtx context.Context) (err error) {
 		return stopCtx.Err()
 	case <-waitCh:
 	}
-	slog.Info("Captcha resCookies", "v", resCookies)
+	slog.Info("Captcha resCookies", "names", lo.Keys(resCookies))
 	if err := o.postprocessCaptchaCookies(resCookies); err != nil {
 		return err
 	}
@@ -128,7 +129,7 @@ func (o *Sydney) UpdateModifiedCookies(modifiedCookies map[string]string) {
 	for k, v := range modifiedCookies { // keep the map pointer
 		o.cookies[k] = v
 	}
-	err := util.UpdateCookiesFile(o.cookies)
+	err := util.UpdateCookiesFileAt(o.cookiesFile, o.cookies)
 	if err != nil {
 		slog.Warn("Cannot update cookies file: ", "err", err)
 	}
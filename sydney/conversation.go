@@ -3,6 +3,7 @@ package sydney
 import (
 	"encoding/json"
 	"errors"
+	"github.com/samber/lo"
 	"log/slog"
 	"strconv"
 	"strings"
@@ -10,6 +11,12 @@ import (
 	"time"
 )
 
+// CreateConversation creates a conversation without asking anything,
+// which can be used to check whether the account is able to chat
+func (o *Sydney) CreateConversation() (CreateConversationResponse, error) {
+	return o.createConversation()
+}
+
 func (o *Sydney) createConversation() (CreateConversationResponse, error) {
 	var empty CreateConversationResponse
 	_, client, err := util.MakeHTTPClient(o.proxy, 10*time.Second)
@@ -46,7 +53,7 @@ func (o *Sydney) createConversation() (CreateConversationResponse, error) {
 		cookieFields = append(cookieFields, strings.Split(field, ";")[0])
 	}
 	newCookies := util.ParseCookiesFromString(strings.Join(cookieFields, "; "))
-	slog.Info("Cookies to update when creating conversation", "diff", newCookies)
+	slog.Info("Cookies to update when creating conversation", "names", lo.Keys(newCookies))
 	o.UpdateModifiedCookies(newCookies)
 	slog.Debug("Create conversation", "response", response)
 	slog.Info("Created Conversation")
@@ -8,20 +8,17 @@ import (
 	"time"
 )
 
+// GetUser returns the name of the account of the cookies
 func (o *Sydney) GetUser() (string, error) {
 	_, client, err := util.MakeHTTPClient(o.proxy, 15*time.Second)
 	if err != nil {
 		return "", err
 	}
-	cookies, err := util.ReadCookiesFile()
-	if err != nil {
-		return "", err
-	}
-	if len(cookies) == 0 {
-		return "", errors.New("cookie file is empty")
+	if len(o.cookies) == 0 {
+		return "", errors.New("cookies are empty")
 	}
 	resp, err := client.R().
-		SetHeader("Cookie", util.FormatCookieString(cookies)).
+		SetHeader("Cookie", util.FormatCookieString(o.cookies)).
 		Get("https://www.bing.com/search?q=Bing+AI&showconv=1")
 	if err != nil {
 		return "", err
@@ -323,24 +323,33 @@ func (o *Sydney) AskStream(options AskStreamOptions) (<-chan Message, error) {
 	return out, nil
 }
 func (o *Sydney) AskStreamRaw(options AskStreamOptions) (CreateConversationResponse, <-chan RawMessage, error) {
-	slog.Info("AskStreamRaw called, creating conversation...")
-	conversation, err := o.createConversation()
-	if err != nil {
-		return CreateConversationResponse{}, nil, err
+	var conversation CreateConversationResponse
+	var err error
+	if options.Conversation != nil {
+		conversation = *options.Conversation
+		slog.Info("AskStreamRaw called, continuing conversation", "conversation-id", conversation.ConversationId,
+			"turn", options.Turn)
+	} else {
+		slog.Info("AskStreamRaw called, creating conversation...")
+		conversation, err = o.createConversation()
+		if err != nil {
+			return CreateConversationResponse{}, nil, err
+		}
+		slog.Info("Conversation created", "conversation-id", conversation.ConversationId)
 	}
-	slog.Info("Conversation created", "conversation-id", conversation.ConversationId)
 	select {
 	case <-options.StopCtx.Done():
 		return conversation, nil, options.StopCtx.Err()
 	default:
 	}
-	previousMessages := []PreviousMessage{
-		{
+	previousMessages := []PreviousMessage{}
+	if options.Turn == 0 {
+		previousMessages = append(previousMessages, PreviousMessage{
 			Author:      "user",
 			Description: options.WebpageContext,
 			ContextType: "WebPage",
 			MessageType: "Context",
-		},
+		})
 	}
 	var uploadFileResult UploadFileResult
 	if options.UploadFilePath != "" {
@@ -448,7 +457,7 @@ func (o *Sydney) AskStreamRaw(options AskStreamOptions) (CreateConversationRespo
 					Plugins:             o.plugins,
 					TraceId:             util.MustGenerateRandomHex(16),
 					RequestId:           messageID,
-					IsStartOfSession:    true,
+					IsStartOfSession:    options.Turn == 0,
 					Message: ArgumentMessage{
 						Locale: o.locale,
 						Market: o.locale,
@@ -483,7 +492,7 @@ func (o *Sydney) AskStreamRaw(options AskStreamOptions) (CreateConversationRespo
 					GptId:            o.gptID,
 				},
 			},
-			InvocationId: "0",
+			InvocationId: strconv.Itoa(options.Turn),
 			Target:       "chat",
 			Type:         4,
 		}
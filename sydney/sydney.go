@@ -25,6 +25,7 @@ type Sydney struct {
 	allowedMessageTypes []string
 	headers             func() map[string]string
 	cookies             map[string]string
+	cookiesFile         string
 	gptID               string
 	plugins             []ArgumentPlugin
 }
@@ -115,6 +116,7 @@ func NewSydney(options Options) *Sydney {
 		createConversationURL: util.Ternary(options.CreateConversationURL == "",
 			"https://edgeservices.bing.com/edgesvc/turing/conversation/create", options.CreateConversationURL),
 		bypassServer: options.BypassServer,
+		cookiesFile:  util.Ternary(options.CookiesFile == "", util.WithPath("cookies.json"), options.CookiesFile),
 		optionsSet:   optionsSet,
 		sliceIDs:     []string{},
 		locationHint: LocationHint{
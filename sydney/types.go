@@ -143,6 +143,7 @@ type Options struct {
 	GPT4Turbo             bool
 	BypassServer          string
 	Plugins               []string
+	CookiesFile           string // where cookies updated by Bing are written back, cookies.json if empty
 }
 type AskStreamOptions struct {
 	StopCtx        context.Context
@@ -150,6 +151,10 @@ type AskStreamOptions struct {
 	WebpageContext string
 	ImageURL       string
 	UploadFilePath string
+	// Conversation is continued instead of creating a new one if not nil.
+	// Turn is the number of questions asked in it before, and WebpageContext is only sent at the first turn.
+	Conversation *CreateConversationResponse
+	Turn         int
 
 	messageID            string // A random uuid. Optional.
 	disableCaptchaBypass bool
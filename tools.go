@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sydneyqt/util"
+)
+
+const (
+	ToolApprovalAsk    = "ask"
+	ToolApprovalAlways = "always"
+	ToolApprovalNever  = "never"
+)
+
+const (
+	// maxToolRounds limits how many times the assistant can call tools before it has to reply
+	maxToolRounds = 8
+	// toolResultLimit is the max runes of a tool result kept in the chat context
+	toolResultLimit = 20000
+)
+
+// ToolApprovals maps the name of a local tool to its approval, which is ask if absent
+type ToolApprovals map[string]string
+
+// LocalTool is a function running on the machine of the user, which the OpenAI backend can call
+type LocalTool struct {
+	Name        string
+	Description string
+	Parameters  jsonschema.Definition
+	Run         func(a *App, arguments string) (string, error)
+}
+type LocalToolInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func decodeToolArguments[T any](arguments string) (T, error) {
+	var args T
+	err := json.Unmarshal([]byte(arguments), &args)
+	if err != nil {
+		return args, errors.Wrap(err, "invalid arguments")
+	}
+	return args, nil
+}
+
+var localTools = []LocalTool{
+	{
+		Name:        "fetch_webpage",
+		Description: "Fetch a webpage and return its title and text content.",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"url": {Type: jsonschema.String, Description: "The URL of the webpage, starting with http or https"},
+			},
+			Required: []string{"url"},
+		},
+		Run: func(a *App, arguments string) (string, error) {
+			args, err := decodeToolArguments[struct {
+				URL string `json:"url"`
+			}](arguments)
+			if err != nil {
+				return "", err
+			}
+			result, err := a.FetchWebpage(args.URL)
+			if err != nil {
+				return "", err
+			}
+			return "# " + result.Title + "\n\n" + result.Content, nil
+		},
+	},
+	{
+		Name:        "read_document",
+		Description: "Read the text of a local document in PDF, DOCX, PPTX, TXT or Markdown.",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"path": {Type: jsonschema.String, Description: "The absolute path of the document"},
+			},
+			Required: []string{"path"},
+		},
+		Run: func(a *App, arguments string) (string, error) {
+			args, err := decodeToolArguments[struct {
+				Path string `json:"path"`
+			}](arguments)
+			if err != nil {
+				return "", err
+			}
+			docReader, err := documentReaderFor(filepath.Ext(args.Path))
+			if err != nil {
+				return "", err
+			}
+			return docReader.Read(args.Path)
+		},
+	},
+	{
+		Name:        "get_youtube_transcript",
+		Description: "Get the title and the transcript of a YouTube video.",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"url": {Type: jsonschema.String, Description: "The URL of the video"},
+				"language_code": {Type: jsonschema.String,
+					Description: "The language code of the preferred captions, such as en. Optional."},
+			},
+			Required: []string{"url"},
+		},
+		Run: func(a *App, arguments string) (string, error) {
+			args, err := decodeToolArguments[struct {
+				URL          string `json:"url"`
+				LanguageCode string `json:"language_code"`
+			}](arguments)
+			if err != nil {
+				return "", err
+			}
+			video, err := a.GetYoutubeVideo(args.URL)
+			if err != nil {
+				return "", err
+			}
+			if len(video.Captions) == 0 {
+				return "", errors.New("the video has no captions")
+			}
+			caption, ok := lo.Find(video.Captions, func(item util.YtCustomCaption) bool {
+				return item.LanguageCode == args.LanguageCode
+			})
+			if !ok {
+				caption, ok = lo.Find(video.Captions, func(item util.YtCustomCaption) bool {
+					return !item.IsTranslated
+				})
+			}
+			if !ok {
+				caption = video.Captions[0]
+			}
+			transcript, err := a.GetYoutubeTranscript(caption)
+			if err != nil {
+				return "", err
+			}
+			lines := lo.Map(transcript, func(item util.YtTranscriptText, index int) string {
+				return item.Value
+			})
+			return "# " + video.Details.Title + "\n\n" + strings.Join(lines, "\n"), nil
+		},
+	},
+	{
+		Name:        "evaluate_arithmetic",
+		Description: "Evaluate an arithmetic expression of numbers, + - * / % ^ and parentheses.",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"expression": {Type: jsonschema.String, Description: "The expression, such as (1 + 2) * 3 ^ 2"},
+			},
+			Required: []string{"expression"},
+		},
+		Run: func(a *App, arguments string) (string, error) {
+			args, err := decodeToolArguments[struct {
+				Expression string `json:"expression"`
+			}](arguments)
+			if err != nil {
+				return "", err
+			}
+			v, err := util.EvalArithmetic(args.Expression)
+			if err != nil {
+				return "", err
+			}
+			return strconv.FormatFloat(v, 'g', -1, 64), nil
+		},
+	},
+}
+
+func (a *App) ListLocalTools() []LocalToolInfo {
+	return lo.Map(localTools, func(item LocalTool, index int) LocalToolInfo {
+		return LocalToolInfo{Name: item.Name, Description: item.Description}
+	})
+}
+
+// toolApproval returns whether a tool call is run without asking, asked or never offered to the assistant
+func (a *App) toolApproval(name string) string {
+	// the config may be replaced by SetConfig during a chat
+	a.settings.mu.RLock()
+	defer a.settings.mu.RUnlock()
+	approval, ok := a.settings.config.ToolApprovals[name]
+	return lo.Ternary(ok, approval, ToolApprovalAsk)
+}
+
+// openAITools returns the tools offered to the assistant
+func (a *App) openAITools() []openai.Tool {
+	var tools []openai.Tool
+	for _, tool := range localTools {
+		if a.toolApproval(tool.Name) == ToolApprovalNever {
+			continue
+		}
+		definition := openai.FunctionDefinition{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.Parameters,
+		}
+		tools = append(tools, openai.Tool{Type: openai.ToolTypeFunction, Function: &definition})
+	}
+	return tools
+}
+
+// approveToolCall asks the user in the frontend unless the tool is always allowed, and waits for the answer
+func (a *App) approveToolCall(ctx context.Context, block util.ToolCallBlock) (bool, error) {
+	switch a.toolApproval(block.Name) {
+	case ToolApprovalAlways:
+		return true, nil
+	case ToolApprovalNever:
+		return false, nil
+	}
+	ch := make(chan bool, 1)
+	runtime.EventsOn(a.ctx, EventChatToolApprovalResult, func(optionalData ...interface{}) {
+		if len(optionalData) < 2 || optionalData[0] != block.ID {
+			return
+		}
+		approved, _ := optionalData[1].(bool)
+		select {
+		case ch <- approved:
+		default:
+		}
+	})
+	defer runtime.EventsOff(a.ctx, EventChatToolApprovalResult)
+	runtime.EventsEmit(a.ctx, EventChatToolApproval, block)
+	select {
+	case approved := <-ch:
+		return approved, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// runToolCall runs the tool if approved, and returns the block with the result, which is an error message on failure
+func (a *App) runToolCall(ctx context.Context, call openai.ToolCall) (util.ToolCallBlock, error) {
+	block := util.ToolCallBlock{
+		ID:        call.ID,
+		Name:      call.Function.Name,
+		Arguments: call.Function.Arguments,
+	}
+	tool, ok := lo.Find(localTools, func(item LocalTool) bool {
+		return item.Name == call.Function.Name
+	})
+	if !ok {
+		block.Result = "Error: tool not exist: " + call.Function.Name
+		return block, nil
+	}
+	approved, err := a.approveToolCall(ctx, block)
+	if err != nil {
+		return block, err
+	}
+	if !approved {
+		block.Result = "Error: the user denied running the tool"
+		return block, nil
+	}
+	result, err := tool.Run(a, call.Function.Arguments)
+	if err != nil {
+		block.Result = "Error: " + err.Error()
+		return block, nil
+	}
+	if runes := []rune(result); len(runes) > toolResultLimit {
+		result = string(runes[:toolResultLimit]) + "\n(truncated)"
+	}
+	block.Result = result
+	return block, nil
+}
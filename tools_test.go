@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAITools(t *testing.T) {
+	app := &App{settings: &Settings{config: Config{ToolApprovals: ToolApprovals{
+		"fetch_webpage":       ToolApprovalNever,
+		"evaluate_arithmetic": ToolApprovalAlways,
+	}}}}
+	assert.Equal(t, ToolApprovalNever, app.toolApproval("fetch_webpage"))
+	assert.Equal(t, ToolApprovalAsk, app.toolApproval("read_document"))
+	names := lo.Map(app.openAITools(), func(item openai.Tool, index int) string {
+		return item.Function.Name
+	})
+	assert.Equal(t, []string{"read_document", "get_youtube_transcript", "evaluate_arithmetic"}, names)
+}
+
+func TestRunToolCall(t *testing.T) {
+	app := &App{settings: &Settings{config: Config{ToolApprovals: ToolApprovals{
+		"evaluate_arithmetic": ToolApprovalAlways,
+		"fetch_webpage":       ToolApprovalNever,
+	}}}}
+	tests := []struct {
+		name      string
+		tool      string
+		arguments string
+		result    string
+	}{
+		{"run", "evaluate_arithmetic", `{"expression":"(1 + 2) * 3 ^ 2"}`, "27"},
+		{"invalid arguments", "evaluate_arithmetic", `{"expression":`, "Error: invalid arguments: unexpected end of JSON input"},
+		{"failed", "evaluate_arithmetic", `{"expression":"1 +"}`, "Error: unexpected end of expression"},
+		{"denied", "fetch_webpage", `{"url":"https://example.com"}`, "Error: the user denied running the tool"},
+		{"unknown tool", "delete_files", `{}`, "Error: tool not exist: delete_files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, err := app.runToolCall(context.Background(), openai.ToolCall{ID: "call_1", Type: openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: tt.tool, Arguments: tt.arguments}})
+			assert.NoError(t, err)
+			assert.Equal(t, "call_1", block.ID)
+			assert.Equal(t, tt.tool, block.Name)
+			assert.Equal(t, tt.arguments, block.Arguments)
+			assert.Equal(t, tt.result, block.Result)
+		})
+	}
+}
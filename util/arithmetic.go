@@ -0,0 +1,141 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// EvalArithmetic evaluates an expression of numbers, + - * / % ^ and parentheses, where ^ is right associative
+func EvalArithmetic(expression string) (float64, error) {
+	p := &arithmeticParser{input: []rune(expression)}
+	v, err := p.parseExpression()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0, errors.New("result is not a finite number")
+	}
+	return v, nil
+}
+
+type arithmeticParser struct {
+	input []rune
+	pos   int
+}
+
+func (o *arithmeticParser) skipSpaces() {
+	for o.pos < len(o.input) && unicode.IsSpace(o.input[o.pos]) {
+		o.pos++
+	}
+}
+
+// next consumes the operator if it comes next
+func (o *arithmeticParser) next(operators string) (rune, bool) {
+	o.skipSpaces()
+	if o.pos < len(o.input) && strings.ContainsRune(operators, o.input[o.pos]) {
+		o.pos++
+		return o.input[o.pos-1], true
+	}
+	return 0, false
+}
+
+func (o *arithmeticParser) parseExpression() (float64, error) {
+	v, err := o.parseTerm()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op, ok := o.next("+-")
+		if !ok {
+			return v, nil
+		}
+		rhs, err := o.parseTerm()
+		if err != nil {
+			return 0, err
+		}
+		v = Ternary(op == '+', v+rhs, v-rhs)
+	}
+}
+
+func (o *arithmeticParser) parseTerm() (float64, error) {
+	v, err := o.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op, ok := o.next("*/%")
+		if !ok {
+			return v, nil
+		}
+		rhs, err := o.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		if op != '*' && rhs == 0 {
+			return 0, errors.New("division by zero")
+		}
+		switch op {
+		case '*':
+			v *= rhs
+		case '/':
+			v /= rhs
+		case '%':
+			v = math.Mod(v, rhs)
+		}
+	}
+}
+
+func (o *arithmeticParser) parseUnary() (float64, error) {
+	if op, ok := o.next("+-"); ok {
+		v, err := o.parseUnary()
+		return Ternary(op == '-', -v, v), err
+	}
+	return o.parsePower()
+}
+
+func (o *arithmeticParser) parsePower() (float64, error) {
+	v, err := o.parsePrimary()
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := o.next("^"); !ok {
+		return v, nil
+	}
+	exponent, err := o.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow(v, exponent), nil
+}
+
+func (o *arithmeticParser) parsePrimary() (float64, error) {
+	if _, ok := o.next("("); ok {
+		v, err := o.parseExpression()
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := o.next(")"); !ok {
+			return 0, errors.New("missing closing parenthesis")
+		}
+		return v, nil
+	}
+	start := o.pos
+	for o.pos < len(o.input) && (unicode.IsDigit(o.input[o.pos]) || o.input[o.pos] == '.') {
+		o.pos++
+	}
+	if start == o.pos {
+		if o.pos == len(o.input) {
+			return 0, errors.New("unexpected end of expression")
+		}
+		return 0, fmt.Errorf("unexpected %q at position %d", o.input[o.pos], o.pos)
+	}
+	return strconv.ParseFloat(string(o.input[start:o.pos]), 64)
+}
@@ -0,0 +1,28 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalArithmetic(t *testing.T) {
+	cases := map[string]float64{
+		"1 + 2 * 3":       7,
+		"(1 + 2) * 3":     9,
+		"-2 ^ 2":          -4,
+		"2 ^ 3 ^ 2":       512,
+		"10 % 4 - 1.5":    0.5,
+		"7 / 2":           3.5,
+		"- (3 - 5) * -.5": -1,
+	}
+	for expression, expected := range cases {
+		v, err := EvalArithmetic(expression)
+		assert.NoError(t, err, expression)
+		assert.InDelta(t, expected, v, 1e-9, expression)
+	}
+	for _, expression := range []string{"", "1 +", "(1 + 2", "1 / 0", "2 x 3", "1.2.3"} {
+		_, err := EvalArithmetic(expression)
+		assert.Error(t, err, expression)
+	}
+}
@@ -1,15 +1,50 @@
 package util
 
 import (
+	"encoding/json"
 	"github.com/dlclark/regexp2"
 	"github.com/sashabaranov/go-openai"
 	"strings"
 )
 
+// ToolCallBlock is the content of a [assistant](#tool_call) block, which records a call of a local tool with its result
+type ToolCallBlock struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result"`
+}
+
+// FormatToolCallBlock returns the block to append to the chat context, keeping the JSON in one line
+func FormatToolCallBlock(block ToolCallBlock) string {
+	v, _ := json.Marshal(&block)
+	return "[assistant](#tool_call)\n" + string(v) + "\n\n"
+}
+
+// GetOpenAIChatMessages converts the chat context, where tool_call blocks become tool calls and their results
 func GetOpenAIChatMessages(chatContext string) []openai.ChatCompletionMessage {
 	var result []openai.ChatCompletionMessage
 	messages := GetChatMessage(chatContext)
 	for _, msg := range messages {
+		var block ToolCallBlock
+		if msg.Type == "tool_call" && json.Unmarshal([]byte(msg.Content), &block) == nil && block.ID != "" {
+			result = append(result, openai.ChatCompletionMessage{
+				Role: openai.ChatMessageRoleAssistant,
+				ToolCalls: []openai.ToolCall{{
+					ID:   block.ID,
+					Type: openai.ToolTypeFunction,
+					Function: openai.FunctionCall{
+						Name:      block.Name,
+						Arguments: block.Arguments,
+					},
+				}},
+			}, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				Content:    block.Result,
+				ToolCallID: block.ID,
+			})
+			continue
+		}
 		content := msg.Content
 		if msg.Type != "message" && !strings.Contains(msg.Type, "instructions") {
 			content = "# " + msg.Type + "\n" + content
@@ -29,10 +64,37 @@ type ChatMessage struct {
 }
 
 func GetChatMessage(chatContext string) []ChatMessage {
+	result, _ := parseChatMessages(chatContext)
+	return result
+}
+
+// SplitChatContext parses the context like GetChatMessage, and also returns the text each message takes up
+// in the context, from its header to the next header. The first one starts at the beginning of the context,
+// so that the texts add up to the context, whitespace and text before the first header included.
+func SplitChatContext(chatContext string) ([]ChatMessage, []string) {
+	messages, starts := parseChatMessages(chatContext)
+	runes := []rune(chatContext)
+	raws := make([]string, len(messages))
+	for i := range messages {
+		start, end := starts[i], len(runes)
+		if i == 0 {
+			start = 0
+		}
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		raws[i] = string(runes[start:end])
+	}
+	return messages, raws
+}
+
+// parseChatMessages returns the messages and the rune offsets of their headers
+func parseChatMessages(chatContext string) ([]ChatMessage, []int) {
 	ctx := chatContext + "\n\n[system](#sydney__placeholder)"
 	re := regexp2.MustCompile(`\[(system|user|assistant)]\(#(.*?)\)([\s\S]*?)(?=\n.*?(^\[(system|user|assistant)]\(#.*?\)))`,
 		regexp2.IgnoreCase|regexp2.Multiline)
 	var result []ChatMessage
+	var starts []int
 	match, err := re.FindStringMatch(ctx)
 	if err != nil {
 		GracefulPanic(err)
@@ -48,12 +110,13 @@ func GetChatMessage(chatContext string) []ChatMessage {
 			Role:    groups[1].String(),
 			Content: content,
 		})
+		starts = append(starts, match.Index)
 		match, err = re.FindNextMatch(match)
 		if err != nil {
 			GracefulPanic(err)
 		}
 	}
-	return result
+	return result, starts
 }
 func CreateOpenAIClient(proxy string, key string, endpoint string) (*openai.Client, error) {
 	hClient, _, err := MakeHTTPClient(proxy, 0)
@@ -0,0 +1,57 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOpenAIChatMessagesToolCall(t *testing.T) {
+	block := ToolCallBlock{
+		ID:        "call_1",
+		Name:      "evaluate_arithmetic",
+		Arguments: `{"expression":"1 + 1"}`,
+		Result:    "2\n[user](#message)\nnot a message",
+	}
+	chatContext := "[user](#message)\nWhat is 1 + 1?\n\n" + FormatToolCallBlock(block) +
+		"[assistant](#message)\nIt is 2.\n\n[assistant](#tool_call)\nnot json\n\n"
+	messages := GetOpenAIChatMessages(chatContext)
+	assert.Len(t, messages, 5)
+	assert.Equal(t, openai.ChatMessageRoleAssistant, messages[1].Role)
+	assert.Equal(t, []openai.ToolCall{{
+		ID:   "call_1",
+		Type: openai.ToolTypeFunction,
+		Function: openai.FunctionCall{
+			Name:      "evaluate_arithmetic",
+			Arguments: `{"expression":"1 + 1"}`,
+		},
+	}}, messages[1].ToolCalls)
+	assert.Equal(t, openai.ChatCompletionMessage{
+		Role:       openai.ChatMessageRoleTool,
+		Content:    block.Result,
+		ToolCallID: "call_1",
+	}, messages[2])
+	assert.Equal(t, "It is 2.", messages[3].Content)
+	assert.Equal(t, "# tool_call\nnot json", messages[4].Content)
+}
+
+func TestSplitChatContext(t *testing.T) {
+	for _, chatContext := range []string{
+		"",
+		"no header at all",
+		"[user](#message)\nhi\n\n[assistant](#message)\nhello\n\n",
+		"  notes before\n[system](#additional_instructions)\n\n  indented\n\n\n[user](#message)\n你好 [user](#message) inline",
+		"[user](#message)\ntrailing spaces   ",
+	} {
+		messages, raws := SplitChatContext(chatContext)
+		assert.Equal(t, GetChatMessage(chatContext), messages, chatContext)
+		assert.Len(t, raws, len(messages))
+		if len(messages) != 0 {
+			assert.Equal(t, chatContext, strings.Join(raws, ""), chatContext)
+		}
+	}
+	_, raws := SplitChatContext("  notes\n[user](#message)\nhi\n\n[assistant](#message)\nhello")
+	assert.Equal(t, []string{"  notes\n[user](#message)\nhi\n\n", "[assistant](#message)\nhello"}, raws)
+}
@@ -0,0 +1,340 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/scrypt"
+)
+
+// secretPrefix marks an encrypted value, so that plaintext values can be told apart and migrated
+const secretPrefix = "enc:v1:"
+
+const (
+	keyringService = "SydneyQt"
+	keyringUser    = "secret-key"
+	// secretCheckText is encrypted into the key file to tell whether a passphrase is right
+	secretCheckText = "sydneyqt"
+)
+
+const (
+	SecretModeKeyring    = "keyring"
+	SecretModePassphrase = "passphrase"
+)
+
+// secretKeyFile describes where the key comes from. The key itself is never written to it.
+type secretKeyFile struct {
+	Mode        string `json:"mode"`
+	KeyringUser string `json:"keyring_user,omitempty"` // for keyring, where keyringUser is used if empty
+	Salt        []byte `json:"salt,omitempty"`         // for passphrase
+	Check       string `json:"check,omitempty"`        // secretCheckText encrypted with the key derived from the passphrase
+}
+
+func (o secretKeyFile) keyringUser() string {
+	if o.KeyringUser == "" {
+		return keyringUser
+	}
+	return o.KeyringUser
+}
+
+// SecretEncrypter is a SecretStore, or a SecretKeyChange which is not committed yet
+type SecretEncrypter interface {
+	Encrypt(plaintext string) (string, error)
+}
+
+// SecretStore encrypts secrets at rest with AES-GCM, using a random key kept in the OS keyring,
+// or a key derived from a passphrase if the user prefers or the keyring is unavailable.
+type SecretStore struct {
+	path    string
+	mu      sync.RWMutex
+	mode    string
+	key     []byte
+	keyFile secretKeyFile
+}
+
+// SecretKeyChange is a new key, which secrets are encrypted with before the key file is switched to it
+// by SecretStore.Commit, so that a failure in between leaves the current key and secrets working
+type SecretKeyChange struct {
+	key     []byte
+	keyFile secretKeyFile
+}
+
+func deriveSecretKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+// OpenSecretStore loads the key described by the key file at path, creating a key in the keyring at the first run.
+// askPassphrase is called with a prompt when the key is derived from a passphrase, or when the keyring cannot be used,
+// and is called again until the passphrase is right or it returns an error.
+// If the key file is broken or the key is gone from the keyring, a new key is created, and secrets encrypted
+// with the lost key are left for the caller to clear, since they can never be decrypted.
+func OpenSecretStore(path string, askPassphrase func(prompt string) (string, error)) (*SecretStore, error) {
+	store := &SecretStore{path: path}
+	v, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, store.create(askPassphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keyFile secretKeyFile
+	err = json.Unmarshal(v, &keyFile)
+	if err != nil {
+		slog.Warn("Cannot parse the secret key file, creating a new key", "err", err)
+		return store, store.create(askPassphrase)
+	}
+	store.mode = keyFile.Mode
+	store.keyFile = keyFile
+	switch keyFile.Mode {
+	case SecretModeKeyring:
+		encoded, err := keyring.Get(keyringService, keyFile.keyringUser())
+		if err == nil {
+			store.key, err = base64.StdEncoding.DecodeString(encoded)
+		}
+		if err != nil {
+			slog.Warn("Cannot read the secret key from keyring, creating a new key", "err", err)
+			return store, store.create(askPassphrase)
+		}
+	case SecretModePassphrase:
+		prompt := "Enter the passphrase to decrypt secrets:"
+		for {
+			passphrase, err := askPassphrase(prompt)
+			if err != nil {
+				return nil, err
+			}
+			key, err := deriveSecretKey(passphrase, keyFile.Salt)
+			if err != nil {
+				return nil, err
+			}
+			check, err := decryptSecret(key, keyFile.Check)
+			if err == nil && check == secretCheckText {
+				store.key = key
+				break
+			}
+			prompt = "Wrong passphrase. Enter the passphrase to decrypt secrets:"
+		}
+	default:
+		slog.Warn("Unknown secret mode, creating a new key", "mode", keyFile.Mode)
+		return store, store.create(askPassphrase)
+	}
+	return store, nil
+}
+
+// create switches to a new key in the keyring, or derived from a passphrase if the keyring cannot be used
+func (o *SecretStore) create(askPassphrase func(prompt string) (string, error)) error {
+	err := o.UseKeyring()
+	if err == nil {
+		return nil
+	}
+	slog.Warn("Cannot use keyring for secrets, falling back to passphrase", "err", err)
+	passphrase, err := askPassphrase("The keyring is unavailable. Create a passphrase to encrypt secrets:")
+	if err != nil {
+		return err
+	}
+	return o.UsePassphrase(passphrase)
+}
+
+func (o *SecretStore) writeKeyFile(keyFile secretKeyFile) error {
+	v, err := json.MarshalIndent(&keyFile, "", "  ")
+	if err != nil {
+		return err
+	}
+	// the key file is replaced at once, since secrets cannot be decrypted with a broken one
+	err = os.WriteFile(o.path+".tmp", v, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(o.path+".tmp", o.path)
+}
+
+// NewKeyringKey creates a random key kept in a new entry of the OS keyring, which leaves the current key untouched
+func NewKeyringKey() (*SecretKeyChange, error) {
+	key, err := randomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+	id, err := randomBytes(4)
+	if err != nil {
+		return nil, err
+	}
+	user := keyringUser + "-" + hex.EncodeToString(id)
+	err = keyring.Set(keyringService, user, base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		return nil, err
+	}
+	return &SecretKeyChange{key: key, keyFile: secretKeyFile{Mode: SecretModeKeyring, KeyringUser: user}}, nil
+}
+
+// NewPassphraseKey derives a key from the passphrase with a new salt
+func NewPassphraseKey(passphrase string) (*SecretKeyChange, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase cannot be empty")
+	}
+	salt, err := randomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	key, err := deriveSecretKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	check, err := encryptSecret(key, secretCheckText)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretKeyChange{key: key, keyFile: secretKeyFile{Mode: SecretModePassphrase, Salt: salt, Check: check}}, nil
+}
+
+// Encrypt returns the value encrypted with the new key, or the value itself if empty
+func (o *SecretKeyChange) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" || IsEncryptedSecret(plaintext) {
+		return plaintext, nil
+	}
+	return encryptSecret(o.key, plaintext)
+}
+
+// Discard removes the new key from the keyring, if the change is abandoned before it is committed
+func (o *SecretKeyChange) Discard() {
+	if o.keyFile.Mode == SecretModeKeyring {
+		_ = keyring.Delete(keyringService, o.keyFile.keyringUser())
+	}
+}
+
+// Commit writes the key file, after which the new key is used. It returns a function removing the previous key
+// from the keyring, which is to be called once nothing is encrypted with it anymore.
+func (o *SecretStore) Commit(change *SecretKeyChange) (func(), error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	err := o.writeKeyFile(change.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	previous := o.keyFile
+	o.mode = change.keyFile.Mode
+	o.key = change.key
+	o.keyFile = change.keyFile
+	return func() {
+		if previous.Mode == SecretModeKeyring && previous.keyringUser() != change.keyFile.keyringUser() {
+			_ = keyring.Delete(keyringService, previous.keyringUser())
+		}
+	}, nil
+}
+
+// use switches to the new key at once, which is fine when nothing is encrypted with the current key
+func (o *SecretStore) use(change *SecretKeyChange, err error) error {
+	if err != nil {
+		return err
+	}
+	cleanup, err := o.Commit(change)
+	if err != nil {
+		change.Discard()
+		return err
+	}
+	cleanup()
+	return nil
+}
+
+// UseKeyring switches to a new random key kept in the OS keyring.
+// Secrets encrypted with the previous key must be encrypted again, and NewKeyringKey is to be used for that.
+func (o *SecretStore) UseKeyring() error {
+	return o.use(NewKeyringKey())
+}
+
+// UsePassphrase switches to a key derived from the passphrase, and removes the previous key from the keyring.
+// Secrets encrypted with the previous key must be encrypted again, and NewPassphraseKey is to be used for that.
+func (o *SecretStore) UsePassphrase(passphrase string) error {
+	return o.use(NewPassphraseKey(passphrase))
+}
+
+func (o *SecretStore) Mode() string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.mode
+}
+
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+func encryptSecret(key []byte, plaintext string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomBytes(gcm.NonceSize())
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(plaintext), nil)), nil
+}
+
+func decryptSecret(key []byte, value string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return value, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, secretPrefix))
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("encrypted secret too short")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("cannot decrypt secret: " + err.Error())
+	}
+	return string(plaintext), nil
+}
+
+// Encrypt returns the encrypted value, or the value itself if empty
+func (o *SecretStore) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" || IsEncryptedSecret(plaintext) {
+		return plaintext, nil
+	}
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return encryptSecret(o.key, plaintext)
+}
+
+// Decrypt returns the plaintext of an encrypted value, or the value itself if it is not encrypted yet
+func (o *SecretStore) Decrypt(value string) (string, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return decryptSecret(o.key, value)
+}
+
+var secretStore *SecretStore
+
+// SetSecretStore makes the cookie file encrypted with the store.
+// Without a store, as in the web API, cookies are read and written in plaintext.
+func SetSecretStore(store *SecretStore) {
+	secretStore = store
+}
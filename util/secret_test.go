@@ -0,0 +1,137 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretStorePassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret.json")
+	store := &SecretStore{path: path}
+	assert.Error(t, store.UsePassphrase(""))
+	assert.NoError(t, store.UsePassphrase("correct horse"))
+	assert.Equal(t, SecretModePassphrase, store.Mode())
+
+	encrypted, err := store.Encrypt("sk-1234567890")
+	assert.NoError(t, err)
+	assert.True(t, IsEncryptedSecret(encrypted))
+	assert.NotContains(t, encrypted, "1234567890")
+	again, err := store.Encrypt(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, encrypted, again)
+	empty, err := store.Encrypt("")
+	assert.NoError(t, err)
+	assert.Empty(t, empty)
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	var prompts []string
+	answers := []string{"wrong", "correct horse"}
+	reopened, err := OpenSecretStore(path, func(prompt string) (string, error) {
+		prompts = append(prompts, prompt)
+		answer := answers[0]
+		answers = answers[1:]
+		return answer, nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, prompts, 2)
+	plaintext, err := reopened.Decrypt(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-1234567890", plaintext)
+	plaintext, err = reopened.Decrypt("not encrypted")
+	assert.NoError(t, err)
+	assert.Equal(t, "not encrypted", plaintext)
+
+	other := &SecretStore{path: filepath.Join(t.TempDir(), "secret.json")}
+	assert.NoError(t, other.UsePassphrase("another"))
+	_, err = other.Decrypt(encrypted)
+	assert.Error(t, err)
+}
+
+func TestSecretKeyChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret.json")
+	store := &SecretStore{path: path}
+	assert.NoError(t, store.UsePassphrase("old"))
+	before, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	oldSecret, err := store.Encrypt("sk-old")
+	assert.NoError(t, err)
+
+	_, err = NewPassphraseKey("")
+	assert.Error(t, err)
+	change, err := NewPassphraseKey("new")
+	assert.NoError(t, err)
+	newSecret, err := change.Encrypt("sk-new")
+	assert.NoError(t, err)
+
+	// nothing changes until the change is committed
+	after, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+	_, err = store.Decrypt(newSecret)
+	assert.Error(t, err)
+	plaintext, err := store.Decrypt(oldSecret)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-old", plaintext)
+
+	removePreviousKey, err := store.Commit(change)
+	assert.NoError(t, err)
+	removePreviousKey()
+	plaintext, err = store.Decrypt(newSecret)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-new", plaintext)
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	reopened, err := OpenSecretStore(path, func(prompt string) (string, error) {
+		return "new", nil
+	})
+	assert.NoError(t, err)
+	plaintext, err = reopened.Decrypt(newSecret)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-new", plaintext)
+}
+
+func TestSecretStoreRecovery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secret.json")
+	store := &SecretStore{path: path}
+	assert.NoError(t, store.UsePassphrase("lost"))
+	cookiesPath := filepath.Join(dir, "cookies.json")
+	SetSecretStore(store)
+	defer SetSecretStore(nil)
+	assert.NoError(t, WriteCookiesFileAt(cookiesPath, []FileCookie{{Name: "_U", Value: "secret"}}))
+
+	// a broken key file is replaced by a new key instead of failing at every startup
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0600))
+	recovered, err := OpenSecretStore(path, func(prompt string) (string, error) {
+		return "new", nil
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, recovered.Mode())
+
+	// values encrypted with the lost key are dropped
+	SetSecretStore(recovered)
+	dropped, err := EncryptCookiesFile(cookiesPath)
+	assert.NoError(t, err)
+	assert.True(t, dropped)
+	cookies, err := ReadCookiesFileAt(cookiesPath)
+	assert.NoError(t, err)
+	assert.Empty(t, cookies)
+
+	assert.NoError(t, os.WriteFile(cookiesPath, []byte(`[{"name": "_U", "value": "plain"}]`), 0644))
+	dropped, err = EncryptCookiesFile(cookiesPath)
+	assert.NoError(t, err)
+	assert.False(t, dropped)
+	v, err := os.ReadFile(cookiesPath)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(v), "plain")
+	cookies, err = ReadCookiesFileAt(cookiesPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"_U": "plain"}, cookies)
+}
@@ -130,7 +130,12 @@ type FileCookie struct {
 }
 
 func ReadCookiesFileRaw() ([]FileCookie, error) {
-	v, err := os.ReadFile(WithPath("cookies.json"))
+	return ReadCookiesFileRawAt(WithPath("cookies.json"))
+}
+
+// ReadCookiesFileRawAt reads the cookie file at path, which is empty if the file does not exist
+func ReadCookiesFileRawAt(path string) ([]FileCookie, error) {
+	v, err := os.ReadFile(path)
 	if err != nil {
 		return nil, nil
 	}
@@ -139,11 +144,26 @@ func ReadCookiesFileRaw() ([]FileCookie, error) {
 	if err != nil {
 		return nil, errors.New("failed to json.Unmarshal content of cookie file")
 	}
+	for i, cookie := range cookies {
+		if !IsEncryptedSecret(cookie.Value) {
+			continue
+		}
+		if secretStore == nil {
+			return nil, errors.New("cookie file is encrypted but no secret key is available")
+		}
+		cookies[i].Value, err = secretStore.Decrypt(cookie.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return cookies, nil
 }
 func ReadCookiesFile() (map[string]string, error) {
+	return ReadCookiesFileAt(WithPath("cookies.json"))
+}
+func ReadCookiesFileAt(path string) (map[string]string, error) {
 	res := map[string]string{}
-	cookies, err := ReadCookiesFileRaw()
+	cookies, err := ReadCookiesFileRawAt(path)
 	if err != nil {
 		return nil, err
 	}
@@ -152,7 +172,51 @@ func ReadCookiesFile() (map[string]string, error) {
 	}
 	return res, nil
 }
+
+// WriteCookiesFile writes the cookies readable only by the user, encrypting the values if there is a secret store
+func WriteCookiesFile(cookies []FileCookie) error {
+	return WriteCookiesFileAt(WithPath("cookies.json"), cookies)
+}
+func WriteCookiesFileAt(path string, cookies []FileCookie) error {
+	if secretStore == nil {
+		return WriteCookiesFileWith(path, cookies, nil)
+	}
+	return WriteCookiesFileWith(path, cookies, secretStore)
+}
+
+// WriteCookiesFileWith writes the cookies with the values encrypted by the encrypter, or in plaintext if it is nil
+func WriteCookiesFileWith(path string, cookies []FileCookie, encrypter SecretEncrypter) error {
+	arr := make([]FileCookie, len(cookies))
+	for i, cookie := range cookies {
+		arr[i] = cookie
+		if encrypter == nil {
+			continue
+		}
+		var err error
+		arr[i].Value, err = encrypter.Encrypt(cookie.Value)
+		if err != nil {
+			return err
+		}
+	}
+	v, err := json.MarshalIndent(&arr, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, v, 0600)
+	if err != nil {
+		return err
+	}
+	// WriteFile keeps the permissions of an existing file
+	return os.Chmod(path, 0600)
+}
 func UpdateCookiesFile(cookies map[string]string) error {
+	return UpdateCookiesFileAt(WithPath("cookies.json"), cookies)
+}
+func UpdateCookiesFileAt(path string, cookies map[string]string) error {
 	var arr []FileCookie
 	for k, v := range cookies {
 		arr = append(arr, FileCookie{
@@ -160,15 +224,42 @@ func UpdateCookiesFile(cookies map[string]string) error {
 			Value: v,
 		})
 	}
-	v, err := json.MarshalIndent(&arr, "", "  ")
+	return WriteCookiesFileAt(path, arr)
+}
+
+// EncryptCookiesFile rewrites the cookie file at path if any value of it is still in plaintext,
+// or cannot be decrypted since the secret key is lost, in which case the values are dropped and true is returned
+func EncryptCookiesFile(path string) (bool, error) {
+	v, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		return false, nil
 	}
-	err = os.WriteFile(WithPath("cookies.json"), v, 0644)
+	var cookies []FileCookie
+	err = json.Unmarshal(v, &cookies)
 	if err != nil {
-		return err
+		return false, errors.New("failed to json.Unmarshal content of cookie file")
+	}
+	if secretStore == nil {
+		return false, errors.New("no secret key is available to encrypt the cookie file")
+	}
+	plaintext, dropped := false, false
+	var result []FileCookie
+	for _, cookie := range cookies {
+		if IsEncryptedSecret(cookie.Value) {
+			cookie.Value, err = secretStore.Decrypt(cookie.Value)
+			if err != nil {
+				dropped = true
+				continue
+			}
+		} else {
+			plaintext = plaintext || cookie.Value != ""
+		}
+		result = append(result, cookie)
+	}
+	if !plaintext && !dropped {
+		return false, os.Chmod(path, 0600)
 	}
-	return nil
+	return dropped, WriteCookiesFileAt(path, result)
 }
 func Map[T any, E any](arr []T, function func(value T) E) []E {
 	var result []E
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sydneyqt/util"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// PublicPaths can be accessed without an API key, so that load balancers are able to probe the server.
+// A valid key is still put in the context, so that they can tell more to authorized requests.
+var PublicPaths = []string{"/healthz", "/readyz"}
+
+var (
+	ErrRateLimited     = errors.New("rate limit reached for requests")
+	ErrQuotaExceeded   = errors.New("daily token quota exceeded")
+	ErrModelRequired   = errors.New("a model is required for this API key")
+	ErrModelNotAllowed = errors.New("model not allowed for this API key")
+)
+
+// AuthTokenLabel is the label of AUTH_TOKEN, which keys in the API keys file cannot use
+const AuthTokenLabel = "default"
+
+// APIKey is an entry of the API keys file. Zero limits mean unlimited.
+type APIKey struct {
+	Key               string   `json:"key"`
+	Label             string   `json:"label"`
+	Models            []string `json:"models"`
+	RequestsPerMinute int      `json:"requests_per_minute"`
+	DailyTokens       int      `json:"daily_tokens"`
+	Cookies           string   `json:"cookies"`
+}
+
+type APIKeyUsage struct {
+	Date          string `json:"date"`
+	DailyTokens   int    `json:"daily_tokens"`
+	DailyRequests int    `json:"daily_requests"`
+	TotalTokens   int    `json:"total_tokens"`
+	TotalRequests int    `json:"total_requests"`
+}
+
+type APIKeyStore struct {
+	mu        sync.Mutex
+	keys      []APIKey
+	usage     map[string]*APIKeyUsage
+	requests  map[string][]time.Time
+	usagePath string
+	version   int
+}
+
+type apiKeyContextKey struct{}
+
+// NewAPIKeyStore loads keys from keysPath if it is not empty and adds authToken as a key without limits,
+// so that the old single token setup keeps working
+func NewAPIKeyStore(keysPath, authToken, usagePath string) (*APIKeyStore, error) {
+	keys, err := LoadAPIKeys(keysPath, authToken)
+	if err != nil {
+		return nil, err
+	}
+	store := &APIKeyStore{
+		keys:      keys,
+		usage:     map[string]*APIKeyUsage{},
+		requests:  map[string][]time.Time{},
+		usagePath: usagePath,
+	}
+	if usagePath != "" {
+		v, err := os.ReadFile(usagePath)
+		if err == nil {
+			err = json.Unmarshal(v, &store.usage)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse API key usage file: %w", err)
+			}
+		}
+		go store.writer()
+	}
+	return store, nil
+}
+
+func LoadAPIKeys(keysPath, authToken string) ([]APIKey, error) {
+	var keys []APIKey
+	if keysPath != "" {
+		v, err := os.ReadFile(keysPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read API keys file: %w", err)
+		}
+		err = json.Unmarshal(v, &keys)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse API keys file: %w", err)
+		}
+		// usage, limits, accounts and owners are all tracked by labels, so they must be unique
+		labels := map[string]bool{}
+		for i, key := range keys {
+			if key.Key == "" {
+				return nil, fmt.Errorf("API key #%d is empty", i)
+			}
+			if key.Label == "" {
+				keys[i].Label = "key-" + strconv.Itoa(i)
+			}
+			if keys[i].Label == AuthTokenLabel {
+				return nil, fmt.Errorf("API key #%d uses the reserved label %s", i, AuthTokenLabel)
+			}
+			if labels[keys[i].Label] {
+				return nil, fmt.Errorf("API key #%d has a duplicate label %s", i, keys[i].Label)
+			}
+			labels[keys[i].Label] = true
+		}
+	}
+	if authToken != "" {
+		keys = append(keys, APIKey{Key: authToken, Label: AuthTokenLabel})
+	}
+	return keys, nil
+}
+
+// SetKeys replaces the keys while keeping the usage, which is tracked by labels
+func (o *APIKeyStore) SetKeys(keys []APIKey) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.keys = keys
+}
+
+func (o *APIKeyStore) Keys() []APIKey {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.keys
+}
+
+// Enabled reports whether authentication is required
+func (o *APIKeyStore) Enabled() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.keys) != 0
+}
+
+// Authenticate compares the token with every key in constant time
+func (o *APIKeyStore) Authenticate(token string) (APIKey, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var found APIKey
+	ok := false
+	for _, key := range o.keys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key.Key)) == 1 {
+			found = key
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+func (o *APIKeyStore) usageOf(label string, now time.Time) *APIKeyUsage {
+	usage, ok := o.usage[label]
+	if !ok {
+		usage = &APIKeyUsage{}
+		o.usage[label] = usage
+	}
+	if date := now.Format(time.DateOnly); usage.Date != date {
+		usage.Date = date
+		usage.DailyTokens = 0
+		usage.DailyRequests = 0
+	}
+	return usage
+}
+
+// Allow records a request of the key, or returns how long to wait if the key is over its limits
+func (o *APIKeyStore) Allow(key APIKey) (time.Duration, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	usage := o.usageOf(key.Label, now)
+	if key.DailyTokens > 0 && usage.DailyTokens >= key.DailyTokens {
+		tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		return tomorrow.Sub(now), ErrQuotaExceeded
+	}
+	if key.RequestsPerMinute > 0 {
+		requests := lo.Filter(o.requests[key.Label], func(item time.Time, index int) bool {
+			return now.Sub(item) < time.Minute
+		})
+		if len(requests) >= key.RequestsPerMinute {
+			o.requests[key.Label] = requests
+			return time.Minute - now.Sub(requests[0]), ErrRateLimited
+		}
+		o.requests[key.Label] = append(requests, now)
+	}
+	usage.DailyRequests++
+	usage.TotalRequests++
+	o.version++
+	return 0, nil
+}
+
+func (o *APIKeyStore) AddTokens(label string, tokens int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	usage := o.usageOf(label, time.Now())
+	usage.DailyTokens += tokens
+	usage.TotalTokens += tokens
+	o.version++
+}
+
+func (o *APIKeyStore) Usage() map[string]APIKeyUsage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	result := map[string]APIKeyUsage{}
+	for label, usage := range o.usage {
+		result[label] = *usage
+	}
+	return result
+}
+
+// writer saves the usage to disk at most once a second, so that it survives a restart
+func (o *APIKeyStore) writer() {
+	localVersion := 0
+	for {
+		o.mu.Lock()
+		version := o.version
+		var (
+			v   []byte
+			err error
+		)
+		if version > localVersion {
+			v, err = json.MarshalIndent(&o.usage, "", "  ")
+		}
+		o.mu.Unlock()
+		if version > localVersion {
+			// requests are not blocked by the disk, and a crash leaves either the old or the new file
+			if err == nil {
+				err = os.WriteFile(o.usagePath+".tmp", v, 0600)
+			}
+			if err == nil {
+				err = os.Rename(o.usagePath+".tmp", o.usagePath)
+			}
+			if err != nil {
+				slog.Error("Cannot save API key usage", "err", err)
+			}
+			localVersion = version
+		}
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// Middleware authenticates the request, checks the limits of the key, and stores the key in the request context.
+// The models of the key are checked by the handlers with CheckRequestModel.
+func (o *APIKeyStore) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !o.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		// signed asset URLs are loaded by players, which cannot send an API key
+		if lo.Contains(PublicPaths, r.URL.Path) ||
+			(r.URL.Path == MusicAssetPath && VerifyMusicAssetQuery(r.URL.Query())) {
+			if key, authenticated := o.Authenticate(token); ok && authenticated {
+				r = r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, &requestAPIKey{
+					APIKey: key,
+					store:  o,
+				}))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !ok {
+			WriteOpenAIError(w, "missing API key", "invalid_request_error", "invalid_api_key", http.StatusUnauthorized)
+			return
+		}
+		key, ok := o.Authenticate(token)
+		if !ok {
+			WriteOpenAIError(w, "incorrect API key provided", "invalid_request_error", "invalid_api_key", http.StatusUnauthorized)
+			return
+		}
+		setRequestAPILabel(r.Context(), key.Label)
+		retryAfter, err := o.Allow(key)
+		if err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			code := util.Ternary(errors.Is(err, ErrQuotaExceeded), "insufficient_quota", "rate_limit_exceeded")
+			WriteOpenAIError(w, err.Error(), code, code, http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, &requestAPIKey{
+			APIKey: key,
+			store:  o,
+		})))
+	})
+}
+
+type requestAPIKey struct {
+	APIKey
+	store *APIKeyStore
+}
+
+// RequestAPIKey returns the API key of the request, if authentication is enabled
+func RequestAPIKey(ctx context.Context) (APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(*requestAPIKey)
+	if !ok {
+		return APIKey{}, false
+	}
+	return key.APIKey, true
+}
+
+// CheckRequestModel returns an error if the API key of the request is limited to some models, and the model
+// or the conversation style which the request resolves to is not one of them. Endpoints without a model pass
+// the conversation style as the model. A conversation style is allowed if it is listed, or if a listed model
+// maps to it.
+func CheckRequestModel(ctx context.Context, model, conversationStyle string) error {
+	key, ok := RequestAPIKey(ctx)
+	if !ok || len(key.Models) == 0 {
+		return nil
+	}
+	if model == "" {
+		return ErrModelRequired
+	}
+	allowed := func(name string) bool {
+		return lo.ContainsBy(key.Models, func(item string) bool {
+			return item == name ||
+				(!lo.Contains(ConversationStyles, item) && ModelToConversationStyle(item) == name)
+		})
+	}
+	// the tag of Ollama models is ignored
+	model = strings.Split(model, ":")[0]
+	if !allowed(model) {
+		return fmt.Errorf("%w: %s", ErrModelNotAllowed, model)
+	}
+	if !allowed(conversationStyle) {
+		return fmt.Errorf("%w: %s", ErrModelNotAllowed, conversationStyle)
+	}
+	return nil
+}
+
+// RequestOwner returns the label of the API key of the request. Jobs, threads and files are only visible to
+// the API key which created them, and all of them are shared if no API key is configured.
+func RequestOwner(r *http.Request) string {
+	key, _ := RequestAPIKey(r.Context())
+	return key.Label
+}
+
+// RecordUsage counts the tokens of the texts and adds them to the usage of the API key of the request
+func RecordUsage(ctx context.Context, texts ...string) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(*requestAPIKey)
+	if !ok {
+		return
+	}
+	tokens := 0
+	for _, text := range texts {
+		tokens += CountTokens(text)
+	}
+	key.store.AddTokens(key.Label, tokens)
+}
+
+// accounts of requests besides AccountKeyPrefix followed by the label of an API key
+const (
+	AccountDefault   = "default"
+	AccountClient    = "client"
+	AccountKeyPrefix = "key:"
+)
+
+// RequestAccount names the cookies which RequestCookies returns, without revealing them
+func RequestAccount(r *http.Request, cookiesStr string) string {
+	if key, ok := RequestAPIKey(r.Context()); ok && key.Cookies != "" {
+		return AccountKeyPrefix + key.Label
+	}
+	return util.Ternary(cookiesStr == "", AccountDefault, AccountClient)
+}
+
+// RequestCookies returns the cookies to use for the request. The account fixed to the API key comes first,
+// then the cookies provided by the client, and the default cookies at last.
+func RequestCookies(r *http.Request, cookiesStr string, defaultCookies map[string]string) map[string]string {
+	setRequestAccount(r.Context(), RequestAccount(r, cookiesStr))
+	if key, ok := RequestAPIKey(r.Context()); ok && key.Cookies != "" {
+		return ParseCookies(key.Cookies)
+	}
+	return util.Ternary(cookiesStr == "", defaultCookies, ParseCookies(cookiesStr))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyStore(t *testing.T) {
+	keysPath := filepath.Join(t.TempDir(), "keys.json")
+	err := os.WriteFile(keysPath, []byte(`[
+  {"key": "sk-limited", "label": "limited", "models": ["gpt-4"], "requests_per_minute": 2, "daily_tokens": 100},
+  {"key": "sk-unlimited"}
+]`), 0644)
+	assert.Nil(t, err)
+
+	store, err := NewAPIKeyStore(keysPath, "sk-legacy", "")
+	assert.Nil(t, err)
+	assert.True(t, store.Enabled())
+
+	key, ok := store.Authenticate("sk-legacy")
+	assert.True(t, ok)
+	assert.Equal(t, AuthTokenLabel, key.Label)
+	key, ok = store.Authenticate("sk-unlimited")
+	assert.True(t, ok)
+	assert.Equal(t, "key-1", key.Label)
+	_, ok = store.Authenticate("sk-wrong")
+	assert.False(t, ok)
+
+	handler := store.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RecordUsage(r.Context(), "some tokens to count")
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(token, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer "+token)
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve("sk-wrong", `{}`).Code)
+	assert.Equal(t, http.StatusOK, serve("sk-limited", `{"model": "gpt-4"}`).Code)
+	assert.Equal(t, http.StatusOK, serve("sk-limited", `{"model": "gpt-4"}`).Code)
+
+	// over requests per minute
+	recorder := serve("sk-limited", `{"model": "gpt-4"}`)
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.NotEmpty(t, recorder.Header().Get("Retry-After"))
+	assert.Contains(t, recorder.Body.String(), "rate_limit_exceeded")
+
+	// over daily tokens
+	store.requests["limited"] = nil
+	store.AddTokens("limited", 100)
+	recorder = serve("sk-limited", `{"model": "gpt-4"}`)
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "insufficient_quota")
+
+	assert.Equal(t, http.StatusOK, serve("sk-unlimited", `{"model": "gpt-3.5-turbo"}`).Code)
+	assert.Equal(t, 1, store.Usage()["key-1"].TotalRequests)
+
+	// probes do not need a key
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// but a valid key is put in the context
+	var probeKey APIKey
+	probe := store.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probeKey, _ = RequestAPIKey(r.Context())
+	}))
+	request := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	request.Header.Set("Authorization", "Bearer sk-unlimited")
+	probe.ServeHTTP(httptest.NewRecorder(), request)
+	assert.Equal(t, "key-1", probeKey.Label)
+	probeKey = APIKey{}
+	request.Header.Set("Authorization", "Bearer sk-wrong")
+	probe.ServeHTTP(httptest.NewRecorder(), request)
+	assert.Empty(t, probeKey.Label)
+
+	// signed asset URLs do not need a key either
+	assetURL := proxyMusicAssetURL("https://th.bing.com/th?&id=audio")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, assetURL, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet,
+		strings.Replace(assetURL, "audio", "video", 1), nil))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet,
+		MusicAssetPath+"?url="+url.QueryEscape("https://th.bing.com/th?&id=audio"), nil))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		keys   string
+		labels []string
+		err    string
+	}{
+		{"labels", `[{"key": "sk-a", "label": "a"}, {"key": "sk-b"}]`, []string{"a", "key-1", AuthTokenLabel}, ""},
+		{"empty key", `[{"key": ""}]`, nil, "API key #0 is empty"},
+		{"duplicate label", `[{"key": "sk-a", "label": "a"}, {"key": "sk-b", "label": "a"}]`, nil,
+			"API key #1 has a duplicate label a"},
+		{"duplicate default label", `[{"key": "sk-a", "label": "key-1"}, {"key": "sk-b"}]`, nil,
+			"API key #1 has a duplicate label key-1"},
+		{"reserved label", `[{"key": "sk-a", "label": "default"}]`, nil, "API key #0 uses the reserved label default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keysPath := filepath.Join(t.TempDir(), "keys.json")
+			assert.Nil(t, os.WriteFile(keysPath, []byte(tt.keys), 0644))
+			keys, err := LoadAPIKeys(keysPath, "sk-legacy")
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.labels, lo.Map(keys, func(item APIKey, index int) string {
+				return item.Label
+			}))
+		})
+	}
+}
+
+func TestCheckRequestModel(t *testing.T) {
+	withKey := func(models ...string) context.Context {
+		return context.WithValue(context.Background(), apiKeyContextKey{}, &requestAPIKey{APIKey: APIKey{Models: models}})
+	}
+	tests := []struct {
+		name              string
+		ctx               context.Context
+		model             string
+		conversationStyle string
+		err               error
+	}{
+		{"no key", context.Background(), "", "Precise", nil},
+		{"no models", withKey(), "", "Precise", nil},
+		{"model", withKey("gpt-4"), "gpt-4", "Creative", nil},
+		{"ollama tag", withKey("gpt-4"), "gpt-4:latest", "Creative", nil},
+		{"missing model", withKey("gpt-4"), "", "Creative", ErrModelRequired},
+		{"other model", withKey("gpt-4"), "gpt-3.5-turbo", "Balanced", ErrModelNotAllowed},
+		{"other conversation style", withKey("gpt-4"), "gpt-4", "Precise", ErrModelNotAllowed},
+		{"conversation style of a model", withKey("gpt-4"), "Creative", "Creative", nil},
+		{"conversation style", withKey("Precise"), "Precise", "Precise", nil},
+		{"only the listed conversation style", withKey("Precise"), "Creative", "Creative", ErrModelNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, CheckRequestModel(tt.ctx, tt.model, tt.conversationStyle), tt.err)
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sydneyqt/util"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// auditBodyLimit is the largest request body kept in full text mode, and larger ones cannot be replayed
+const auditBodyLimit = 1 << 20
+
+// auditErrorLimit is the length of error responses kept in the audit log
+const auditErrorLimit = 1024
+
+var ErrAuditEntryNotFound = errors.New("audit entry not found")
+
+// AuditEntry is a line of the audit log. Prompt, Context and Request are only kept in full text mode,
+// and otherwise the prompt and the context are identified by their SHA-256 hashes.
+type AuditEntry struct {
+	Time        time.Time       `json:"time"`
+	RequestID   string          `json:"request_id"`
+	Method      string          `json:"method"`
+	Path        string          `json:"path"`
+	Status      int             `json:"status"`
+	LatencyMs   int64           `json:"latency_ms"`
+	APILabel    string          `json:"api_label,omitempty"`
+	Account     string          `json:"account,omitempty"`
+	Model       string          `json:"model,omitempty"`
+	PromptHash  string          `json:"prompt_hash,omitempty"`
+	ContextHash string          `json:"context_hash,omitempty"`
+	Prompt      string          `json:"prompt,omitempty"`
+	Context     string          `json:"context,omitempty"`
+	Request     json.RawMessage `json:"request,omitempty"`
+	Responses   []string        `json:"responses,omitempty"`
+	Errors      []string        `json:"errors,omitempty"`
+}
+
+func hashText(text string) string {
+	if text == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditLog appends entries to a JSONL file, which is rotated when it would exceed maxSize or the date changes.
+// Rotated files are renamed with the time of rotation and never deleted.
+type AuditLog struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+	date    string
+	now     func() time.Time
+}
+
+func NewAuditLog(path string, maxSize int64) (*AuditLog, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return nil, err
+	}
+	o := &AuditLog{path: path, maxSize: maxSize, now: time.Now}
+	return o, o.open()
+}
+
+func (o *AuditLog) open() error {
+	file, err := os.OpenFile(o.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	o.file = file
+	o.size = info.Size()
+	// an existing file belongs to the date it was last written
+	o.date = util.Ternary(o.size == 0, o.now(), info.ModTime()).Format(time.DateOnly)
+	return nil
+}
+
+func (o *AuditLog) rotate(now time.Time) error {
+	err := o.file.Close()
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(o.path)
+	rotated := strings.TrimSuffix(o.path, ext) + "-" + now.Format("20060102-150405.000") + ext
+	err = os.Rename(o.path, rotated)
+	if err != nil {
+		return err
+	}
+	return o.open()
+}
+
+func (o *AuditLog) Write(entry AuditEntry) error {
+	v, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	v = append(v, '\n')
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := o.now()
+	if o.size > 0 && (o.date != now.Format(time.DateOnly) || (o.maxSize > 0 && o.size+int64(len(v)) > o.maxSize)) {
+		err = o.rotate(now)
+		if err != nil {
+			return err
+		}
+	}
+	n, err := o.file.Write(v)
+	o.size += int64(n)
+	o.date = now.Format(time.DateOnly)
+	return err
+}
+
+func (o *AuditLog) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.file.Close()
+}
+
+// limitedBuffer keeps the beginning of what is written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (o *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := o.limit - o.Len(); remaining > 0 {
+		o.Buffer.Write(p[:min(len(p), remaining)])
+	}
+	return len(p), nil
+}
+
+// auditRequestBody reads the JSON body of the request without consuming it, and drops cookies
+func auditRequestBody(r *http.Request) json.RawMessage {
+	if r.Body == nil || r.Method != http.MethodPost {
+		return nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "" && mediaType != "application/json" {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, auditBodyLimit+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil || len(body) > auditBodyLimit {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return nil
+	}
+	delete(fields, "cookies")
+	v, err := json.Marshal(fields)
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+// Middleware appends an entry for every request which sets the model or the prompt of its RequestInfo,
+// which are the requests asking Sydney or creating images. fullText is checked on every request.
+func (o *AuditLog) Middleware(fullText func() bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			logFullText := fullText()
+			var body json.RawMessage
+			if logFullText {
+				body = auditRequestBody(r)
+			}
+			errorBody := &limitedBuffer{limit: auditErrorLimit}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(errorBody)
+			next.ServeHTTP(ww, r)
+
+			info := GetRequestInfo(r.Context())
+			if info.Model == "" && info.Prompt == "" {
+				return
+			}
+			entry := AuditEntry{
+				Time:        start,
+				RequestID:   middleware.GetReqID(r.Context()),
+				Method:      r.Method,
+				Path:        r.URL.Path,
+				Status:      ww.Status(),
+				LatencyMs:   time.Since(start).Milliseconds(),
+				APILabel:    info.APILabel,
+				Account:     info.Account,
+				Model:       info.Model,
+				PromptHash:  hashText(info.Prompt),
+				ContextHash: hashText(info.Context),
+				Responses:   info.Responses,
+				Errors:      info.Errors,
+			}
+			if logFullText {
+				entry.Prompt = info.Prompt
+				entry.Context = info.Context
+				entry.Request = body
+			}
+			if text := strings.TrimSpace(errorBody.String()); entry.Status >= http.StatusBadRequest && text != "" {
+				entry.Errors = append(entry.Errors, text)
+			}
+			if err := o.Write(entry); err != nil {
+				slog.Error("Cannot write audit log", "err", err)
+			}
+		})
+	}
+}
+
+// FindAuditEntry returns the entry of the request ID in the audit log file
+func FindAuditEntry(path, requestID string) (AuditEntry, error) {
+	var entry AuditEntry
+	file, err := os.Open(path)
+	if err != nil {
+		return entry, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	// lines in full text mode carry whole contexts
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for scanner.Scan() {
+		if !bytes.Contains(scanner.Bytes(), []byte(requestID)) {
+			continue
+		}
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.RequestID == requestID {
+			return entry, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return entry, err
+	}
+	return entry, ErrAuditEntryNotFound
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func readAuditEntries(t *testing.T, path string) []AuditEntry {
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+	auditLog, err := NewAuditLog(path, 300)
+	assert.Nil(t, err)
+	defer auditLog.Close()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	auditLog.now = func() time.Time {
+		return now
+	}
+
+	entry := AuditEntry{RequestID: "req", Method: http.MethodPost, Path: "/v1/chat/completions", Status: http.StatusOK}
+	assert.Nil(t, auditLog.Write(entry))
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// by size
+	assert.Nil(t, auditLog.Write(entry))
+	assert.Nil(t, auditLog.Write(entry))
+	// by date
+	now = now.Add(24 * time.Hour)
+	assert.Nil(t, auditLog.Write(entry))
+
+	matches, err := filepath.Glob(filepath.Join(dir, "audit-*.jsonl"))
+	assert.Nil(t, err)
+	assert.Len(t, matches, 2)
+	assert.Len(t, readAuditEntries(t, path), 1)
+	total := 1
+	for _, match := range matches {
+		total += len(readAuditEntries(t, match))
+	}
+	assert.Equal(t, 4, total)
+}
+
+func TestAuditLogMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := NewAuditLog(path, 0)
+	assert.Nil(t, err)
+	defer auditLog.Close()
+	fullText := false
+
+	handler := middleware.RequestID(RequestInfoMiddleware(auditLog.Middleware(func() bool {
+		return fullText
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var request struct {
+			Prompt string `json:"prompt"`
+		}
+		json.Unmarshal(body, &request)
+		SetRequestInfo(r.Context(), "gpt-4", request.Prompt)
+		RequestCookies(r, "_U=client", nil)
+		if request.Prompt == "fail" {
+			http.Error(w, "error creating conversation: throttled", http.StatusInternalServerError)
+			return
+		}
+		AddRequestError(r.Context(), "captcha")
+		RecordExchange(r.Context(), "some context", request.Prompt, "Hello")
+	}))))
+	serve := func(path, body string) {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	serve("/", `{}`)
+	serve("/chat/stream", `{"prompt": "Hi", "cookies": "_U=secret"}`)
+	fullText = true
+	serve("/chat/stream", `{"prompt": "Hi", "cookies": "_U=secret"}`)
+	serve("/chat/stream", `{"prompt": "fail"}`)
+
+	v, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(v), "secret")
+	entries := readAuditEntries(t, path)
+	assert.Len(t, entries, 3)
+
+	hashed := entries[0]
+	assert.Equal(t, "gpt-4", hashed.Model)
+	assert.Equal(t, AccountClient, hashed.Account)
+	assert.Equal(t, hashText("Hi"), hashed.PromptHash)
+	assert.Equal(t, hashText("some context"), hashed.ContextHash)
+	assert.Empty(t, hashed.Prompt)
+	assert.Nil(t, hashed.Request)
+	assert.Equal(t, []string{"Hello"}, hashed.Responses)
+	assert.Equal(t, []string{"captcha"}, hashed.Errors)
+	assert.NotEmpty(t, hashed.RequestID)
+
+	full := entries[1]
+	assert.Equal(t, "Hi", full.Prompt)
+	assert.Equal(t, "some context", full.Context)
+	assert.JSONEq(t, `{"prompt": "Hi"}`, string(full.Request))
+
+	failed := entries[2]
+	assert.Equal(t, http.StatusInternalServerError, failed.Status)
+	assert.Equal(t, []string{"error creating conversation: throttled"}, failed.Errors)
+
+	// replay
+	found, err := FindAuditEntry(path, full.RequestID)
+	assert.Nil(t, err)
+	assert.Equal(t, full.Path, found.Path)
+	_, err = FindAuditEntry(path, "missing")
+	assert.ErrorIs(t, err, ErrAuditEntryNotFound)
+
+	var replayed *http.Request
+	var replayedBody []byte
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replayed = r
+		replayedBody, _ = io.ReadAll(r.Body)
+		w.Write([]byte("replayed"))
+	}))
+	defer target.Close()
+	var out bytes.Buffer
+	status, err := ReplayAuditEntry(found, target.URL+"/", "sk-test", &out)
+	assert.Nil(t, err)
+	assert.Equal(t, "200 OK", status)
+	assert.Equal(t, "replayed", out.String())
+	assert.Equal(t, "/chat/stream", replayed.URL.Path)
+	assert.Equal(t, "Bearer sk-test", replayed.Header.Get("Authorization"))
+	assert.Equal(t, CacheStatusBypass, replayed.Header.Get(CacheHeader))
+	assert.JSONEq(t, `{"prompt": "Hi"}`, string(replayedBody))
+
+	_, err = ReplayAuditEntry(hashed, target.URL, "", &out)
+	assert.ErrorIs(t, err, ErrNoRequestBody)
+}
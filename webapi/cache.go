@@ -0,0 +1,403 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sydneyqt/sydney"
+	"sydneyqt/util"
+	"sync"
+	"time"
+)
+
+// CacheHeader bypasses the cache if it is `bypass` in requests, and tells how the response was made in responses
+const CacheHeader = "X-Sydney-Cache"
+
+const (
+	CacheStatusHit       = "hit"
+	CacheStatusMiss      = "miss"
+	CacheStatusCoalesced = "coalesced"
+	CacheStatusBypass    = "bypass"
+)
+
+// CacheKey is the normalized form of an ask. The image is hashed before it is uploaded, since the upload
+// results in a new URL every time. Replies are never shared across API keys or Bing accounts.
+type CacheKey struct {
+	Owner             string `json:"owner"`
+	Account           string `json:"account"` // the digest of the account cookie
+	ConversationStyle string `json:"conversation_style"`
+	NoSearch          bool   `json:"no_search"`
+	Prompt            string `json:"prompt"`
+	WebpageContext    string `json:"context"`
+	ImageHash         string `json:"image_hash"`
+	FileID            string `json:"file_id"`
+	// Choice keeps the choices of a request with `n` different
+	Choice int `json:"choice"`
+}
+
+func NewCacheKey(owner string, cookies map[string]string, conversationStyle string, noSearch bool,
+	prompt, webpageContext, imageURL, fileID string, choice int) CacheKey {
+	key := CacheKey{
+		Owner:             owner,
+		Account:           digestAccount(cookies),
+		ConversationStyle: conversationStyle,
+		NoSearch:          noSearch,
+		Prompt:            prompt,
+		WebpageContext:    webpageContext,
+		FileID:            fileID,
+		Choice:            choice,
+	}
+	if imageURL != "" {
+		sum := sha256.Sum256([]byte(imageURL))
+		key.ImageHash = hex.EncodeToString(sum[:])
+	}
+	return key
+}
+
+func (o CacheKey) String() string {
+	v, _ := json.Marshal(o)
+	sum := sha256.Sum256(v)
+	return hex.EncodeToString(sum[:])
+}
+
+// CacheBypassed reports whether the client asks for a fresh response
+func CacheBypassed(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get(CacheHeader), CacheStatusBypass)
+}
+
+type cachedMessage struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type cacheEntry struct {
+	Key       string          `json:"key"`
+	ExpiresAt time.Time       `json:"expires_at"`
+	Messages  []cachedMessage `json:"messages"`
+}
+
+// flight is an upstream ask shared by identical requests
+type flight struct {
+	key      string
+	started  chan struct{} // closed once the ask has been started or has failed
+	err      error
+	cancel   context.CancelFunc
+	messages []sydney.Message
+	done     bool
+	updated  chan struct{} // closed and replaced whenever messages or done change
+	waiters  int
+}
+
+// ResponseCache keeps complete message streams in an LRU, and optionally on disk. Identical asks in flight
+// are coalesced into one upstream ask, whose messages fan out to all waiters.
+type ResponseCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	dir      string
+	entries  *list.List
+	index    map[string]*list.Element
+	flights  map[string]*flight
+}
+
+func NewResponseCache(capacity int, ttl time.Duration, dir string) (*ResponseCache, error) {
+	if dir != "" {
+		err := os.MkdirAll(dir, 0750)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &ResponseCache{
+		capacity: capacity,
+		ttl:      ttl,
+		dir:      dir,
+		entries:  list.New(),
+		index:    map[string]*list.Element{},
+		flights:  map[string]*flight{},
+	}, nil
+}
+
+// Ask returns the cached stream of the key, joins an identical ask in flight, or starts a new one.
+// The upstream ask is only stopped when all of its waiters have gone away.
+func (o *ResponseCache) Ask(ctx context.Context, key string,
+	ask func(ctx context.Context) (<-chan sydney.Message, error)) (<-chan sydney.Message, string, error) {
+	o.mu.Lock()
+	if messages, ok := o.get(key); ok {
+		o.mu.Unlock()
+		return replay(ctx, messages), CacheStatusHit, nil
+	}
+	f, coalesced := o.flights[key]
+	if !coalesced {
+		upstreamCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight{
+			key:     key,
+			started: make(chan struct{}),
+			cancel:  cancel,
+			updated: make(chan struct{}),
+		}
+		o.flights[key] = f
+		go o.run(upstreamCtx, key, f, ask)
+	}
+	f.waiters++
+	o.mu.Unlock()
+
+	select {
+	case <-f.started:
+	case <-ctx.Done():
+		o.leave(f)
+		return nil, "", ctx.Err()
+	}
+	if f.err != nil {
+		o.leave(f)
+		return nil, "", f.err
+	}
+	return o.follow(ctx, f), util.Ternary(coalesced, CacheStatusCoalesced, CacheStatusMiss), nil
+}
+
+func (o *ResponseCache) run(ctx context.Context, key string, f *flight,
+	ask func(ctx context.Context) (<-chan sydney.Message, error)) {
+	defer f.cancel()
+	messageCh, err := ask(ctx)
+	f.err = err
+	close(f.started)
+	if err != nil {
+		o.mu.Lock()
+		if o.flights[key] == f {
+			delete(o.flights, key)
+		}
+		o.mu.Unlock()
+		return
+	}
+	errored := false
+	for message := range messageCh {
+		errored = errored || message.Type == sydney.MessageTypeError
+		o.mu.Lock()
+		f.messages = append(f.messages, message)
+		close(f.updated)
+		f.updated = make(chan struct{})
+		o.mu.Unlock()
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	f.done = true
+	close(f.updated)
+	if o.flights[key] == f {
+		delete(o.flights, key)
+	}
+	// only complete replies are worth keeping
+	if !errored && ctx.Err() == nil && len(f.messages) != 0 {
+		o.put(key, f.messages)
+	}
+}
+
+func (o *ResponseCache) follow(ctx context.Context, f *flight) <-chan sydney.Message {
+	out := make(chan sydney.Message)
+	go func() {
+		defer close(out)
+		defer o.leave(f)
+		sent := 0
+		for {
+			o.mu.Lock()
+			pending := f.messages[sent:]
+			done := f.done
+			updated := f.updated
+			o.mu.Unlock()
+			for _, message := range pending {
+				select {
+				case out <- message:
+					sent++
+				case <-ctx.Done():
+					return
+				}
+			}
+			if done && len(pending) == 0 {
+				return
+			}
+			if len(pending) != 0 {
+				continue
+			}
+			select {
+			case <-updated:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (o *ResponseCache) leave(f *flight) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	f.waiters--
+	if f.waiters == 0 && !f.done {
+		f.cancel()
+		// later requests should not join a cancelled ask
+		if o.flights[f.key] == f {
+			delete(o.flights, f.key)
+		}
+	}
+}
+
+func replay(ctx context.Context, messages []sydney.Message) <-chan sydney.Message {
+	out := make(chan sydney.Message)
+	go func() {
+		defer close(out)
+		for _, message := range messages {
+			select {
+			case out <- message:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (o *ResponseCache) path(key string) string {
+	return filepath.Join(o.dir, key+".json")
+}
+
+// get looks up the memory first and then the disk. The caller must hold the lock.
+func (o *ResponseCache) get(key string) ([]sydney.Message, bool) {
+	now := time.Now()
+	if element, ok := o.index[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		if now.Before(entry.ExpiresAt) {
+			o.entries.MoveToFront(element)
+			return toMessages(entry.Messages), true
+		}
+		o.entries.Remove(element)
+		delete(o.index, key)
+	}
+	if o.dir == "" {
+		return nil, false
+	}
+	v, err := os.ReadFile(o.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if json.Unmarshal(v, &entry) != nil || !now.Before(entry.ExpiresAt) {
+		_ = os.Remove(o.path(key))
+		return nil, false
+	}
+	o.remember(&entry)
+	return toMessages(entry.Messages), true
+}
+
+// put stores the messages in memory and on disk. The caller must hold the lock.
+func (o *ResponseCache) put(key string, messages []sydney.Message) {
+	entry := &cacheEntry{
+		Key:       key,
+		ExpiresAt: time.Now().Add(o.ttl),
+	}
+	for _, message := range messages {
+		entry.Messages = append(entry.Messages, cachedMessage{Type: message.Type, Text: message.Text})
+	}
+	o.remember(entry)
+	if o.dir == "" {
+		return
+	}
+	v, err := json.Marshal(entry)
+	if err == nil {
+		// write to a temporary file first so that readers never see a partial entry
+		tmp := o.path(key) + ".tmp"
+		err = os.WriteFile(tmp, v, 0600)
+		if err == nil {
+			err = os.Rename(tmp, o.path(key))
+		}
+	}
+	if err != nil {
+		slog.Error("Cannot save cache entry", "err", err)
+	}
+}
+
+func (o *ResponseCache) remember(entry *cacheEntry) {
+	if element, ok := o.index[entry.Key]; ok {
+		element.Value = entry
+		o.entries.MoveToFront(element)
+		return
+	}
+	o.index[entry.Key] = o.entries.PushFront(entry)
+	for o.entries.Len() > o.capacity {
+		oldest := o.entries.Back()
+		o.entries.Remove(oldest)
+		delete(o.index, oldest.Value.(*cacheEntry).Key)
+	}
+}
+
+// Purge removes expired entries on disk, which are otherwise only removed when they are looked up
+func (o *ResponseCache) Purge() error {
+	if o.dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var errs []error
+	for _, dirEntry := range entries {
+		if !strings.HasSuffix(dirEntry.Name(), ".json") {
+			continue
+		}
+		p := filepath.Join(o.dir, dirEntry.Name())
+		v, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		var entry cacheEntry
+		if json.Unmarshal(v, &entry) != nil || !now.Before(entry.ExpiresAt) {
+			errs = append(errs, os.Remove(p))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func toMessages(cached []cachedMessage) []sydney.Message {
+	messages := make([]sydney.Message, 0, len(cached))
+	for _, message := range cached {
+		messages = append(messages, sydney.Message{Type: message.Type, Text: message.Text})
+	}
+	return messages
+}
+
+// CachedAsk routes every ask of a request through the cache, unless the cache is disabled or the client
+// bypasses it. The status of every choice is written to statuses, whose length is the number of choices.
+func CachedAsk(cache *ResponseCache, r *http.Request, key func(index int) CacheKey,
+	ask func(ctx context.Context, index int) (<-chan sydney.Message, error),
+	statuses []string) func(ctx context.Context, index int) (<-chan sydney.Message, error) {
+	if cache == nil {
+		return ask
+	}
+	if CacheBypassed(r) {
+		return func(ctx context.Context, index int) (<-chan sydney.Message, error) {
+			statuses[index] = CacheStatusBypass
+			return ask(ctx, index)
+		}
+	}
+	return func(ctx context.Context, index int) (<-chan sydney.Message, error) {
+		messageCh, status, err := cache.Ask(ctx, key(index).String(), func(ctx context.Context) (<-chan sydney.Message, error) {
+			return ask(ctx, index)
+		})
+		statuses[index] = status
+		return messageCh, err
+	}
+}
+
+// SetCacheHeader reports the cache status of every choice, in the order of choices
+func SetCacheHeader(w http.ResponseWriter, statuses []string) {
+	if len(statuses) == 0 || statuses[0] == "" {
+		return
+	}
+	w.Header().Set(CacheHeader, strings.Join(statuses, ", "))
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sydneyqt/sydney"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func collectTexts(messageCh <-chan sydney.Message) []string {
+	var texts []string
+	for message := range messageCh {
+		texts = append(texts, message.Text)
+	}
+	return texts
+}
+
+func TestResponseCache(t *testing.T) {
+	var asks atomic.Int32
+	reply := func(texts ...string) func(ctx context.Context) (<-chan sydney.Message, error) {
+		return func(ctx context.Context) (<-chan sydney.Message, error) {
+			asks.Add(1)
+			messageCh := make(chan sydney.Message, len(texts))
+			for _, text := range texts {
+				messageCh <- sydney.Message{Type: sydney.MessageTypeMessageText, Text: text}
+			}
+			close(messageCh)
+			return messageCh, nil
+		}
+	}
+
+	t.Run("hit and miss", func(t *testing.T) {
+		asks.Store(0)
+		cache, err := NewResponseCache(10, time.Hour, "")
+		assert.Nil(t, err)
+		for _, status := range []string{CacheStatusMiss, CacheStatusHit} {
+			messageCh, actual, err := cache.Ask(context.Background(), "a", reply("Hello", " world"))
+			assert.Nil(t, err)
+			assert.Equal(t, status, actual)
+			assert.Equal(t, []string{"Hello", " world"}, collectTexts(messageCh))
+		}
+		assert.EqualValues(t, 1, asks.Load())
+	})
+
+	t.Run("coalescing", func(t *testing.T) {
+		asks.Store(0)
+		cache, err := NewResponseCache(10, time.Hour, "")
+		assert.Nil(t, err)
+		release := make(chan struct{})
+		ask := func(ctx context.Context) (<-chan sydney.Message, error) {
+			asks.Add(1)
+			messageCh := make(chan sydney.Message)
+			go func() {
+				defer close(messageCh)
+				messageCh <- sydney.Message{Type: sydney.MessageTypeMessageText, Text: "Hello"}
+				<-release
+				messageCh <- sydney.Message{Type: sydney.MessageTypeMessageText, Text: " world"}
+			}()
+			return messageCh, nil
+		}
+		first, status, err := cache.Ask(context.Background(), "a", ask)
+		assert.Nil(t, err)
+		assert.Equal(t, CacheStatusMiss, status)
+		second, status, err := cache.Ask(context.Background(), "a", ask)
+		assert.Nil(t, err)
+		assert.Equal(t, CacheStatusCoalesced, status)
+		close(release)
+
+		var wg sync.WaitGroup
+		results := make([][]string, 2)
+		for i, messageCh := range []<-chan sydney.Message{first, second} {
+			wg.Add(1)
+			go func(i int, messageCh <-chan sydney.Message) {
+				defer wg.Done()
+				results[i] = collectTexts(messageCh)
+			}(i, messageCh)
+		}
+		wg.Wait()
+		assert.Equal(t, []string{"Hello", " world"}, results[0])
+		assert.Equal(t, []string{"Hello", " world"}, results[1])
+		assert.EqualValues(t, 1, asks.Load())
+	})
+
+	t.Run("errors are not cached", func(t *testing.T) {
+		asks.Store(0)
+		cache, err := NewResponseCache(10, time.Hour, "")
+		assert.Nil(t, err)
+		_, _, err = cache.Ask(context.Background(), "a", func(ctx context.Context) (<-chan sydney.Message, error) {
+			asks.Add(1)
+			return nil, errors.New("cannot create conversation")
+		})
+		assert.NotNil(t, err)
+		messageCh, _, err := cache.Ask(context.Background(), "a", func(ctx context.Context) (<-chan sydney.Message, error) {
+			asks.Add(1)
+			messageCh := make(chan sydney.Message, 1)
+			messageCh <- sydney.Message{Type: sydney.MessageTypeError, Text: "throttled"}
+			close(messageCh)
+			return messageCh, nil
+		})
+		assert.Nil(t, err)
+		collectTexts(messageCh)
+		_, status, err := cache.Ask(context.Background(), "a", reply("Hello"))
+		assert.Nil(t, err)
+		assert.Equal(t, CacheStatusMiss, status)
+		assert.EqualValues(t, 3, asks.Load())
+	})
+
+	t.Run("LRU eviction", func(t *testing.T) {
+		cache, err := NewResponseCache(2, time.Hour, "")
+		assert.Nil(t, err)
+		for _, key := range []string{"a", "b", "a", "c"} {
+			messageCh, _, err := cache.Ask(context.Background(), key, reply(key))
+			assert.Nil(t, err)
+			collectTexts(messageCh)
+		}
+		// b is the least recently used one
+		for _, c := range []struct {
+			key    string
+			status string
+		}{{"a", CacheStatusHit}, {"c", CacheStatusHit}, {"b", CacheStatusMiss}} {
+			messageCh, status, err := cache.Ask(context.Background(), c.key, reply(c.key))
+			assert.Nil(t, err)
+			assert.Equal(t, c.status, status, c.key)
+			collectTexts(messageCh)
+		}
+	})
+
+	t.Run("disk and TTL", func(t *testing.T) {
+		asks.Store(0)
+		dir := t.TempDir()
+		cache, err := NewResponseCache(10, time.Hour, dir)
+		assert.Nil(t, err)
+		messageCh, _, err := cache.Ask(context.Background(), "a", reply("Hello"))
+		assert.Nil(t, err)
+		collectTexts(messageCh)
+		info, err := os.Stat(filepath.Join(dir, "a.json"))
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		// a new cache reads the entry from disk
+		restarted, err := NewResponseCache(10, time.Hour, dir)
+		assert.Nil(t, err)
+		messageCh, status, err := restarted.Ask(context.Background(), "a", reply("Bye"))
+		assert.Nil(t, err)
+		assert.Equal(t, CacheStatusHit, status)
+		assert.Equal(t, []string{"Hello"}, collectTexts(messageCh))
+
+		expired, err := NewResponseCache(10, -time.Hour, dir)
+		assert.Nil(t, err)
+		messageCh, _, err = expired.Ask(context.Background(), "b", reply("Hello"))
+		assert.Nil(t, err)
+		collectTexts(messageCh)
+		assert.Nil(t, expired.Purge())
+		_, err = os.Stat(filepath.Join(dir, "b.json"))
+		assert.True(t, os.IsNotExist(err))
+		_, err = os.Stat(filepath.Join(dir, "a.json"))
+		assert.Nil(t, err)
+		assert.EqualValues(t, 2, asks.Load())
+	})
+}
+
+func TestCachedAsk(t *testing.T) {
+	cache, err := NewResponseCache(10, time.Hour, "")
+	assert.Nil(t, err)
+	ask := func(ctx context.Context, index int) (<-chan sydney.Message, error) {
+		messageCh := make(chan sydney.Message, 1)
+		messageCh <- sydney.Message{Type: sydney.MessageTypeMessageText, Text: "Hello"}
+		close(messageCh)
+		return messageCh, nil
+	}
+	key := func(index int) CacheKey {
+		return NewCacheKey("team-a", map[string]string{"_U": "a"}, "Creative", true, "Hi", "",
+			"data:image/png;base64,AAAA", "", index)
+	}
+	run := func(r *http.Request, cache *ResponseCache) string {
+		statuses := make([]string, 2)
+		cachedAsk := CachedAsk(cache, r, key, ask, statuses)
+		for i := range statuses {
+			messageCh, err := cachedAsk(context.Background(), i)
+			assert.Nil(t, err)
+			collectTexts(messageCh)
+		}
+		w := httptest.NewRecorder()
+		SetCacheHeader(w, statuses)
+		return w.Header().Get(CacheHeader)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	assert.Equal(t, "", run(r, nil))
+	assert.Equal(t, "miss, miss", run(r, cache))
+	assert.Equal(t, "hit, hit", run(r, cache))
+	r.Header.Set(CacheHeader, "Bypass")
+	assert.Equal(t, "bypass, bypass", run(r, cache))
+
+	assert.NotEqual(t, key(0).String(), key(1).String())
+	assert.NotEqual(t, key(0).String(), NewCacheKey("team-a", map[string]string{"_U": "a"}, "Creative", true,
+		"Hi", "", "", "", 0).String())
+	// not shared across API keys or accounts
+	assert.NotEqual(t, key(0).String(), NewCacheKey("team-b", map[string]string{"_U": "a"}, "Creative", true,
+		"Hi", "", "data:image/png;base64,AAAA", "", 0).String())
+	assert.NotEqual(t, key(0).String(), NewCacheKey("team-a", map[string]string{"_U": "b"}, "Creative", true,
+		"Hi", "", "data:image/png;base64,AAAA", "", 0).String())
+}
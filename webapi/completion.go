@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sydneyqt/sydney"
+	"sync"
+)
+
+// MaxChoiceCount is the maximum number of choices of a completion request, since every choice is a conversation
+const MaxChoiceCount = 5
+
+var ErrTooManyChoices = fmt.Errorf("n must be between 1 and %d", MaxChoiceCount)
+
+// Parameters honoured by /v1/chat/completions and /v1/completions, and others are reported as ignored.
+// `temperature` is not in the lists as it is only mapped to a conversation style.
+var (
+	ChatCompletionParams = []string{"model", "messages", "stream", "tool_choice", "conversation",
+		"stop", "max_tokens", "n", "sydney_extensions"}
+	CompletionParams = []string{"model", "prompt", "stream", "suffix", "echo", "stop", "max_tokens", "n"}
+)
+
+func unmarshalStrings(data []byte, name string) ([]string, error) {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		return []string{str}, nil
+	}
+	var arr []string
+	if err := json.Unmarshal(data, &arr); err != nil {
+		return nil, errors.New(name + " must be a string or an array of strings")
+	}
+	return arr, nil
+}
+
+// StopSequences accepts both a string and an array of strings, like `stop` of the OpenAI API
+type StopSequences []string
+
+func (o *StopSequences) UnmarshalJSON(data []byte) error {
+	arr, err := unmarshalStrings(data, "stop")
+	*o = arr
+	return err
+}
+
+// CompletionPrompts accepts both a string and an array of strings, like `prompt` of the OpenAI API.
+// Arrays of tokens are not supported.
+type CompletionPrompts []string
+
+func (o *CompletionPrompts) UnmarshalJSON(data []byte) error {
+	arr, err := unmarshalStrings(data, "prompt")
+	*o = arr
+	return err
+}
+
+// TemperatureToConversationStyle maps the temperature to the closest conversation style
+func TemperatureToConversationStyle(temperature float64) string {
+	switch {
+	case temperature <= 0.4:
+		return "Precise"
+	case temperature < 1:
+		return "Balanced"
+	default:
+		return "Creative"
+	}
+}
+
+// CompletionConversationStyle returns the conversation style of model, or the one mapped from the temperature
+// if no model is given, since the model is checked against the models of the API key
+func CompletionConversationStyle(model string, temperature *float64) string {
+	if model == "" && temperature != nil {
+		return TemperatureToConversationStyle(*temperature)
+	}
+	return ModelToConversationStyle(model)
+}
+
+// IgnoredCompletionParams returns the sorted keys of the request body which are not in supportedParams
+func IgnoredCompletionParams(body []byte, supportedParams []string) []string {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil
+	}
+	var ignored []string
+	for key, value := range params {
+		if string(value) == "null" {
+			continue
+		}
+		found := false
+		for _, supported := range supportedParams {
+			if key == supported {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ignored = append(ignored, key)
+		}
+	}
+	sort.Strings(ignored)
+	return ignored
+}
+
+// CompletionLimiter cuts a streamed reply at the first stop sequence or at maxTokens. Text which may be
+// the beginning of a stop sequence is held back until the next delta, so that stop sequences spanning
+// chunks are found.
+type CompletionLimiter struct {
+	stops        []string
+	maxTokens    int
+	emitted      strings.Builder
+	pending      string
+	finishReason string
+}
+
+func NewCompletionLimiter(stops []string, maxTokens int) *CompletionLimiter {
+	var nonEmpty []string
+	for _, stop := range stops {
+		if stop != "" {
+			nonEmpty = append(nonEmpty, stop)
+		}
+	}
+	return &CompletionLimiter{stops: nonEmpty, maxTokens: maxTokens}
+}
+
+// Write returns the part of delta which can be sent, and whether the reply is finished
+func (o *CompletionLimiter) Write(delta string) (string, bool) {
+	if o.finishReason != "" {
+		return "", true
+	}
+	text := o.pending + delta
+	o.pending = ""
+
+	stopIndex := -1
+	for _, stop := range o.stops {
+		if i := strings.Index(text, stop); i != -1 && (stopIndex == -1 || i < stopIndex) {
+			stopIndex = i
+		}
+	}
+	if stopIndex != -1 {
+		text = text[:stopIndex]
+	} else {
+		held := o.heldSuffix(text)
+		o.pending = text[len(text)-held:]
+		text = text[:len(text)-held]
+	}
+
+	text = o.limitTokens(text)
+	if o.finishReason == "" && stopIndex != -1 {
+		o.finishReason = FinishReasonStop
+	}
+	return text, o.finishReason != ""
+}
+
+// Flush returns the text held back at the end of the stream
+func (o *CompletionLimiter) Flush() string {
+	if o.finishReason != "" {
+		return ""
+	}
+	text := o.limitTokens(o.pending)
+	o.pending = ""
+	return text
+}
+
+// FinishReason is `stop` or `length` if the reply has been cut, or empty otherwise
+func (o *CompletionLimiter) FinishReason() string {
+	return o.finishReason
+}
+
+// heldSuffix is the length of the longest suffix of text which is a prefix of a stop sequence
+func (o *CompletionLimiter) heldSuffix(text string) int {
+	held := 0
+	for _, stop := range o.stops {
+		for k := min(len(text), len(stop)-1); k > held; k-- {
+			if strings.HasPrefix(stop, text[len(text)-k:]) {
+				held = k
+				break
+			}
+		}
+	}
+	return held
+}
+
+func (o *CompletionLimiter) limitTokens(text string) string {
+	if o.maxTokens <= 0 || text == "" {
+		o.emitted.WriteString(text)
+		return text
+	}
+	emitted := o.emitted.String()
+	truncated, cut := TruncateTokens(emitted+text, o.maxTokens)
+	if !cut {
+		o.emitted.WriteString(text)
+		return text
+	}
+	o.finishReason = FinishReasonLength
+	// the tokens of the emitted text may change with the new text, so only the extra part is sent
+	if len(truncated) <= len(emitted) || !strings.HasPrefix(truncated, emitted) {
+		return ""
+	}
+	text = truncated[len(emitted):]
+	o.emitted.WriteString(text)
+	return text
+}
+
+type ChoiceResult struct {
+	Content      string
+	FinishReason string
+	Extension    SydneyExtension
+}
+
+type ChoiceOptions struct {
+	NewLimiter func() *CompletionLimiter
+	OnDelta    func(index int, delta string)
+	OnFinish   func(index int, finishReason string)
+	// OnExtension receives search queries, search results, suggested responses and generative images
+	// if it is set
+	OnExtension func(index int, extension SydneyExtension)
+	// GenerateImage resolves generative images into image URLs if it is set
+	GenerateImage func(generativeImage sydney.GenerativeImage) (sydney.GenerateImageResult, error)
+}
+
+// AskChoices starts n asks in parallel and fails if any of them cannot be started. Then it streams
+// every choice through its own limiter and calls the callbacks of options, whose calls are serialized.
+func AskChoices(ctx context.Context, n int, ask func(ctx context.Context, index int) (<-chan sydney.Message, error),
+	options ChoiceOptions) ([]ChoiceResult, error) {
+	contexts := make([]context.Context, n)
+	cancels := make([]context.CancelFunc, n)
+	channels := make([]<-chan sydney.Message, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		contexts[i], cancels[i] = context.WithCancel(ctx)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			channels[i], errs[i] = ask(contexts[i], i)
+		}(i)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		for _, cancel := range cancels {
+			cancel()
+		}
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	results := make([]ChoiceResult, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer cancels[i]()
+			limiter := options.NewLimiter()
+			var builder strings.Builder
+			errored := false
+			emit := func(delta string) {
+				if delta == "" {
+					return
+				}
+				builder.WriteString(delta)
+				mu.Lock()
+				options.OnDelta(i, delta)
+				mu.Unlock()
+			}
+			emitExtension := func(extension SydneyExtension) {
+				mu.Lock()
+				results[i].Extension.Merge(extension)
+				options.OnExtension(i, extension)
+				mu.Unlock()
+			}
+			// images are created in the background and sent before the end of the choice
+			var imageWg sync.WaitGroup
+			for message := range channels[i] {
+				if limiter.FinishReason() != "" {
+					// drain the channel until Sydney notices the cancellation
+					continue
+				}
+				switch message.Type {
+				case sydney.MessageTypeMessageText:
+					delta, finished := limiter.Write(message.Text)
+					emit(delta)
+					if finished {
+						cancels[i]()
+					}
+				case sydney.MessageTypeError:
+					errored = true
+					emit(limiter.Flush())
+					emit(fmt.Sprintf("`Error: %s`", message.Text))
+				case sydney.MessageTypeGenerativeImage:
+					var generativeImage sydney.GenerativeImage
+					if options.OnExtension == nil || json.Unmarshal([]byte(message.Text), &generativeImage) != nil {
+						continue
+					}
+					if options.GenerateImage == nil {
+						emitExtension(SydneyExtension{GenerativeImages: []GenerativeImageExtension{{
+							Prompt: generativeImage.Text,
+						}}})
+						continue
+					}
+					imageWg.Add(1)
+					go func() {
+						defer imageWg.Done()
+						image := GenerativeImageExtension{Prompt: generativeImage.Text}
+						result, err := options.GenerateImage(generativeImage)
+						if err != nil {
+							image.Error = err.Error()
+						} else {
+							image.ImageURLs = result.ImageURLs
+						}
+						emitExtension(SydneyExtension{GenerativeImages: []GenerativeImageExtension{image}})
+					}()
+				default:
+					if options.OnExtension == nil {
+						continue
+					}
+					if extension, ok := ParseSydneyExtension(message); ok {
+						emitExtension(extension)
+					}
+				}
+			}
+			emit(limiter.Flush())
+			imageWg.Wait()
+
+			finishReason := limiter.FinishReason()
+			if finishReason == "" {
+				finishReason = FinishReasonStop
+				if errored {
+					finishReason = FinishReasonLength
+				}
+			}
+			mu.Lock()
+			results[i].Content = builder.String()
+			results[i].FinishReason = finishReason
+			options.OnFinish(i, finishReason)
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	return results, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sydneyqt/sydney"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStopSequences(t *testing.T) {
+	var request OpenAIChatCompletionRequest
+	assert.Nil(t, json.Unmarshal([]byte(`{"stop": "END"}`), &request))
+	assert.Equal(t, StopSequences{"END"}, request.Stop)
+	assert.Nil(t, json.Unmarshal([]byte(`{"stop": ["a", "b"]}`), &request))
+	assert.Equal(t, StopSequences{"a", "b"}, request.Stop)
+	assert.NotNil(t, json.Unmarshal([]byte(`{"stop": 1}`), &request))
+
+	var completionRequest OpenAICompletionRequest
+	assert.Nil(t, json.Unmarshal([]byte(`{"prompt": ["a", "b"]}`), &completionRequest))
+	assert.Equal(t, CompletionPrompts{"a", "b"}, completionRequest.Prompt)
+	assert.NotNil(t, json.Unmarshal([]byte(`{"prompt": [1, 2]}`), &completionRequest))
+}
+
+func TestCompletionLimiter(t *testing.T) {
+	write := func(limiter *CompletionLimiter, deltas ...string) string {
+		var builder strings.Builder
+		for _, delta := range deltas {
+			out, finished := limiter.Write(delta)
+			builder.WriteString(out)
+			if finished {
+				return builder.String()
+			}
+		}
+		builder.WriteString(limiter.Flush())
+		return builder.String()
+	}
+
+	tests := []struct {
+		name         string
+		stops        []string
+		deltas       []string
+		want         string
+		finishReason string
+	}{
+		{"no stop", nil, []string{"Hello", " world"}, "Hello world", ""},
+		{"stop in a chunk", []string{"STOP"}, []string{"Hello STOP world"}, "Hello ", FinishReasonStop},
+		{"stop across chunks", []string{"\n\nUser:"}, []string{"Hi there.\n", "\nUs", "er: next"}, "Hi there.", FinishReasonStop},
+		{"partial match released", []string{"STOP"}, []string{"ST", "ART"}, "START", ""},
+		{"earliest stop wins", []string{"b", "a"}, []string{"xxab"}, "xx", FinishReasonStop},
+		{"held text flushed", []string{"STOP"}, []string{"the end ST"}, "the end ST", ""},
+		{"multibyte", []string{"。"}, []string{"你好", "。再见"}, "你好", FinishReasonStop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewCompletionLimiter(tt.stops, 0)
+			assert.Equal(t, tt.want, write(limiter, tt.deltas...))
+			assert.Equal(t, tt.finishReason, limiter.FinishReason())
+		})
+	}
+
+	t.Run("max tokens", func(t *testing.T) {
+		limiter := NewCompletionLimiter(nil, 5)
+		text := strings.Repeat("word ", 20)
+		out := write(limiter, strings.Split(text, " ")[0], " "+strings.Join(strings.Split(text, " ")[1:], " "))
+		assert.Equal(t, FinishReasonLength, limiter.FinishReason())
+		assert.True(t, strings.HasPrefix(text, out))
+		assert.LessOrEqual(t, CountTokens(out), 5)
+		assert.NotEmpty(t, out)
+	})
+}
+
+func TestIgnoredCompletionParams(t *testing.T) {
+	ignored := IgnoredCompletionParams([]byte(`{"model": "gpt-4", "messages": [], "stop": "x", "n": 2,
+		"temperature": 0.2, "user": "u-1", "top_p": 1, "seed": null}`), ChatCompletionParams)
+	assert.Equal(t, []string{"temperature", "top_p", "user"}, ignored)
+
+	assert.Equal(t, "Precise", TemperatureToConversationStyle(0))
+	assert.Equal(t, "Balanced", TemperatureToConversationStyle(0.7))
+	assert.Equal(t, "Creative", TemperatureToConversationStyle(1.5))
+	temperature := 0.2
+	assert.Equal(t, "Precise", CompletionConversationStyle("", &temperature))
+	assert.Equal(t, "Balanced", CompletionConversationStyle("gpt-3.5-turbo", &temperature))
+	assert.Equal(t, "Creative", CompletionConversationStyle("", nil))
+}
+
+func TestAskChoices(t *testing.T) {
+	replies := [][]sydney.Message{
+		{
+			{Type: sydney.MessageTypeSearchQuery, Text: "weather today"},
+			{Type: sydney.MessageTypeMessageText, Text: "first "},
+			{Type: sydney.MessageTypeMessageText, Text: "reply ST"},
+			{Type: sydney.MessageTypeMessageText, Text: "OP ignored"},
+		},
+		{
+			{Type: sydney.MessageTypeMessageText, Text: "second reply"},
+			{Type: sydney.MessageTypeSearchResult, Text: `[{"index": 1, "link": "https://example.com", "title": "Example"}]`},
+			{Type: sydney.MessageTypeGenerativeImage, Text: `{"text": "a cat", "url": "https://www.bing.com/images/create"}`},
+			{Type: sydney.MessageTypeSuggestedResponses, Text: `["Tell me more"]`},
+		},
+	}
+	ask := func(ctx context.Context, index int) (<-chan sydney.Message, error) {
+		ch := make(chan sydney.Message)
+		go func() {
+			defer close(ch)
+			for _, message := range replies[index] {
+				select {
+				case ch <- message:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return ch, nil
+	}
+	newLimiter := func() *CompletionLimiter {
+		return NewCompletionLimiter([]string{"STOP"}, 0)
+	}
+
+	t.Run("without extensions", func(t *testing.T) {
+		deltas := map[int]string{}
+		finishes := map[int]string{}
+		results, err := AskChoices(context.Background(), 2, ask, ChoiceOptions{
+			NewLimiter: newLimiter,
+			OnDelta:    func(index int, delta string) { deltas[index] += delta },
+			OnFinish:   func(index int, finishReason string) { finishes[index] = finishReason },
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(results))
+		for i, result := range results {
+			assert.Equal(t, deltas[i], result.Content)
+			assert.Equal(t, finishes[i], result.FinishReason)
+			assert.True(t, result.Extension.IsEmpty())
+		}
+		assert.Equal(t, "first reply ", results[0].Content)
+		assert.Equal(t, FinishReasonStop, results[0].FinishReason)
+		assert.Equal(t, "second reply", results[1].Content)
+	})
+
+	t.Run("with extensions", func(t *testing.T) {
+		var extensions []SydneyExtension
+		finished := map[int]bool{}
+		results, err := AskChoices(context.Background(), 2, ask, ChoiceOptions{
+			NewLimiter: newLimiter,
+			OnDelta:    func(index int, delta string) {},
+			OnFinish:   func(index int, finishReason string) { finished[index] = true },
+			OnExtension: func(index int, extension SydneyExtension) {
+				// images are sent before the end of the choice
+				assert.False(t, finished[index])
+				extensions = append(extensions, extension)
+			},
+			GenerateImage: func(generativeImage sydney.GenerativeImage) (sydney.GenerateImageResult, error) {
+				return sydney.GenerateImageResult{
+					GenerativeImage: generativeImage,
+					ImageURLs:       []string{"https://tse1.mm.bing.net/th/id/OIG.1"},
+				}, nil
+			},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(extensions))
+		assert.Equal(t, []string{"weather today"}, results[0].Extension.SearchQueries)
+		assert.Equal(t, "Example", results[1].Extension.SearchResults[0].Title)
+		assert.Equal(t, []string{"Tell me more"}, results[1].Extension.SuggestedResponses)
+		assert.Equal(t, []GenerativeImageExtension{{
+			Prompt:    "a cat",
+			ImageURLs: []string{"https://tse1.mm.bing.net/th/id/OIG.1"},
+		}}, results[1].Extension.GenerativeImages)
+	})
+
+	t.Run("cannot start", func(t *testing.T) {
+		_, err := AskChoices(context.Background(), 2, func(ctx context.Context, index int) (<-chan sydney.Message, error) {
+			return nil, errors.New("captcha")
+		}, ChoiceOptions{NewLimiter: newLimiter})
+		assert.NotNil(t, err)
+	})
+}
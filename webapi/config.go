@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration which is written as a string like `30s` in config files
+type Duration time.Duration
+
+func (o *Duration) UnmarshalText(text []byte) error {
+	d, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*o = Duration(d)
+	return nil
+}
+
+func (o Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(o).String()), nil
+}
+
+// Config is the configuration of the server. It is read from a YAML or JSON file,
+// and then overridden by environment variables.
+type Config struct {
+	Port             string `json:"port" yaml:"port"`
+	UnixSocket       string `json:"unix_socket" yaml:"unix_socket"`
+	TLSCertFile      string `json:"tls_cert_file" yaml:"tls_cert_file"`
+	TLSKeyFile       string `json:"tls_key_file" yaml:"tls_key_file"`
+	Proxy            string `json:"proxy" yaml:"proxy"`
+	AllowedOrigins   string `json:"allowed_origins" yaml:"allowed_origins"`
+	NoLog            bool   `json:"no_log" yaml:"no_log"`
+	LogPrompts       bool   `json:"log_prompts" yaml:"log_prompts"`
+	DefaultCookies   string `json:"default_cookies" yaml:"default_cookies"`
+	AuthToken        string `json:"auth_token" yaml:"auth_token"`
+	APIKeysFile      string `json:"api_keys_file" yaml:"api_keys_file"`
+	APIKeysUsageFile string `json:"api_keys_usage_file" yaml:"api_keys_usage_file"`
+	ThreadsDir       string `json:"threads_dir" yaml:"threads_dir"`
+	FilesDir         string `json:"files_dir" yaml:"files_dir"`
+	JobsDir          string `json:"jobs_dir" yaml:"jobs_dir"`
+	// WebhookSecret signs the callbacks of jobs, which are refused if it is empty
+	WebhookSecret string `json:"webhook_secret" yaml:"webhook_secret"`
+	// WebhookAllowedHosts is a comma separated list of callback hosts which may resolve to private addresses
+	WebhookAllowedHosts string `json:"webhook_allowed_hosts" yaml:"webhook_allowed_hosts"`
+
+	ReadHeaderTimeout Duration `json:"read_header_timeout" yaml:"read_header_timeout"`
+	ReadTimeout       Duration `json:"read_timeout" yaml:"read_timeout"`
+	// WriteTimeout is zero by default, since it would cut off long streams
+	WriteTimeout    Duration `json:"write_timeout" yaml:"write_timeout"`
+	IdleTimeout     Duration `json:"idle_timeout" yaml:"idle_timeout"`
+	ShutdownTimeout Duration `json:"shutdown_timeout" yaml:"shutdown_timeout"`
+	// ReadinessTTL is how long the result of checking accounts is cached by /readyz
+	ReadinessTTL Duration `json:"readiness_ttl" yaml:"readiness_ttl"`
+
+	// AuditLogFile enables the audit log if it is not empty
+	AuditLogFile string `json:"audit_log_file" yaml:"audit_log_file"`
+	// AuditLogFullText keeps prompts, contexts and request bodies instead of only hashes of them
+	AuditLogFullText bool `json:"audit_log_full_text" yaml:"audit_log_full_text"`
+	// AuditLogMaxSize is the size in megabytes at which the audit log is rotated
+	AuditLogMaxSize int `json:"audit_log_max_size" yaml:"audit_log_max_size"`
+
+	// CacheSize is the number of replies kept in memory, and the cache is disabled if it is zero
+	CacheSize int      `json:"cache_size" yaml:"cache_size"`
+	CacheTTL  Duration `json:"cache_ttl" yaml:"cache_ttl"`
+	// CacheDir keeps replies on disk across restarts if it is not empty
+	CacheDir string `json:"cache_dir" yaml:"cache_dir"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Port:              "8080",
+		AllowedOrigins:    "*",
+		ThreadsDir:        "threads",
+		FilesDir:          "files",
+		JobsDir:           "jobs",
+		ReadHeaderTimeout: Duration(10 * time.Second),
+		ReadTimeout:       Duration(60 * time.Second),
+		IdleTimeout:       Duration(120 * time.Second),
+		ShutdownTimeout:   Duration(60 * time.Second),
+		ReadinessTTL:      Duration(5 * time.Minute),
+		AuditLogMaxSize:   100,
+		CacheTTL:          Duration(time.Hour),
+	}
+}
+
+// LoadConfig reads the config file if path is not empty and applies environment variables on top of it.
+// Files ending with .json are parsed as JSON, and others as YAML.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	if path != "" {
+		v, err := os.ReadFile(path)
+		if err != nil {
+			return config, fmt.Errorf("cannot read config file: %w", err)
+		}
+		if strings.ToLower(filepath.Ext(path)) == ".json" {
+			err = json.Unmarshal(v, &config)
+		} else {
+			err = yaml.Unmarshal(v, &config)
+		}
+		if err != nil {
+			return config, fmt.Errorf("cannot parse config file: %w", err)
+		}
+	}
+	config.applyEnv(os.LookupEnv)
+	return config, nil
+}
+
+func (o *Config) applyEnv(lookupEnv func(key string) (string, bool)) {
+	// HTTPS_PROXY comes after HTTP_PROXY so that it wins
+	stringEnvs := []struct {
+		key   string
+		value *string
+	}{
+		{"PORT", &o.Port},
+		{"UNIX_SOCKET", &o.UnixSocket},
+		{"TLS_CERT_FILE", &o.TLSCertFile},
+		{"TLS_KEY_FILE", &o.TLSKeyFile},
+		{"HTTP_PROXY", &o.Proxy},
+		{"HTTPS_PROXY", &o.Proxy},
+		{"ALLOWED_ORIGINS", &o.AllowedOrigins},
+		{"DEFAULT_COOKIES", &o.DefaultCookies},
+		{"AUTH_TOKEN", &o.AuthToken},
+		{"API_KEYS_FILE", &o.APIKeysFile},
+		{"API_KEYS_USAGE_FILE", &o.APIKeysUsageFile},
+		{"THREADS_DIR", &o.ThreadsDir},
+		{"FILES_DIR", &o.FilesDir},
+		{"JOBS_DIR", &o.JobsDir},
+		{"WEBHOOK_SECRET", &o.WebhookSecret},
+		{"WEBHOOK_ALLOWED_HOSTS", &o.WebhookAllowedHosts},
+		{"CACHE_DIR", &o.CacheDir},
+		{"AUDIT_LOG_FILE", &o.AuditLogFile},
+	}
+	for _, env := range stringEnvs {
+		if value, ok := lookupEnv(env.key); ok && value != "" {
+			*env.value = value
+		}
+	}
+	if v, ok := lookupEnv("CACHE_SIZE"); ok && v != "" {
+		if size, err := strconv.Atoi(v); err == nil {
+			o.CacheSize = size
+		}
+	}
+	boolEnvs := map[string]*bool{
+		"NO_LOG":              &o.NoLog,
+		"LOG_PROMPTS":         &o.LogPrompts,
+		"AUDIT_LOG_FULL_TEXT": &o.AuditLogFullText,
+	}
+	for key, value := range boolEnvs {
+		if v, ok := lookupEnv(key); ok && v != "" {
+			*value = true
+		}
+	}
+}
+
+// Validate checks the options which cannot be fixed at runtime
+func (o Config) Validate() error {
+	if (o.TLSCertFile == "") != (o.TLSKeyFile == "") {
+		return fmt.Errorf("tls_cert_file and tls_key_file must be set together")
+	}
+	if o.UnixSocket == "" && o.Port == "" {
+		return fmt.Errorf("either port or unix_socket must be set")
+	}
+	if o.CacheSize > 0 && o.CacheTTL <= 0 {
+		return fmt.Errorf("cache_ttl must be positive if the cache is enabled")
+	}
+	return nil
+}
+
+// Address describes where the server listens, for logging
+func (o Config) Address() string {
+	if o.UnixSocket != "" {
+		return "unix:" + o.UnixSocket
+	}
+	return ":" + o.Port
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	for _, env := range []string{"PORT", "UNIX_SOCKET", "TLS_CERT_FILE", "TLS_KEY_FILE", "HTTP_PROXY", "HTTPS_PROXY",
+		"ALLOWED_ORIGINS", "DEFAULT_COOKIES", "AUTH_TOKEN", "API_KEYS_FILE", "API_KEYS_USAGE_FILE",
+		"THREADS_DIR", "FILES_DIR", "JOBS_DIR", "WEBHOOK_SECRET", "WEBHOOK_ALLOWED_HOSTS", "CACHE_SIZE", "CACHE_DIR",
+		"AUDIT_LOG_FILE", "AUDIT_LOG_FULL_TEXT", "NO_LOG", "LOG_PROMPTS"} {
+		t.Setenv(env, "")
+	}
+	dir := t.TempDir()
+
+	t.Run("defaults", func(t *testing.T) {
+		config, err := LoadConfig("")
+		assert.Nil(t, err)
+		assert.Equal(t, DefaultConfig(), config)
+		assert.Equal(t, Duration(0), config.WriteTimeout)
+	})
+	t.Run("yaml", func(t *testing.T) {
+		path := filepath.Join(dir, "config.yaml")
+		err := os.WriteFile(path, []byte("port: \"9090\"\nproxy: http://127.0.0.1:7890\nidle_timeout: 5m\nlog_prompts: true\n"), 0644)
+		assert.Nil(t, err)
+		config, err := LoadConfig(path)
+		assert.Nil(t, err)
+		assert.Equal(t, "9090", config.Port)
+		assert.Equal(t, "http://127.0.0.1:7890", config.Proxy)
+		assert.Equal(t, Duration(5*time.Minute), config.IdleTimeout)
+		assert.True(t, config.LogPrompts)
+		assert.Equal(t, "threads", config.ThreadsDir)
+	})
+	t.Run("json with env overrides", func(t *testing.T) {
+		path := filepath.Join(dir, "config.json")
+		err := os.WriteFile(path, []byte(`{"port": "9090", "allowed_origins": "https://a.example", "read_timeout": "30s"}`), 0644)
+		assert.Nil(t, err)
+		t.Setenv("PORT", "7070")
+		t.Setenv("HTTP_PROXY", "http://http-proxy")
+		t.Setenv("HTTPS_PROXY", "http://https-proxy")
+		t.Setenv("NO_LOG", "1")
+		t.Setenv("CACHE_SIZE", "100")
+		config, err := LoadConfig(path)
+		assert.Nil(t, err)
+		assert.Equal(t, "7070", config.Port)
+		assert.Equal(t, "https://a.example", config.AllowedOrigins)
+		assert.Equal(t, "http://https-proxy", config.Proxy)
+		assert.Equal(t, Duration(30*time.Second), config.ReadTimeout)
+		assert.True(t, config.NoLog)
+		assert.Equal(t, 100, config.CacheSize)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		path := filepath.Join(dir, "invalid.yaml")
+		err := os.WriteFile(path, []byte("read_timeout: soon\n"), 0644)
+		assert.Nil(t, err)
+		_, err = LoadConfig(path)
+		assert.NotNil(t, err)
+
+		config := DefaultConfig()
+		config.TLSCertFile = "cert.pem"
+		assert.NotNil(t, config.Validate())
+
+		config = DefaultConfig()
+		config.CacheSize = 100
+		config.CacheTTL = 0
+		assert.NotNil(t, config.Validate())
+	})
+}
+
+func TestServerReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	keysPath := filepath.Join(dir, "keys.json")
+	for _, env := range []string{"PORT", "HTTP_PROXY", "HTTPS_PROXY", "AUTH_TOKEN", "API_KEYS_FILE", "DEFAULT_COOKIES"} {
+		t.Setenv(env, "")
+	}
+	err := os.WriteFile(path, []byte("proxy: http://old\ndefault_cookies: _U=old\nauth_token: sk-old\n"), 0644)
+	assert.Nil(t, err)
+	server, err := NewServer(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://old", server.Proxy())
+	assert.Equal(t, "old", server.DefaultCookies()["_U"])
+	_, ok := server.APIKeys().Authenticate("sk-old")
+	assert.True(t, ok)
+
+	err = os.WriteFile(keysPath, []byte(`[{"key": "sk-new"}]`), 0644)
+	assert.Nil(t, err)
+	err = os.WriteFile(path, []byte("port: \"9999\"\nproxy: http://new\ndefault_cookies: _U=new\napi_keys_file: "+keysPath+"\n"), 0644)
+	assert.Nil(t, err)
+	assert.Nil(t, server.Reload())
+	assert.Equal(t, "http://new", server.Proxy())
+	assert.Equal(t, "new", server.DefaultCookies()["_U"])
+	assert.Equal(t, "8080", server.Config().Port)
+	_, ok = server.APIKeys().Authenticate("sk-old")
+	assert.False(t, ok)
+	_, ok = server.APIKeys().Authenticate("sk-new")
+	assert.True(t, ok)
+
+	// a broken file keeps the current config
+	err = os.WriteFile(path, []byte("api_keys_file: "+filepath.Join(dir, "missing.json")+"\n"), 0644)
+	assert.Nil(t, err)
+	assert.NotNil(t, server.Reload())
+	assert.Equal(t, "http://new", server.Proxy())
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sydneyqt/sydney"
+)
+
+// SydneyExtensionsHeader enables the extension as well as the `sydney_extensions` field of requests,
+// for SDKs which cannot add fields
+const SydneyExtensionsHeader = "X-Sydney-Extensions"
+
+type GenerativeImageExtension struct {
+	Prompt    string   `json:"prompt"`
+	ImageURLs []string `json:"image_urls,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// SydneyExtension carries what Sydney sends besides the reply text. It is added to responses
+// as the `sydney` field, which standard clients ignore.
+type SydneyExtension struct {
+	SearchQueries      []string                   `json:"search_queries,omitempty"`
+	SearchResults      []sydney.SourceAttribute   `json:"search_results,omitempty"`
+	SuggestedResponses []string                   `json:"suggested_responses,omitempty"`
+	GenerativeImages   []GenerativeImageExtension `json:"generative_images,omitempty"`
+}
+
+func (o *SydneyExtension) Merge(extension SydneyExtension) {
+	o.SearchQueries = append(o.SearchQueries, extension.SearchQueries...)
+	o.SearchResults = append(o.SearchResults, extension.SearchResults...)
+	o.SuggestedResponses = append(o.SuggestedResponses, extension.SuggestedResponses...)
+	o.GenerativeImages = append(o.GenerativeImages, extension.GenerativeImages...)
+}
+
+func (o SydneyExtension) IsEmpty() bool {
+	return len(o.SearchQueries) == 0 && len(o.SearchResults) == 0 &&
+		len(o.SuggestedResponses) == 0 && len(o.GenerativeImages) == 0
+}
+
+// ParseSydneyExtension converts search queries, search results and suggested responses.
+// Generative images are left to the caller, since they may need to be created first.
+func ParseSydneyExtension(message sydney.Message) (SydneyExtension, bool) {
+	var extension SydneyExtension
+	switch message.Type {
+	case sydney.MessageTypeSearchQuery:
+		extension.SearchQueries = []string{message.Text}
+	case sydney.MessageTypeSearchResult:
+		if json.Unmarshal([]byte(message.Text), &extension.SearchResults) != nil {
+			return extension, false
+		}
+	case sydney.MessageTypeSuggestedResponses:
+		if json.Unmarshal([]byte(message.Text), &extension.SuggestedResponses) != nil {
+			return extension, false
+		}
+	default:
+		return extension, false
+	}
+	return extension, !extension.IsEmpty()
+}
+
+// SydneyExtensionsEnabled reports whether the client opts in by the request field or the header
+func SydneyExtensionsEnabled(r *http.Request, field bool) bool {
+	if field {
+		return true
+	}
+	enabled, _ := strconv.ParseBool(r.Header.Get(SydneyExtensionsHeader))
+	return enabled
+}
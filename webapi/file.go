@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sydneyqt/sydney"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/samber/lo"
+)
+
+// MaxFileSize is the maximum size of a file uploaded to /v1/files, which fits in memory when parsing the form
+const MaxFileSize = 32 << 20
+
+var ErrFileNotFound = errors.New("file not found")
+var ErrFileTooLarge = errors.New("file too large, the limit is " + strconv.Itoa(MaxFileSize>>20) + " MiB")
+
+// fileMetadata is kept in `<dir>/<id>.json` since the directory of a file only holds the document.
+// Files uploaded before it have their purpose in `<dir>/<id>.purpose` and no owner.
+type fileMetadata struct {
+	Purpose string `json:"purpose"`
+	Owner   string `json:"owner"`
+}
+
+// FileStore keeps uploaded documents in `<dir>/<id>/<filename>`, so that the original file name and extension,
+// which are required by the Bing file upload, are preserved
+type FileStore struct {
+	mu  sync.RWMutex
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (o *FileStore) Save(owner, filename, purpose string, reader io.Reader) (OpenAIFile, error) {
+	var empty OpenAIFile
+	filename = filepath.Base(filename)
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+	if !lo.Contains(sydney.BingAllowedFileExtensions, ext) {
+		return empty, errors.New("file type ." + ext + " is not allowed")
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	id := "file-" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	err := os.Mkdir(filepath.Join(o.dir, id), 0750)
+	if err != nil {
+		return empty, err
+	}
+	err = o.write(filepath.Join(o.dir, id, filename), reader)
+	if err == nil {
+		var v []byte
+		v, err = json.Marshal(fileMetadata{Purpose: purpose, Owner: owner})
+		if err == nil {
+			err = os.WriteFile(filepath.Join(o.dir, id+".json"), v, 0644)
+		}
+	}
+	if err != nil {
+		// leave no partial file behind
+		_ = os.Remove(filepath.Join(o.dir, id+".json"))
+		_ = os.RemoveAll(filepath.Join(o.dir, id))
+		return empty, err
+	}
+	return o.get(id)
+}
+
+func (o *FileStore) write(p string, reader io.Reader) error {
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, reader)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Path returns the local path of the file uploaded by the owner, which can be used as AskStreamOptions.UploadFilePath
+func (o *FileStore) Path(owner, id string) (string, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	file, err := o.get(id)
+	if err != nil {
+		return "", err
+	}
+	if file.Owner != owner {
+		return "", ErrFileNotFound
+	}
+	return o.path(id)
+}
+
+func (o *FileStore) path(id string) (string, error) {
+	if id == "" || filepath.Base(id) != id || strings.HasPrefix(id, ".") {
+		return "", ErrFileNotFound
+	}
+	entries, err := os.ReadDir(filepath.Join(o.dir, id))
+	if err != nil || len(entries) == 0 {
+		return "", ErrFileNotFound
+	}
+	return filepath.Join(o.dir, id, entries[0].Name()), nil
+}
+
+func (o *FileStore) get(id string) (OpenAIFile, error) {
+	var empty OpenAIFile
+	p, err := o.path(id)
+	if err != nil {
+		return empty, err
+	}
+	stat, err := os.Stat(p)
+	if err != nil {
+		return empty, err
+	}
+	var metadata fileMetadata
+	v, err := os.ReadFile(filepath.Join(o.dir, id+".json"))
+	if err == nil {
+		err = json.Unmarshal(v, &metadata)
+		if err != nil {
+			return empty, err
+		}
+	} else {
+		v, _ = os.ReadFile(filepath.Join(o.dir, id+".purpose"))
+		metadata.Purpose = string(v)
+	}
+	return OpenAIFile{
+		ID:        id,
+		Object:    "file",
+		Bytes:     stat.Size(),
+		CreatedAt: stat.ModTime().Unix(),
+		Filename:  stat.Name(),
+		Purpose:   metadata.Purpose,
+		Owner:     metadata.Owner,
+	}, nil
+}
+
+func (o *FileStore) Get(id string) (OpenAIFile, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.get(id)
+}
+
+// List returns the files of the owner, the newest first
+func (o *FileStore) List(owner string) ([]OpenAIFile, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []OpenAIFile{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		file, err := o.get(entry.Name())
+		if err != nil || file.Owner != owner {
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].CreatedAt > files[j].CreatedAt
+	})
+	return files, nil
+}
+
+func (o *FileStore) Delete(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, err := o.path(id); err != nil {
+		return err
+	}
+	_ = os.Remove(filepath.Join(o.dir, id+".json"))
+	_ = os.Remove(filepath.Join(o.dir, id+".purpose"))
+	return os.RemoveAll(filepath.Join(o.dir, id))
+}
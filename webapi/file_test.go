@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	assert.Nil(t, err)
+
+	file, err := store.Save("alice", "../Report.PDF", "assistants", strings.NewReader("%PDF-1.4"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Report.PDF", file.Filename)
+	assert.Equal(t, "assistants", file.Purpose)
+	assert.Equal(t, int64(8), file.Bytes)
+	assert.Equal(t, "alice", file.Owner)
+
+	_, err = store.Save("alice", "script.exe", "", strings.NewReader("MZ"))
+	assert.Error(t, err)
+
+	// a failed upload leaves nothing behind
+	_, err = store.Save("alice", "notes.txt", "", io.MultiReader(strings.NewReader("half"), failingReader{}))
+	assert.Error(t, err)
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2) // the first file and its metadata
+
+	files, err := store.List("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, []OpenAIFile{file}, files)
+	files, err = store.List("bob")
+	assert.Nil(t, err)
+	assert.Empty(t, files)
+	_, err = store.Path("bob", file.ID)
+	assert.Equal(t, ErrFileNotFound, err)
+	p, err := store.Path("alice", file.ID)
+	assert.Nil(t, err)
+	assert.FileExists(t, p)
+
+	assert.Nil(t, store.Delete(file.ID))
+	_, err = store.Get(file.ID)
+	assert.Equal(t, ErrFileNotFound, err)
+}
+
+func TestFileStoreLegacyPurpose(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "file-old"), 0750))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "file-old", "notes.txt"), []byte("notes"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "file-old.purpose"), []byte("assistants"), 0644))
+
+	file, err := store.Get("file-old")
+	assert.Nil(t, err)
+	assert.Equal(t, "assistants", file.Purpose)
+	assert.Empty(t, file.Owner)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sydneyqt/sydney"
+	"sync"
+	"time"
+)
+
+const (
+	ReadinessStatusOK          = "ok"
+	ReadinessStatusDegraded    = "degraded"
+	ReadinessStatusUnavailable = "unavailable"
+)
+
+// Account is a set of Bing cookies checked by readiness, named by its API key label or `default`
+type Account struct {
+	Name    string
+	Cookies map[string]string
+}
+
+type AccountStatus struct {
+	Name      string `json:"name"`
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+// ReadinessReport tells the status of every account only to authorized requests,
+// since the names are API key labels and the errors come from Bing as is
+type ReadinessReport struct {
+	Status        string          `json:"status"`
+	CheckedAt     time.Time       `json:"checked_at"`
+	ExpiresAt     time.Time       `json:"expires_at"`
+	AccountsOK    int             `json:"accounts_ok"`
+	AccountsTotal int             `json:"accounts_total"`
+	Accounts      []AccountStatus `json:"accounts,omitempty"`
+}
+
+// HealthChecker checks all accounts and caches the report, since every check costs requests to Bing.
+// Concurrent probes share one check, which runs without holding the lock.
+type HealthChecker struct {
+	mu         sync.Mutex
+	report     *ReadinessReport
+	checking   chan struct{} // closed when the running check finishes
+	ttl        func() time.Duration
+	accounts   func() []Account
+	check      func(account Account) error
+	draining   func() bool
+	authorized func(r *http.Request) bool
+}
+
+func NewHealthChecker(server *Server) *HealthChecker {
+	return &HealthChecker{
+		ttl: func() time.Duration {
+			return time.Duration(server.Config().ReadinessTTL)
+		},
+		accounts: server.Accounts,
+		check: func(account Account) error {
+			return CheckAccount(server.Proxy(), account.Cookies)
+		},
+		draining: server.Draining,
+		authorized: func(r *http.Request) bool {
+			_, ok := RequestAPIKey(r.Context())
+			return ok || !server.APIKeys().Enabled()
+		},
+	}
+}
+
+// CheckAccount verifies that the cookies belong to a signed-in user who is able to create a conversation
+func CheckAccount(proxy string, cookies map[string]string) error {
+	sydneyAPI := sydney.NewSydney(sydney.Options{
+		Cookies: cookies,
+		Proxy:   proxy,
+	})
+	_, err := sydneyAPI.GetUser()
+	if err != nil {
+		return err
+	}
+	_, err = sydneyAPI.CreateConversation()
+	return err
+}
+
+// Report returns the cached report, or checks all accounts concurrently if it has expired
+func (o *HealthChecker) Report() ReadinessReport {
+	o.mu.Lock()
+	if o.report != nil && time.Now().Before(o.report.ExpiresAt) {
+		report := *o.report
+		o.mu.Unlock()
+		return report
+	}
+	if checking := o.checking; checking != nil {
+		o.mu.Unlock()
+		<-checking
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		return *o.report
+	}
+	checking := make(chan struct{})
+	o.checking = checking
+	o.mu.Unlock()
+
+	report := o.run()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.report = &report
+	o.checking = nil
+	close(checking)
+	return report
+}
+
+func (o *HealthChecker) run() ReadinessReport {
+	now := time.Now()
+	accounts := o.accounts()
+	statuses := make([]AccountStatus, len(accounts))
+	var wg sync.WaitGroup
+	for i, account := range accounts {
+		wg.Add(1)
+		go func(i int, account Account) {
+			defer wg.Done()
+			start := time.Now()
+			err := o.check(account)
+			statuses[i] = AccountStatus{
+				Name:      account.Name,
+				OK:        err == nil,
+				LatencyMs: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				statuses[i].Error = err.Error()
+			}
+		}(i, account)
+	}
+	wg.Wait()
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	okCount := 0
+	for _, status := range statuses {
+		if status.OK {
+			okCount++
+		}
+	}
+	report := ReadinessReport{
+		Status:        ReadinessStatusDegraded,
+		CheckedAt:     now,
+		ExpiresAt:     now.Add(o.ttl()),
+		AccountsOK:    okCount,
+		AccountsTotal: len(statuses),
+		Accounts:      statuses,
+	}
+	switch okCount {
+	case len(statuses):
+		report.Status = ReadinessStatusOK
+	case 0:
+		report.Status = ReadinessStatusUnavailable
+	}
+	return report
+}
+
+// ServeHealthz reports that the process is up without touching any account
+func (o *HealthChecker) ServeHealthz(w http.ResponseWriter, r *http.Request) {
+	// set headers
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// write response
+	json.NewEncoder(w).Encode(map[string]string{"status": ReadinessStatusOK})
+}
+
+// ServeReadyz responds 503 if no account works or the server is shutting down, so that load balancers
+// stop sending requests, and 200 otherwise, even if only some of the accounts work
+func (o *HealthChecker) ServeReadyz(w http.ResponseWriter, r *http.Request) {
+	var report ReadinessReport
+	if o.draining() {
+		now := time.Now()
+		report = ReadinessReport{
+			Status:    ReadinessStatusUnavailable,
+			CheckedAt: now,
+			ExpiresAt: now,
+			Accounts:  []AccountStatus{},
+		}
+	} else {
+		report = o.Report()
+	}
+	if !o.authorized(r) {
+		report.Accounts = nil
+	}
+
+	// set headers
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status == ReadinessStatusUnavailable {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	// write response
+	json.NewEncoder(w).Encode(report)
+}
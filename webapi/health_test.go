@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthChecker(t *testing.T) {
+	var checks atomic.Int32
+	broken := map[string]bool{"team-b": true}
+	draining := false
+	checker := &HealthChecker{
+		ttl: func() time.Duration {
+			return time.Minute
+		},
+		accounts: func() []Account {
+			return []Account{{Name: "default"}, {Name: "team-b"}}
+		},
+		check: func(account Account) error {
+			checks.Add(1)
+			if broken[account.Name] {
+				return errors.New("cannot identify current user, please check if cookie is expired")
+			}
+			return nil
+		},
+		draining: func() bool {
+			return draining
+		},
+		authorized: func(r *http.Request) bool {
+			return r.Header.Get("Authorization") != ""
+		},
+	}
+	serve := func() (*httptest.ResponseRecorder, ReadinessReport) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+		r.Header.Set("Authorization", "Bearer sk-test")
+		checker.ServeReadyz(w, r)
+		var report ReadinessReport
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &report))
+		return w, report
+	}
+
+	w, report := serve()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ReadinessStatusDegraded, report.Status)
+	assert.Equal(t, 2, len(report.Accounts))
+	assert.True(t, report.Accounts[0].OK)
+	assert.False(t, report.Accounts[1].OK)
+	assert.Equal(t, "team-b", report.Accounts[1].Name)
+	assert.NotEmpty(t, report.Accounts[1].Error)
+	assert.Equal(t, 1, report.AccountsOK)
+	assert.Equal(t, 2, report.AccountsTotal)
+
+	// cached within the TTL
+	serve()
+	assert.Equal(t, int32(2), checks.Load())
+
+	// only counts without authorization
+	w = httptest.NewRecorder()
+	checker.ServeReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.NotContains(t, w.Body.String(), "team-b")
+	assert.NotContains(t, w.Body.String(), "cookie")
+	assert.Contains(t, w.Body.String(), `"accounts_ok":1`)
+
+	broken["default"] = true
+	checker.report.ExpiresAt = time.Now()
+	w, report = serve()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, ReadinessStatusUnavailable, report.Status)
+	assert.Equal(t, int32(4), checks.Load())
+
+	delete(broken, "default")
+	delete(broken, "team-b")
+	checker.report.ExpiresAt = time.Now()
+	_, report = serve()
+	assert.Equal(t, ReadinessStatusOK, report.Status)
+
+	draining = true
+	w, report = serve()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, ReadinessStatusUnavailable, report.Status)
+	assert.Equal(t, int32(6), checks.Load())
+
+	w = httptest.NewRecorder()
+	checker.ServeHealthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+}
+
+func TestHealthCheckerSingleFlight(t *testing.T) {
+	var checks atomic.Int32
+	release := make(chan struct{})
+	checker := &HealthChecker{
+		ttl: func() time.Duration {
+			return time.Minute
+		},
+		accounts: func() []Account {
+			return []Account{{Name: "default"}}
+		},
+		check: func(account Account) error {
+			checks.Add(1)
+			<-release
+			return nil
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, ReadinessStatusOK, checker.Report().Status)
+		}()
+	}
+	// the lock is not held while checking
+	assert.Eventually(t, func() bool {
+		if !checker.mu.TryLock() {
+			return false
+		}
+		defer checker.mu.Unlock()
+		return checker.checking != nil
+	}, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), checks.Load())
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sydneyqt/sydney"
+	"sydneyqt/util"
+	"time"
+)
+
+// MaxImageCount is the maximum of `n` accepted by /v1/images/generations
+const MaxImageCount = 10
+
+var ErrEmptyGenerativeImage = errors.New("empty generative image")
+
+// CreateImage asks Sydney for a generative image of the prompt and then creates it,
+// which usually results in a batch of up to 4 images
+func CreateImage(ctx context.Context, sydneyAPI *sydney.Sydney, prompt string) (sydney.GenerateImageResult, error) {
+	var empty sydney.GenerateImageResult
+
+	newContext, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	messageCh, err := sydneyAPI.AskStream(sydney.AskStreamOptions{
+		StopCtx:        newContext,
+		Prompt:         "Create image for the description: " + prompt,
+		WebpageContext: ImageGeneratorContext,
+	})
+	if err != nil {
+		return empty, err
+	}
+
+	var generativeImage sydney.GenerativeImage
+
+	for message := range messageCh {
+		if message.Type == sydney.MessageTypeGenerativeImage {
+			err := json.Unmarshal([]byte(message.Text), &generativeImage)
+			if err == nil {
+				break
+			}
+		}
+	}
+	cancel()
+
+	if generativeImage.URL == "" {
+		return empty, ErrEmptyGenerativeImage
+	}
+
+	return sydneyAPI.GenerateImage(generativeImage)
+}
+
+// CreateImages creates batches of images until there are at least n images
+func CreateImages(ctx context.Context, sydneyAPI *sydney.Sydney, prompt string, n int) ([]sydney.GenerateImageResult, error) {
+	var results []sydney.GenerateImageResult
+	count := 0
+
+	for count < n {
+		result, err := CreateImage(ctx, sydneyAPI, prompt)
+		if err != nil {
+			// return what has been created if any
+			if len(results) != 0 {
+				return results, nil
+			}
+			return nil, err
+		}
+		if len(result.ImageURLs) == 0 {
+			if len(results) != 0 {
+				return results, nil
+			}
+			return nil, ErrEmptyGenerativeImage
+		}
+		results = append(results, result)
+		count += len(result.ImageURLs)
+	}
+
+	return results, nil
+}
+
+// ParseImageSize parses sizes like `1024x1024`
+func ParseImageSize(size string) (width, height int, err error) {
+	widthStr, heightStr, ok := strings.Cut(size, "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid image size: %s", size)
+	}
+	width, err = strconv.Atoi(widthStr)
+	if err != nil || width <= 0 {
+		return 0, 0, fmt.Errorf("invalid image width: %s", widthStr)
+	}
+	height, err = strconv.Atoi(heightStr)
+	if err != nil || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid image height: %s", heightStr)
+	}
+	return width, height, nil
+}
+
+// ResizeImageURL removes the query string of a Bing image URL to get the original 1024x1024 image,
+// or asks Bing to resize it if another size is required
+func ResizeImageURL(url, size string) (string, error) {
+	urlWithoutQuery := strings.Split(url, "?")[0]
+	if size == "" || size == "1024x1024" {
+		return urlWithoutQuery, nil
+	}
+	width, height, err := ParseImageSize(size)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s?w=%d&h=%d&c=7", urlWithoutQuery, width, height), nil
+}
+
+// DownloadBase64 downloads an image or another asset from Bing as base64
+func DownloadBase64(proxy, url string) (string, error) {
+	_, client, err := util.MakeHTTPClient(proxy, 30*time.Second)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.R().Get(url)
+	if err != nil {
+		return "", err
+	}
+	if resp.IsErrorState() {
+		return "", errors.New("cannot download asset, status: " + resp.GetStatus())
+	}
+	return base64.StdEncoding.EncodeToString(resp.Bytes()), nil
+}
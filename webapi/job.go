@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sydneyqt/sydney"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	JobTypeImage = "image"
+	JobTypeMusic = "music"
+)
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusFailed    = "failed"
+)
+
+// headers of callback requests
+const (
+	WebhookJobIDHeader     = "X-Sydney-Job-ID"
+	WebhookTimestampHeader = "X-Sydney-Timestamp"
+	WebhookSignatureHeader = "X-Sydney-Signature"
+)
+
+// MaxDeliveryAttempts is the number of times a callback is tried before the delivery fails
+const MaxDeliveryAttempts = 8
+
+var (
+	ErrJobNotFound     = errors.New("job not found")
+	ErrJobInterrupted  = errors.New("interrupted by a restart, and the cookies of the client are not kept")
+	ErrPrivateCallback = errors.New("callback_url must not resolve to a loopback, private or link-local address, " +
+		"unless its host is in webhook_allowed_hosts")
+	ErrInvalidJob = errors.New("type must be image with prompt or image.url, " +
+		"or music with prompt or music.iframeid and music.requestid")
+)
+
+type JobDelivery struct {
+	Status        string `json:"status"`
+	Attempts      int    `json:"attempts"`
+	LastError     string `json:"last_error,omitempty"`
+	NextAttemptAt int64  `json:"next_attempt_at,omitempty"`
+	DeliveredAt   int64  `json:"delivered_at,omitempty"`
+}
+
+// Job is an image or music generation run in the background. It either starts from a prompt, which is asked
+// to Sydney in the job, or from a GenerativeImage or GenerativeMusic already obtained from a chat.
+// Its result is sydney.GenerateImageResult, or a list of them for a prompt, or sydney.GenerateMusicResult
+// depending on the type.
+type Job struct {
+	ID          string                  `json:"id"`
+	Object      string                  `json:"object"`
+	Type        string                  `json:"type"`
+	Status      string                  `json:"status"`
+	CreatedAt   int64                   `json:"created_at"`
+	CompletedAt int64                   `json:"completed_at,omitempty"`
+	Prompt      string                  `json:"prompt,omitempty"`
+	N           int                     `json:"n,omitempty"`
+	Image       *sydney.GenerativeImage `json:"image,omitempty"`
+	Music       *sydney.GenerativeMusic `json:"music,omitempty"`
+	Result      json.RawMessage         `json:"result,omitempty"`
+	Error       string                  `json:"error,omitempty"`
+	CallbackURL string                  `json:"callback_url,omitempty"`
+	Delivery    *JobDelivery            `json:"delivery,omitempty"`
+}
+
+func (o Job) Finished() bool {
+	return o.Status == JobStatusSucceeded || o.Status == JobStatusFailed
+}
+
+// jobRecord is what is stored for a job, which also tells who submitted it and which account runs it
+type jobRecord struct {
+	Job
+	Owner   string `json:"owner"`
+	Account string `json:"account"`
+}
+
+// JobStore keeps every job in its own JSON file, so that jobs survive a restart
+type JobStore struct {
+	mu  sync.RWMutex
+	dir string
+}
+
+func NewJobStore(dir string) (*JobStore, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+	return &JobStore{dir: dir}, nil
+}
+
+func (o *JobStore) path(id string) (string, error) {
+	if id == "" || filepath.Base(id) != id || strings.HasPrefix(id, ".") {
+		return "", ErrJobNotFound
+	}
+	return filepath.Join(o.dir, id+".json"), nil
+}
+
+func (o *JobStore) read(id string) (jobRecord, error) {
+	var record jobRecord
+	p, err := o.path(id)
+	if err != nil {
+		return record, err
+	}
+	v, err := os.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return record, ErrJobNotFound
+		}
+		return record, err
+	}
+	err = json.Unmarshal(v, &record)
+	return record, err
+}
+
+func (o *JobStore) write(record jobRecord) error {
+	p, err := o.path(record.ID)
+	if err != nil {
+		return err
+	}
+	v, err := json.MarshalIndent(&record, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first to avoid corrupting the job on crash
+	err = os.WriteFile(p+".tmp", v, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+func (o *JobStore) Create(record jobRecord) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.write(record)
+}
+
+func (o *JobStore) Get(id string) (jobRecord, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.read(id)
+}
+
+// List returns the jobs of the owner, the newest first
+func (o *JobStore) List(owner string) ([]jobRecord, error) {
+	records, err := o.all()
+	if err != nil {
+		return nil, err
+	}
+	owned := []jobRecord{}
+	for _, record := range records {
+		if record.Owner == owner {
+			owned = append(owned, record)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		return owned[i].CreatedAt > owned[j].CreatedAt
+	})
+	return owned, nil
+}
+
+// all returns the jobs of every owner
+func (o *JobStore) all() ([]jobRecord, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+	var records []jobRecord
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		record, err := o.read(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Update changes the job in place, and fails with ErrJobNotFound if it has been deleted
+func (o *JobStore) Update(id string, update func(record *jobRecord)) (jobRecord, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	record, err := o.read(id)
+	if err != nil {
+		return record, err
+	}
+	update(&record)
+	return record, o.write(record)
+}
+
+func (o *JobStore) Delete(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, err := o.path(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrJobNotFound
+	}
+	return err
+}
+
+// SignWebhook is the hex encoded HMAC-SHA256 of the timestamp, a dot and the body
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeliveryBackoff is the delay after the given number of failed attempts, doubling from 5 seconds up to 10 minutes
+func DeliveryBackoff(attempts int) time.Duration {
+	return min(5*time.Second<<max(attempts-1, 0), 10*time.Minute)
+}
+
+// JobRunner runs submitted jobs in the background and posts them to their callback URLs once they finish
+type JobRunner struct {
+	store    *JobStore
+	secret   func() string
+	cookies  func(account string) (map[string]string, bool)
+	generate func(record jobRecord, cookies map[string]string) (any, error)
+	client   *http.Client
+	backoff  func(attempts int) time.Duration
+}
+
+func NewJobRunner(store *JobStore, server *Server, metrics *Metrics) *JobRunner {
+	return &JobRunner{
+		store: store,
+		secret: func() string {
+			return server.Config().WebhookSecret
+		},
+		cookies: func(account string) (map[string]string, bool) {
+			switch account {
+			case AccountDefault:
+				return server.DefaultCookies(), true
+			case AccountClient:
+				return nil, false
+			}
+			for _, key := range server.APIKeys().Keys() {
+				if AccountKeyPrefix+key.Label == account && key.Cookies != "" {
+					return ParseCookies(key.Cookies), true
+				}
+			}
+			return nil, false
+		},
+		generate: func(record jobRecord, cookies map[string]string) (any, error) {
+			options := sydney.Options{
+				Cookies:           cookies,
+				Proxy:             server.Proxy(),
+				ConversationStyle: "Creative",
+				Locale:            "en-US",
+			}
+			var (
+				result any
+				err    error
+			)
+			switch {
+			case record.Type == JobTypeMusic && record.Prompt != "":
+				options.Plugins = []string{"Suno"}
+				result, err = CreateMusic(context.Background(), sydney.NewSydney(options), record.Prompt)
+			case record.Type == JobTypeMusic:
+				result, err = sydney.NewSydney(options).GenerateMusic(*record.Music)
+			case record.Prompt != "":
+				result, err = CreateImages(context.Background(), sydney.NewSydney(options), record.Prompt, record.N)
+			default:
+				result, err = sydney.NewSydney(options).GenerateImage(*record.Image)
+			}
+			metrics.ObserveJob(record.Type, err)
+			return result, err
+		},
+		client: newCallbackClient(func() string {
+			return server.Config().WebhookAllowedHosts
+		}),
+		backoff: DeliveryBackoff,
+	}
+}
+
+// Submit stores the job and starts it in the background
+func (o *JobRunner) Submit(record jobRecord, cookies map[string]string) (Job, error) {
+	record.ID = "job_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	record.Object = "job"
+	record.Status = JobStatusQueued
+	record.CreatedAt = time.Now().Unix()
+	if record.CallbackURL != "" {
+		record.Delivery = &JobDelivery{Status: DeliveryStatusPending}
+	}
+	err := o.store.Create(record)
+	if err != nil {
+		return record.Job, err
+	}
+	go o.run(record.ID, cookies)
+	return record.Job, nil
+}
+
+// Resume continues the jobs and the deliveries which were unfinished when the server stopped.
+// Jobs run with the cookies of the client fail, since the cookies are not stored.
+func (o *JobRunner) Resume() error {
+	records, err := o.store.all()
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		switch {
+		case !record.Finished():
+			cookies, ok := o.cookies(record.Account)
+			if !ok {
+				go o.complete(record.ID, nil, ErrJobInterrupted)
+				continue
+			}
+			go o.run(record.ID, cookies)
+		case record.Delivery != nil && record.Delivery.Status == DeliveryStatusPending:
+			go o.deliver(record.ID, time.Until(time.Unix(record.Delivery.NextAttemptAt, 0)))
+		}
+	}
+	return nil
+}
+
+func (o *JobRunner) run(id string, cookies map[string]string) {
+	record, err := o.store.Update(id, func(record *jobRecord) {
+		record.Status = JobStatusRunning
+	})
+	if err != nil {
+		return
+	}
+	result, err := o.generate(record, cookies)
+	o.complete(id, result, err)
+}
+
+func (o *JobRunner) complete(id string, result any, err error) {
+	var v []byte
+	if err == nil {
+		v, err = json.Marshal(result)
+	}
+	record, updateErr := o.store.Update(id, func(record *jobRecord) {
+		record.CompletedAt = time.Now().Unix()
+		if err != nil {
+			record.Status = JobStatusFailed
+			record.Error = err.Error()
+			return
+		}
+		record.Status = JobStatusSucceeded
+		record.Result = v
+	})
+	if updateErr != nil {
+		if !errors.Is(updateErr, ErrJobNotFound) {
+			slog.Error("Cannot save job", "id", id, "err", updateErr)
+		}
+		return
+	}
+	if record.Delivery != nil {
+		o.deliver(id, 0)
+	}
+}
+
+// deliver posts the job to its callback URL until it succeeds, the attempts run out or the job is deleted
+func (o *JobRunner) deliver(id string, wait time.Duration) {
+	for {
+		time.Sleep(wait)
+		record, err := o.store.Get(id)
+		if err != nil || record.Delivery == nil || record.Delivery.Status != DeliveryStatusPending {
+			return
+		}
+		err = o.post(record.Job)
+		record, updateErr := o.store.Update(id, func(record *jobRecord) {
+			delivery := record.Delivery
+			delivery.Attempts++
+			delivery.NextAttemptAt = 0
+			if err == nil {
+				delivery.Status = DeliveryStatusDelivered
+				delivery.DeliveredAt = time.Now().Unix()
+				delivery.LastError = ""
+				return
+			}
+			delivery.LastError = err.Error()
+			if delivery.Attempts >= MaxDeliveryAttempts {
+				delivery.Status = DeliveryStatusFailed
+				return
+			}
+			wait = o.backoff(delivery.Attempts)
+			delivery.NextAttemptAt = time.Now().Add(wait).Unix()
+		})
+		if updateErr != nil || record.Delivery.Status != DeliveryStatusPending {
+			return
+		}
+	}
+}
+
+func (o *JobRunner) post(job Job) error {
+	// the delivery is still in progress, so it is left out
+	job.Delivery = nil
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequest(http.MethodPost, job.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set(WebhookJobIDHeader, job.ID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(o.secret(), timestamp, body))
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("callback status: " + resp.Status)
+	}
+	return nil
+}
+
+// reservedNetworks are not covered by the methods of net.IP, but cannot be reached from the internet either
+var reservedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// publicIP tells whether callbacks may be posted to the address
+func publicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// callbackHostAllowed tells whether the host is in the comma separated allowlist, whose hosts may be private
+func callbackHostAllowed(host string, allowedHosts string) bool {
+	for _, allowed := range strings.Split(allowedHosts, ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed != "" && strings.EqualFold(strings.Trim(allowed, "[]"), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCallbackURL accepts absolute http and https URLs whose hosts only resolve to public addresses,
+// so that callbacks cannot be used to reach the network of the server
+func validateCallbackURL(ctx context.Context, callbackURL string, allowedHosts string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback_url: %s", callbackURL)
+	}
+	if callbackHostAllowed(u.Hostname(), allowedHosts) {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("cannot resolve callback_url: %w", err)
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return ErrPrivateCallback
+		}
+	}
+	return nil
+}
+
+// newCallbackClient checks the address again when connecting, since the host may resolve differently
+// by the time the callback is posted, and redirects are followed as well. Callbacks are not sent through
+// the proxy, otherwise the address of the proxy would be checked instead.
+func newCallbackClient(allowedHosts func() string) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	guardedDialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicIP(net.ParseIP(host)) {
+				return ErrPrivateCallback
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err == nil && callbackHostAllowed(host, allowedHosts()) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return guardedDialer.DialContext(ctx, network, addr)
+	}
+	return &http.Client{Timeout: 30 * time.Second, Transport: transport}
+}
+
+func registerJobRoutes(r chi.Router, runner *JobRunner, server *Server) {
+	writeStoreError := func(w http.ResponseWriter, err error) {
+		if errors.Is(err, ErrJobNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	writeJSON := func(w http.ResponseWriter, status int, v any) {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	getOwned := func(r *http.Request) (jobRecord, error) {
+		record, err := runner.store.Get(chi.URLParam(r, "id"))
+		if err == nil && record.Owner != RequestOwner(r) {
+			return record, ErrJobNotFound
+		}
+		return record, err
+	}
+
+	r.Post("/v1/jobs", func(w http.ResponseWriter, r *http.Request) {
+		var request CreateJobRequest
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// a prompt wins over what is obtained from a chat
+		switch {
+		case request.Type == JobTypeImage && request.Prompt != "":
+			if request.N == 0 {
+				request.N = 1
+			}
+			if request.N < 1 || request.N > MaxImageCount {
+				http.Error(w, "n must be between 1 and "+strconv.Itoa(MaxImageCount), http.StatusBadRequest)
+				return
+			}
+			request.Image, request.Music = nil, nil
+		case request.Type == JobTypeMusic && request.Prompt != "":
+			request.N = 0
+			request.Image, request.Music = nil, nil
+		case request.Type == JobTypeImage && request.Image != nil && request.Image.URL != "":
+			request.N = 0
+			request.Music = nil
+		case request.Type == JobTypeMusic && request.Music != nil &&
+			request.Music.IFrameID != "" && request.Music.RequestID != "":
+			request.N = 0
+			request.Image = nil
+		default:
+			http.Error(w, ErrInvalidJob.Error(), http.StatusBadRequest)
+			return
+		}
+		if request.CallbackURL != "" {
+			err := validateCallbackURL(r.Context(), request.CallbackURL, server.Config().WebhookAllowedHosts)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if runner.secret() == "" {
+				http.Error(w, "callback_url requires webhook_secret to be configured", http.StatusBadRequest)
+				return
+			}
+		}
+
+		cookies := RequestCookies(r, request.Cookies, server.DefaultCookies())
+
+		job, err := runner.Submit(jobRecord{
+			Job: Job{
+				Type:        request.Type,
+				Prompt:      request.Prompt,
+				N:           request.N,
+				Image:       request.Image,
+				Music:       request.Music,
+				CallbackURL: request.CallbackURL,
+			},
+			Owner: RequestOwner(r),
+			// the account is stored so that the job can be resumed with the same cookies
+			Account: RequestAccount(r, request.Cookies),
+		}, cookies)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, job)
+	})
+
+	r.Get("/v1/jobs", func(w http.ResponseWriter, r *http.Request) {
+		records, err := runner.store.List(RequestOwner(r))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		jobs := []Job{}
+		for _, record := range records {
+			jobs = append(jobs, record.Job)
+		}
+		writeJSON(w, http.StatusOK, JobList{Object: "list", Data: jobs})
+	})
+
+	r.Get("/v1/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		record, err := getOwned(r)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, record.Job)
+	})
+
+	r.Delete("/v1/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		record, err := getOwned(r)
+		if err == nil {
+			err = runner.store.Delete(record.ID)
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
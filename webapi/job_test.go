@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sydneyqt/sydney"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+type callbackRecorder struct {
+	mu       sync.Mutex
+	failures int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (o *callbackRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.failures > 0 {
+		o.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	o.bodies = append(o.bodies, body)
+	o.headers = append(o.headers, r.Header.Clone())
+}
+
+func (o *callbackRecorder) count() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.bodies)
+}
+
+func newTestJobRunner(t *testing.T, dir string) *JobRunner {
+	store, err := NewJobStore(dir)
+	assert.Nil(t, err)
+	return &JobRunner{
+		store: store,
+		secret: func() string {
+			return "secret"
+		},
+		cookies: func(account string) (map[string]string, bool) {
+			return map[string]string{}, account == AccountDefault
+		},
+		generate: func(record jobRecord, cookies map[string]string) (any, error) {
+			if record.Prompt != "" {
+				return []sydney.GenerateImageResult{{
+					GenerativeImage: sydney.GenerativeImage{Text: record.Prompt},
+					ImageURLs:       make([]string, record.N),
+				}}, nil
+			}
+			if record.Image.Text == "broken" {
+				return nil, errors.New("image creation failed")
+			}
+			return sydney.GenerateImageResult{
+				GenerativeImage: *record.Image,
+				ImageURLs:       []string{"https://example.com/1.jpg"},
+			}, nil
+		},
+		client: http.DefaultClient,
+		backoff: func(attempts int) time.Duration {
+			return time.Millisecond
+		},
+	}
+}
+
+func waitForJob(t *testing.T, runner *JobRunner, id string, done func(record jobRecord) bool) jobRecord {
+	var record jobRecord
+	assert.Eventually(t, func() bool {
+		var err error
+		record, err = runner.store.Get(id)
+		return err == nil && done(record)
+	}, 5*time.Second, 10*time.Millisecond)
+	return record
+}
+
+func delivered(record jobRecord) bool {
+	return record.Delivery != nil && record.Delivery.Status != DeliveryStatusPending
+}
+
+func TestJobRunner(t *testing.T) {
+	callbacks := &callbackRecorder{failures: 2}
+	callbackServer := httptest.NewServer(callbacks)
+	defer callbackServer.Close()
+
+	t.Run("delivery with retries", func(t *testing.T) {
+		runner := newTestJobRunner(t, t.TempDir())
+		job, err := runner.Submit(jobRecord{
+			Job: Job{
+				Type:        JobTypeImage,
+				Image:       &sydney.GenerativeImage{Text: "a cat", URL: "https://www.bing.com/images/create"},
+				CallbackURL: callbackServer.URL,
+			},
+			Account: AccountDefault,
+		}, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, JobStatusQueued, job.Status)
+
+		record := waitForJob(t, runner, job.ID, delivered)
+		assert.Equal(t, JobStatusSucceeded, record.Status)
+		assert.Equal(t, DeliveryStatusDelivered, record.Delivery.Status)
+		assert.Equal(t, 3, record.Delivery.Attempts)
+		assert.Equal(t, 1, callbacks.count())
+
+		// the signature covers the timestamp and the body
+		body, header := callbacks.bodies[0], callbacks.headers[0]
+		timestamp, err := strconv.ParseInt(header.Get(WebhookTimestampHeader), 10, 64)
+		assert.Nil(t, err)
+		assert.Equal(t, "sha256="+SignWebhook("secret", timestamp, body), header.Get(WebhookSignatureHeader))
+		assert.Equal(t, job.ID, header.Get(WebhookJobIDHeader))
+		var posted Job
+		assert.Nil(t, json.Unmarshal(body, &posted))
+		assert.Nil(t, posted.Delivery)
+		var result sydney.GenerateImageResult
+		assert.Nil(t, json.Unmarshal(posted.Result, &result))
+		assert.Equal(t, []string{"https://example.com/1.jpg"}, result.ImageURLs)
+	})
+
+	t.Run("delivery gives up", func(t *testing.T) {
+		callbacks.mu.Lock()
+		callbacks.failures = MaxDeliveryAttempts
+		callbacks.mu.Unlock()
+		runner := newTestJobRunner(t, t.TempDir())
+		job, err := runner.Submit(jobRecord{
+			Job: Job{
+				Type:        JobTypeImage,
+				Image:       &sydney.GenerativeImage{Text: "broken", URL: "https://www.bing.com/images/create"},
+				CallbackURL: callbackServer.URL,
+			},
+			Account: AccountDefault,
+		}, nil)
+		assert.Nil(t, err)
+
+		record := waitForJob(t, runner, job.ID, delivered)
+		assert.Equal(t, JobStatusFailed, record.Status)
+		assert.Equal(t, "image creation failed", record.Error)
+		assert.Equal(t, DeliveryStatusFailed, record.Delivery.Status)
+		assert.Equal(t, MaxDeliveryAttempts, record.Delivery.Attempts)
+		assert.Contains(t, record.Delivery.LastError, "500")
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		dir := t.TempDir()
+		runner := newTestJobRunner(t, dir)
+		image := &sydney.GenerativeImage{Text: "a dog", URL: "https://www.bing.com/images/create"}
+		for _, record := range []jobRecord{
+			{Job: Job{ID: "job_queued", Type: JobTypeImage, Status: JobStatusQueued, Image: image}, Account: AccountDefault},
+			{Job: Job{ID: "job_client", Type: JobTypeImage, Status: JobStatusRunning, Image: image}, Account: AccountClient},
+			{Job: Job{ID: "job_undelivered", Type: JobTypeImage, Status: JobStatusSucceeded, Image: image,
+				CallbackURL: callbackServer.URL, Delivery: &JobDelivery{Status: DeliveryStatusPending, Attempts: 1}}},
+		} {
+			assert.Nil(t, runner.store.Create(record))
+		}
+		before := callbacks.count()
+
+		assert.Nil(t, newTestJobRunner(t, dir).Resume())
+		record := waitForJob(t, runner, "job_queued", func(record jobRecord) bool {
+			return record.Finished()
+		})
+		assert.Equal(t, JobStatusSucceeded, record.Status)
+		record = waitForJob(t, runner, "job_client", func(record jobRecord) bool {
+			return record.Finished()
+		})
+		assert.Equal(t, ErrJobInterrupted.Error(), record.Error)
+		record = waitForJob(t, runner, "job_undelivered", delivered)
+		assert.Equal(t, DeliveryStatusDelivered, record.Delivery.Status)
+		assert.Equal(t, before+1, callbacks.count())
+	})
+}
+
+func TestJobRoutes(t *testing.T) {
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, "keys.json")
+	err := os.WriteFile(keysPath, []byte(`[{"key": "sk-a", "label": "a"}, {"key": "sk-b", "label": "b"}]`), 0644)
+	assert.Nil(t, err)
+	configPath := filepath.Join(dir, "config.yaml")
+	err = os.WriteFile(configPath, []byte("api_keys_file: "+keysPath+"\ndefault_cookies: _U=x\n"), 0644)
+	assert.Nil(t, err)
+	for _, env := range []string{"API_KEYS_FILE", "AUTH_TOKEN", "DEFAULT_COOKIES", "WEBHOOK_SECRET"} {
+		t.Setenv(env, "")
+	}
+	server, err := NewServer(configPath)
+	assert.Nil(t, err)
+
+	runner := newTestJobRunner(t, filepath.Join(dir, "jobs"))
+	secret := ""
+	runner.secret = func() string {
+		return secret
+	}
+	r := chi.NewRouter()
+	r.Use(server.APIKeys().Middleware)
+	registerJobRoutes(r, runner, server)
+	serve := func(method, path, token, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	image := `"type": "image", "image": {"text": "a cat", "url": "https://www.bing.com/images/create"}`
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/v1/jobs", "sk-a", `{"type": "video"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/v1/jobs", "sk-a", `{"type": "music", "music": {}}`).Code)
+	assert.Equal(t, http.StatusBadRequest,
+		serve(http.MethodPost, "/v1/jobs", "sk-a", `{`+image+`, "callback_url": "https://93.184.216.34/hook"}`).Code)
+	secret = "secret"
+	assert.Equal(t, http.StatusBadRequest,
+		serve(http.MethodPost, "/v1/jobs", "sk-a", `{`+image+`, "callback_url": "ftp://example.com"}`).Code)
+	assert.Equal(t, http.StatusBadRequest,
+		serve(http.MethodPost, "/v1/jobs", "sk-a", `{`+image+`, "callback_url": "http://127.0.0.1/hook"}`).Code)
+
+	recorder := serve(http.MethodPost, "/v1/jobs", "sk-a", `{`+image+`}`)
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+	var job Job
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &job))
+	waitForJob(t, runner, job.ID, func(record jobRecord) bool {
+		return record.Finished()
+	})
+
+	recorder = serve(http.MethodGet, "/v1/jobs/"+job.ID, "sk-a", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &job))
+	assert.Equal(t, JobStatusSucceeded, job.Status)
+	assert.NotContains(t, recorder.Body.String(), "owner")
+
+	// jobs of other API keys are hidden
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/v1/jobs/"+job.ID, "sk-b", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodDelete, "/v1/jobs/"+job.ID, "sk-b", "").Code)
+	var list JobList
+	assert.Nil(t, json.Unmarshal(serve(http.MethodGet, "/v1/jobs", "sk-b", "").Body.Bytes(), &list))
+	assert.Empty(t, list.Data)
+	assert.Nil(t, json.Unmarshal(serve(http.MethodGet, "/v1/jobs", "sk-a", "").Body.Bytes(), &list))
+	assert.Len(t, list.Data, 1)
+
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/v1/jobs/"+job.ID, "sk-a", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/v1/jobs/"+job.ID, "sk-a", "").Code)
+
+	// jobs from a prompt
+	assert.Equal(t, http.StatusBadRequest,
+		serve(http.MethodPost, "/v1/jobs", "sk-a", `{"type": "image", "prompt": "a cat", "n": 100}`).Code)
+	recorder = serve(http.MethodPost, "/v1/jobs", "sk-a", `{"type": "image", "prompt": "a cat", "n": 2}`)
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &job))
+	assert.Equal(t, "a cat", job.Prompt)
+	assert.Equal(t, 2, job.N)
+	record := waitForJob(t, runner, job.ID, func(record jobRecord) bool {
+		return record.Finished()
+	})
+	var results []sydney.GenerateImageResult
+	assert.Nil(t, json.Unmarshal(record.Result, &results))
+	assert.Len(t, results[0].ImageURLs, 2)
+	recorder = serve(http.MethodPost, "/v1/jobs", "sk-a", `{"type": "music", "prompt": "a song"}`)
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+	var musicJob Job
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &musicJob))
+	assert.Equal(t, "a song", musicJob.Prompt)
+	assert.Equal(t, 0, musicJob.N)
+	assert.Nil(t, musicJob.Music)
+}
+
+func TestValidateCallbackURL(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		url          string
+		allowedHosts string
+		valid        bool
+	}{
+		{"https://93.184.216.34/hook", "", true},
+		{"http://[2606:2800:220:1::]/hook", "", true},
+		{"ftp://93.184.216.34", "", false},
+		{"/hook", "", false},
+		{"http://127.0.0.1:8080/hook", "", false},
+		{"http://localhost/hook", "", false},
+		{"http://[::1]/hook", "", false},
+		{"http://10.1.2.3/hook", "", false},
+		{"http://192.168.1.1/hook", "", false},
+		{"http://169.254.169.254/latest/meta-data", "", false},
+		{"http://100.100.100.200/hook", "", false},
+		{"http://0.0.0.0/hook", "", false},
+		{"http://[::ffff:127.0.0.1]/hook", "", false},
+		{"http://localhost/hook", "hooks.internal, LocalHost", true},
+		{"http://[::1]/hook", "[::1]", true},
+	} {
+		err := validateCallbackURL(ctx, tc.url, tc.allowedHosts)
+		assert.Equal(t, tc.valid, err == nil, tc.url)
+	}
+}
+
+func TestCallbackClient(t *testing.T) {
+	callbacks := &callbackRecorder{}
+	callbackServer := httptest.NewServer(callbacks)
+	defer callbackServer.Close()
+
+	allowedHosts := ""
+	client := newCallbackClient(func() string {
+		return allowedHosts
+	})
+	// the address is checked when connecting, in case the host resolves differently later
+	_, err := client.Post(callbackServer.URL, "application/json", strings.NewReader("{}"))
+	assert.ErrorIs(t, err, ErrPrivateCallback)
+	assert.Equal(t, 0, callbacks.count())
+
+	allowedHosts = "127.0.0.1"
+	resp, err := client.Post(callbackServer.URL, "application/json", strings.NewReader("{}"))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 1, callbacks.count())
+}
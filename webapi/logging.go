@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// redactedKeys are attributes whose values never appear in logs
+var redactedKeys = []string{"cookie", "cookies", "authorization", "token", "key"}
+
+type requestInfoContextKey struct{}
+
+// RequestInfo is filled by handlers, so that middlewares can log, measure and audit requests by model
+type RequestInfo struct {
+	mu        sync.Mutex
+	Model     string
+	Prompt    string
+	APILabel  string
+	Account   string
+	Context   string
+	Responses []string
+	Errors    []string
+}
+
+func GetRequestInfo(ctx context.Context) *RequestInfo {
+	info, ok := ctx.Value(requestInfoContextKey{}).(*RequestInfo)
+	if !ok {
+		return &RequestInfo{}
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	return &RequestInfo{
+		Model:     info.Model,
+		Prompt:    info.Prompt,
+		APILabel:  info.APILabel,
+		Account:   info.Account,
+		Context:   info.Context,
+		Responses: append([]string(nil), info.Responses...),
+		Errors:    append([]string(nil), info.Errors...),
+	}
+}
+
+func updateRequestInfo(ctx context.Context, update func(info *RequestInfo)) {
+	info, ok := ctx.Value(requestInfoContextKey{}).(*RequestInfo)
+	if !ok {
+		return
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	update(info)
+}
+
+func SetRequestInfo(ctx context.Context, model, prompt string) {
+	updateRequestInfo(ctx, func(info *RequestInfo) {
+		info.Model = model
+		info.Prompt = prompt
+	})
+}
+
+func setRequestAPILabel(ctx context.Context, label string) {
+	updateRequestInfo(ctx, func(info *RequestInfo) {
+		info.APILabel = label
+	})
+}
+
+func setRequestAccount(ctx context.Context, account string) {
+	updateRequestInfo(ctx, func(info *RequestInfo) {
+		info.Account = account
+	})
+}
+
+// AddRequestError records an error which occurred while streaming, since the response status is 200 by then
+func AddRequestError(ctx context.Context, text string) {
+	updateRequestInfo(ctx, func(info *RequestInfo) {
+		info.Errors = append(info.Errors, text)
+	})
+}
+
+// RecordExchange records the usage of a reply like RecordUsage, and keeps the reply for the audit log.
+// It is called once per reply, so requests with several choices or asks keep all of them.
+func RecordExchange(ctx context.Context, webpageContext, prompt, reply string) {
+	RecordUsage(ctx, webpageContext, prompt, reply)
+	updateRequestInfo(ctx, func(info *RequestInfo) {
+		if info.Context == "" {
+			info.Context = webpageContext
+		}
+		info.Responses = append(info.Responses, reply)
+	})
+}
+
+func RequestInfoMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestInfoContextKey{}, &RequestInfo{})))
+	})
+}
+
+// NewJSONLogger creates a JSON logger which redacts cookies, tokens and auth headers
+func NewJSONLogger(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			key := strings.ToLower(a.Key)
+			for _, redacted := range redactedKeys {
+				if strings.Contains(key, redacted) {
+					return slog.String(a.Key, "[REDACTED]")
+				}
+			}
+			return a
+		},
+	}))
+}
+
+// RequestLogger logs every request as a structured record. The prompt is only logged if logPrompt returns true,
+// which is checked on every request so that it can be changed at runtime.
+func RequestLogger(logger *slog.Logger, logPrompt func() bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+			info := GetRequestInfo(r.Context())
+			attrs := []any{
+				"method", r.Method,
+				"path", r.URL.Path,
+				"route", RoutePattern(r),
+				"status", ww.Status(),
+				"bytes", ww.BytesWritten(),
+				"duration_ms", time.Since(start).Milliseconds(),
+				"remote_ip", r.RemoteAddr,
+				"request_id", middleware.GetReqID(r.Context()),
+				"user_agent", r.UserAgent(),
+			}
+			if info.APILabel != "" {
+				attrs = append(attrs, "api_label", info.APILabel)
+			}
+			if info.Model != "" {
+				attrs = append(attrs, "model", info.Model)
+			}
+			if info.Prompt != "" && logPrompt() {
+				attrs = append(attrs, "prompt", info.Prompt)
+			}
+			logger.Info("request", attrs...)
+		})
+	}
+}
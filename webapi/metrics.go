@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sydneyqt/sydney"
+	"sydneyqt/util"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/samber/lo"
+)
+
+const (
+	ErrorClassFiltered  = "filtered"
+	ErrorClassRevoked   = "revoked"
+	ErrorClassCaptcha   = "captcha"
+	ErrorClassThrottled = "throttled"
+	ErrorClassTransport = "transport"
+)
+
+var defaultBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120}
+
+// metricVec is a minimal implementation of Prometheus counters and histograms with labels,
+// which is enough for the text exposition format
+type metricVec struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64  // counter value or histogram sum
+	count       uint64   // histogram count
+	counts      []uint64 // histogram cumulative bucket counts
+}
+
+func newCounterVec(name, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: "counter", labels: labels, series: map[string]*metricSeries{}}
+}
+
+func newHistogramVec(name, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: "histogram", labels: labels, buckets: defaultBuckets,
+		series: map[string]*metricSeries{}}
+}
+
+func (o *metricVec) get(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	series, ok := o.series[key]
+	if !ok {
+		series = &metricSeries{labelValues: labelValues, counts: make([]uint64, len(o.buckets))}
+		o.series[key] = series
+	}
+	return series
+}
+
+func (o *metricVec) Inc(labelValues ...string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.get(labelValues).value++
+}
+
+func (o *metricVec) Observe(value float64, labelValues ...string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	series := o.get(labelValues)
+	series.value += value
+	series.count++
+	for i, bucket := range o.buckets {
+		if value <= bucket {
+			series.counts[i]++
+		}
+	}
+}
+
+// labelValueReplacer escapes label values in the Prometheus text format, which unlike Go keeps other characters as is
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (o *metricVec) formatLabels(labelValues []string, extra ...string) string {
+	var pairs []string
+	for i, label := range o.labels {
+		pairs = append(pairs, label+`="`+labelValueReplacer.Replace(labelValues[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+labelValueReplacer.Replace(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (o *metricVec) Write(w io.Writer) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", o.name, o.help, o.name, o.kind)
+	keys := make([]string, 0, len(o.series))
+	for key := range o.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := o.series[key]
+		if o.kind == "counter" {
+			fmt.Fprintf(w, "%s%s %v\n", o.name, o.formatLabels(series.labelValues), series.value)
+			continue
+		}
+		for i, bucket := range o.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", o.name,
+				o.formatLabels(series.labelValues, "le", strconv.FormatFloat(bucket, 'g', -1, 64)), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", o.name, o.formatLabels(series.labelValues, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %v\n", o.name, o.formatLabels(series.labelValues), series.value)
+		fmt.Fprintf(w, "%s_count%s %d\n", o.name, o.formatLabels(series.labelValues), series.count)
+	}
+}
+
+type Metrics struct {
+	requests        *metricVec
+	requestDuration *metricVec
+	firstToken      *metricVec
+	streamDuration  *metricVec
+	errors          *metricVec
+	jobs            *metricVec
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: newCounterVec("sydney_http_requests_total",
+			"Number of HTTP requests.", "route", "model", "status"),
+		requestDuration: newHistogramVec("sydney_http_request_duration_seconds",
+			"Duration of HTTP requests.", "route", "model"),
+		firstToken: newHistogramVec("sydney_time_to_first_token_seconds",
+			"Time from the start of an ask to the first message text.", "route", "model"),
+		streamDuration: newHistogramVec("sydney_stream_duration_seconds",
+			"Duration of ask streams.", "route", "model"),
+		errors: newCounterVec("sydney_errors_total",
+			"Number of errors from Sydney by class.", "route", "class"),
+		jobs: newCounterVec("sydney_jobs_total",
+			"Number of image and music creation jobs by outcome.", "kind", "outcome"),
+	}
+}
+
+func (o *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=UTF-8")
+	for _, vec := range []*metricVec{o.requests, o.requestDuration, o.firstToken, o.streamDuration, o.errors, o.jobs} {
+		vec.Write(w)
+	}
+}
+
+// Middleware records the count and the latency of requests by route and model.
+// It should be used after RequestInfoMiddleware, whose info carries the model.
+func (o *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		route := RoutePattern(r)
+		model := MetricModel(GetRequestInfo(r.Context()).Model)
+		o.requests.Inc(route, model, strconv.Itoa(ww.Status()))
+		o.requestDuration.Observe(time.Since(start).Seconds(), route, model)
+	})
+}
+
+// ObserveStream passes messages through while recording the time to first token, the stream duration and errors.
+// Errors are also added to the request info for the audit log.
+func (o *Metrics) ObserveStream(r *http.Request, model string, messageCh <-chan sydney.Message) <-chan sydney.Message {
+	out := make(chan sydney.Message)
+	route := RoutePattern(r)
+	model = MetricModel(model)
+	go func() {
+		defer close(out)
+		start := time.Now()
+		gotFirstToken := false
+		for message := range messageCh {
+			switch message.Type {
+			case sydney.MessageTypeMessageText:
+				if !gotFirstToken {
+					gotFirstToken = true
+					o.firstToken.Observe(time.Since(start).Seconds(), route, model)
+				}
+			case sydney.MessageTypeError:
+				o.errors.Inc(route, ClassifyError(message.Error))
+				AddRequestError(r.Context(), message.Text)
+			}
+			out <- message
+		}
+		o.streamDuration.Observe(time.Since(start).Seconds(), route, model)
+	}()
+	return out
+}
+
+// MetricModel maps the model of a request, which is any string from the client, to a conversation style,
+// so that the series of the model label are bounded
+func MetricModel(model string) string {
+	if model == "" || lo.Contains(ConversationStyles, model) {
+		return model
+	}
+	return ModelToConversationStyle(model)
+}
+
+func (o *Metrics) ObserveJob(kind string, err error) {
+	o.jobs.Inc(kind, util.Ternary(err == nil, "success", "failure"))
+}
+
+// ClassifyError maps errors from Sydney to the classes reported by sydney_errors_total
+func ClassifyError(err error) string {
+	if err == nil {
+		return ErrorClassTransport
+	}
+	switch {
+	case errors.Is(err, sydney.ErrMessageFiltered):
+		return ErrorClassFiltered
+	case errors.Is(err, sydney.ErrMessageRevoke):
+		return ErrorClassRevoked
+	}
+	text := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(text, "captcha"):
+		return ErrorClassCaptcha
+	case strings.Contains(text, "throttl"), strings.Contains(text, "429"), strings.Contains(text, "limit"):
+		return ErrorClassThrottled
+	default:
+		return ErrorClassTransport
+	}
+}
+
+// RoutePattern returns the matched chi route, or the path if no route matches
+func RoutePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+	return "unmatched"
+}
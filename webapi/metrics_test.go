@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sydneyqt/sydney"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	assert.Equal(t, ErrorClassFiltered, ClassifyError(sydney.ErrMessageFiltered))
+	assert.Equal(t, ErrorClassRevoked, ClassifyError(sydney.ErrMessageRevoke))
+	assert.Equal(t, ErrorClassCaptcha, ClassifyError(errors.New("infinite CAPTCHA detected")))
+	assert.Equal(t, ErrorClassThrottled, ClassifyError(errors.New("bing explicit error: value: Throttled")))
+	assert.Equal(t, ErrorClassTransport, ClassifyError(errors.New("websocket: close 1006")))
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.requests.Inc("/v1/chat/completions", "gpt-4", "200")
+	metrics.requestDuration.Observe(0.3, "/v1/chat/completions", "gpt-4")
+	metrics.ObserveJob("image", nil)
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := recorder.Body.String()
+	assert.Contains(t, body, `sydney_http_requests_total{route="/v1/chat/completions",model="gpt-4",status="200"} 1`)
+	assert.Contains(t, body, `sydney_http_request_duration_seconds_bucket{route="/v1/chat/completions",model="gpt-4",le="0.25"} 0`)
+	assert.Contains(t, body, `sydney_http_request_duration_seconds_bucket{route="/v1/chat/completions",model="gpt-4",le="0.5"} 1`)
+	assert.Contains(t, body, `sydney_http_request_duration_seconds_count{route="/v1/chat/completions",model="gpt-4"} 1`)
+	assert.Contains(t, body, `sydney_jobs_total{kind="image",outcome="success"} 1`)
+}
+
+func TestMetricLabels(t *testing.T) {
+	assert.Equal(t, "Creative", MetricModel("gpt-4-"+strings.Repeat("x", 100)))
+	assert.Equal(t, "Balanced", MetricModel("gpt-3.5-turbo-0125"))
+	assert.Equal(t, "Precise", MetricModel("Precise"))
+	assert.Equal(t, "", MetricModel(""))
+
+	metrics := NewMetrics()
+	metrics.errors.Inc("/v1/你好", "a\\b\"c\nd")
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, recorder.Body.String(), `sydney_errors_total{route="/v1/你好",class="a\\b\"c\nd"} 1`)
+}
+
+func TestJSONLoggerRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	NewJSONLogger(&buf).Info("test", "cookies", "_U=secret", "Authorization", "Bearer sk-secret", "model", "gpt-4")
+	assert.NotContains(t, buf.String(), "secret")
+	assert.Contains(t, buf.String(), `"model":"gpt-4"`)
+}
@@ -1,6 +1,9 @@
 package main
 
-import "sydneyqt/sydney"
+import (
+	"sydneyqt/sydney"
+	"time"
+)
 
 type CreateConversationRequest struct {
 	Cookies string `json:"cookies"`
@@ -11,6 +14,12 @@ type CreateImageRequest struct {
 	Cookies string                 `json:"cookies"`
 }
 
+type CreateMusicRequest struct {
+	Prompt      string `json:"prompt"`
+	Cookies     string `json:"cookies"`
+	ProxyAssets bool   `json:"proxy_assets"`
+}
+
 type ChatStreamRequest struct {
 	Prompt            string   `json:"prompt"`
 	WebpageContext    string   `json:"context"`
@@ -57,6 +66,7 @@ type OpenAIMessagesParseResult struct {
 	WebpageContext string
 	Prompt         string
 	ImageURL       string
+	FileID         string
 }
 
 // Most fields are omitted due to limitations of the Bing API
@@ -66,6 +76,14 @@ type OpenAIChatCompletionRequest struct {
 	Stream       bool                              `json:"stream"`
 	ToolChoice   *interface{}                      `json:"tool_choice"`
 	Conversation sydney.CreateConversationResponse `json:"conversation"`
+	Stop         StopSequences                     `json:"stop"`
+	MaxTokens    int                               `json:"max_tokens"`
+	N            int                               `json:"n"`
+	Temperature  *float64                          `json:"temperature"`
+	User         string                            `json:"user"`
+	// SydneyExtensions adds search queries, search results, suggested responses and generated images
+	// to choices as the `sydney` field
+	SydneyExtensions bool `json:"sydney_extensions"`
 }
 
 type ChoiceDelta struct {
@@ -74,9 +92,10 @@ type ChoiceDelta struct {
 }
 
 type ChatCompletionChunkChoice struct {
-	Index        int         `json:"index"`
-	Delta        ChoiceDelta `json:"delta"`
-	FinishReason *string     `json:"finish_reason"`
+	Index        int              `json:"index"`
+	Delta        ChoiceDelta      `json:"delta"`
+	FinishReason *string          `json:"finish_reason"`
+	Sydney       *SydneyExtension `json:"sydney,omitempty"`
 }
 
 type OpenAIChatCompletionChunk struct {
@@ -100,9 +119,10 @@ type UsageStats struct {
 }
 
 type ChatCompletionChoice struct {
-	Index        int           `json:"index"`
-	Message      ChoiceMessage `json:"message"`
-	FinishReason string        `json:"finish_reason"`
+	Index        int              `json:"index"`
+	Message      ChoiceMessage    `json:"message"`
+	FinishReason string           `json:"finish_reason"`
+	Sydney       *SydneyExtension `json:"sydney,omitempty"`
 }
 
 type OpenAIChatCompletion struct {
@@ -115,8 +135,39 @@ type OpenAIChatCompletion struct {
 	Usage             UsageStats             `json:"usage"`
 }
 
+type OpenAICompletionRequest struct {
+	Model       string            `json:"model"`
+	Prompt      CompletionPrompts `json:"prompt"`
+	Suffix      string            `json:"suffix"`
+	Echo        bool              `json:"echo"`
+	Stream      bool              `json:"stream"`
+	Stop        StopSequences     `json:"stop"`
+	MaxTokens   int               `json:"max_tokens"`
+	N           int               `json:"n"`
+	Temperature *float64          `json:"temperature"`
+	User        string            `json:"user"`
+}
+
+type CompletionChoice struct {
+	Text         string  `json:"text"`
+	Index        int     `json:"index"`
+	Logprobs     any     `json:"logprobs"`
+	FinishReason *string `json:"finish_reason"`
+}
+
+type OpenAICompletion struct {
+	ID                string             `json:"id"`
+	Object            string             `json:"object"`
+	Created           int64              `json:"created"`
+	Model             string             `json:"model"`
+	SystemFingerprint string             `json:"system_fingerprint"`
+	Choices           []CompletionChoice `json:"choices"`
+	Usage             *UsageStats        `json:"usage,omitempty"`
+}
+
 type OpenAIImageObject struct {
-	URL           string `json:"url"`
+	URL           string `json:"url,omitempty"`
+	B64JSON       string `json:"b64_json,omitempty"`
 	RevisedPrompt string `json:"revised_prompt"`
 }
 
@@ -126,5 +177,155 @@ type OpenAIImageGeneration struct {
 }
 
 type OpenAIImageGenerationRequest struct {
-	Prompt string `json:"prompt"`
+	Prompt         string `json:"prompt"`
+	N              int    `json:"n"`
+	Size           string `json:"size"`
+	ResponseFormat string `json:"response_format"`
+}
+
+type OpenAIAudioGenerationRequest struct {
+	Prompt         string `json:"prompt"`
+	Model          string `json:"model"`
+	ResponseFormat string `json:"response_format"`
+	ProxyAssets    bool   `json:"proxy_assets"`
+}
+
+// OpenAIAudioObject is shaped like OpenAIImageObject, with the details of the song besides
+type OpenAIAudioObject struct {
+	URL           string  `json:"url,omitempty"`
+	B64JSON       string  `json:"b64_json,omitempty"`
+	RevisedPrompt string  `json:"revised_prompt"`
+	Title         string  `json:"title"`
+	Lyrics        string  `json:"lyrics"`
+	MusicalStyle  string  `json:"musical_style"`
+	Duration      float64 `json:"duration"`
+	CoverImageURL string  `json:"cover_image_url"`
+	VideoURL      string  `json:"video_url"`
+}
+
+type OpenAIAudioGeneration struct {
+	Created int64               `json:"created"`
+	Data    []OpenAIAudioObject `json:"data"`
+}
+
+type OpenAIFile struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+
+	Owner string `json:"-"`
+}
+
+type OpenAIFileList struct {
+	Object string       `json:"object"`
+	Data   []OpenAIFile `json:"data"`
+}
+
+type OllamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"`
+}
+
+// Options such as `temperature` are accepted but ignored by Bing
+type OllamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []OllamaMessage `json:"messages"`
+	Stream   *bool           `json:"stream"`
+	Options  map[string]any  `json:"options"`
+}
+
+type OllamaGenerateRequest struct {
+	Model   string         `json:"model"`
+	Prompt  string         `json:"prompt"`
+	System  string         `json:"system"`
+	Images  []string       `json:"images"`
+	Stream  *bool          `json:"stream"`
+	Options map[string]any `json:"options"`
+}
+
+type OllamaChatResponse struct {
+	Model      string        `json:"model"`
+	CreatedAt  time.Time     `json:"created_at"`
+	Message    OllamaMessage `json:"message"`
+	Done       bool          `json:"done"`
+	DoneReason string        `json:"done_reason,omitempty"`
+}
+
+type OllamaGenerateResponse struct {
+	Model      string    `json:"model"`
+	CreatedAt  time.Time `json:"created_at"`
+	Response   string    `json:"response"`
+	Done       bool      `json:"done"`
+	DoneReason string    `json:"done_reason,omitempty"`
+}
+
+type OllamaModelDetails struct {
+	Format            string `json:"format"`
+	Family            string `json:"family"`
+	ParameterSize     string `json:"parameter_size"`
+	QuantizationLevel string `json:"quantization_level"`
+}
+
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt time.Time          `json:"modified_at"`
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+type OllamaTagsResponse struct {
+	Models []OllamaModel `json:"models"`
+}
+
+type ThreadMessageRequest struct {
+	Role     string `json:"role"`
+	Content  string `json:"content"`
+	ImageURL string `json:"image_url"`
+}
+
+type CreateThreadRequest struct {
+	Messages []ThreadMessageRequest `json:"messages"`
+	Metadata map[string]string      `json:"metadata"`
+}
+
+type RunThreadRequest struct {
+	Model  string `json:"model"`
+	Stream bool   `json:"stream"`
+}
+
+type ThreadList struct {
+	Object string   `json:"object"`
+	Data   []Thread `json:"data"`
+}
+
+type CreateJobRequest struct {
+	Type        string                  `json:"type"`
+	Prompt      string                  `json:"prompt"`
+	N           int                     `json:"n"`
+	Image       *sydney.GenerativeImage `json:"image"`
+	Music       *sydney.GenerativeMusic `json:"music"`
+	Cookies     string                  `json:"cookies"`
+	CallbackURL string                  `json:"callback_url"`
+}
+
+type JobList struct {
+	Object string `json:"object"`
+	Data   []Job  `json:"data"`
+}
+
+type OpenAIError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    string  `json:"code"`
+}
+
+type OpenAIErrorResponse struct {
+	Error OpenAIError `json:"error"`
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sydneyqt/sydney"
+	"time"
+)
+
+// MusicAssetPath is the route which proxies cover images, audios and videos of songs
+const MusicAssetPath = "/music/assets"
+
+// MusicAssetURLTTL is how long a signed asset URL can be loaded without an API key
+const MusicAssetURLTTL = 24 * time.Hour
+
+var ErrEmptyGenerativeMusic = errors.New("empty generative music")
+
+// musicAssetKey signs the proxied asset URLs, since <audio> and <img> cannot send an API key.
+// It is generated on startup, so signed URLs stop working after a restart.
+var musicAssetKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// CreateMusic asks Sydney with the Suno plugin for a song of the prompt and then waits for it to be created
+func CreateMusic(ctx context.Context, sydneyAPI *sydney.Sydney, prompt string) (sydney.GenerateMusicResult, error) {
+	var empty sydney.GenerateMusicResult
+
+	newContext, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	messageCh, err := sydneyAPI.AskStream(sydney.AskStreamOptions{
+		StopCtx:        newContext,
+		Prompt:         "Create a song for the description: " + prompt,
+		WebpageContext: MusicGeneratorContext,
+	})
+	if err != nil {
+		return empty, err
+	}
+
+	var generativeMusic sydney.GenerativeMusic
+	// the first error of Sydney tells why there is no song, such as throttling or a CAPTCHA
+	var messageErr error
+
+	for message := range messageCh {
+		if message.Type == sydney.MessageTypeError && messageErr == nil {
+			messageErr = errors.New(message.Text)
+		}
+		if message.Type == sydney.MessageTypeGenerativeMusic {
+			err := json.Unmarshal([]byte(message.Text), &generativeMusic)
+			if err == nil {
+				break
+			}
+		}
+	}
+	cancel()
+
+	if generativeMusic.IFrameID == "" || generativeMusic.RequestID == "" {
+		if messageErr != nil {
+			return empty, messageErr
+		}
+		return empty, ErrEmptyGenerativeMusic
+	}
+
+	return sydneyAPI.GenerateMusic(generativeMusic)
+}
+
+// IsMusicAssetURL reports whether u is an asset of a song created by Bing, which are the only URLs
+// served by the asset proxy
+func IsMusicAssetURL(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	return parsed.Scheme == "https" && parsed.Host == "th.bing.com" && parsed.Path == "/th"
+}
+
+func signMusicAsset(u string, expires int64) string {
+	mac := hmac.New(sha256.New, musicAssetKey)
+	mac.Write([]byte(strconv.FormatInt(expires, 10) + "." + u))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyMusicAssetQuery reports whether the query of an asset URL is signed by this server and has not expired
+func VerifyMusicAssetQuery(query url.Values) bool {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(query.Get("signature")), []byte(signMusicAsset(query.Get("url"), expires)))
+}
+
+// proxyMusicAssetURL rewrites the URL to the asset proxy, signed so that it can be loaded without an API key
+func proxyMusicAssetURL(u string) string {
+	if u == "" {
+		return ""
+	}
+	expires := time.Now().Add(MusicAssetURLTTL).Unix()
+	return MusicAssetPath + "?" + url.Values{
+		"url":       {u},
+		"expires":   {strconv.FormatInt(expires, 10)},
+		"signature": {signMusicAsset(u, expires)},
+	}.Encode()
+}
+
+// ProxyMusicAssets rewrites the asset URLs of the song to the asset proxy of this server
+func ProxyMusicAssets(result sydney.GenerateMusicResult) sydney.GenerateMusicResult {
+	result.CoverImgURL = proxyMusicAssetURL(result.CoverImgURL)
+	result.AudioURL = proxyMusicAssetURL(result.AudioURL)
+	result.VideoURL = proxyMusicAssetURL(result.VideoURL)
+	return result
+}
+
+// ServeMusicAsset streams the asset in the `url` query from Bing, keeping range requests working
+// so that players can seek
+func ServeMusicAsset(w http.ResponseWriter, r *http.Request, client *http.Client) {
+	assetURL := r.URL.Query().Get("url")
+	if !IsMusicAssetURL(assetURL) {
+		http.Error(w, "invalid music asset url: "+assetURL, http.StatusBadRequest)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, assetURL, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	// set headers
+	for _, key := range []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges"} {
+		if value := resp.Header.Get(key); value != "" {
+			w.Header().Set(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	// write response
+	io.Copy(w, resp.Body)
+}
+
+// ToOpenAIAudioGeneration converts a song to the shape of OpenAIImageGeneration
+func ToOpenAIAudioGeneration(result sydney.GenerateMusicResult) OpenAIAudioGeneration {
+	return OpenAIAudioGeneration{
+		Created: time.Now().Unix(),
+		Data: []OpenAIAudioObject{{
+			URL:           result.AudioURL,
+			RevisedPrompt: result.Text,
+			Title:         result.Title,
+			Lyrics:        result.Lyrics,
+			MusicalStyle:  result.MusicalStyle,
+			Duration:      result.MusicDuration.Seconds(),
+			CoverImageURL: result.CoverImgURL,
+			VideoURL:      result.VideoURL,
+		}},
+	}
+}
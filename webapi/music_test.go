@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sydneyqt/sydney"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestIsMusicAssetURL(t *testing.T) {
+	for _, c := range []struct {
+		url   string
+		valid bool
+	}{
+		{"https://th.bing.com/th?&id=OIG.abc", true},
+		{"http://th.bing.com/th?&id=OIG.abc", false},
+		{"https://th.bing.com.example.com/th?&id=OIG.abc", false},
+		{"https://th.bing.com/other?&id=OIG.abc", false},
+		{"https://user@evil.com/th", false},
+		{"", false},
+	} {
+		assert.Equal(t, c.valid, IsMusicAssetURL(c.url), c.url)
+	}
+}
+
+func TestMusicConversion(t *testing.T) {
+	result := sydney.GenerateMusicResult{
+		GenerativeMusic: sydney.GenerativeMusic{Text: "a song about cats"},
+		CoverImgURL:     "https://th.bing.com/th?&id=cover",
+		AudioURL:        "https://th.bing.com/th?&id=audio",
+		MusicDuration:   90 * time.Second,
+		Title:           "Cats",
+	}
+
+	proxied := ProxyMusicAssets(result)
+	for original, proxiedURL := range map[string]string{
+		result.AudioURL:    proxied.AudioURL,
+		result.CoverImgURL: proxied.CoverImgURL,
+	} {
+		u, err := url.Parse(proxiedURL)
+		assert.Nil(t, err)
+		assert.Equal(t, MusicAssetPath, u.Path)
+		assert.Equal(t, original, u.Query().Get("url"))
+		assert.True(t, VerifyMusicAssetQuery(u.Query()))
+	}
+	assert.Empty(t, proxied.VideoURL)
+
+	// the signature covers the URL and the expiry
+	query, _ := url.ParseQuery(strings.TrimPrefix(proxied.AudioURL, MusicAssetPath+"?"))
+	query.Set("url", result.CoverImgURL)
+	assert.False(t, VerifyMusicAssetQuery(query))
+	query, _ = url.ParseQuery(strings.TrimPrefix(proxied.AudioURL, MusicAssetPath+"?"))
+	query.Set("expires", strconv.FormatInt(time.Now().Add(365*24*time.Hour).Unix(), 10))
+	assert.False(t, VerifyMusicAssetQuery(query))
+	expires := time.Now().Add(-time.Minute).Unix()
+	assert.False(t, VerifyMusicAssetQuery(url.Values{
+		"url":       {result.AudioURL},
+		"expires":   {strconv.FormatInt(expires, 10)},
+		"signature": {signMusicAsset(result.AudioURL, expires)},
+	}))
+
+	generation := ToOpenAIAudioGeneration(result)
+	assert.Len(t, generation.Data, 1)
+	object := generation.Data[0]
+	assert.Equal(t, result.AudioURL, object.URL)
+	assert.Equal(t, "a song about cats", object.RevisedPrompt)
+	assert.Equal(t, "Cats", object.Title)
+	assert.Equal(t, 90.0, object.Duration)
+}
+
+func TestServeMusicAsset(t *testing.T) {
+	var requested *http.Request
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		requested = r
+		header := http.Header{}
+		header.Set("Content-Type", "audio/mpeg")
+		header.Set("Content-Range", "bytes 0-4/100")
+		header.Set("Set-Cookie", "secret=1")
+		return &http.Response{
+			StatusCode: http.StatusPartialContent,
+			Header:     header,
+			Body:       io.NopCloser(strings.NewReader("audio")),
+		}, nil
+	})}
+	serve := func(assetURL string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, MusicAssetPath+"?url="+url.QueryEscape(assetURL), nil)
+		r.Header.Set("Range", "bytes=0-4")
+		w := httptest.NewRecorder()
+		ServeMusicAsset(w, r, client)
+		return w
+	}
+
+	w := serve("https://example.com/th?&id=audio")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Nil(t, requested)
+
+	w = serve("https://th.bing.com/th?&id=audio")
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "audio", w.Body.String())
+	assert.Equal(t, "audio/mpeg", w.Header().Get("Content-Type"))
+	assert.Equal(t, "bytes 0-4/100", w.Header().Get("Content-Range"))
+	assert.Empty(t, w.Header().Get("Set-Cookie"))
+	assert.Equal(t, "bytes=0-4", requested.Header.Get("Range"))
+	assert.Equal(t, "th.bing.com", requested.URL.Host)
+}
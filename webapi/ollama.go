@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sydneyqt/sydney"
+	"sydneyqt/util"
+	"time"
+)
+
+// OllamaModelNames are the models reported by /api/tags, which are mapped to
+// conversation styles in the same way as the OpenAI routes
+var OllamaModelNames = []string{"gpt-4", "gpt-3.5-turbo"}
+
+// OllamaChatToOpenAIMessages converts Ollama messages to OpenAI ones, so that they can share
+// the same context construction, and returns the images attached to the last user message
+func OllamaChatToOpenAIMessages(messages []OllamaMessage) ([]OpenAIMessage, []string) {
+	var result []OpenAIMessage
+	var images []string
+
+	for _, message := range messages {
+		result = append(result, OpenAIMessage{
+			Role:    message.Role,
+			Content: message.Content,
+		})
+		if message.Role == MessageRoleUser {
+			images = message.Images
+		}
+	}
+
+	return result, images
+}
+
+func OllamaGenerateToOpenAIMessages(request OllamaGenerateRequest) []OpenAIMessage {
+	var result []OpenAIMessage
+
+	if request.System != "" {
+		result = append(result, OpenAIMessage{
+			Role:    MessageRoleSystem,
+			Content: request.System,
+		})
+	}
+
+	return append(result, OpenAIMessage{
+		Role:    MessageRoleUser,
+		Content: request.Prompt,
+	})
+}
+
+func NewOllamaTags() OllamaTagsResponse {
+	var models []OllamaModel
+
+	for _, name := range OllamaModelNames {
+		models = append(models, OllamaModel{
+			Name:       name + ":latest",
+			Model:      name + ":latest",
+			ModifiedAt: time.Now(),
+			Digest:     strings.Repeat("0", 64),
+			Details: OllamaModelDetails{
+				Format: "gguf",
+				Family: "bing",
+			},
+		})
+	}
+
+	return OllamaTagsResponse{Models: models}
+}
+
+// UploadOllamaImage uploads the first base64 encoded image and returns its Bing URL,
+// since Bing only accepts one image per message
+func UploadOllamaImage(sydneyAPI *sydney.Sydney, images []string) (string, error) {
+	if len(images) == 0 {
+		return "", nil
+	}
+
+	return UploadBase64Image(sydneyAPI, images[0])
+}
+
+func WriteOllamaError(w http.ResponseWriter, message string, code int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// ServeOllama asks Sydney and writes the reply as NDJSON when streaming, or as a single object otherwise.
+// newResponse builds the route specific response object from a delta.
+func ServeOllama(
+	w http.ResponseWriter,
+	r *http.Request,
+	metrics *Metrics,
+	options sydney.Options,
+	model string,
+	messages []OpenAIMessage,
+	images []string,
+	stream bool,
+	newResponse func(delta string, done bool, doneReason string) any,
+) {
+	parsedMessages, err := ParseOpenAIMessages(messages)
+	if err != nil {
+		WriteOllamaError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	SetRequestInfo(r.Context(), model, parsedMessages.Prompt)
+
+	options.ConversationStyle = ModelToConversationStyle(model)
+	if err := CheckRequestModel(r.Context(), model, options.ConversationStyle); err != nil {
+		WriteOllamaError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	options.Locale = "en-US"
+	options.NoSearch = true
+	options.GPT4Turbo = true
+	sydneyAPI := sydney.NewSydney(options)
+
+	imageURL, err := UploadOllamaImage(sydneyAPI, images)
+	if err != nil {
+		WriteOllamaError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	messageCh, err := sydneyAPI.AskStream(sydney.AskStreamOptions{
+		StopCtx:        ctx,
+		Prompt:         parsedMessages.Prompt,
+		WebpageContext: parsedMessages.WebpageContext,
+		ImageURL:       imageURL,
+	})
+	if err != nil {
+		WriteOllamaError(w, "error creating conversation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	messageCh = metrics.ObserveStream(r, model, messageCh)
+
+	// handle non-stream
+	if !stream {
+		var replyBuilder strings.Builder
+		errored := false
+
+		for message := range messageCh {
+			switch message.Type {
+			case sydney.MessageTypeMessageText:
+				replyBuilder.WriteString(message.Text)
+			case sydney.MessageTypeError:
+				errored = true
+				replyBuilder.WriteString("`Error: " + message.Text + "`")
+			}
+		}
+
+		RecordExchange(r.Context(), parsedMessages.WebpageContext, parsedMessages.Prompt, replyBuilder.String())
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		json.NewEncoder(w).Encode(newResponse(replyBuilder.String(), true,
+			util.Ternary(errored, FinishReasonLength, FinishReasonStop)))
+
+		return
+	}
+
+	// set headers
+	w.Header().Set("Content-Type", "application/x-ndjson; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// write response
+	encoder := json.NewEncoder(w)
+	var replyBuilder strings.Builder
+	errored := false
+
+	for message := range messageCh {
+		var delta string
+
+		switch message.Type {
+		case sydney.MessageTypeMessageText:
+			delta = message.Text
+		case sydney.MessageTypeError:
+			errored = true
+			delta = fmt.Sprintf("`Error: %s`", message.Text)
+		default:
+			continue
+		}
+
+		replyBuilder.WriteString(delta)
+
+		encoder.Encode(newResponse(delta, false, ""))
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	RecordExchange(r.Context(), parsedMessages.WebpageContext, parsedMessages.Prompt, replyBuilder.String())
+
+	// write final object
+	encoder.Encode(newResponse("", true, util.Ternary(errored, FinishReasonLength, FinishReasonStop)))
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"strings"
 	"sydneyqt/sydney"
+	"sydneyqt/util"
 	"time"
 )
 
@@ -16,6 +18,13 @@ var (
 	MessageRoleSystem    = "system"
 )
 
+var ConversationStyles = []string{"Creative", "Balanced", "Precise"}
+
+// ModelToConversationStyle maps an OpenAI model name to a Sydney conversation style
+func ModelToConversationStyle(model string) string {
+	return util.Ternary(strings.HasPrefix(model, "gpt-3.5-turbo"), "Balanced", "Creative")
+}
+
 func ParseOpenAIMessages(messages []OpenAIMessage) (OpenAIMessagesParseResult, error) {
 	if len(messages) == 0 {
 		return OpenAIMessagesParseResult{}, ErrMissingPrompt
@@ -33,7 +42,7 @@ func ParseOpenAIMessages(messages []OpenAIMessage) (OpenAIMessagesParseResult, e
 		}
 	}
 
-	prompt, imageUrl := ParseOpenAIMessageContent(promptMessage.Content)
+	prompt, imageUrl, fileID := ParseOpenAIMessageContent(promptMessage.Content)
 
 	if prompt == "" {
 		return OpenAIMessagesParseResult{}, ErrMissingPrompt
@@ -43,6 +52,7 @@ func ParseOpenAIMessages(messages []OpenAIMessage) (OpenAIMessagesParseResult, e
 		return OpenAIMessagesParseResult{
 			Prompt:   prompt,
 			ImageURL: imageUrl,
+			FileID:   fileID,
 		}, nil
 	}
 
@@ -55,7 +65,7 @@ func ParseOpenAIMessages(messages []OpenAIMessage) (OpenAIMessagesParseResult, e
 
 	for i, message := range messages {
 		// assert types
-		text, _ := ParseOpenAIMessageContent(message.Content)
+		text, _, _ := ParseOpenAIMessageContent(message.Content)
 
 		// append role to context
 		switch message.Role {
@@ -64,7 +74,7 @@ func ParseOpenAIMessages(messages []OpenAIMessage) (OpenAIMessagesParseResult, e
 		case MessageRoleAssistant:
 			contextBuilder.WriteString("[assistant](#message)\n")
 		case MessageRoleSystem:
-			contextBuilder.WriteString("[system](#instructions)\n")
+			contextBuilder.WriteString("[system](#additional_instructions)\n")
 		default:
 			continue // skip unknown roles
 		}
@@ -80,10 +90,25 @@ func ParseOpenAIMessages(messages []OpenAIMessage) (OpenAIMessagesParseResult, e
 		Prompt:         prompt,
 		WebpageContext: contextBuilder.String(),
 		ImageURL:       imageUrl,
+		FileID:         fileID,
 	}, nil
 }
 
-func ParseOpenAIMessageContent(content interface{}) (text, imageUrl string) {
+// ParseOpenAIMessageContent extracts the text, the image url and the id of the file
+// uploaded through /v1/files from the content of a message
+func ParseOpenAIMessageContent(content interface{}) (text, imageUrl, fileID string) {
+	switch content.(type) {
+	case string, []interface{}, nil:
+	default:
+		// content is constructed in Go (e.g. []map[string]interface{}), normalize it to the decoded form
+		if v, err := json.Marshal(content); err == nil {
+			var normalized interface{}
+			if json.Unmarshal(v, &normalized) == nil {
+				content = normalized
+			}
+		}
+	}
+
 	switch content := content.(type) {
 	case string:
 		// content is string, and it automatically becomes prompt
@@ -104,6 +129,10 @@ func ParseOpenAIMessageContent(content interface{}) (text, imageUrl string) {
 				if url, ok := content["image_url"].(map[string]interface{}); ok {
 					imageUrl, _ = url["url"].(string)
 				}
+			case "file":
+				if file, ok := content["file"].(map[string]interface{}); ok {
+					fileID, _ = file["file_id"].(string)
+				}
 			}
 		}
 	}
@@ -156,19 +185,53 @@ func NewOpenAIChatCompletionChunk(model, delta string, finishReason *string) *Op
 	}
 }
 
-func ToOpenAIImageGeneration(result sydney.GenerateImageResult) OpenAIImageGeneration {
-	var objects []OpenAIImageObject
+func NewOpenAICompletion(model string, choices []CompletionChoice) *OpenAICompletion {
+	return &OpenAICompletion{
+		ID:                "cmpl-123",
+		Object:            "text_completion",
+		Created:           time.Now().Unix(),
+		Model:             model,
+		SystemFingerprint: "fp_44709d6fcb",
+		Choices:           choices,
+	}
+}
+
+// CompletionContext asks Sydney to continue the prompt as a text completion model would do,
+// or to fill in the text between the prompt and the suffix
+func CompletionContext(suffix string) string {
+	if suffix == "" {
+		return "[system](#additional_instructions)\nYou are a text completion model. " +
+			"Continue the text in the user message from exactly where it ends. " +
+			"Reply with the continuation only, without repeating the text, quoting it or adding any comment.\n\n"
+	}
+	return "[system](#additional_instructions)\nYou are a text completion model. " +
+		"Write the text which fits between the text in the user message and the following suffix. " +
+		"Reply with the inserted text only, without repeating the text or the suffix, quoting it or adding any comment.\n\n" +
+		"[system](#suffix)\n" + suffix + "\n\n"
+}
+
+// ToOpenAIImageGeneration flattens batches of images and keeps the first n of them
+func ToOpenAIImageGeneration(results []sydney.GenerateImageResult, n int, size string) (OpenAIImageGeneration, error) {
+	objects := []OpenAIImageObject{}
 
-	for _, url := range result.ImageURLs {
-		urlWithoutQuery := strings.Split(url, "?")[0]
-		objects = append(objects, OpenAIImageObject{
-			URL:           urlWithoutQuery,
-			RevisedPrompt: result.Text,
-		})
+	for _, result := range results {
+		for _, url := range result.ImageURLs {
+			if len(objects) == n {
+				break
+			}
+			resizedURL, err := ResizeImageURL(url, size)
+			if err != nil {
+				return OpenAIImageGeneration{}, err
+			}
+			objects = append(objects, OpenAIImageObject{
+				URL:           resizedURL,
+				RevisedPrompt: result.Text,
+			})
+		}
 	}
 
 	return OpenAIImageGeneration{
 		Created: time.Now().Unix(),
 		Data:    objects,
-	}
+	}, nil
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"sydneyqt/sydney"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -93,4 +94,59 @@ func TestParseOpenAIMessages(t *testing.T) {
 			ImageURL:       "https://example.com/image.jpg",
 		}, result)
 	})
+	t.Run("file", func(t *testing.T) {
+		messages := []OpenAIMessage{
+			{
+				Role: "user",
+				Content: []map[string]interface{}{
+					{
+						"type": "text",
+						"text": "Summarize the file.",
+					},
+					{
+						"type": "file",
+						"file": map[string]string{
+							"file_id": "file-123",
+						},
+					},
+				},
+			},
+		}
+		result, err := ParseOpenAIMessages(messages)
+		assert.Nil(t, err)
+		assert.Equal(t, OpenAIMessagesParseResult{
+			Prompt: "Summarize the file.",
+			FileID: "file-123",
+		}, result)
+	})
+}
+
+func TestToOpenAIImageGeneration(t *testing.T) {
+	results := []sydney.GenerateImageResult{
+		{
+			GenerativeImage: sydney.GenerativeImage{Text: "a pigeon"},
+			ImageURLs:       []string{"https://th.bing.com/th/id/1?w=270", "https://th.bing.com/th/id/2?w=270"},
+		},
+		{
+			GenerativeImage: sydney.GenerativeImage{Text: "a pigeon"},
+			ImageURLs:       []string{"https://th.bing.com/th/id/3?w=270"},
+		},
+	}
+
+	generation, err := ToOpenAIImageGeneration(results, 3, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []OpenAIImageObject{
+		{URL: "https://th.bing.com/th/id/1", RevisedPrompt: "a pigeon"},
+		{URL: "https://th.bing.com/th/id/2", RevisedPrompt: "a pigeon"},
+		{URL: "https://th.bing.com/th/id/3", RevisedPrompt: "a pigeon"},
+	}, generation.Data)
+
+	generation, err = ToOpenAIImageGeneration(results, 1, "512x512")
+	assert.Nil(t, err)
+	assert.Equal(t, []OpenAIImageObject{
+		{URL: "https://th.bing.com/th/id/1?w=512&h=512&c=7", RevisedPrompt: "a pigeon"},
+	}, generation.Data)
+
+	_, err = ToOpenAIImageGeneration(results, 1, "large")
+	assert.NotNil(t, err)
 }
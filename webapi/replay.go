@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var ErrNoRequestBody = errors.New("the entry has no request body, since audit_log_full_text was disabled or the body was too large")
+
+// runReplay is the `replay` command, which sends a request in the audit log to a server again
+// and prints the response, for debugging
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	serverURL := flags.String("server", "http://localhost:8080", "the server to send the request to")
+	token := flags.String("token", os.Getenv("AUTH_TOKEN"), "the API key to send the request with")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: webapi replay [flags] <audit log file> <request id>")
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errors.New("audit log file and request id are required")
+	}
+
+	entry, err := FindAuditEntry(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	status, err := ReplayAuditEntry(entry, *serverURL, *token, os.Stdout)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "\nreplayed %s %s: %s (was %d)\n", entry.Method, entry.Path, status, entry.Status)
+	return nil
+}
+
+// ReplayAuditEntry sends the request of the entry to the server, bypassing the response cache,
+// and copies the response body to w
+func ReplayAuditEntry(entry AuditEntry, serverURL, token string, w io.Writer) (string, error) {
+	if len(entry.Request) == 0 {
+		return "", ErrNoRequestBody
+	}
+	req, err := http.NewRequest(entry.Method, strings.TrimSuffix(serverURL, "/")+entry.Path, bytes.NewReader(entry.Request))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(CacheHeader, CacheStatusBypass)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return resp.Status, err
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sydneyqt/util"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Server holds the configuration which can be reloaded at runtime. Handlers should read the proxy,
+// the default cookies and API keys from it on every request instead of capturing them.
+type Server struct {
+	configPath     string
+	config         atomic.Pointer[Config]
+	defaultCookies atomic.Pointer[map[string]string]
+	certificate    atomic.Pointer[tls.Certificate]
+	apiKeys        *APIKeyStore
+	draining       atomic.Bool
+}
+
+func NewServer(configPath string) (*Server, error) {
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{configPath: configPath}
+	server.apiKeys, err = NewAPIKeyStore(config.APIKeysFile, config.AuthToken, config.APIKeysUsageFile)
+	if err != nil {
+		return nil, err
+	}
+	err = server.apply(config)
+	if err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+func (o *Server) Config() Config {
+	return *o.config.Load()
+}
+
+func (o *Server) Proxy() string {
+	return o.config.Load().Proxy
+}
+
+func (o *Server) DefaultCookies() map[string]string {
+	return *o.defaultCookies.Load()
+}
+
+func (o *Server) APIKeys() *APIKeyStore {
+	return o.apiKeys
+}
+
+// Accounts returns the default cookies and the cookies fixed to API keys
+func (o *Server) Accounts() []Account {
+	accounts := []Account{{Name: "default", Cookies: o.DefaultCookies()}}
+	for _, key := range o.apiKeys.Keys() {
+		if key.Cookies != "" {
+			accounts = append(accounts, Account{Name: key.Label, Cookies: ParseCookies(key.Cookies)})
+		}
+	}
+	return accounts
+}
+
+// Draining reports whether the server is shutting down
+func (o *Server) Draining() bool {
+	return o.draining.Load()
+}
+
+// apply loads everything the config refers to before switching to it, so that a broken file
+// does not leave the server half reloaded
+func (o *Server) apply(config Config) error {
+	var keys []APIKey
+	var certificate tls.Certificate
+	var err error
+	// keys are loaded by NewAPIKeyStore at the first time
+	reloading := o.config.Load() != nil
+	if reloading {
+		keys, err = LoadAPIKeys(config.APIKeysFile, config.AuthToken)
+		if err != nil {
+			return err
+		}
+	}
+	if config.TLSCertFile != "" {
+		certificate, err = tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	defaultCookies := ParseCookies(config.DefaultCookies)
+	if len(defaultCookies) == 0 {
+		slog.Info("default_cookies not set, reading from cookies.json")
+		defaultCookies, err = util.ReadCookiesFile()
+		if err != nil {
+			// the desktop app encrypts cookies.json with a key the web API does not have
+			return fmt.Errorf("cannot read cookies.json: %w; "+
+				"a file encrypted by the desktop app cannot be used, set default_cookies instead", err)
+		}
+		if len(defaultCookies) == 0 {
+			slog.Warn("cookies.json not found, using empty cookies")
+		}
+	} else {
+		slog.Info("default_cookies set, cookies.json will be ignored")
+	}
+
+	if reloading {
+		o.apiKeys.SetKeys(keys)
+	}
+	o.certificate.Store(&certificate)
+	o.defaultCookies.Store(&defaultCookies)
+	o.config.Store(&config)
+	return nil
+}
+
+// Reload reads the config file and environment variables again. The listener, directories, timeouts,
+// the cache and the audit log file require a restart, so changes of them are ignored.
+func (o *Server) Reload() error {
+	config, err := LoadConfig(o.configPath)
+	if err != nil {
+		return err
+	}
+	old := o.Config()
+	if config.Port != old.Port || config.UnixSocket != old.UnixSocket ||
+		(config.TLSCertFile == "") != (old.TLSCertFile == "") ||
+		config.ThreadsDir != old.ThreadsDir || config.FilesDir != old.FilesDir || config.JobsDir != old.JobsDir ||
+		config.APIKeysUsageFile != old.APIKeysUsageFile ||
+		config.ReadHeaderTimeout != old.ReadHeaderTimeout || config.ReadTimeout != old.ReadTimeout ||
+		config.WriteTimeout != old.WriteTimeout || config.IdleTimeout != old.IdleTimeout ||
+		config.CacheSize != old.CacheSize || config.CacheTTL != old.CacheTTL || config.CacheDir != old.CacheDir ||
+		config.AuditLogFile != old.AuditLogFile || config.AuditLogMaxSize != old.AuditLogMaxSize {
+		slog.Warn("Listener, directory, timeout, cache and audit log changes require a restart and are ignored")
+	}
+	config.Port = old.Port
+	config.UnixSocket = old.UnixSocket
+	if old.TLSCertFile == "" {
+		config.TLSCertFile, config.TLSKeyFile = "", ""
+	} else if config.TLSCertFile == "" {
+		config.TLSCertFile, config.TLSKeyFile = old.TLSCertFile, old.TLSKeyFile
+	}
+	config.ThreadsDir = old.ThreadsDir
+	config.FilesDir = old.FilesDir
+	config.JobsDir = old.JobsDir
+	config.APIKeysUsageFile = old.APIKeysUsageFile
+	config.ReadHeaderTimeout = old.ReadHeaderTimeout
+	config.ReadTimeout = old.ReadTimeout
+	config.WriteTimeout = old.WriteTimeout
+	config.IdleTimeout = old.IdleTimeout
+	config.CacheSize = old.CacheSize
+	config.CacheTTL = old.CacheTTL
+	config.CacheDir = old.CacheDir
+	config.AuditLogFile = old.AuditLogFile
+	config.AuditLogMaxSize = old.AuditLogMaxSize
+	return o.apply(config)
+}
+
+func (o *Server) listen() (net.Listener, error) {
+	config := o.Config()
+	if config.UnixSocket != "" {
+		// remove the socket left by a previous run
+		if err := os.Remove(config.UnixSocket); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return net.Listen("unix", config.UnixSocket)
+	}
+	return net.Listen("tcp", ":"+config.Port)
+}
+
+// ListenAndServe serves the handler until SIGINT or SIGTERM, and then waits for in-flight requests
+// including streams to finish for at most the shutdown timeout. SIGHUP reloads the config.
+func (o *Server) ListenAndServe(handler http.Handler) error {
+	config := o.Config()
+	httpServer := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(config.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(config.ReadTimeout),
+		WriteTimeout:      time.Duration(config.WriteTimeout),
+		IdleTimeout:       time.Duration(config.IdleTimeout),
+	}
+	listener, err := o.listen()
+	if err != nil {
+		return err
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Listening", "address", config.Address(), "tls", config.TLSCertFile != "")
+		if config.TLSCertFile == "" {
+			serveErr <- httpServer.Serve(listener)
+			return
+		}
+		// certificates are read on every handshake, so that renewed ones are picked up on reload
+		httpServer.TLSConfig = &tls.Config{
+			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return o.certificate.Load(), nil
+			},
+		}
+		serveErr <- httpServer.ServeTLS(listener, "", "")
+	}()
+
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signalCh)
+
+	for {
+		select {
+		case err := <-serveErr:
+			return err
+		case sig := <-signalCh:
+			if sig == syscall.SIGHUP {
+				if err := o.Reload(); err != nil {
+					slog.Error("Cannot reload config", "err", err)
+				} else {
+					slog.Info("Config reloaded")
+				}
+				continue
+			}
+			o.draining.Store(true)
+			timeout := time.Duration(o.Config().ShutdownTimeout)
+			slog.Info("Shutting down, waiting for in-flight requests", "signal", sig.String(), "timeout", timeout.String())
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			err := httpServer.Shutdown(ctx)
+			cancel()
+			if err != nil {
+				slog.Warn("Shutdown timed out, closing remaining connections", "err", err)
+				return httpServer.Close()
+			}
+			return nil
+		}
+	}
+}
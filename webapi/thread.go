@@ -0,0 +1,561 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sydneyqt/sydney"
+	"sydneyqt/util"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+var ErrThreadNotFound = errors.New("thread not found")
+
+// maxThreadConversationTurns is the number of questions after which a new Bing conversation is created for a thread
+const maxThreadConversationTurns = 20
+
+type ThreadMessage struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	CreatedAt int64  `json:"created_at"`
+	Role      string `json:"role"`
+	Content   string `json:"content"`
+	ImageURL  string `json:"image_url,omitempty"`
+}
+
+type Thread struct {
+	ID        string            `json:"id"`
+	Object    string            `json:"object"`
+	CreatedAt int64             `json:"created_at"`
+	Metadata  map[string]string `json:"metadata"`
+	Messages  []ThreadMessage   `json:"messages"`
+
+	// never sent to clients
+	Owner        string              `json:"owner,omitempty"`
+	Conversation *ThreadConversation `json:"conversation,omitempty"`
+}
+
+// ThreadConversation is the Bing conversation of the last run, which the next run continues
+// if only a user message has been added to the thread since then
+type ThreadConversation struct {
+	Conversation sydney.CreateConversationResponse `json:"conversation"`
+	Style        string                            `json:"style"`
+	Account      string                            `json:"account"` // the digest of the account cookie
+	Turn         int                               `json:"turn"`
+	Messages     int                               `json:"messages"` // how many messages of the thread it has seen
+	Digest       string                            `json:"digest"`   // the digest of these messages
+}
+
+func digestThreadMessages(messages []ThreadMessage) string {
+	v, _ := json.Marshal(messages)
+	sum := sha256.Sum256(v)
+	return hex.EncodeToString(sum[:])
+}
+
+func digestAccount(cookies map[string]string) string {
+	sum := sha256.Sum256([]byte(cookies["_U"]))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Public returns the thread without the owner and the conversation, which holds the tokens of the account
+func (o Thread) Public() Thread {
+	o.Owner = ""
+	o.Conversation = nil
+	return o
+}
+
+// ContinuableConversation returns the conversation of the last run if it can answer the last message of the thread
+func (o Thread) ContinuableConversation(style, account string) (ThreadConversation, bool) {
+	c := o.Conversation
+	if c == nil || c.Style != style || c.Account != account || c.Turn >= maxThreadConversationTurns ||
+		len(o.Messages) != c.Messages+1 || o.Messages[c.Messages].Role != MessageRoleUser {
+		return ThreadConversation{}, false
+	}
+	if digestThreadMessages(o.Messages[:c.Messages]) != c.Digest {
+		return ThreadConversation{}, false
+	}
+	return *c, true
+}
+
+func NewThreadMessage(role, content, imageURL string) ThreadMessage {
+	return ThreadMessage{
+		ID:        "msg_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Object:    "thread.message",
+		CreatedAt: time.Now().Unix(),
+		Role:      role,
+		Content:   content,
+		ImageURL:  imageURL,
+	}
+}
+
+// ToOpenAIMessages converts the thread so that it can be parsed by ParseOpenAIMessages
+func (o Thread) ToOpenAIMessages() []OpenAIMessage {
+	var result []OpenAIMessage
+
+	for _, message := range o.Messages {
+		var content interface{} = message.Content
+		if message.ImageURL != "" {
+			content = []interface{}{
+				map[string]interface{}{"type": "text", "text": message.Content},
+				map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": message.ImageURL}},
+			}
+		}
+		result = append(result, OpenAIMessage{
+			Role:    message.Role,
+			Content: content,
+		})
+	}
+
+	return result
+}
+
+// ToContext renders the thread in the `[role](#type)` format used by the desktop app
+func (o Thread) ToContext() string {
+	var builder strings.Builder
+
+	for _, message := range o.Messages {
+		builder.WriteString(fmt.Sprintf("[%s](#%s)\n%s\n\n", message.Role,
+			util.Ternary(message.Role == MessageRoleSystem, "additional_instructions", "message"),
+			message.Content))
+	}
+
+	return builder.String()
+}
+
+// ThreadStore keeps every thread in its own JSON file, so that saving a thread never rewrites the others
+type ThreadStore struct {
+	mu  sync.RWMutex
+	dir string
+}
+
+func NewThreadStore(dir string) (*ThreadStore, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+	return &ThreadStore{dir: dir}, nil
+}
+
+func (o *ThreadStore) path(id string) (string, error) {
+	if id == "" || filepath.Base(id) != id || strings.HasPrefix(id, ".") {
+		return "", ErrThreadNotFound
+	}
+	return filepath.Join(o.dir, id+".json"), nil
+}
+
+func (o *ThreadStore) read(id string) (Thread, error) {
+	var thread Thread
+	p, err := o.path(id)
+	if err != nil {
+		return thread, err
+	}
+	v, err := os.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return thread, ErrThreadNotFound
+		}
+		return thread, err
+	}
+	err = json.Unmarshal(v, &thread)
+	return thread, err
+}
+
+func (o *ThreadStore) write(thread Thread) error {
+	p, err := o.path(thread.ID)
+	if err != nil {
+		return err
+	}
+	v, err := json.MarshalIndent(&thread, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first to avoid corrupting the thread on crash
+	err = os.WriteFile(p+".tmp", v, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+func (o *ThreadStore) Create(owner string, metadata map[string]string, messages []ThreadMessage) (Thread, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	thread := Thread{
+		ID:        "thread_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Object:    "thread",
+		CreatedAt: time.Now().Unix(),
+		Metadata:  metadata,
+		Messages:  messages,
+		Owner:     owner,
+	}
+	return thread, o.write(thread)
+}
+
+func (o *ThreadStore) Get(id string) (Thread, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.read(id)
+}
+
+// List returns the threads of the owner without their messages, the newest first
+func (o *ThreadStore) List(owner string) ([]Thread, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+	threads := []Thread{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		thread, err := o.read(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil || thread.Owner != owner {
+			continue
+		}
+		thread.Messages = nil
+		threads = append(threads, thread.Public())
+	}
+	sort.Slice(threads, func(i, j int) bool {
+		return threads[i].CreatedAt > threads[j].CreatedAt
+	})
+	return threads, nil
+}
+
+func (o *ThreadStore) Delete(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, err := o.path(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrThreadNotFound
+	}
+	return err
+}
+
+func (o *ThreadStore) AppendMessages(id string, messages ...ThreadMessage) (Thread, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	thread, err := o.read(id)
+	if err != nil {
+		return thread, err
+	}
+	thread.Messages = append(thread.Messages, messages...)
+	return thread, o.write(thread)
+}
+
+// SaveRun appends the reply of a run which has seen the first asked messages of the thread, and keeps the
+// conversation for the next run. The conversation is dropped if it is nil or messages were added during the run.
+func (o *ThreadStore) SaveRun(id string, asked int, reply *ThreadMessage, conversation *ThreadConversation) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	thread, err := o.read(id)
+	if err != nil {
+		return err
+	}
+	if len(thread.Messages) != asked {
+		conversation = nil
+	}
+	if reply != nil {
+		thread.Messages = append(thread.Messages, *reply)
+	}
+	if conversation != nil {
+		conversation.Messages = len(thread.Messages)
+		conversation.Digest = digestThreadMessages(thread.Messages)
+	}
+	thread.Conversation = conversation
+	return o.write(thread)
+}
+
+func registerThreadRoutes(r chi.Router, store *ThreadStore, metrics *Metrics, server *Server) {
+	writeStoreError := func(w http.ResponseWriter, err error) {
+		if errors.Is(err, ErrThreadNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	writeJSON := func(w http.ResponseWriter, v any) {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		json.NewEncoder(w).Encode(v)
+	}
+	getOwned := func(r *http.Request) (Thread, error) {
+		thread, err := store.Get(chi.URLParam(r, "id"))
+		if err == nil && thread.Owner != RequestOwner(r) {
+			return Thread{}, ErrThreadNotFound
+		}
+		return thread, err
+	}
+
+	r.Post("/v1/threads", func(w http.ResponseWriter, r *http.Request) {
+		var request CreateThreadRequest
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		messages := util.Map(request.Messages, func(value ThreadMessageRequest) ThreadMessage {
+			return NewThreadMessage(value.Role, value.Content, value.ImageURL)
+		})
+
+		thread, err := store.Create(RequestOwner(r), request.Metadata, messages)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		writeJSON(w, thread.Public())
+	})
+
+	r.Get("/v1/threads", func(w http.ResponseWriter, r *http.Request) {
+		threads, err := store.List(RequestOwner(r))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		writeJSON(w, ThreadList{Object: "list", Data: threads})
+	})
+
+	r.Get("/v1/threads/{id}", func(w http.ResponseWriter, r *http.Request) {
+		thread, err := getOwned(r)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		writeJSON(w, thread.Public())
+	})
+
+	r.Delete("/v1/threads/{id}", func(w http.ResponseWriter, r *http.Request) {
+		thread, err := getOwned(r)
+		if err == nil {
+			err = store.Delete(thread.ID)
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r.Get("/v1/threads/{id}/export", func(w http.ResponseWriter, r *http.Request) {
+		thread, err := getOwned(r)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		switch r.URL.Query().Get("format") {
+		case "", "json":
+			w.Header().Set("Content-Disposition", "attachment; filename=\""+thread.ID+".json\"")
+			writeJSON(w, thread.Public())
+		case "context":
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.Header().Set("Content-Disposition", "attachment; filename=\""+thread.ID+".txt\"")
+			fmt.Fprint(w, thread.ToContext())
+		default:
+			http.Error(w, "unsupported export format", http.StatusBadRequest)
+		}
+	})
+
+	r.Get("/v1/threads/{id}/messages", func(w http.ResponseWriter, r *http.Request) {
+		thread, err := getOwned(r)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		writeJSON(w, map[string]any{"object": "list", "data": thread.Messages})
+	})
+
+	r.Post("/v1/threads/{id}/messages", func(w http.ResponseWriter, r *http.Request) {
+		var request ThreadMessageRequest
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if request.Role == "" {
+			request.Role = MessageRoleUser
+		}
+
+		message := NewThreadMessage(request.Role, request.Content, request.ImageURL)
+
+		thread, err := getOwned(r)
+		if err == nil {
+			_, err = store.AppendMessages(thread.ID, message)
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		writeJSON(w, message)
+	})
+
+	r.Post("/v1/threads/{id}/runs", func(w http.ResponseWriter, r *http.Request) {
+		var request RunThreadRequest
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		thread, err := getOwned(r)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		cookiesStr := r.Header.Get("Cookie")
+		cookies := RequestCookies(r, cookiesStr, server.DefaultCookies())
+
+		conversationStyle := ModelToConversationStyle(request.Model)
+		if err := CheckRequestModel(r.Context(), request.Model, conversationStyle); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		account := digestAccount(cookies)
+
+		// continue the conversation of the last run if only the new message is unseen,
+		// otherwise the context is rebuilt from the stored messages in a new conversation
+		conversation, continued := thread.ContinuableConversation(conversationStyle, account)
+		messagesToParse := thread.ToOpenAIMessages()
+		if continued {
+			messagesToParse = messagesToParse[len(messagesToParse)-1:]
+		}
+		parsedMessages, err := ParseOpenAIMessages(messagesToParse)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		SetRequestInfo(r.Context(), request.Model, parsedMessages.Prompt)
+
+		sydneyAPI := sydney.NewSydney(sydney.Options{
+			Cookies:           cookies,
+			Proxy:             server.Proxy(),
+			ConversationStyle: conversationStyle,
+			Locale:            "en-US",
+			NoSearch:          true,
+			GPT4Turbo:         true,
+		})
+
+		if !continued {
+			conversation = ThreadConversation{Style: conversationStyle, Account: account}
+			conversation.Conversation, err = sydneyAPI.CreateConversation()
+			if err != nil {
+				http.Error(w, "error creating conversation: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		messageCh, err := sydneyAPI.AskStream(sydney.AskStreamOptions{
+			StopCtx:        r.Context(),
+			Prompt:         parsedMessages.Prompt,
+			WebpageContext: parsedMessages.WebpageContext,
+			ImageURL:       parsedMessages.ImageURL,
+			Conversation:   &conversation.Conversation,
+			Turn:           conversation.Turn,
+		})
+		if err != nil {
+			http.Error(w, "error creating conversation: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		messageCh = metrics.ObserveStream(r, request.Model, messageCh)
+
+		if request.Stream {
+			// set headers
+			w.Header().Set("Content-Type", "text/event-stream; charset=UTF-8")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Connection", "keep-alive")
+		}
+
+		var replyBuilder strings.Builder
+		errored := false
+
+		for message := range messageCh {
+			var delta string
+
+			switch message.Type {
+			case sydney.MessageTypeMessageText:
+				delta = message.Text
+			case sydney.MessageTypeError:
+				errored = true
+				delta = fmt.Sprintf("`Error: %s`", message.Text)
+			default:
+				continue
+			}
+			replyBuilder.WriteString(delta)
+
+			if !request.Stream {
+				continue
+			}
+
+			encoded, err := json.Marshal(NewOpenAIChatCompletionChunk(conversationStyle, delta, nil))
+			if err != nil {
+				continue
+			}
+
+			fmt.Fprintf(w, "data: %s\n\n", encoded)
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+
+		RecordExchange(r.Context(), parsedMessages.WebpageContext, parsedMessages.Prompt, replyBuilder.String())
+
+		// save the reply, even if the client has gone away, and drop the conversation on error
+		reply := NewThreadMessage(MessageRoleAssistant, replyBuilder.String(), "")
+		var savedReply *ThreadMessage
+		var savedConversation *ThreadConversation
+		if !errored {
+			conversation.Turn++
+			savedConversation = &conversation
+			if reply.Content != "" {
+				savedReply = &reply
+			}
+		}
+		err = store.SaveRun(thread.ID, len(thread.Messages), savedReply, savedConversation)
+		if err != nil {
+			slog.Error("Cannot save thread reply", "thread", thread.ID, "err", err)
+		}
+
+		if !request.Stream {
+			if errored {
+				http.Error(w, reply.Content, http.StatusBadGateway)
+				return
+			}
+			writeJSON(w, reply)
+			return
+		}
+
+		// write final chunk
+		chunk := NewOpenAIChatCompletionChunk(conversationStyle, "", util.Ternary(errored, &FinishReasonLength, &FinishReasonStop))
+		encoded, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n", encoded)
+	})
+}
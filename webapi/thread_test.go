@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThreadStore(t *testing.T) {
+	store, err := NewThreadStore(t.TempDir())
+	assert.Nil(t, err)
+
+	thread, err := store.Create("alice", map[string]string{"name": "test"}, []ThreadMessage{
+		NewThreadMessage(MessageRoleSystem, "You are Sydney.", ""),
+	})
+	assert.Nil(t, err)
+
+	_, err = store.AppendMessages(thread.ID,
+		NewThreadMessage(MessageRoleUser, "Hello!", ""),
+		NewThreadMessage(MessageRoleAssistant, "Hi!", ""),
+		NewThreadMessage(MessageRoleUser, "How are you?", "https://example.com/image.jpg"))
+	assert.Nil(t, err)
+
+	thread, err = store.Get(thread.ID)
+	assert.Nil(t, err)
+	assert.Len(t, thread.Messages, 4)
+	assert.Equal(t, "[system](#additional_instructions)\nYou are Sydney.\n\n[user](#message)\nHello!\n\n"+
+		"[assistant](#message)\nHi!\n\n[user](#message)\nHow are you?\n\n", thread.ToContext())
+
+	result, err := ParseOpenAIMessages(thread.ToOpenAIMessages())
+	assert.Nil(t, err)
+	assert.Equal(t, OpenAIMessagesParseResult{
+		Prompt:         "How are you?",
+		WebpageContext: "\n\n[system](#additional_instructions)\nYou are Sydney.\n\n[user](#message)\nHello!\n\n[assistant](#message)\nHi!",
+		ImageURL:       "https://example.com/image.jpg",
+	}, result)
+
+	threads, err := store.List("alice")
+	assert.Nil(t, err)
+	assert.Len(t, threads, 1)
+	assert.Empty(t, threads[0].Messages)
+	assert.Empty(t, threads[0].Owner)
+	threads, err = store.List("bob")
+	assert.Nil(t, err)
+	assert.Empty(t, threads)
+
+	assert.Nil(t, store.Delete(thread.ID))
+	_, err = store.Get(thread.ID)
+	assert.Equal(t, ErrThreadNotFound, err)
+	assert.Equal(t, ErrThreadNotFound, store.Delete("../config"))
+}
+
+func TestThreadConversation(t *testing.T) {
+	store, err := NewThreadStore(t.TempDir())
+	assert.Nil(t, err)
+
+	thread, err := store.Create("", nil, []ThreadMessage{
+		NewThreadMessage(MessageRoleSystem, "You are Sydney.", ""),
+		NewThreadMessage(MessageRoleUser, "Hello!", ""),
+	})
+	assert.Nil(t, err)
+	_, ok := thread.ContinuableConversation("Creative", "account")
+	assert.False(t, ok)
+
+	// the first run sees both messages
+	reply := NewThreadMessage(MessageRoleAssistant, "Hi!", "")
+	conversation := ThreadConversation{Style: "Creative", Account: "account", Turn: 1}
+	conversation.Conversation.ConversationId = "conversation"
+	assert.Nil(t, store.SaveRun(thread.ID, 2, &reply, &conversation))
+
+	thread, err = store.AppendMessages(thread.ID, NewThreadMessage(MessageRoleUser, "How are you?", ""))
+	assert.Nil(t, err)
+	assert.Nil(t, thread.Public().Conversation)
+	continued, ok := thread.ContinuableConversation("Creative", "account")
+	assert.True(t, ok)
+	assert.Equal(t, "conversation", continued.Conversation.ConversationId)
+	assert.Equal(t, 1, continued.Turn)
+	_, ok = thread.ContinuableConversation("Balanced", "account")
+	assert.False(t, ok)
+	_, ok = thread.ContinuableConversation("Creative", "another")
+	assert.False(t, ok)
+
+	// an edited message needs a new conversation
+	edited := thread
+	edited.Messages = append([]ThreadMessage{}, thread.Messages...)
+	edited.Messages[1].Content = "Hey!"
+	_, ok = edited.ContinuableConversation("Creative", "account")
+	assert.False(t, ok)
+
+	// so does a message added while running
+	thread, err = store.AppendMessages(thread.ID, NewThreadMessage(MessageRoleUser, "Are you there?", ""))
+	assert.Nil(t, err)
+	_, ok = thread.ContinuableConversation("Creative", "account")
+	assert.False(t, ok)
+	assert.Nil(t, store.SaveRun(thread.ID, 4, &reply, &continued))
+	thread, err = store.Get(thread.ID)
+	assert.Nil(t, err)
+	assert.Len(t, thread.Messages, 6)
+	assert.Nil(t, thread.Conversation)
+}
+
+func TestThreadRoutesOwner(t *testing.T) {
+	store, err := NewThreadStore(t.TempDir())
+	assert.Nil(t, err)
+	router := chi.NewRouter()
+	registerThreadRoutes(router, store, nil, nil)
+	serve := func(label, method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, &requestAPIKey{APIKey: APIKey{Label: label}}))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("alice", http.MethodPost, "/v1/threads", `{"messages":[{"role":"user","content":"Hello!"}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var thread Thread
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &thread))
+	assert.Empty(t, thread.Owner)
+
+	assert.Equal(t, http.StatusOK, serve("alice", http.MethodGet, "/v1/threads/"+thread.ID, "").Code)
+	assert.Contains(t, serve("alice", http.MethodGet, "/v1/threads", "").Body.String(), thread.ID)
+	assert.NotContains(t, serve("bob", http.MethodGet, "/v1/threads", "").Body.String(), thread.ID)
+	for _, path := range []string{"", "/messages", "/export"} {
+		assert.Equal(t, http.StatusNotFound, serve("bob", http.MethodGet, "/v1/threads/"+thread.ID+path, "").Code)
+	}
+	assert.Equal(t, http.StatusNotFound, serve("bob", http.MethodPost, "/v1/threads/"+thread.ID+"/messages",
+		`{"content":"Hi!"}`).Code)
+	assert.Equal(t, http.StatusNotFound, serve("bob", http.MethodPost, "/v1/threads/"+thread.ID+"/runs", `{}`).Code)
+	assert.Equal(t, http.StatusNotFound, serve("bob", http.MethodDelete, "/v1/threads/"+thread.ID, "").Code)
+	assert.Equal(t, http.StatusNoContent, serve("alice", http.MethodDelete, "/v1/threads/"+thread.ID, "").Code)
+}
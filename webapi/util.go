@@ -1,7 +1,18 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"strings"
+	"sydneyqt/sydney"
+	"sydneyqt/util"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
 )
 
 func ParseCookies(cookiesStr string) map[string]string {
@@ -14,3 +25,83 @@ func ParseCookies(cookiesStr string) map[string]string {
 	}
 	return cookies
 }
+
+// UploadBase64Image uploads a base64 encoded image of any supported format and returns its Bing URL
+func UploadBase64Image(sydneyAPI *sydney.Sydney, b64 string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return "", fmt.Errorf("cannot decode image: %w", err)
+	}
+
+	jpgData, err := util.ConvertImageToJpg(data)
+	if err != nil {
+		return "", fmt.Errorf("cannot convert image: %w", err)
+	}
+
+	return sydneyAPI.UploadImage(jpgData)
+}
+
+// ResolveImageURL uploads the image if it is a base64 data URI, since Bing only accepts its own URLs
+// or public ones, and returns other URLs unchanged
+func ResolveImageURL(sydneyAPI *sydney.Sydney, imageURL string) (string, error) {
+	if !strings.HasPrefix(imageURL, "data:") {
+		return imageURL, nil
+	}
+
+	header, b64, ok := strings.Cut(imageURL, ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return "", errors.New("image data URI is not base64 encoded")
+	}
+
+	return UploadBase64Image(sydneyAPI, b64)
+}
+
+var tk *tiktoken.Tiktoken
+var initTkFunc = sync.OnceFunc(func() {
+	slog.Info("Init tiktoken")
+	t, err := tiktoken.EncodingForModel("gpt-4")
+	if err != nil {
+		slog.Error("Cannot init tiktoken, falling back to estimation", "err", err)
+		return
+	}
+	tk = t
+})
+
+func CountTokens(text string) int {
+	initTkFunc()
+	if tk == nil {
+		// roughly 4 characters per token for English text
+		return (len(text) + 3) / 4
+	}
+	return len(tk.Encode(text, nil, nil))
+}
+
+// TruncateTokens keeps the first maxTokens tokens of the text, and reports whether the text has been cut
+func TruncateTokens(text string, maxTokens int) (string, bool) {
+	initTkFunc()
+	if tk == nil {
+		if CountTokens(text) <= maxTokens {
+			return text, false
+		}
+		return strings.ToValidUTF8(text[:maxTokens*4], ""), true
+	}
+	tokens := tk.Encode(text, nil, nil)
+	if len(tokens) <= maxTokens {
+		return text, false
+	}
+	// a token may end in the middle of a multibyte character
+	return strings.ToValidUTF8(tk.Decode(tokens[:maxTokens]), ""), true
+}
+
+// WriteOpenAIError writes an error in the format of the OpenAI API
+func WriteOpenAIError(w http.ResponseWriter, message, errType, code string, status int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(OpenAIErrorResponse{
+		Error: OpenAIError{
+			Message: message,
+			Type:    errType,
+			Code:    code,
+		},
+	})
+}
@@ -3,65 +3,109 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sydneyqt/sydney"
 	"sydneyqt/util"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
 func main() {
-	// read envs
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	logger := NewJSONLogger(os.Stdout)
+	slog.SetDefault(logger)
+
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := runReplay(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	proxy := os.Getenv("HTTPS_PROXY")
-	if proxy == "" {
-		proxy = os.Getenv("HTTP_PROXY")
+	// read config
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or JSON config file")
+	flag.Parse()
+
+	server, err := NewServer(*configPath)
+	if err != nil {
+		log.Fatal(err)
 	}
+	config := server.Config()
 
-	allowedOrigins := os.Getenv("ALLOWED_ORIGINS")
-	if allowedOrigins == "" {
-		allowedOrigins = "*"
+	threadStore, err := NewThreadStore(config.ThreadsDir)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	noLog := os.Getenv("NO_LOG") != ""
+	fileStore, err := NewFileStore(config.FilesDir)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	defaultCookies := ParseCookies(os.Getenv("DEFAULT_COOKIES"))
-	if len(defaultCookies) == 0 {
-		slog.Info("DEFAULT_COOKIES not set, reading from cookies.json")
-		defaultCookies, _ = util.ReadCookiesFile()
-		if len(defaultCookies) == 0 {
-			slog.Warn("cookies.json not found, using empty cookies")
-		}
-	} else {
-		slog.Info("DEFAULT_COOKIES set, cookies.json will be ignored")
+	jobStore, err := NewJobStore(config.JobsDir)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	authToken := os.Getenv("AUTH_TOKEN")
+	var cache *ResponseCache
+	if config.CacheSize > 0 {
+		cache, err = NewResponseCache(config.CacheSize, time.Duration(config.CacheTTL), config.CacheDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := cache.Purge(); err != nil {
+			slog.Warn("Cannot purge expired cache entries", "err", err)
+		}
+	}
 
 	// create router
 	r := chi.NewRouter()
 
 	// set middleware
+	metrics := NewMetrics()
+	requestLogger := RequestLogger(logger, func() bool {
+		return server.Config().LogPrompts
+	})
+
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RealIP)
-	if !noLog {
-		r.Use(middleware.Logger)
+	r.Use(middleware.RequestID)
+	r.Use(RequestInfoMiddleware)
+	r.Use(func(h http.Handler) http.Handler {
+		logged := requestLogger(h)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if server.Config().NoLog {
+				h.ServeHTTP(w, r)
+				return
+			}
+			logged.ServeHTTP(w, r)
+		})
+	})
+	r.Use(metrics.Middleware)
+	if config.AuditLogFile != "" {
+		auditLog, err := NewAuditLog(config.AuditLogFile, int64(config.AuditLogMaxSize)<<20)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer auditLog.Close()
+		r.Use(auditLog.Middleware(func() bool {
+			return server.Config().AuditLogFullText
+		}))
 	}
 	// handle CORS and preflight requests
 	r.Use(func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", allowedOrigins)
+			w.Header().Set("Access-Control-Allow-Origin", server.Config().AllowedOrigins)
 			w.Header().Set("Access-Control-Allow-Methods", "*")
 			w.Header().Set("Access-Control-Allow-Headers", "*")
 			w.Header().Set("Access-Control-Max-Age", "86400")
@@ -74,19 +118,7 @@ func main() {
 		})
 	})
 	// auth middleware
-	r.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if authToken == "" {
-				next.ServeHTTP(w, r)
-				return
-			}
-			if r.Header.Get("Authorization") != "Bearer "+authToken {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-			next.ServeHTTP(w, r)
-		})
-	})
+	r.Use(server.APIKeys().Middleware)
 
 	// add handlers
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
@@ -97,12 +129,18 @@ func main() {
 		fmt.Fprint(w, "OK")
 	})
 
+	healthChecker := NewHealthChecker(server)
+	r.Get("/healthz", healthChecker.ServeHealthz)
+	r.Get("/readyz", healthChecker.ServeReadyz)
+
+	r.Get("/metrics", metrics.ServeHTTP)
+
 	r.Post("/image/upload", func(w http.ResponseWriter, r *http.Request) {
 		// parse request
 		r.ParseMultipartForm(16 << 20)
 
 		cookiesStr := r.FormValue("cookies")
-		cookies := util.Ternary(cookiesStr == "", defaultCookies, ParseCookies(cookiesStr))
+		cookies := RequestCookies(r, cookiesStr, server.DefaultCookies())
 
 		file, _, err := r.FormFile("file")
 		if err != nil {
@@ -121,7 +159,7 @@ func main() {
 		imgUrl, err := sydney.
 			NewSydney(sydney.Options{
 				Cookies: cookies,
-				Proxy:   proxy,
+				Proxy:   server.Proxy(),
 			}).
 			UploadImage(bytes)
 
@@ -146,16 +184,17 @@ func main() {
 			return
 		}
 
-		cookies := util.Ternary(request.Cookies == "", defaultCookies, ParseCookies(request.Cookies))
+		cookies := RequestCookies(r, request.Cookies, server.DefaultCookies())
 
 		// create image
 		image, err := sydney.
 			NewSydney(sydney.Options{
 				Cookies:           cookies,
-				Proxy:             proxy,
+				Proxy:             server.Proxy(),
 				ConversationStyle: "Creative",
 			}).
 			GenerateImage(request.Image)
+		metrics.ObserveJob("image", err)
 
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -169,6 +208,53 @@ func main() {
 		json.NewEncoder(w).Encode(image)
 	})
 
+	r.Post("/music/create", func(w http.ResponseWriter, r *http.Request) {
+		var request CreateMusicRequest
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		cookies := RequestCookies(r, request.Cookies, server.DefaultCookies())
+
+		SetRequestInfo(r.Context(), "suno", request.Prompt)
+
+		// create music
+		music, err := CreateMusic(r.Context(), sydney.NewSydney(sydney.Options{
+			Cookies:           cookies,
+			Proxy:             server.Proxy(),
+			ConversationStyle: "Creative",
+			Locale:            "en-US",
+			Plugins:           []string{"Suno"},
+		}), request.Prompt)
+		metrics.ObserveJob("music", err)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if request.ProxyAssets {
+			music = ProxyMusicAssets(music)
+		}
+
+		// set headers
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+		// write response
+		json.NewEncoder(w).Encode(music)
+	})
+
+	r.Get(MusicAssetPath, func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := util.MakeHTTPClient(server.Proxy(), 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ServeMusicAsset(w, r, client)
+	})
+
 	r.Post("/chat/stream", func(w http.ResponseWriter, r *http.Request) {
 		// parse request
 		request := ChatStreamRequest{
@@ -181,11 +267,16 @@ func main() {
 			return
 		}
 
-		cookies := util.Ternary(request.Cookies == "", defaultCookies, ParseCookies(request.Cookies))
+		if err := CheckRequestModel(r.Context(), request.ConversationStyle, request.ConversationStyle); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		cookies := RequestCookies(r, request.Cookies, server.DefaultCookies())
 
 		sydneyAPI := sydney.NewSydney(sydney.Options{
 			Cookies:           cookies,
-			Proxy:             proxy,
+			Proxy:             server.Proxy(),
 			ConversationStyle: request.ConversationStyle,
 			NoSearch:          request.NoSearch,
 			GPT4Turbo:         request.UseGPT4Turbo,
@@ -193,6 +284,8 @@ func main() {
 			Plugins:           request.Plugins,
 		})
 
+		SetRequestInfo(r.Context(), request.ConversationStyle, request.Prompt)
+
 		// stream chat
 		messageCh, err := sydneyAPI.AskStream(sydney.AskStreamOptions{
 			StopCtx:        r.Context(),
@@ -204,6 +297,7 @@ func main() {
 			http.Error(w, "error creating conversation: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		messageCh = metrics.ObserveStream(r, request.ConversationStyle, messageCh)
 
 		// set headers
 		w.Header().Set("Content-Type", "text/event-stream; charset=UTF-8")
@@ -211,25 +305,46 @@ func main() {
 		w.Header().Set("Connection", "keep-alive")
 
 		// write response
+		var replyBuilder strings.Builder
 		for message := range messageCh {
+			if message.Type == sydney.MessageTypeMessageText {
+				replyBuilder.WriteString(message.Text)
+			}
 			encoded, _ := json.Marshal(message.Text)
 			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", message.Type, encoded)
 			if f, ok := w.(http.Flusher); ok {
 				f.Flush()
 			}
 		}
+		RecordExchange(r.Context(), request.WebpageContext, request.Prompt, replyBuilder.String())
+	})
+
+	r.Get("/chat/ws", func(w http.ResponseWriter, r *http.Request) {
+		ServeChatWebSocket(w, r, server, metrics)
 	})
 
 	r.Post("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
 		// parse request
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		var request OpenAIChatCompletionRequest
 
-		err := json.NewDecoder(r.Body).Decode(&request)
+		err = json.Unmarshal(body, &request)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
+		n := util.Ternary(request.N == 0, 1, request.N)
+		if n < 1 || n > MaxChoiceCount {
+			WriteOpenAIError(w, ErrTooManyChoices.Error(), "invalid_request_error", "invalid_n", http.StatusBadRequest)
+			return
+		}
+
 		parsedMessages, err := ParseOpenAIMessages(request.Messages)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -237,86 +352,305 @@ func main() {
 		}
 
 		cookiesStr := r.Header.Get("Cookie")
-		cookies := util.Ternary(cookiesStr == "", defaultCookies, ParseCookies(cookiesStr))
+		cookies := RequestCookies(r, cookiesStr, server.DefaultCookies())
 
-		conversationStyle := util.Ternary(
-			strings.HasPrefix(request.Model, "gpt-3.5-turbo"), "Balanced", "Creative")
+		// Bing has no sampling parameters, so the temperature is mapped to the closest conversation style
+		// when no model is given
+		conversationStyle := CompletionConversationStyle(request.Model, request.Temperature)
+		if err := CheckRequestModel(r.Context(), request.Model, conversationStyle); err != nil {
+			WriteOpenAIError(w, err.Error(), "invalid_request_error", "model_not_found", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("X-Conversation-Style", conversationStyle)
+		if ignoredParams := IgnoredCompletionParams(body, ChatCompletionParams); len(ignoredParams) != 0 {
+			w.Header().Set("X-Ignored-Params", strings.Join(ignoredParams, ", "))
+		}
 
-		sydneyAPI := sydney.NewSydney(sydney.Options{
-			Cookies:           cookies,
-			Proxy:             proxy,
-			ConversationStyle: conversationStyle,
-			Locale:            "en-US",
-			NoSearch:          request.ToolChoice == nil,
-			GPT4Turbo:         true,
-		})
+		SetRequestInfo(r.Context(), request.Model, parsedMessages.Prompt)
 
-		messageCh, err := sydneyAPI.AskStream(sydney.AskStreamOptions{
-			StopCtx:        r.Context(),
-			Prompt:         parsedMessages.Prompt,
-			WebpageContext: parsedMessages.WebpageContext,
-			ImageURL:       parsedMessages.ImageURL,
-		})
+		newSydney := func() *sydney.Sydney {
+			return sydney.NewSydney(sydney.Options{
+				Cookies:           cookies,
+				Proxy:             server.Proxy(),
+				ConversationStyle: conversationStyle,
+				Locale:            "en-US",
+				NoSearch:          request.ToolChoice == nil,
+				GPT4Turbo:         true,
+			})
+		}
+
+		imageURL, err := ResolveImageURL(newSydney(), parsedMessages.ImageURL)
 		if err != nil {
-			http.Error(w, "error creating conversation: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
+		var uploadFilePath string
+		if parsedMessages.FileID != "" {
+			uploadFilePath, err = fileStore.Path(RequestOwner(r), parsedMessages.FileID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		ask := func(ctx context.Context, index int) (<-chan sydney.Message, error) {
+			messageCh, err := newSydney().AskStream(sydney.AskStreamOptions{
+				StopCtx:        ctx,
+				Prompt:         parsedMessages.Prompt,
+				WebpageContext: parsedMessages.WebpageContext,
+				ImageURL:       imageURL,
+				UploadFilePath: uploadFilePath,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return metrics.ObserveStream(r, request.Model, messageCh), nil
+		}
+		cacheStatuses := make([]string, n)
+		ask = CachedAsk(cache, r, func(index int) CacheKey {
+			return NewCacheKey(RequestOwner(r), cookies, conversationStyle, request.ToolChoice == nil, parsedMessages.Prompt,
+				parsedMessages.WebpageContext, parsedMessages.ImageURL, parsedMessages.FileID, index)
+		}, ask, cacheStatuses)
+		newLimiter := func() *CompletionLimiter {
+			return NewCompletionLimiter(request.Stop, request.MaxTokens)
+		}
+
+		extensionsEnabled := SydneyExtensionsEnabled(r, request.SydneyExtensions)
+		choiceOptions := func(onDelta func(index int, delta string), onFinish func(index int, finishReason string),
+			onExtension func(index int, extension SydneyExtension)) ChoiceOptions {
+			options := ChoiceOptions{
+				NewLimiter: newLimiter,
+				OnDelta:    onDelta,
+				OnFinish:   onFinish,
+			}
+			if extensionsEnabled {
+				options.OnExtension = onExtension
+				options.GenerateImage = func(generativeImage sydney.GenerativeImage) (sydney.GenerateImageResult, error) {
+					result, err := newSydney().GenerateImage(generativeImage)
+					metrics.ObserveJob("image", err)
+					return result, err
+				}
+			}
+			return options
+		}
+
 		// handle non-stream
 		if !request.Stream {
+			results, err := AskChoices(r.Context(), n, ask, choiceOptions(
+				func(index int, delta string) {},
+				func(index int, finishReason string) {},
+				func(index int, extension SydneyExtension) {}))
+			if err != nil {
+				http.Error(w, "error creating conversation: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+
 			// set headers
 			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			SetCacheHeader(w, cacheStatuses)
 
 			// write response
-			var replyBuilder strings.Builder
-			errored := false
-
-			for message := range messageCh {
-				switch message.Type {
-				case sydney.MessageTypeMessageText:
-					replyBuilder.WriteString(message.Text)
-				case sydney.MessageTypeError:
-					errored = true
-					replyBuilder.WriteString("`Error: ")
-					replyBuilder.WriteString(message.Text)
-					replyBuilder.WriteString("`")
+			completion := NewOpenAIChatCompletion(conversationStyle, results[0].Content, results[0].FinishReason)
+			completion.Choices = nil
+			for i, result := range results {
+				RecordExchange(r.Context(), parsedMessages.WebpageContext, parsedMessages.Prompt, result.Content)
+				choice := ChatCompletionChoice{
+					Index:        i,
+					Message:      ChoiceMessage{Role: MessageRoleAssistant, Content: result.Content},
+					FinishReason: result.FinishReason,
+				}
+				if extensionsEnabled && !result.Extension.IsEmpty() {
+					choice.Sydney = &results[i].Extension
 				}
+				completion.Choices = append(completion.Choices, choice)
 			}
 
-			json.NewEncoder(w).Encode(NewOpenAIChatCompletion(
-				conversationStyle,
-				replyBuilder.String(),
-				util.Ternary(errored, FinishReasonLength, FinishReasonStop),
-			))
+			json.NewEncoder(w).Encode(completion)
 
 			return
 		}
 
-		// set headers
-		w.Header().Set("Content-Type", "text/event-stream; charset=UTF-8")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
-
 		// write response
-		errored := false
+		writeChunk := func(index int, delta string, finishReason *string, extension *SydneyExtension) {
+			chunk := NewOpenAIChatCompletionChunk(conversationStyle, delta, finishReason)
+			chunk.Choices[0].Index = index
+			chunk.Choices[0].Sydney = extension
+			encoded, err := json.Marshal(chunk)
+			if err != nil {
+				return
+			}
 
-		for message := range messageCh {
-			var delta string
-
-			switch message.Type {
-			case sydney.MessageTypeMessageText:
-				delta = message.Text
-			case sydney.MessageTypeError:
-				errored = true
-				delta = fmt.Sprintf("`Error: %s`", message.Text)
-			default:
-				continue
+			fmt.Fprintf(w, "data: %s\n\n", encoded)
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		headersWritten := false
+		writeHeaders := func() {
+			if headersWritten {
+				return
 			}
+			headersWritten = true
 
-			chunk := NewOpenAIChatCompletionChunk(conversationStyle, delta, nil)
-			encoded, err := json.Marshal(chunk)
+			// set headers
+			w.Header().Set("Content-Type", "text/event-stream; charset=UTF-8")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Connection", "keep-alive")
+			SetCacheHeader(w, cacheStatuses)
+		}
+
+		results, err := AskChoices(r.Context(), n, ask, choiceOptions(
+			func(index int, delta string) {
+				writeHeaders()
+				writeChunk(index, delta, nil, nil)
+			},
+			func(index int, finishReason string) {
+				writeHeaders()
+				writeChunk(index, "", &finishReason, nil)
+			},
+			func(index int, extension SydneyExtension) {
+				writeHeaders()
+				writeChunk(index, "", nil, &extension)
+			}))
+		if err != nil {
+			http.Error(w, "error creating conversation: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		for _, result := range results {
+			RecordExchange(r.Context(), parsedMessages.WebpageContext, parsedMessages.Prompt, result.Content)
+		}
+
+		fmt.Fprint(w, "data: [DONE]\n")
+	})
+
+	r.Post("/v1/completions", func(w http.ResponseWriter, r *http.Request) {
+		// parse request
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var request OpenAICompletionRequest
+
+		err = json.Unmarshal(body, &request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if len(request.Prompt) == 0 {
+			WriteOpenAIError(w, ErrMissingPrompt.Error(), "invalid_request_error", "invalid_prompt", http.StatusBadRequest)
+			return
+		}
+		n := util.Ternary(request.N == 0, 1, request.N)
+		// every prompt gets n choices, whose indexes are in the order of prompts
+		if n < 1 || n*len(request.Prompt) > MaxChoiceCount {
+			WriteOpenAIError(w, ErrTooManyChoices.Error(), "invalid_request_error", "invalid_n", http.StatusBadRequest)
+			return
+		}
+
+		cookiesStr := r.Header.Get("Cookie")
+		cookies := RequestCookies(r, cookiesStr, server.DefaultCookies())
+
+		conversationStyle := CompletionConversationStyle(request.Model, request.Temperature)
+		if err := CheckRequestModel(r.Context(), request.Model, conversationStyle); err != nil {
+			WriteOpenAIError(w, err.Error(), "invalid_request_error", "model_not_found", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("X-Conversation-Style", conversationStyle)
+		if ignoredParams := IgnoredCompletionParams(body, CompletionParams); len(ignoredParams) != 0 {
+			w.Header().Set("X-Ignored-Params", strings.Join(ignoredParams, ", "))
+		}
+
+		SetRequestInfo(r.Context(), request.Model, request.Prompt[0])
+
+		webpageContext := CompletionContext(request.Suffix)
+		ask := func(ctx context.Context, index int) (<-chan sydney.Message, error) {
+			messageCh, err := sydney.NewSydney(sydney.Options{
+				Cookies:           cookies,
+				Proxy:             server.Proxy(),
+				ConversationStyle: conversationStyle,
+				Locale:            "en-US",
+				NoSearch:          true,
+				GPT4Turbo:         true,
+			}).AskStream(sydney.AskStreamOptions{
+				StopCtx:        ctx,
+				Prompt:         request.Prompt[index/n],
+				WebpageContext: webpageContext,
+			})
 			if err != nil {
-				continue
+				return nil, err
+			}
+			return metrics.ObserveStream(r, request.Model, messageCh), nil
+		}
+		cacheStatuses := make([]string, n*len(request.Prompt))
+		ask = CachedAsk(cache, r, func(index int) CacheKey {
+			return NewCacheKey(RequestOwner(r), cookies, conversationStyle, true, request.Prompt[index/n], webpageContext,
+				"", "", index%n)
+		}, ask, cacheStatuses)
+		newLimiter := func() *CompletionLimiter {
+			return NewCompletionLimiter(request.Stop, request.MaxTokens)
+		}
+		echo := func(index int) string {
+			return util.Ternary(request.Echo, request.Prompt[index/n], "")
+		}
+		recordUsage := func(results []ChoiceResult) UsageStats {
+			var usage UsageStats
+			for i, result := range results {
+				RecordExchange(r.Context(), webpageContext, request.Prompt[i/n], result.Content)
+				usage.PromptTokens += CountTokens(request.Prompt[i/n])
+				usage.CompletionTokens += CountTokens(result.Content)
+			}
+			usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+			return usage
+		}
+
+		// handle non-stream
+		if !request.Stream {
+			results, err := AskChoices(r.Context(), n*len(request.Prompt), ask, ChoiceOptions{
+				NewLimiter: newLimiter,
+				OnDelta:    func(index int, delta string) {},
+				OnFinish:   func(index int, finishReason string) {},
+			})
+			if err != nil {
+				http.Error(w, "error creating conversation: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			// set headers
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			SetCacheHeader(w, cacheStatuses)
+
+			// write response
+			var choices []CompletionChoice
+			for i, result := range results {
+				choices = append(choices, CompletionChoice{
+					Text:         echo(i) + result.Content,
+					Index:        i,
+					FinishReason: &results[i].FinishReason,
+				})
+			}
+			usage := recordUsage(results)
+			completion := NewOpenAICompletion(request.Model, choices)
+			completion.Usage = &usage
+
+			json.NewEncoder(w).Encode(completion)
+
+			return
+		}
+
+		// write response
+		writeChunk := func(index int, text string, finishReason *string) {
+			encoded, err := json.Marshal(NewOpenAICompletion(request.Model, []CompletionChoice{{
+				Text:         text,
+				Index:        index,
+				FinishReason: finishReason,
+			}}))
+			if err != nil {
+				return
 			}
 
 			fmt.Fprintf(w, "data: %s\n\n", encoded)
@@ -324,16 +658,50 @@ func main() {
 				f.Flush()
 			}
 		}
+		started := map[int]bool{}
+		start := func(index int) {
+			if len(started) == 0 {
+				// set headers
+				w.Header().Set("Content-Type", "text/event-stream; charset=UTF-8")
+				w.Header().Set("Cache-Control", "no-cache")
+				w.Header().Set("Connection", "keep-alive")
+				SetCacheHeader(w, cacheStatuses)
+			}
+			if !started[index] {
+				started[index] = true
+				if text := echo(index); text != "" {
+					writeChunk(index, text, nil)
+				}
+			}
+		}
 
-		// write final chunk
-		chunk := NewOpenAIChatCompletionChunk(conversationStyle, "", util.Ternary(errored, &FinishReasonLength, &FinishReasonStop))
-		encoded, _ := json.Marshal(chunk)
-		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n", encoded)
+		results, err := AskChoices(r.Context(), n*len(request.Prompt), ask, ChoiceOptions{
+			NewLimiter: newLimiter,
+			OnDelta: func(index int, delta string) {
+				start(index)
+				writeChunk(index, delta, nil)
+			},
+			OnFinish: func(index int, finishReason string) {
+				start(index)
+				writeChunk(index, "", &finishReason)
+			},
+		})
+		if err != nil {
+			http.Error(w, "error creating conversation: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		recordUsage(results)
+
+		fmt.Fprint(w, "data: [DONE]\n")
 	})
 
 	r.Post("/v1/images/generations", func(w http.ResponseWriter, r *http.Request) {
 		// parse request
-		var request OpenAIImageGenerationRequest
+		request := OpenAIImageGenerationRequest{
+			N:              1,
+			ResponseFormat: "url",
+		}
 
 		err := json.NewDecoder(r.Body).Decode(&request)
 		if err != nil {
@@ -341,49 +709,175 @@ func main() {
 			return
 		}
 
+		if request.N < 1 || request.N > MaxImageCount {
+			http.Error(w, "n must be between 1 and "+strconv.Itoa(MaxImageCount), http.StatusBadRequest)
+			return
+		}
+		if request.ResponseFormat != "url" && request.ResponseFormat != "b64_json" {
+			http.Error(w, "unsupported response_format: "+request.ResponseFormat, http.StatusBadRequest)
+			return
+		}
+		if _, err := ResizeImageURL("", request.Size); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		cookiesStr := r.Header.Get("Cookie")
-		cookies := util.Ternary(cookiesStr == "", defaultCookies, ParseCookies(cookiesStr))
+		cookies := RequestCookies(r, cookiesStr, server.DefaultCookies())
+
+		SetRequestInfo(r.Context(), "dall-e-3", request.Prompt)
 
 		sydneyAPI := sydney.NewSydney(sydney.Options{
 			Cookies:           cookies,
-			Proxy:             proxy,
+			Proxy:             server.Proxy(),
 			ConversationStyle: "Creative",
 			Locale:            "en-US",
 		})
 
-		// ask stream
-		newContext, cancel := context.WithCancel(r.Context())
-		defer cancel()
+		// create images, in more than one batch if n is larger than a batch
+		results, err := CreateImages(r.Context(), sydneyAPI, request.Prompt, request.N)
+		metrics.ObserveJob("image", err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		generation, err := ToOpenAIImageGeneration(results, request.N, request.Size)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-		messageCh, err := sydneyAPI.AskStream(sydney.AskStreamOptions{
-			StopCtx:        newContext,
-			Prompt:         "Create image for the description: " + request.Prompt,
-			WebpageContext: ImageGeneratorContext,
+		if request.ResponseFormat == "b64_json" {
+			for i, object := range generation.Data {
+				b64, err := DownloadBase64(server.Proxy(), object.URL)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				generation.Data[i].URL = ""
+				generation.Data[i].B64JSON = b64
+			}
+		}
+
+		// set headers
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+		// write response
+		json.NewEncoder(w).Encode(generation)
+	})
+
+	r.Post("/v1/audio/generations", func(w http.ResponseWriter, r *http.Request) {
+		// parse request
+		request := OpenAIAudioGenerationRequest{
+			ResponseFormat: "url",
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if request.Prompt == "" {
+			http.Error(w, "prompt is required", http.StatusBadRequest)
+			return
+		}
+		if request.ResponseFormat != "url" && request.ResponseFormat != "b64_json" {
+			http.Error(w, "unsupported response_format: "+request.ResponseFormat, http.StatusBadRequest)
+			return
+		}
+
+		cookiesStr := r.Header.Get("Cookie")
+		cookies := RequestCookies(r, cookiesStr, server.DefaultCookies())
+
+		SetRequestInfo(r.Context(), "suno", request.Prompt)
+
+		sydneyAPI := sydney.NewSydney(sydney.Options{
+			Cookies:           cookies,
+			Proxy:             server.Proxy(),
+			ConversationStyle: "Creative",
+			Locale:            "en-US",
+			Plugins:           []string{"Suno"},
 		})
+
+		// create music
+		music, err := CreateMusic(r.Context(), sydneyAPI, request.Prompt)
+		metrics.ObserveJob("music", err)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		var generativeImage sydney.GenerativeImage
+		generation := ToOpenAIAudioGeneration(music)
 
-		for message := range messageCh {
-			if message.Type == sydney.MessageTypeGenerativeImage {
-				err := json.Unmarshal([]byte(message.Text), &generativeImage)
-				if err == nil {
-					break
+		// the audio is downloaded before the URLs are rewritten, since the proxied ones are relative
+		if request.ResponseFormat == "b64_json" {
+			for i, object := range generation.Data {
+				b64, err := DownloadBase64(server.Proxy(), object.URL)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
 				}
+				generation.Data[i].URL = ""
+				generation.Data[i].B64JSON = b64
+			}
+		}
+		if request.ProxyAssets {
+			for i, object := range generation.Data {
+				generation.Data[i].URL = proxyMusicAssetURL(object.URL)
+				generation.Data[i].CoverImageURL = proxyMusicAssetURL(object.CoverImageURL)
+				generation.Data[i].VideoURL = proxyMusicAssetURL(object.VideoURL)
 			}
 		}
-		cancel()
 
-		if generativeImage.URL == "" {
-			http.Error(w, "empty generative image", http.StatusInternalServerError)
+		// set headers
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+		// write response
+		json.NewEncoder(w).Encode(generation)
+	})
+
+	r.Post("/v1/files", func(w http.ResponseWriter, r *http.Request) {
+		// parse request
+		r.Body = http.MaxBytesReader(w, r.Body, MaxFileSize+1<<20) // leave room for the other fields
+		err := r.ParseMultipartForm(MaxFileSize + 1<<20)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, ErrFileTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		// create image
-		image, err := sydneyAPI.GenerateImage(generativeImage)
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		if header.Size > MaxFileSize {
+			http.Error(w, ErrFileTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		uploaded, err := fileStore.Save(RequestOwner(r), header.Filename, r.FormValue("purpose"), file)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// set headers
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+		// write response
+		json.NewEncoder(w).Encode(uploaded)
+	})
+
+	r.Get("/v1/files", func(w http.ResponseWriter, r *http.Request) {
+		files, err := fileStore.List(RequestOwner(r))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -393,10 +887,129 @@ func main() {
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 		// write response
-		json.NewEncoder(w).Encode(ToOpenAIImageGeneration(image))
+		json.NewEncoder(w).Encode(OpenAIFileList{Object: "list", Data: files})
 	})
 
+	r.Get("/v1/files/{id}", func(w http.ResponseWriter, r *http.Request) {
+		file, err := fileStore.Get(chi.URLParam(r, "id"))
+		if err == nil && file.Owner != RequestOwner(r) {
+			err = ErrFileNotFound
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		// set headers
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+		// write response
+		json.NewEncoder(w).Encode(file)
+	})
+
+	r.Delete("/v1/files/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		file, err := fileStore.Get(id)
+		if err == nil && file.Owner != RequestOwner(r) {
+			err = ErrFileNotFound
+		}
+		if err == nil {
+			err = fileStore.Delete(id)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		// set headers
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+		// write response
+		json.NewEncoder(w).Encode(map[string]any{"id": id, "object": "file", "deleted": true})
+	})
+
+	r.Get("/api/tags", func(w http.ResponseWriter, r *http.Request) {
+		// set headers
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+		// write response
+		json.NewEncoder(w).Encode(NewOllamaTags())
+	})
+
+	r.Post("/api/chat", func(w http.ResponseWriter, r *http.Request) {
+		// parse request
+		var request OllamaChatRequest
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			WriteOllamaError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		cookiesStr := r.Header.Get("Cookie")
+		cookies := RequestCookies(r, cookiesStr, server.DefaultCookies())
+
+		messages, images := OllamaChatToOpenAIMessages(request.Messages)
+
+		ServeOllama(w, r, metrics, sydney.Options{
+			Cookies: cookies,
+			Proxy:   server.Proxy(),
+		}, request.Model, messages, images, request.Stream == nil || *request.Stream,
+			func(delta string, done bool, doneReason string) any {
+				return OllamaChatResponse{
+					Model:     request.Model,
+					CreatedAt: time.Now(),
+					Message: OllamaMessage{
+						Role:    MessageRoleAssistant,
+						Content: delta,
+					},
+					Done:       done,
+					DoneReason: doneReason,
+				}
+			})
+	})
+
+	r.Post("/api/generate", func(w http.ResponseWriter, r *http.Request) {
+		// parse request
+		var request OllamaGenerateRequest
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			WriteOllamaError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		cookiesStr := r.Header.Get("Cookie")
+		cookies := RequestCookies(r, cookiesStr, server.DefaultCookies())
+
+		ServeOllama(w, r, metrics, sydney.Options{
+			Cookies: cookies,
+			Proxy:   server.Proxy(),
+		}, request.Model, OllamaGenerateToOpenAIMessages(request), request.Images,
+			request.Stream == nil || *request.Stream,
+			func(delta string, done bool, doneReason string) any {
+				return OllamaGenerateResponse{
+					Model:      request.Model,
+					CreatedAt:  time.Now(),
+					Response:   delta,
+					Done:       done,
+					DoneReason: doneReason,
+				}
+			})
+	})
+
+	registerThreadRoutes(r, threadStore, metrics, server)
+
+	jobRunner := NewJobRunner(jobStore, server, metrics)
+	if err := jobRunner.Resume(); err != nil {
+		slog.Error("Cannot resume jobs", "err", err)
+	}
+	registerJobRoutes(r, jobRunner, server)
+
 	// serve the router
-	log.Println("Listening on :" + port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	err = server.ListenAndServe(r)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
 }
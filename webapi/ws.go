@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sydneyqt/sydney"
+	"sync"
+
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+// frames sent by the client
+const (
+	WSFrameAsk           = "ask"
+	WSFrameFollowUp      = "follow_up"
+	WSFrameStop          = "stop"
+	WSFrameGenerateImage = "generate_image"
+)
+
+// frames sent by the server besides the types of sydney.Message
+const (
+	WSFrameImage = "image"
+	WSFrameDone  = "done"
+)
+
+// wsReadLimit allows large webpage contexts in ask frames
+const wsReadLimit = 8 << 20
+
+var (
+	ErrWSBusy        = errors.New("an ask is in progress, send stop first")
+	ErrWSNoAsk       = errors.New("follow_up requires an ask first")
+	ErrWSNoImage     = errors.New("generate_image requires generativeImage")
+	ErrWSUnknownType = errors.New("unknown frame type")
+)
+
+// WSClientFrame is a frame sent by the client. `ask` carries the fields of ChatStreamRequest,
+// `follow_up` carries `prompt` and optionally `imageUrl`, and `generate_image` carries `generativeImage`,
+// which is the parsed text of a received `generative_image` frame.
+type WSClientFrame struct {
+	Type string `json:"type"`
+	ChatStreamRequest
+	GenerativeImage *sydney.GenerativeImage `json:"generativeImage"`
+}
+
+// WSServerFrame is a frame sent by the server. Its type is one of the types of sydney.Message,
+// `image` whose text is the JSON of sydney.GenerateImageResult, or `done` at the end of every ask.
+type WSServerFrame struct {
+	Type    string `json:"type"`
+	Text    string `json:"text,omitempty"`
+	Stopped bool   `json:"stopped,omitempty"`
+}
+
+// WSAcceptOptions allows the same origins as the CORS middleware
+func WSAcceptOptions(allowedOrigins string) *websocket.AcceptOptions {
+	if allowedOrigins == "*" {
+		return &websocket.AcceptOptions{InsecureSkipVerify: true}
+	}
+	var patterns []string
+	for _, origin := range strings.Split(allowedOrigins, ",") {
+		origin = strings.TrimSpace(origin)
+		if u, err := url.Parse(origin); err == nil && u.Host != "" {
+			origin = u.Host
+		}
+		patterns = append(patterns, origin)
+	}
+	return &websocket.AcceptOptions{OriginPatterns: patterns}
+}
+
+// wsSession is a chat over a single socket. Follow-ups continue the Bing conversation of the ask,
+// or rebuild the context from the history in a new conversation if the last reply has failed.
+type wsSession struct {
+	conn    *websocket.Conn
+	r       *http.Request
+	ctx     context.Context
+	server  *Server
+	metrics *Metrics
+
+	mu      sync.Mutex
+	request ChatStreamRequest
+	history []ThreadMessage
+	asked   bool
+	cancel  context.CancelFunc
+	// conversation is nil before the first reply and after a failed one
+	conversation *sydney.CreateConversationResponse
+	turn         int
+}
+
+func ServeChatWebSocket(w http.ResponseWriter, r *http.Request, server *Server, metrics *Metrics) {
+	conn, err := websocket.Accept(w, r, WSAcceptOptions(server.Config().AllowedOrigins))
+	if err != nil {
+		// Accept has written the response
+		return
+	}
+	defer conn.Close(websocket.StatusInternalError, "")
+	conn.SetReadLimit(wsReadLimit)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	session := &wsSession{
+		conn:    conn,
+		r:       r,
+		ctx:     ctx,
+		server:  server,
+		metrics: metrics,
+	}
+
+	for {
+		_, v, err := conn.Read(ctx)
+		if err != nil {
+			if websocket.CloseStatus(err) == websocket.StatusNormalClosure ||
+				websocket.CloseStatus(err) == websocket.StatusGoingAway {
+				conn.Close(websocket.StatusNormalClosure, "")
+			}
+			return
+		}
+		// wsjson.Read would close the socket on invalid JSON, so frames are parsed here
+		var frame WSClientFrame
+		err = json.Unmarshal(v, &frame)
+		if err == nil {
+			err = session.handle(frame)
+		}
+		if err != nil {
+			session.write(WSServerFrame{Type: sydney.MessageTypeError, Text: err.Error()})
+		}
+	}
+}
+
+func (o *wsSession) write(frame WSServerFrame) {
+	_ = wsjson.Write(o.ctx, o.conn, frame)
+}
+
+func (o *wsSession) handle(frame WSClientFrame) error {
+	switch frame.Type {
+	case WSFrameAsk:
+		request := frame.ChatStreamRequest
+		if request.ConversationStyle == "" {
+			request.ConversationStyle = "Creative"
+		}
+		if err := CheckRequestModel(o.r.Context(), request.ConversationStyle, request.ConversationStyle); err != nil {
+			return err
+		}
+		return o.ask(request, true)
+	case WSFrameFollowUp:
+		o.mu.Lock()
+		asked := o.asked
+		request := o.request
+		o.mu.Unlock()
+		if !asked {
+			return ErrWSNoAsk
+		}
+		request.Prompt = frame.Prompt
+		request.ImageURL = frame.ImageURL
+		return o.ask(request, false)
+	case WSFrameStop:
+		o.mu.Lock()
+		if o.cancel != nil {
+			o.cancel()
+		}
+		o.mu.Unlock()
+		return nil
+	case WSFrameGenerateImage:
+		if frame.GenerativeImage == nil || frame.GenerativeImage.URL == "" {
+			return ErrWSNoImage
+		}
+		go o.generateImage(*frame.GenerativeImage)
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrWSUnknownType, frame.Type)
+	}
+}
+
+func (o *wsSession) newSydney(request ChatStreamRequest) *sydney.Sydney {
+	return sydney.NewSydney(sydney.Options{
+		Cookies:           RequestCookies(o.r, request.Cookies, o.server.DefaultCookies()),
+		Proxy:             o.server.Proxy(),
+		ConversationStyle: request.ConversationStyle,
+		NoSearch:          request.NoSearch,
+		GPT4Turbo:         request.UseGPT4Turbo,
+		UseClassic:        request.UseClassic,
+		Plugins:           request.Plugins,
+	})
+}
+
+// ask starts streaming in the background, so that stop frames can be read meanwhile.
+// A new ask resets the history and the conversation, and a follow-up appends to them.
+func (o *wsSession) ask(request ChatStreamRequest, reset bool) error {
+	o.mu.Lock()
+	if o.cancel != nil {
+		o.mu.Unlock()
+		return ErrWSBusy
+	}
+	if reset {
+		o.request = request
+		o.history = nil
+		o.conversation = nil
+		o.asked = true
+		SetRequestInfo(o.r.Context(), request.ConversationStyle, request.Prompt)
+	}
+	conversation, turn := o.conversation, o.turn
+	var webpageContext string
+	if conversation == nil {
+		webpageContext = o.request.WebpageContext
+		if len(o.history) != 0 {
+			webpageContext += "\n\n" + Thread{Messages: o.history}.ToContext()
+		}
+	}
+	askCtx, cancel := context.WithCancel(o.ctx)
+	o.cancel = cancel
+	o.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		var replyBuilder strings.Builder
+
+		sydneyAPI := o.newSydney(request)
+		var err error
+		if conversation == nil {
+			var created sydney.CreateConversationResponse
+			created, err = sydneyAPI.CreateConversation()
+			conversation, turn = &created, 0
+		}
+		var messageCh <-chan sydney.Message
+		if err == nil {
+			messageCh, err = sydneyAPI.AskStream(sydney.AskStreamOptions{
+				StopCtx:        askCtx,
+				Prompt:         request.Prompt,
+				WebpageContext: webpageContext,
+				ImageURL:       request.ImageURL,
+				Conversation:   conversation,
+				Turn:           turn,
+			})
+		}
+		errored := err != nil
+		if err != nil {
+			o.write(WSServerFrame{Type: sydney.MessageTypeError, Text: "error creating conversation: " + err.Error()})
+		} else {
+			messageCh = o.metrics.ObserveStream(o.r, request.ConversationStyle, messageCh)
+			for message := range messageCh {
+				switch message.Type {
+				case sydney.MessageTypeMessageText:
+					replyBuilder.WriteString(message.Text)
+				case sydney.MessageTypeError:
+					errored = true
+				}
+				o.write(WSServerFrame{Type: message.Type, Text: message.Text})
+			}
+			RecordExchange(o.r.Context(), webpageContext, request.Prompt, replyBuilder.String())
+		}
+
+		o.mu.Lock()
+		// the conversation is dropped on error like the one of a thread run
+		if errored {
+			o.conversation = nil
+		} else {
+			o.conversation = conversation
+			o.turn = turn + 1
+		}
+		stopped := askCtx.Err() != nil
+		// a stopped reply is kept since the user has seen it
+		if replyBuilder.Len() != 0 {
+			o.history = append(o.history,
+				NewThreadMessage(MessageRoleUser, request.Prompt, request.ImageURL),
+				NewThreadMessage(MessageRoleAssistant, replyBuilder.String(), ""))
+		}
+		o.cancel = nil
+		o.mu.Unlock()
+
+		o.write(WSServerFrame{Type: WSFrameDone, Stopped: stopped})
+	}()
+	return nil
+}
+
+func (o *wsSession) generateImage(generativeImage sydney.GenerativeImage) {
+	o.mu.Lock()
+	request := o.request
+	o.mu.Unlock()
+
+	result, err := o.newSydney(request).GenerateImage(generativeImage)
+	o.metrics.ObserveJob("image", err)
+	if err != nil {
+		o.write(WSServerFrame{Type: sydney.MessageTypeError, Text: "error generating image: " + err.Error()})
+		return
+	}
+	v, err := json.Marshal(result)
+	if err != nil {
+		o.write(WSServerFrame{Type: sydney.MessageTypeError, Text: err.Error()})
+		return
+	}
+	o.write(WSServerFrame{Type: WSFrameImage, Text: string(v)})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sydneyqt/sydney"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+func TestWSAcceptOptions(t *testing.T) {
+	assert.True(t, WSAcceptOptions("*").InsecureSkipVerify)
+	options := WSAcceptOptions("https://a.example, b.example:3000")
+	assert.False(t, options.InsecureSkipVerify)
+	assert.Equal(t, []string{"a.example", "b.example:3000"}, options.OriginPatterns)
+}
+
+func TestChatWebSocketProtocolErrors(t *testing.T) {
+	server := &Server{}
+	config := DefaultConfig()
+	server.config.Store(&config)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeChatWebSocket(w, r, server, NewMetrics())
+	}))
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(httpServer.URL, "http"), nil)
+	assert.Nil(t, err)
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	tests := []struct {
+		name  string
+		frame string
+		err   error
+	}{
+		{"follow-up without ask", `{"type": "follow_up", "prompt": "and then?"}`, ErrWSNoAsk},
+		{"generate image without image", `{"type": "generate_image"}`, ErrWSNoImage},
+		{"unknown type", `{"type": "dance"}`, ErrWSUnknownType},
+		{"invalid json", `{"type": `, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := conn.Write(ctx, websocket.MessageText, []byte(tt.frame))
+			assert.Nil(t, err)
+			var frame WSServerFrame
+			err = wsjson.Read(ctx, conn, &frame)
+			assert.Nil(t, err)
+			assert.Equal(t, sydney.MessageTypeError, frame.Type)
+			if tt.err != nil {
+				assert.Contains(t, frame.Text, tt.err.Error())
+			}
+		})
+	}
+
+	// stop without an ask in progress is ignored, and the socket keeps working
+	err = wsjson.Write(ctx, conn, WSClientFrame{Type: WSFrameStop})
+	assert.Nil(t, err)
+	err = wsjson.Write(ctx, conn, WSClientFrame{Type: "dance"})
+	assert.Nil(t, err)
+	var frame WSServerFrame
+	err = wsjson.Read(ctx, conn, &frame)
+	assert.Nil(t, err)
+	assert.Contains(t, frame.Text, ErrWSUnknownType.Error())
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sydneyqt/util"
+	"sync"
+	"time"
+)
+
+const workspaceIndexFile = "index.json"
+
+// WorkspaceStore keeps every workspace in its own file, so that a change only rewrites the workspace it belongs to.
+// Summaries of all workspaces are kept in an index file and loaded at startup,
+// while contexts, inputs and data references, which make up the bulk of a workspace, are loaded on demand.
+type WorkspaceStore struct {
+	dir        string
+	mu         sync.Mutex
+	index      map[int]Workspace // summaries
+	loaded     map[int]Workspace
+	dirty      map[int]bool // written on the next flush, or removed if not in the index
+	indexDirty bool
+	// the search index is built without holding mu, from the workspaces marked stale
+	searchMu    sync.Mutex
+	searchIndex *workspaceIndex
+	searchStale map[int]bool // nil until the first search
+	// histories are merged when the workspaces are written, rather than on every change
+	histories      map[int]*WorkspaceHistory
+	historyPending map[int][]pendingContext
+	historyDirty   map[int]bool
+	exit           chan struct{}
+	done           chan struct{}
+}
+
+func NewWorkspaceStore(dir string) (*WorkspaceStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	store := &WorkspaceStore{
+		dir:            dir,
+		index:          map[int]Workspace{},
+		loaded:         map[int]Workspace{},
+		dirty:          map[int]bool{},
+		searchIndex:    newWorkspaceIndex(),
+		histories:      map[int]*WorkspaceHistory{},
+		historyPending: map[int][]pendingContext{},
+		historyDirty:   map[int]bool{},
+		exit:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	err = store.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	go store.writer()
+	return store, nil
+}
+
+func summarizeWorkspace(workspace Workspace) Workspace {
+	workspace.Context = ""
+	workspace.Input = ""
+	workspace.DataReferences = nil
+	return workspace
+}
+
+func (o *WorkspaceStore) workspacePath(id int) string {
+	return filepath.Join(o.dir, strconv.Itoa(id)+".json")
+}
+
+func (o *WorkspaceStore) loadIndex() error {
+	v, err := os.ReadFile(filepath.Join(o.dir, workspaceIndexFile))
+	if err == nil {
+		var summaries []Workspace
+		err = json.Unmarshal(v, &summaries)
+		if err != nil {
+			return errors.Wrap(err, "cannot parse workspace index")
+		}
+		for _, summary := range summaries {
+			o.index[summary.ID] = summary
+		}
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// rebuild the index from the workspace files if it is lost
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == workspaceIndexFile || filepath.Ext(name) != ".json" {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimSuffix(name, ".json")); err != nil {
+			continue
+		}
+		v, err := os.ReadFile(filepath.Join(o.dir, name))
+		if err != nil {
+			return err
+		}
+		var workspace Workspace
+		err = json.Unmarshal(v, &workspace)
+		if err != nil {
+			return errors.Wrap(err, "cannot parse workspace "+name)
+		}
+		o.index[workspace.ID] = summarizeWorkspace(workspace)
+		o.indexDirty = true
+	}
+	return nil
+}
+
+// ListWorkspaces returns the summaries of all workspaces, whose contexts, inputs and data references are empty
+func (o *WorkspaceStore) ListWorkspaces() []Workspace {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	summaries := lo.Values(o.index)
+	slices.SortFunc(summaries, func(a, b Workspace) int {
+		return a.ID - b.ID
+	})
+	return summaries
+}
+
+// GetWorkspace returns the whole workspace, loading it from its file if needed
+func (o *WorkspaceStore) GetWorkspace(id int) (Workspace, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.get(id)
+}
+
+func (o *WorkspaceStore) get(id int) (Workspace, error) {
+	if _, ok := o.index[id]; !ok {
+		return Workspace{}, errors.New("workspace not exist by id: " + strconv.Itoa(id))
+	}
+	if workspace, ok := o.loaded[id]; ok {
+		return workspace, nil
+	}
+	workspace, err := o.readFile(id)
+	if err != nil {
+		return Workspace{}, err
+	}
+	o.loaded[id] = workspace
+	return workspace, nil
+}
+
+// readFile reads the workspace from its file, which does not need the lock since files are replaced atomically
+func (o *WorkspaceStore) readFile(id int) (Workspace, error) {
+	var workspace Workspace
+	v, err := os.ReadFile(o.workspacePath(id))
+	if err != nil {
+		return workspace, err
+	}
+	err = json.Unmarshal(v, &workspace)
+	return workspace, err
+}
+
+// SaveWorkspace creates or updates the workspace, which is written to disk within a second
+func (o *WorkspaceStore) SaveWorkspace(workspace Workspace) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.save(workspace)
+}
+
+func (o *WorkspaceStore) save(workspace Workspace) {
+	summary := summarizeWorkspace(workspace)
+	if previous, ok := o.index[workspace.ID]; !ok || !reflect.DeepEqual(previous, summary) {
+		o.index[workspace.ID] = summary
+		o.indexDirty = true
+	}
+	if previous, ok := o.loaded[workspace.ID]; !ok || previous.Context != workspace.Context {
+		o.addPendingContext(workspace.ID, previous.Context, workspace.Context)
+	}
+	o.loaded[workspace.ID] = workspace
+	o.dirty[workspace.ID] = true
+	o.invalidateSearch(workspace.ID)
+}
+
+// RenameWorkspace changes the title of the workspace, which may not be loaded
+func (o *WorkspaceStore) RenameWorkspace(id int, title string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	workspace, err := o.get(id)
+	if err != nil {
+		return err
+	}
+	workspace.Title = title
+	o.save(workspace)
+	return nil
+}
+
+func (o *WorkspaceStore) DeleteWorkspace(id int) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.index[id]; !ok {
+		return errors.New("workspace not exist by id: " + strconv.Itoa(id))
+	}
+	delete(o.index, id)
+	delete(o.loaded, id)
+	delete(o.histories, id)
+	delete(o.historyPending, id)
+	delete(o.historyDirty, id)
+	o.dirty[id] = true
+	o.indexDirty = true
+	o.invalidateSearch(id)
+	return nil
+}
+
+// importWorkspaces saves the workspaces and writes them to disk at once
+func (o *WorkspaceStore) importWorkspaces(workspaces []Workspace) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, workspace := range workspaces {
+		o.save(workspace)
+	}
+	return o.flush()
+}
+
+func writeFileAtomically(path string, v []byte) error {
+	tmpPath := path + ".tmp"
+	err := os.WriteFile(tmpPath, v, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// flush writes the changed workspaces and the index, and must be called with the lock held
+func (o *WorkspaceStore) flush() error {
+	now := time.Now()
+	for id := range o.historyPending {
+		err := o.mergeHistory(id, now)
+		if err != nil {
+			return err
+		}
+	}
+	for id := range o.historyDirty {
+		v, err := json.Marshal(o.histories[id])
+		if err != nil {
+			return err
+		}
+		err = writeFileAtomically(o.historyPath(id), v)
+		if err != nil {
+			return err
+		}
+		delete(o.historyDirty, id)
+	}
+	for id := range o.dirty {
+		workspace, ok := o.loaded[id]
+		if !ok {
+			for _, path := range []string{o.workspacePath(id), o.historyPath(id)} {
+				err := os.Remove(path)
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+			delete(o.dirty, id)
+			continue
+		}
+		v, err := json.Marshal(&workspace)
+		if err != nil {
+			return err
+		}
+		err = writeFileAtomically(o.workspacePath(id), v)
+		if err != nil {
+			return err
+		}
+		delete(o.dirty, id)
+	}
+	if !o.indexDirty {
+		return nil
+	}
+	summaries := lo.Values(o.index)
+	slices.SortFunc(summaries, func(a, b Workspace) int {
+		return a.ID - b.ID
+	})
+	v, err := json.MarshalIndent(summaries, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomically(filepath.Join(o.dir, workspaceIndexFile), v)
+	if err != nil {
+		return err
+	}
+	o.indexDirty = false
+	return nil
+}
+
+func (o *WorkspaceStore) writer() {
+	defer close(o.done)
+	for {
+		o.mu.Lock()
+		err := o.flush()
+		o.mu.Unlock()
+		if err != nil {
+			util.GracefulPanic(err)
+		}
+		select {
+		case <-o.exit:
+			o.mu.Lock()
+			err := o.flush()
+			o.mu.Unlock()
+			if err != nil {
+				util.GracefulPanic(err)
+			}
+			return
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+// close writes pending changes and stops the writer
+func (o *WorkspaceStore) close() {
+	close(o.exit)
+	<-o.done
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkspaceStoreFlush(t *testing.T) {
+	dir := t.TempDir()
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	first := Workspace{ID: 1, Title: "First", Context: "[user](#message)\nHi\n\n", Input: "draft",
+		CreatedAt: createdAt, DataReferences: []DataReference{{UUID: "a", Type: "image"}}}
+	second := Workspace{ID: 2, Title: "Second", Context: "[user](#message)\nHello\n\n", CreatedAt: createdAt}
+
+	store, err := NewWorkspaceStore(dir)
+	assert.NoError(t, err)
+	store.SaveWorkspace(first)
+	store.SaveWorkspace(second)
+	assert.NoError(t, store.RenameWorkspace(2, "Renamed"))
+	assert.Error(t, store.RenameWorkspace(3, "Missing"))
+	store.close()
+	second.Title = "Renamed"
+
+	for _, name := range []string{workspaceIndexFile, "1.json", "2.json", "1.history.json", "2.history.json"} {
+		assert.FileExists(t, filepath.Join(dir, name))
+	}
+
+	store, err = NewWorkspaceStore(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []Workspace{summarizeWorkspace(first), summarizeWorkspace(second)}, store.ListWorkspaces())
+	workspace, err := store.GetWorkspace(1)
+	assert.NoError(t, err)
+	assert.Equal(t, first, workspace)
+	_, err = store.GetWorkspace(3)
+	assert.Error(t, err)
+	assert.NoError(t, store.DeleteWorkspace(1))
+	assert.Error(t, store.DeleteWorkspace(1))
+	store.close()
+
+	assert.NoFileExists(t, filepath.Join(dir, "1.json"))
+	assert.NoFileExists(t, filepath.Join(dir, "1.history.json"))
+
+	// the index is rebuilt from the workspace files if it is lost
+	assert.NoError(t, os.Remove(filepath.Join(dir, workspaceIndexFile)))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "notes.json"), []byte("{}"), 0644))
+	store, err = NewWorkspaceStore(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []Workspace{summarizeWorkspace(second)}, store.ListWorkspaces())
+	store.close()
+	assert.FileExists(t, filepath.Join(dir, workspaceIndexFile))
+}
+
+func TestWorkspaceMigration(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewWorkspaceStore(dir)
+	assert.NoError(t, err)
+	defer store.close()
+	workspaces := []Workspace{
+		{ID: 1, Title: "First", Context: "[user](#message)\nHi\n\n"},
+		{ID: 3, Title: "Third", Context: "[user](#message)\nHello\n\n", Input: "draft"},
+	}
+	config := Config{
+		Workspaces: workspaces,
+		OpenAIBackends: []OpenAIBackend{
+			{Name: "GPT-4", OpenaiLongModel: "gpt-4-32k"},
+			{Name: "Local", OpenaiLongModel: "llama3"},
+			{Name: "Custom", OpenaiLongModel: "gpt-4o", OpenaiLongLimit: 32000},
+		},
+	}
+	assert.NoError(t, config.DoMigration(store))
+
+	assert.Nil(t, config.Workspaces)
+	assert.True(t, config.Migration.Workspaces20261018)
+	assert.True(t, config.Migration.OpenAILongLimit20261018)
+	assert.Equal(t, 32768, config.OpenAIBackends[0].OpenaiLongLimit)
+	assert.Equal(t, 0, config.OpenAIBackends[1].OpenaiLongLimit)
+	assert.Equal(t, 32000, config.OpenAIBackends[2].OpenaiLongLimit)
+	// workspaces are written at once rather than by the writer
+	for _, name := range []string{workspaceIndexFile, "1.json", "3.json"} {
+		assert.FileExists(t, filepath.Join(dir, name))
+	}
+	for _, workspace := range workspaces {
+		saved, err := store.GetWorkspace(workspace.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, workspace, saved)
+	}
+
+	// migrated only once
+	config.OpenAIBackends[1].OpenaiLongModel = "gpt-4"
+	config.Workspaces = []Workspace{{ID: 2, Title: "Second"}}
+	assert.NoError(t, config.DoMigration(store))
+	assert.Equal(t, 0, config.OpenAIBackends[1].OpenaiLongLimit)
+	assert.Len(t, store.ListWorkspaces(), 2)
+}